@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"time"
+	"ws-go/api/service"
+	"ws-go/protocol/event"
+)
+
+// eventPingInterval 没有事件时的心跳 防止代理断开空闲连接
+const eventPingInterval = 15 * time.Second
+
+var eventUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// 和其他接口一样不检查来源
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamGap 续传时部分事件已经不在缓冲中 客户端需要通过其他接口补齐
+type streamGap struct {
+	LastEventId string `json:"lastEventId"`
+}
+
+// EventsController 实时推送账号的事件 WebSocket 升级请求使用 WebSocket 否则使用 SSE
+// 续传位置使用 Last-Event-ID 请求头或者 lastEventId 参数 types 为逗号分隔的事件名称
+// 订阅者处理不过来丢弃事件时断开连接 客户端使用最后的 id 重新连接
+func EventsController(ctx *gin.Context) {
+	lastEventId := ctx.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = ctx.Query("lastEventId")
+	}
+	sub, resumed, resp := service.SubscribeEventsService(ctx.Param("key"), lastEventId, ctx.Query("types"))
+	if resp != nil {
+		ctx.JSON(http.StatusOK, resp)
+		return
+	}
+	defer sub.Close()
+	var gap *streamGap
+	if !resumed {
+		gap = &streamGap{LastEventId: lastEventId}
+	}
+	if websocket.IsWebSocketUpgrade(ctx.Request) {
+		serveEventWebSocket(ctx, sub, gap)
+		return
+	}
+	serveEventSSE(ctx, sub, gap)
+}
+
+// serveEventSSE id 为事件的 seq event 为事件类型 data 为事件的 json
+func serveEventSSE(ctx *gin.Context, sub *event.Subscription, gap *streamGap) {
+	w := ctx.Writer
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if gap != nil {
+		d, _ := json.Marshal(gap)
+		if _, err := fmt.Fprintf(w, "event: gap\ndata: %s\n\n", d); err != nil {
+			return
+		}
+	}
+	w.Flush()
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case env, ok := <-sub.C():
+			if !ok || sub.Dropped() > 0 {
+				return
+			}
+			d, err := json.Marshal(env)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", env.Seq, env.Type, d); err != nil {
+				return
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Request.Context().Done():
+			return
+		}
+		w.Flush()
+	}
+}
+
+// serveEventWebSocket 每个事件一条文本消息 内容和 SSE 的 data 相同
+func serveEventWebSocket(ctx *gin.Context, sub *event.Subscription, gap *streamGap) {
+	conn, err := eventUpgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	// 只读取控制消息 客户端关闭时结束
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	write := func(v interface{}) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(eventPingInterval))
+		return conn.WriteJSON(v)
+	}
+	if gap != nil {
+		if err := write(gin.H{"type": "gap", "event": gap}); err != nil {
+			return
+		}
+	}
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case env, ok := <-sub.C():
+			if !ok || sub.Dropped() > 0 {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "events dropped"), time.Now().Add(time.Second))
+				return
+			}
+			if err := write(env); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventPingInterval)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
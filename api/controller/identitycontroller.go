@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ws-go/api/dto"
+	"ws-go/api/service"
+)
+
+// GetSafetyNumberController 获取和联系人的安全码
+func GetSafetyNumberController(ctx *gin.Context) {
+	safetyDto := &dto.SafetyNumberDto{}
+	if !validateData(ctx, &safetyDto) {
+		return
+	}
+	resp := service.GetSafetyNumberService(ctx.Param("key"), *safetyDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// VerifySafetyNumberController 验证扫描的安全码二维码
+func VerifySafetyNumberController(ctx *gin.Context) {
+	verifyDto := &dto.VerifySafetyNumberDto{}
+	if !validateData(ctx, &verifyDto) {
+		return
+	}
+	resp := service.VerifySafetyNumberService(ctx.Param("key"), *verifyDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// SetIdentityVerifiedController 手动设置安全码是否已经验证
+func SetIdentityVerifiedController(ctx *gin.Context) {
+	verifiedDto := &dto.SetIdentityVerifiedDto{}
+	if !validateData(ctx, &verifiedDto) {
+		return
+	}
+	resp := service.SetIdentityVerifiedService(ctx.Param("key"), *verifiedDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// ApproveIdentityController 确认联系人新的身份密钥 block 策略下解除阻止
+func ApproveIdentityController(ctx *gin.Context) {
+	approveDto := &dto.ApproveIdentityDto{}
+	if !validateData(ctx, &approveDto) {
+		return
+	}
+	resp := service.ApproveIdentityService(ctx.Param("key"), *approveDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
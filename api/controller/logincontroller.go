@@ -54,6 +54,28 @@ func HasUnsentPreKeysController(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, &resp)
 }
 
+// GetInboundMetricsController 收到的帧的队列长度和延迟
+func GetInboundMetricsController(ctx *gin.Context) {
+	key := ctx.Param("key")
+	if key == "" {
+		ctx.JSON(http.StatusOK, vo.IncompleteParameters())
+		return
+	}
+	resp := service.GetInboundMetricsService(key)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetKeepaliveStatsController 心跳的往返时间和没有回复的次数
+func GetKeepaliveStatsController(ctx *gin.Context) {
+	key := ctx.Param("key")
+	if key == "" {
+		ctx.JSON(http.StatusOK, vo.IncompleteParameters())
+		return
+	}
+	resp := service.GetKeepaliveStatsService(key)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
 // LogOutController  退出登录
 func LogOutController(ctx *gin.Context) {
 	key := ctx.Param("key")
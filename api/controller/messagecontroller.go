@@ -81,3 +81,73 @@ func SendVcardMessageController(ctx *gin.Context) {
 	resp := service.SendVcardMessageService(ctx.Param("key"), *Dto)
 	ctx.JSON(http.StatusOK, &resp)
 }
+
+// GetMessageStatusController 按消息 id 查询发送状态
+func GetMessageStatusController(ctx *gin.Context) {
+	statusDto := &dto.MessageStatusDto{}
+	if !validateData(ctx, &statusDto) {
+		return
+	}
+	resp := service.GetMessageStatusService(ctx.Param("key"), *statusDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetChatMessageStatusController 查询会话中发送的消息的状态
+func GetChatMessageStatusController(ctx *gin.Context) {
+	statusDto := &dto.ChatMessageStatusDto{}
+	if !validateData(ctx, &statusDto) {
+		return
+	}
+	resp := service.GetChatMessageStatusService(ctx.Param("key"), *statusDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetChatsController 有收到消息的会话
+func GetChatsController(ctx *gin.Context) {
+	chatsDto := &dto.ChatListDto{}
+	if !validateData(ctx, &chatsDto) {
+		return
+	}
+	resp := service.GetChatsService(ctx.Param("key"), *chatsDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetChatHistoryController 分页查询会话中收到的消息
+func GetChatHistoryController(ctx *gin.Context) {
+	historyDto := &dto.ChatHistoryDto{}
+	if !validateData(ctx, &historyDto) {
+		return
+	}
+	resp := service.GetChatHistoryService(ctx.Param("key"), *historyDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetMessageController 按消息 id 查询收到的消息
+func GetMessageController(ctx *gin.Context) {
+	messageDto := &dto.MessageStatusDto{}
+	if !validateData(ctx, &messageDto) {
+		return
+	}
+	resp := service.GetMessageService(ctx.Param("key"), *messageDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetQuarantinedController 无法解密的消息列表
+func GetQuarantinedController(ctx *gin.Context) {
+	quarantinedDto := &dto.QuarantinedListDto{}
+	if !validateData(ctx, &quarantinedDto) {
+		return
+	}
+	resp := service.GetQuarantinedService(ctx.Param("key"), *quarantinedDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetQuarantinedMessageController 按消息 id 查询无法解密的消息
+func GetQuarantinedMessageController(ctx *gin.Context) {
+	messageDto := &dto.MessageStatusDto{}
+	if !validateData(ctx, &messageDto) {
+		return
+	}
+	resp := service.GetQuarantinedMessageService(ctx.Param("key"), *messageDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
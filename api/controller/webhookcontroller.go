@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"ws-go/api/dto"
+	"ws-go/api/service"
+)
+
+// AddWebhookController 添加 webhook
+func AddWebhookController(ctx *gin.Context) {
+	webhookDto := &dto.WebhookDto{}
+	if !validateData(ctx, &webhookDto) {
+		return
+	}
+	resp := service.AddWebhookService(ctx.Param("key"), *webhookDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetWebhooksController 账号的 webhook
+func GetWebhooksController(ctx *gin.Context) {
+	resp := service.GetWebhooksService(ctx.Param("key"))
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// DeleteWebhookController 删除 webhook
+func DeleteWebhookController(ctx *gin.Context) {
+	idDto := &dto.WebhookIdDto{}
+	if !validateData(ctx, &idDto) {
+		return
+	}
+	resp := service.DeleteWebhookService(ctx.Param("key"), *idDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// GetDeadLettersController 推送失败的死信
+func GetDeadLettersController(ctx *gin.Context) {
+	deadLetterDto := &dto.DeadLetterDto{}
+	if !validateData(ctx, &deadLetterDto) {
+		return
+	}
+	resp := service.GetDeadLettersService(ctx.Param("key"), *deadLetterDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// ReplayDeadLetterController 重新推送死信
+func ReplayDeadLetterController(ctx *gin.Context) {
+	idDto := &dto.WebhookIdDto{}
+	if !validateData(ctx, &idDto) {
+		return
+	}
+	resp := service.ReplayDeadLetterService(ctx.Param("key"), *idDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
+
+// DeleteDeadLetterController 删除死信
+func DeleteDeadLetterController(ctx *gin.Context) {
+	idDto := &dto.WebhookIdDto{}
+	if !validateData(ctx, &idDto) {
+		return
+	}
+	resp := service.DeleteDeadLetterService(ctx.Param("key"), *idDto)
+	ctx.JSON(http.StatusOK, &resp)
+}
@@ -21,6 +21,8 @@ type LoginDto struct {
 	ClientStaticKeypair string
 	//EdgeRouting
 	EdgeRouting string
+	// ServerStaticPubKey 上次登录得到的服务器公钥 有值时使用 IK 握手 为空时使用服务端保存的
+	ServerStaticPubKey string
 	//
 	IdentityPubKey string
 	IdentityPriKey string
@@ -224,3 +226,76 @@ type ScanNumberDto struct {
 type ExistenceDto struct {
 	Number []string
 }
+
+// SafetyNumberDto 联系人的安全码
+type SafetyNumberDto struct {
+	ToWid string
+}
+
+// VerifySafetyNumberDto Scan 为扫描对方二维码得到的数据的 base64
+type VerifySafetyNumberDto struct {
+	ToWid string
+	Scan  string
+}
+
+// SetIdentityVerifiedDto
+type SetIdentityVerifiedDto struct {
+	ToWid    string
+	Verified bool
+}
+
+// ApproveIdentityDto NewKey 为 IdentityChanged 事件中的 newKey
+type ApproveIdentityDto struct {
+	ToWid  string
+	NewKey string
+}
+
+// MessageStatusDto 发送的消息的状态
+type MessageStatusDto struct {
+	MsgId string
+}
+
+// ChatMessageStatusDto 会话中发送的消息的状态 Before 为上一页最后一条消息的 id 为空时从最新的开始
+type ChatMessageStatusDto struct {
+	ToWid     string
+	SentGroup bool
+	Before    string
+	Limit     int
+}
+
+// ChatListDto 会话列表
+type ChatListDto struct {
+	Limit int
+}
+
+// QuarantinedListDto 无法解密的消息 Sender 为空时返回所有的
+type QuarantinedListDto struct {
+	Sender string
+	Limit  int
+}
+
+// ChatHistoryDto 会话中收到的消息 Before 为上一页最后一条消息的 id 为空时从最新的开始
+type ChatHistoryDto struct {
+	ToWid     string
+	SentGroup bool
+	Before    string
+	Limit     int
+}
+
+// WebhookDto Secret 为空时生成 Types 为事件名称 为空时推送新消息和回执
+type WebhookDto struct {
+	Url    string
+	Secret string
+	Types  []string
+}
+
+// WebhookIdDto
+type WebhookIdDto struct {
+	Id int64
+}
+
+// DeadLetterDto HookId 为 0 时返回所有 webhook 的死信
+type DeadLetterDto struct {
+	HookId int64
+	Limit  int
+}
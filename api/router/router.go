@@ -46,6 +46,8 @@ func setApi_V1(engine *gin.Engine) {
 		login.POST("/SetBusinessCategory/:key", controller.SetBusinessCategoryController)
 		login.POST("/SetNetWorkProxy/:key", controller.SetNetWorkProxyController)
 		login.POST("/HasUnsentPreKeys/:key", controller.HasUnsentPreKeysController)
+		login.GET("/GetInboundMetrics/:key", controller.GetInboundMetricsController)
+		login.GET("/GetKeepaliveStats/:key", controller.GetKeepaliveStatsController)
 	}
 	// 消息
 	message := engine.Group(ver + "/message")
@@ -57,6 +59,13 @@ func setApi_V1(engine *gin.Engine) {
 		message.POST("/SendVideoMessage/:key", controller.SendVideoMessageController)
 		message.POST("/SendVcardMessage/:key", controller.SendVcardMessageController)
 		message.POST("/SendMessageDownload/:key", controller.SendMessageDownloadController)
+		message.POST("/GetMessageStatus/:key", controller.GetMessageStatusController)
+		message.POST("/GetChatMessageStatus/:key", controller.GetChatMessageStatusController)
+		message.POST("/GetChats/:key", controller.GetChatsController)
+		message.POST("/GetChatHistory/:key", controller.GetChatHistoryController)
+		message.POST("/GetMessage/:key", controller.GetMessageController)
+		message.POST("/GetQuarantined/:key", controller.GetQuarantinedController)
+		message.POST("/GetQuarantinedMessage/:key", controller.GetQuarantinedMessageController)
 	}
 
 	// 同步
@@ -103,6 +112,26 @@ func setApi_V1(engine *gin.Engine) {
 	{
 		task.POST("/AddTask/:key", controller.AddTaskController)
 	}
+	// 安全码
+	identity := engine.Group(ver + "/identity")
+	{
+		identity.POST("/GetSafetyNumber/:key", controller.GetSafetyNumberController)
+		identity.POST("/VerifySafetyNumber/:key", controller.VerifySafetyNumberController)
+		identity.POST("/SetVerified/:key", controller.SetIdentityVerifiedController)
+		identity.POST("/ApproveIdentity/:key", controller.ApproveIdentityController)
+	}
+	// 实时事件 SSE 或 WebSocket
+	engine.GET(ver+"/events/:key", controller.EventsController)
+	// webhook 推送
+	hook := engine.Group(ver + "/webhook")
+	{
+		hook.POST("/AddWebhook/:key", controller.AddWebhookController)
+		hook.GET("/GetWebhooks/:key", controller.GetWebhooksController)
+		hook.POST("/DeleteWebhook/:key", controller.DeleteWebhookController)
+		hook.POST("/GetDeadLetters/:key", controller.GetDeadLettersController)
+		hook.POST("/ReplayDeadLetter/:key", controller.ReplayDeadLetterController)
+		hook.POST("/DeleteDeadLetter/:key", controller.DeleteDeadLetterController)
+	}
 	//扫号
 	number := engine.Group(ver + "/number")
 	{
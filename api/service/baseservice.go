@@ -18,46 +18,58 @@ func init() {
 	msgCache = gcache.New()
 }
 
+// RemoveWSApp 删除后关闭账号 释放连接和数据库
 func RemoveWSApp(k string) {
-	appCache.Remove(k)
+	v, _ := appCache.Remove(k)
+	if a, ok := v.(*app.WaApp); ok && a != nil {
+		a.Close()
+	}
+}
+
+// LookupWSApp 缓存中的账号 不检查登录状态
+func LookupWSApp(k string) (*app.WaApp, bool) {
+	v, err := appCache.Get(k)
+	if err != nil || v == nil {
+		return nil, false
+	}
+	a, ok := v.(*app.WaApp)
+	return a, ok && a != nil
 }
 
-// GetWSApp
+// GetWSApp 在线时返回 true
+// supervisor 没有停止时 连接中 等待重连 或者还没有处理断开 返回账号和 false 不删除
+// 停止后掉线 断开 网络异常的账号可以重新登录 也不删除 由调用方根据 GetLoginStatus 处理
+// 被封 或者登录失败并且不再重连时删除并关闭账号
 func GetWSApp(k string) (*app.WaApp, bool) {
-	existence, _ := appCache.Contains(k)
-	if existence {
-		a, err := appCache.Get(k)
-		if err != nil {
-			return nil, false
-		}
-		v := a.(*app.WaApp)
-		/*Online // 上线 认证成功后上线(连接成功的)
-		Drops  // 掉线 认证成功后掉线 （连接断开）
-		AuthFailed      // 认证失败 (连接成功后认证失败)
-		Banned          // 被禁止使用
-		HandshakeFailed // 握手失败
-		Connect         // 连接成功
-		Disconnect      // 断开连接*/
-		fmt.Println("账号:[", v.GetUserName(), "状态=", v.GetLoginStatus().String(), "]")
-		if v.GetLoginStatus() == app.Banned {
-			RemoveWSApp(k)
-			return nil, false
-		}
-		if v.GetLoginStatus() == app.Drops {
-			RemoveWSApp(k)
-			return nil, false
-		}
-		if v.GetLoginStatus() == app.Disconnect {
-			RemoveWSApp(k)
-			return nil, false
-		}
-		if v.GetLoginStatus() != app.Online {
-			RemoveWSApp(k)
-			return nil, false
-		}
+	v, exist := LookupWSApp(k)
+	if !exist {
+		return nil, false
+	}
+	/*Online // 上线 认证成功后上线(连接成功的)
+	Drops  // 掉线 认证成功后掉线 （连接断开）
+	AuthFailed      // 认证失败 (连接成功后认证失败)
+	Banned          // 被禁止使用
+	HandshakeFailed // 握手失败
+	Connect         // 连接成功
+	Disconnect      // 断开连接
+	NETNOT          // 网络异常*/
+	status := v.GetLoginStatus()
+	state := v.ConnState()
+	fmt.Println("账号:[", v.GetUserName(), "状态=", status.String(), "连接=", state.String(), "]")
+	switch {
+	case status == app.Online:
 		return v, true
+	case status == app.Banned || state == app.StateBanned:
+		RemoveWSApp(k)
+		return nil, false
+	case state != app.StateStopped:
+		return v, false
+	case status == 0 || status == app.Drops || status == app.Connect || status == app.Disconnect || status == app.NETNOT:
+		return v, false
+	default:
+		RemoveWSApp(k)
+		return nil, false
 	}
-	return nil, false
 }
 
 // CreateWSApp
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"ws-go/protocol/app"
+	"ws-go/protocol/event"
+	"ws-go/protocol/testserver"
+)
+
+// testConfig 开启自动重连
+const testConfig = testserver.Config + `
+[reconnect]
+    enabled        = true
+    initialBackoff = "10ms"
+    jitter         = 0
+`
+
+// newTestApp 创建一个连接本地测试服务器的账号 保存在缓存中
+func newTestApp(t *testing.T, s *testserver.Server, username uint64) *app.WaApp {
+	t.Helper()
+	testserver.Workspace(t, testConfig)
+	if app.WXServer == nil {
+		app.ServerStart()
+	}
+	payload, staticKey := testserver.Account(t, username)
+	info := app.EmptyAccountInfo()
+	info.SetCliPayload(payload)
+	if err := info.SetStaticHdKeys(staticKey.Private, staticKey.Public); err != nil {
+		t.Fatal(err)
+	}
+	w, err := CreateWSApp(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetServerAddr(s.Addr())
+	t.Cleanup(func() { RemoveWSApp(info.GetUserName()) })
+	return w
+}
+
+func newTestServer(t *testing.T, scenario testserver.Scenario) *testserver.Server {
+	t.Helper()
+	s, err := testserver.NewServer(scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// waitEvent 等待满足条件的事件
+func waitEvent(t *testing.T, events *event.Subscription, name string, match func(e event.Event) bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case env := <-events.C():
+			if match(env.Event) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("wait %s time out", name)
+		}
+	}
+}
+
+func login(t *testing.T, w *app.WaApp) {
+	t.Helper()
+	if any, err := w.WALogin().GetResult(); err != nil || any != app.Online {
+		t.Fatalf("login result = %v, err = %v", any, err)
+	}
+}
+
+// assertCached 接口调用时账号不在线 但是没有被删除和关闭
+func assertCached(t *testing.T, w *app.WaApp) {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		if v, online := GetWSApp(w.GetUserName()); v != w || online {
+			t.Fatalf("GetWSApp = %p %v, want %p false state = %s", v, online, w, w.ConnState())
+		}
+	}
+	if v, ok := LookupWSApp(w.GetUserName()); !ok || v != w {
+		t.Fatal("app removed from cache")
+	}
+}
+
+// 自动重连期间调用接口 账号不会被删除 重连后重新在线
+func TestGetWSApp_DuringReconnect(t *testing.T) {
+	// 第一次上线后服务器直接关闭连接
+	s := newTestServer(t, testserver.Sequence(testserver.SendSuccess(), testserver.ReadUntil("presence")))
+	w := newTestApp(t, s, 8613800000101)
+	events := w.Events().Subscribe(64, event.TypeConnectionTransition, event.TypeConnectionStateChanged)
+	defer events.Close()
+	transition := func(to app.ConnState) func(e event.Event) bool {
+		return func(e event.Event) bool {
+			tr, ok := e.(*event.ConnectionTransition)
+			return ok && tr.To == to.String()
+		}
+	}
+
+	login(t, w)
+	// 重连的连接握手后等待放行才返回登录成功
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(unblock)
+	s.SetScenario(func(c *testserver.Conn) error {
+		<-release
+		return testserver.Success()(c)
+	})
+
+	waitEvent(t, events, "Backoff", transition(app.StateBackoff))
+	assertCached(t, w)
+	waitEvent(t, events, "Authenticating", transition(app.StateAuthenticating))
+	assertCached(t, w)
+
+	// 和登录接口一样 重连期间重新登录返回这次重连的结果
+	retry := w.RetryLogin()
+	if retry == nil {
+		t.Fatal("RetryLogin returned nil while reconnecting")
+	}
+	unblock()
+	if any, err := retry.GetResult(); err != nil || any != app.Online {
+		t.Fatalf("retry login result = %v, err = %v", any, err)
+	}
+	waitEvent(t, events, "Online", func(e event.Event) bool {
+		changed, ok := e.(*event.ConnectionStateChanged)
+		return ok && changed.State == app.Online.String()
+	})
+	if v, online := GetWSApp(w.GetUserName()); v != w || !online {
+		t.Fatalf("GetWSApp = %p %v, want online", v, online)
+	}
+}
+
+// 连接出错后还没有处理断开时 supervisor 还是 Online 不能删除账号
+func TestGetWSApp_NetworkErrorBeforeDisconnect(t *testing.T) {
+	s := newTestServer(t, testserver.Success())
+	w := newTestApp(t, s, 8613800000102)
+
+	login(t, w)
+	w.SetLoginStatus(app.NETNOT)
+	assertCached(t, w)
+	if w.ConnState() != app.StateOnline {
+		t.Fatalf("state = %s, want Online", w.ConnState())
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"ws-go/api/dto"
+	"ws-go/api/vo"
+	"ws-go/protocol/stores"
+)
+
+// 每次最多返回的会话数和消息数
+const (
+	maxChatListLimit    = 500
+	maxChatHistoryLimit = 200
+)
+
+// GetChatsService 有收到消息的会话 按最后一条消息倒序
+func GetChatsService(k string, dto dto.ChatListDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	limit := dto.Limit
+	if limit <= 0 || limit > maxChatListLimit {
+		limit = maxChatListLimit
+	}
+	chats, err := app.GetChats(limit)
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(chats, app.GetPlatform(), "成功")
+}
+
+// GetChatHistoryService 会话中收到的消息 Before 为上一页最后一条消息的 id
+func GetChatHistoryService(k string, dto dto.ChatHistoryDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	if isEmpty(dto.ToWid) {
+		return vo.ParameterError("ToWid", "会话不能为空")
+	}
+	limit := dto.Limit
+	if limit <= 0 || limit > maxChatHistoryLimit {
+		limit = maxChatHistoryLimit
+	}
+	messages, err := app.GetChatHistory(dto.ToWid, dto.SentGroup, dto.Before, limit)
+	if errors.Is(err, stores.ErrMessageNotFound) {
+		return vo.ParameterError("Before", "消息不存在")
+	}
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(messages, app.GetPlatform(), "成功")
+}
+
+// GetMessageService 按消息 id 查询收到的消息
+func GetMessageService(k string, dto dto.MessageStatusDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	if isEmpty(dto.MsgId) {
+		return vo.ParameterError("MsgId", "消息 id 不能为空")
+	}
+	message, err := app.GetMessage(dto.MsgId)
+	if errors.Is(err, stores.ErrMessageNotFound) {
+		return vo.ParameterError("MsgId", "消息不存在")
+	}
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(message, app.GetPlatform(), "成功")
+}
+
+// GetQuarantinedService 无法解密的消息 Sender 为空时返回所有的
+func GetQuarantinedService(k string, dto dto.QuarantinedListDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	limit := dto.Limit
+	if limit <= 0 || limit > maxChatHistoryLimit {
+		limit = maxChatHistoryLimit
+	}
+	messages, err := app.GetQuarantined(dto.Sender, limit)
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(messages, app.GetPlatform(), "成功")
+}
+
+// GetQuarantinedMessageService 按消息 id 查询无法解密的消息
+func GetQuarantinedMessageService(k string, dto dto.MessageStatusDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	if isEmpty(dto.MsgId) {
+		return vo.ParameterError("MsgId", "消息 id 不能为空")
+	}
+	message, err := app.GetQuarantinedMessage(dto.MsgId)
+	if errors.Is(err, stores.ErrMessageNotFound) {
+		return vo.ParameterError("MsgId", "消息不存在")
+	}
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(message, app.GetPlatform(), "成功")
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"ws-go/api/vo"
+	"ws-go/protocol/event"
+	"ws-go/protocol/webhook"
+)
+
+// defaultStreamTypes 没有指定 types 时推送的事件
+var defaultStreamTypes = []event.Type{event.TypeMessageReceived, event.TypeReceiptUpdated, event.TypeConnectionStateChanged}
+
+// SubscribeEventsService 订阅账号的事件 lastEventId 不为空时先发送之后保存的事件
+// resumed 为 false 时 lastEventId 之后的部分事件已经丢失
+// 掉线和重连时也可以订阅 才能收到连接状态的变化
+func SubscribeEventsService(k, lastEventId, types string) (sub *event.Subscription, resumed bool, resp *vo.Resp) {
+	app, isExist := LookupWSApp(k)
+	if !isExist {
+		r := vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		return nil, false, &r
+	}
+	eventTypes, err := webhook.ParseTypes(types)
+	if err != nil {
+		r := vo.ParameterError("types", err.Error())
+		return nil, false, &r
+	}
+	if len(eventTypes) == 0 {
+		eventTypes = defaultStreamTypes
+	}
+	lastEventId = strings.TrimSpace(lastEventId)
+	if lastEventId == "" {
+		return app.Events().Subscribe(event.DefaultBuffer, eventTypes...), true, nil
+	}
+	seq, err := strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		r := vo.ParameterError("Last-Event-ID", "需要是事件的 seq")
+		return nil, false, &r
+	}
+	sub, resumed = app.Events().SubscribeSince(seq, event.DefaultBuffer, eventTypes...)
+	return sub, resumed, nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"ws-go/api/dto"
+	"ws-go/api/vo"
+)
+
+// GetSafetyNumberService 获取和联系人的安全码
+func GetSafetyNumberService(k string, dto dto.SafetyNumberDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	safetyNumber, err := app.SafetyNumber(dto.ToWid)
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(safetyNumber, app.GetPlatform(), "成功")
+}
+
+// VerifySafetyNumberService 验证扫描的安全码二维码 匹配时保存为已经验证
+func VerifySafetyNumberService(k string, dto dto.VerifySafetyNumberDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	scan, err := base64.StdEncoding.DecodeString(dto.Scan)
+	if err != nil {
+		return vo.ParameterError("Scan", "二维码数据不是 base64")
+	}
+	verified, err := app.VerifySafetyNumber(dto.ToWid, scan)
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(gin.H{
+		"toWid":    dto.ToWid,
+		"verified": verified,
+	}, app.GetPlatform(), "成功")
+}
+
+// SetIdentityVerifiedService 手动设置联系人的安全码是否已经验证
+func SetIdentityVerifiedService(k string, dto dto.SetIdentityVerifiedDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	if err := app.SetIdentityVerified(dto.ToWid, dto.Verified); err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(gin.H{
+		"toWid":    dto.ToWid,
+		"verified": dto.Verified,
+	}, app.GetPlatform(), "成功")
+}
+
+// ApproveIdentityService 确认联系人新的身份密钥 block 策略下解除阻止
+func ApproveIdentityService(k string, dto dto.ApproveIdentityDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	if dto.ToWid == "" || dto.NewKey == "" {
+		return vo.IncompleteParameters()
+	}
+	if err := app.ApproveIdentity(dto.ToWid, dto.NewKey); err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(gin.H{
+		"toWid":    dto.ToWid,
+		"approved": true,
+	}, app.GetPlatform(), "成功")
+}
@@ -43,6 +43,15 @@ func LoginService(dto dto.LoginDto) vo.Resp {
 		routingInfo, _ := base64.StdEncoding.DecodeString(dto.EdgeRouting)
 		emptyAccountInfo.SetRoutingInfo(routingInfo)
 	}
+	if err := emptyAccountInfo.SetServerStaticBase64(dto.ServerStaticPubKey); err != nil {
+		return vo.ParameterError("ServerStaticPubKey", err.Error())
+	}
+	// 没有传入时使用上次登录保存的服务器公钥
+	if dto.ServerStaticPubKey == "" {
+		if err := emptyAccountInfo.LoadServerStatic(); err != nil {
+			wslog.GetLogger().Ctx(emptyAccountInfo.Ctx()).Debug("load server static:", err)
+		}
+	}
 	if dto.IdentityPriKey != "" {
 		emptyAccountInfo.SetStaticPriKey(dto.IdentityPriKey)
 	}
@@ -59,8 +68,8 @@ func LoginService(dto dto.LoginDto) vo.Resp {
 		app   *app2.WaApp
 		exist bool
 	)
-	// does it exist
-	if app, exist = GetWSApp(emptyAccountInfo.GetUserName()); exist {
+	// does it exist 掉线或者正在重连的账号继续使用
+	if app, exist = LookupWSApp(emptyAccountInfo.GetUserName()); exist {
 		// TODO 重新上线？
 		status := app.GetLoginStatus()
 		if status == app2.Online {
@@ -99,7 +108,10 @@ func LoginService(dto dto.LoginDto) vo.Resp {
 	// login result
 	loginState, ok := Any.(app2.LoginStatus)
 	if !ok || loginState != app2.Online {
-		RemoveWSApp(emptyAccountInfo.GetUserName())
+		// 登录失败并且不会自动重连时关闭账号
+		if !app.Reconnecting() {
+			RemoveWSApp(emptyAccountInfo.GetUserName())
+		}
 	} else {
 		// set new message notify
 		app.SetNewMessageNotify(func(message *entity.ChatMessage) {
@@ -122,12 +134,13 @@ func LoginService(dto dto.LoginDto) vo.Resp {
 
 // 退出登录
 func LogOutService(k string) vo.Resp {
-	app, isExist := GetWSApp(k)
+	// 掉线或者正在重连的账号也可以退出
+	app, isExist := LookupWSApp(k)
 	if !isExist {
 		return vo.AnErrorOccurred(fmt.Errorf("账号%s已下线", k))
 	}
-	app.NewtWorkClose()
-	app.SetLoginStatusOne(app2.Disconnect)
+	app.StopReconnect("logout")
+	// 关闭连接 webhook 和数据库
 	RemoveWSApp(app.GetUserName())
 	//登录成功开启
 	connectMgr := app2.WXServer.GetWXConnectMgr()
@@ -202,6 +215,24 @@ func SetNetWorkProxyService(k string, dto dto.SetNetWorkProxyDto) vo.Resp {
 	}, app.GetPlatform(), "ok")
 }
 
+// GetInboundMetricsService 收到的帧等待处理的数量和延迟
+func GetInboundMetricsService(k string) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		return vo.AnErrorOccurred(fmt.Errorf("账号%s已下线", k))
+	}
+	return vo.Success(app.InboundMetrics(), app.GetPlatform(), "ok")
+}
+
+// GetKeepaliveStatsService 心跳的往返时间和没有回复的次数
+func GetKeepaliveStatsService(k string) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		return vo.AnErrorOccurred(fmt.Errorf("账号%s已下线", k))
+	}
+	return vo.Success(app.KeepaliveStats(), app.GetPlatform(), "ok")
+}
+
 // HasUnsentPreKeysService
 func HasUnsentPreKeysService(k string) vo.Resp {
 	app, isExist := GetWSApp(k)
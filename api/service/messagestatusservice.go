@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"ws-go/api/dto"
+	"ws-go/api/vo"
+	"ws-go/protocol/msg"
+)
+
+// 每次最多返回的消息数
+const maxChatMessageStatusLimit = 200
+
+// GetMessageStatusService 按消息 id 查询发送状态和每个接收者的回执
+func GetMessageStatusService(k string, dto dto.MessageStatusDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	if isEmpty(dto.MsgId) {
+		return vo.ParameterError("MsgId", "消息 id 不能为空")
+	}
+	myMsg, err := app.GetMyMsg(dto.MsgId)
+	if errors.Is(err, msg.ErrMsgNotFound) {
+		return vo.ParameterError("MsgId", "消息不存在")
+	}
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(messageStatus(myMsg), app.GetPlatform(), "成功")
+}
+
+// GetChatMessageStatusService 查询会话中发送的消息的状态 按发送顺序倒序
+func GetChatMessageStatusService(k string, dto dto.ChatMessageStatusDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	if isEmpty(dto.ToWid) {
+		return vo.ParameterError("ToWid", "会话不能为空")
+	}
+	limit := dto.Limit
+	if limit <= 0 || limit > maxChatMessageStatusLimit {
+		limit = maxChatMessageStatusLimit
+	}
+	myMsgs, err := app.GetChatMyMsg(dto.ToWid, dto.SentGroup, dto.Before, limit)
+	if errors.Is(err, msg.ErrMsgNotFound) {
+		return vo.ParameterError("Before", "消息不存在")
+	}
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	list := make([]gin.H, 0, len(myMsgs))
+	for _, myMsg := range myMsgs {
+		list = append(list, messageStatus(myMsg))
+	}
+	return vo.Success(list, app.GetPlatform(), "成功")
+}
+
+// messageStatus 状态同时返回数字和名称
+func messageStatus(myMsg *msg.MySendMsg) gin.H {
+	recipients := make([]gin.H, 0, len(myMsg.Recipients))
+	for _, r := range myMsg.Recipients {
+		recipients = append(recipients, gin.H{
+			"recipient":  r.Recipient,
+			"status":     r.Status,
+			"statusText": r.Status.String(),
+			"updatedAt":  r.UpdatedAt,
+		})
+	}
+	return gin.H{
+		"id":         myMsg.Id,
+		"to":         myMsg.To,
+		"type":       myMsg.MsgType,
+		"content":    myMsg.Content,
+		"timestamp":  myMsg.Timestamp,
+		"status":     myMsg.Status,
+		"statusText": myMsg.Status.String(),
+		"error":      myMsg.Error,
+		"recipients": recipients,
+	}
+}
@@ -52,7 +52,6 @@ func GenAuthDataService(dto dto.AuthDataDto) vo.Resp {
 	} else {
 		return vo.AnErrorOccurred(fmt.Errorf("platform参数不正确%s", dto.UserAgent.Platform.String()))
 	}
-	fmt.Sprintln("platform参数=%s", dto.UserAgent.Platform.String())
 	// set locale
 	if dto.UserAgent.LocaleLanguageIso_639_1 == nil {
 		dto.UserAgent.LocaleLanguageIso_639_1 = proto.String("zh")
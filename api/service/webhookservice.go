@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/url"
+	"strings"
+	"ws-go/api/dto"
+	"ws-go/api/vo"
+	"ws-go/protocol/webhook"
+)
+
+// AddWebhookService 添加 webhook 返回的 secret 用于校验签名
+func AddWebhookService(k string, dto dto.WebhookDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	if u, err := url.Parse(dto.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return vo.ParameterError("Url", "需要 http 或 https 地址")
+	}
+	types, err := webhook.ParseTypes(strings.Join(dto.Types, ","))
+	if err != nil {
+		return vo.ParameterError("Types", err.Error())
+	}
+	hook, err := app.Webhooks().AddHook(dto.Url, dto.Secret, types)
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(hook, app.GetPlatform(), "成功")
+}
+
+// GetWebhooksService secret 只返回前几位
+func GetWebhooksService(k string) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	hooks, err := app.Webhooks().Hooks()
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	for _, hook := range hooks {
+		if len(hook.Secret) > 4 {
+			hook.Secret = hook.Secret[:4] + "****"
+		}
+	}
+	return vo.Success(hooks, app.GetPlatform(), "成功")
+}
+
+// DeleteWebhookService
+func DeleteWebhookService(k string, dto dto.WebhookIdDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	err := app.Webhooks().DeleteHook(dto.Id)
+	if errors.Is(err, webhook.ErrNotFound) {
+		return vo.ParameterError("Id", "webhook 不存在")
+	}
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(gin.H{"id": dto.Id}, app.GetPlatform(), "成功")
+}
+
+// GetDeadLettersService 重试后仍然失败的推送
+func GetDeadLettersService(k string, dto dto.DeadLetterDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	letters, err := app.Webhooks().DeadLetters(dto.HookId, dto.Limit)
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(letters, app.GetPlatform(), "成功")
+}
+
+// ReplayDeadLetterService 重新推送一次 成功后删除死信
+func ReplayDeadLetterService(k string, dto dto.WebhookIdDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	err := app.Webhooks().Replay(context.Background(), dto.Id)
+	if errors.Is(err, webhook.ErrNotFound) {
+		return vo.ParameterError("Id", "死信或者 webhook 不存在")
+	}
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(gin.H{"id": dto.Id}, app.GetPlatform(), "成功")
+}
+
+// DeleteDeadLetterService
+func DeleteDeadLetterService(k string, dto dto.WebhookIdDto) vo.Resp {
+	app, isExist := GetWSApp(k)
+	if !isExist {
+		if app == nil {
+			return vo.AnErrorOccurred(fmt.Errorf("账号%s不在线,请重新登录", k))
+		}
+		return vo.FailedStatue(app.GetLoginStatus(), app.GetLoginStatus().String())
+	}
+	err := app.Webhooks().DeleteDeadLetter(dto.Id)
+	if errors.Is(err, webhook.ErrNotFound) {
+		return vo.ParameterError("Id", "死信不存在")
+	}
+	if err != nil {
+		return vo.AnErrorOccurred(err)
+	}
+	return vo.Success(gin.H{"id": dto.Id}, app.GetPlatform(), "成功")
+}
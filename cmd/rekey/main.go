@@ -0,0 +1,54 @@
+// rekey 离线修改 axolotl 数据库的 KEK 执行前先停止 api 程序
+// 读取 config.toml 中的 axolotl.backend sqlite 修改 -dir 下的数据库 mysql pgsql 修改 axolotl.link 的共用数据库
+//
+//	rekey -dir wadata -new new.key             明文数据库全部加密
+//	rekey -dir wadata -old old.key -new new.key 更换 KEK
+//	rekey -dir wadata -old old.key             解密为明文
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"ws-go/protocol/axolotl"
+	"ws-go/protocol/axolotl/store"
+	"ws-go/protocol/define"
+)
+
+func main() {
+	dir := flag.String("dir", define.DefaultDbPath, "账号数据目录")
+	oldKey := flag.String("old", "", "旧的 key 文件 为空时数据库是明文")
+	newKey := flag.String("new", "", "新的 key 文件 为空时解密为明文")
+	flag.Parse()
+	if *oldKey == "" && *newKey == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	from, err := readSealer(*oldKey)
+	if err != nil {
+		fail(err)
+	}
+	to, err := readSealer(*newKey)
+	if err != nil {
+		fail(err)
+	}
+	result, err := axolotl.Rekey(*dir, from, to)
+	for account, n := range result {
+		fmt.Printf("%s: %d rows\n", account, n)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func readSealer(path string) (*store.Sealer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return axolotl.ReadSealer(path)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "rekey:", err)
+	os.Exit(1)
+}
@@ -11,7 +11,7 @@ func NewFingerprint(displayFingerprint *Display) *Fingerprint {
 // fingerprint for identity verification.
 type Fingerprint struct {
 	fingerprintDisplay *Display
-	fingerprintScan    string
+	fingerprintScan    *Scannable
 }
 
 // Display will return a fingerprint display structure for getting a
@@ -22,6 +22,6 @@ func (f *Fingerprint) Display() *Display {
 
 // Scan will return a fingerprint scan structure for getting a scannable
 // representation of given keys.
-func (f *Fingerprint) Scan() string {
+func (f *Fingerprint) Scan() *Scannable {
 	return f.fingerprintScan
 }
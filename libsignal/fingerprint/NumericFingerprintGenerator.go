@@ -0,0 +1,84 @@
+package fingerprint
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"sort"
+	"ws-go/libsignal/keys/identity"
+)
+
+// fingerprintVersion is the version prepended to the hash input.
+const fingerprintVersion = 0
+
+// DefaultIterations is the number of hash iterations recommended
+// by the Signal protocol.
+const DefaultIterations = 5200
+
+// NewNumericFingerprintGenerator will return a new fingerprint generator
+// that hashes the identity keys with the given number of iterations.
+func NewNumericFingerprintGenerator(iterations int) *NumericFingerprintGenerator {
+	return &NumericFingerprintGenerator{iterations: iterations}
+}
+
+// NumericFingerprintGenerator generates numeric "safety numbers" and
+// scannable fingerprints for a pair of identities.
+type NumericFingerprintGenerator struct {
+	iterations int
+}
+
+// CreateFor will return a fingerprint for the given local and remote
+// identities.
+func (n *NumericFingerprintGenerator) CreateFor(localStableIdentifier, remoteStableIdentifier string,
+	localIdentityKey, remoteIdentityKey *identity.Key) *Fingerprint {
+
+	return n.CreateForMultiple(
+		localStableIdentifier, remoteStableIdentifier,
+		[]*identity.Key{localIdentityKey}, []*identity.Key{remoteIdentityKey},
+	)
+}
+
+// CreateForMultiple will return a fingerprint for the given local and
+// remote identities, each of which may have multiple identity keys.
+func (n *NumericFingerprintGenerator) CreateForMultiple(localStableIdentifier, remoteStableIdentifier string,
+	localIdentityKeys, remoteIdentityKeys []*identity.Key) *Fingerprint {
+
+	localFingerprint := n.getFingerprint(localStableIdentifier, localIdentityKeys)
+	remoteFingerprint := n.getFingerprint(remoteStableIdentifier, remoteIdentityKeys)
+
+	return &Fingerprint{
+		fingerprintDisplay: NewDisplay(localFingerprint, remoteFingerprint),
+		fingerprintScan:    NewScannable(scannableVersion, localFingerprint, remoteFingerprint),
+	}
+}
+
+// getFingerprint will iteratively hash the stable identifier and the
+// identity keys.
+func (n *NumericFingerprintGenerator) getFingerprint(stableIdentifier string, unsortedIdentityKeys []*identity.Key) []byte {
+	publicKey := logicalKeyBytes(unsortedIdentityKeys)
+
+	version := make([]byte, 2)
+	binary.BigEndian.PutUint16(version, fingerprintVersion)
+
+	hash := append(append(version, publicKey...), []byte(stableIdentifier)...)
+	for i := 0; i < n.iterations; i++ {
+		digest := sha512.New()
+		digest.Write(hash)
+		digest.Write(publicKey)
+		hash = digest.Sum(nil)
+	}
+	return hash
+}
+
+// logicalKeyBytes will return the serialized identity keys sorted in
+// byte order so the result doesn't depend on the input order.
+func logicalKeyBytes(identityKeys []*identity.Key) []byte {
+	keys := make([][]byte, len(identityKeys))
+	for i := range identityKeys {
+		keys[i] = identityKeys[i].PublicKey().Serialize()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return bytes.Join(keys, nil)
+}
@@ -0,0 +1,147 @@
+package fingerprint
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// scannableVersion is the version of the combined fingerprints structure.
+const scannableVersion = 1
+
+// scannableLength is the length each fingerprint is truncated to.
+const scannableLength = 32
+
+// Errors returned when comparing a scanned fingerprint.
+var (
+	ErrFingerprintVersion = errors.New("fingerprint version mismatch")
+	ErrFingerprintParsing = errors.New("fingerprint parsing failed")
+)
+
+// NewScannable will return a new scannable fingerprint.
+func NewScannable(version uint32, localFingerprint, remoteFingerprint []byte) *Scannable {
+	return &Scannable{
+		version:           version,
+		localFingerprint:  truncate(localFingerprint, scannableLength),
+		remoteFingerprint: truncate(remoteFingerprint, scannableLength),
+	}
+}
+
+// Scannable is a structure for a fingerprint that can be encoded
+// into a QR code and compared with the one scanned from the other side.
+type Scannable struct {
+	version           uint32
+	localFingerprint  []byte
+	remoteFingerprint []byte
+}
+
+// Serialize will return the scannable fingerprint as a CombinedFingerprints
+// protobuf message.
+func (s *Scannable) Serialize() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.version))
+	b = appendLogicalFingerprint(b, 2, s.localFingerprint)
+	b = appendLogicalFingerprint(b, 3, s.remoteFingerprint)
+	return b
+}
+
+// Compare will return true if the scanned fingerprint was created by the
+// other side of the conversation for the same pair of identity keys.
+func (s *Scannable) Compare(scanned []byte) (bool, error) {
+	version, local, remote, err := parseCombinedFingerprints(scanned)
+	if err != nil {
+		return false, err
+	}
+	if version != s.version {
+		return false, ErrFingerprintVersion
+	}
+	return subtle.ConstantTimeCompare(s.localFingerprint, remote) == 1 &&
+		subtle.ConstantTimeCompare(s.remoteFingerprint, local) == 1, nil
+}
+
+// appendLogicalFingerprint appends a LogicalFingerprint{content} field.
+func appendLogicalFingerprint(b []byte, num protowire.Number, content []byte) []byte {
+	var m []byte
+	m = protowire.AppendTag(m, 1, protowire.BytesType)
+	m = protowire.AppendBytes(m, content)
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// parseCombinedFingerprints parses the fields written by Serialize.
+func parseCombinedFingerprints(b []byte) (version uint32, local, remote []byte, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, nil, nil, ErrFingerprintParsing
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0, nil, nil, ErrFingerprintParsing
+			}
+			version, b = uint32(v), b[n:]
+		case (num == 2 || num == 3) && typ == protowire.BytesType:
+			m, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return 0, nil, nil, ErrFingerprintParsing
+			}
+			content, err := parseLogicalFingerprint(m)
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			if num == 2 {
+				local = content
+			} else {
+				remote = content
+			}
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return 0, nil, nil, ErrFingerprintParsing
+			}
+			b = b[n:]
+		}
+	}
+	if local == nil || remote == nil {
+		return 0, nil, nil, ErrFingerprintParsing
+	}
+	return version, local, remote, nil
+}
+
+func parseLogicalFingerprint(b []byte) ([]byte, error) {
+	var content []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, ErrFingerprintParsing
+		}
+		b = b[n:]
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, ErrFingerprintParsing
+			}
+			content, b = v, b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, ErrFingerprintParsing
+		}
+		b = b[n:]
+	}
+	return content, nil
+}
+
+func truncate(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
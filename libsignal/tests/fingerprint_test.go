@@ -24,3 +24,53 @@ func TestFingerprint(t *testing.T) {
 	fmt.Println(fp.DisplayText())
 
 }
+
+// TestNumericFingerprint will test that both sides of a conversation
+// generate the same safety number and accept each other's scan.
+func TestNumericFingerprint(t *testing.T) {
+	serializer := newSerializer()
+	alice := newUser("Alice", 1, serializer)
+	bob := newUser("Bob", 2, serializer)
+	eve := newUser("Eve", 3, serializer)
+
+	generator := fingerprint.NewNumericFingerprintGenerator(fingerprint.DefaultIterations)
+	aliceFingerprint := generator.CreateFor("+14152222222", "+14153333333",
+		alice.identityKeyPair.PublicKey(), bob.identityKeyPair.PublicKey())
+	bobFingerprint := generator.CreateFor("+14153333333", "+14152222222",
+		bob.identityKeyPair.PublicKey(), alice.identityKeyPair.PublicKey())
+
+	if aliceFingerprint.Display().DisplayText() != bobFingerprint.Display().DisplayText() {
+		t.Fatal("display fingerprints don't match")
+	}
+	if len(aliceFingerprint.Display().DisplayText()) != 60 {
+		t.Fatalf("display fingerprint length = %d", len(aliceFingerprint.Display().DisplayText()))
+	}
+	if ok, err := aliceFingerprint.Scan().Compare(bobFingerprint.Scan().Serialize()); err != nil || !ok {
+		t.Fatalf("alice compare = %v, %v", ok, err)
+	}
+	if ok, err := bobFingerprint.Scan().Compare(aliceFingerprint.Scan().Serialize()); err != nil || !ok {
+		t.Fatalf("bob compare = %v, %v", ok, err)
+	}
+	// Comparing with our own scan must fail.
+	if ok, _ := aliceFingerprint.Scan().Compare(aliceFingerprint.Scan().Serialize()); ok {
+		t.Fatal("own fingerprint should not match")
+	}
+
+	// A man in the middle changes the remote key.
+	eveFingerprint := generator.CreateFor("+14152222222", "+14153333333",
+		alice.identityKeyPair.PublicKey(), eve.identityKeyPair.PublicKey())
+	if eveFingerprint.Display().DisplayText() == bobFingerprint.Display().DisplayText() {
+		t.Fatal("display fingerprints should not match")
+	}
+	if ok, err := eveFingerprint.Scan().Compare(bobFingerprint.Scan().Serialize()); err != nil || ok {
+		t.Fatalf("eve compare = %v, %v", ok, err)
+	}
+
+	if _, err := aliceFingerprint.Scan().Compare([]byte{0xff}); err != fingerprint.ErrFingerprintParsing {
+		t.Fatalf("parse error = %v", err)
+	}
+	other := fingerprint.NewScannable(2, make([]byte, 32), make([]byte, 32)).Serialize()
+	if _, err := aliceFingerprint.Scan().Compare(other); err != fingerprint.ErrFingerprintVersion {
+		t.Fatalf("version error = %v", err)
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gogf/gf/util/gconv"
 	"github.com/golang/protobuf/proto"
 	"log"
+	"sync"
 	"time"
 	"ws-go/noise"
 	"ws-go/protocol/db"
@@ -25,6 +27,9 @@ type loginInfo struct {
 	routingInfo    []byte
 	staticPubKey   string
 	staticPriKey   string
+	// serverStatic 上次握手得到的服务器公钥 有值时使用 IK 握手 登录线程写入 使用 serverStaticMutex
+	serverStatic      []byte
+	serverStaticMutex sync.RWMutex
 }
 
 func (l *loginInfo) SetStaticPubKey(d string) {
@@ -41,6 +46,65 @@ func (l *loginInfo) SetRoutingInfo(d []byte) {
 	l.routingInfo = d
 }
 
+// SetServerStatic 设置服务器公钥
+func (l *loginInfo) SetServerStatic(d []byte) {
+	l.serverStaticMutex.Lock()
+	defer l.serverStaticMutex.Unlock()
+	l.serverStatic = d
+}
+
+// GetServerStatic 服务器公钥
+func (l *loginInfo) GetServerStatic() []byte {
+	l.serverStaticMutex.RLock()
+	defer l.serverStaticMutex.RUnlock()
+	return l.serverStatic
+}
+
+// serverStaticKey 保存服务器公钥的 redis key
+func serverStaticKey(userName string) string {
+	return fmt.Sprintf("serverStatic:%s", userName)
+}
+
+// SaveServerStatic 保存服务器公钥 程序重启后登录仍然可以使用 IK 握手
+func (a *AccountInfo) SaveServerStatic() error {
+	serverStatic := a.GetServerStatic()
+	if len(serverStatic) != 32 {
+		return nil
+	}
+	return db.SETObj(serverStaticKey(a.GetUserName()), base64.StdEncoding.EncodeToString(serverStatic))
+}
+
+// LoadServerStatic 读取上次保存的服务器公钥 没有保存时不变
+func (a *AccountInfo) LoadServerStatic() error {
+	var serverStatic string
+	if err := db.GETObj(serverStaticKey(a.GetUserName()), &serverStatic); err != nil {
+		return err
+	}
+	return a.SetServerStaticBase64(serverStatic)
+}
+
+// ClearServerStatic 服务器公钥失效时删除 下次登录使用 XX 握手
+func (a *AccountInfo) ClearServerStatic() error {
+	a.SetServerStatic(nil)
+	return db.DelObj(serverStaticKey(a.GetUserName()))
+}
+
+// SetServerStaticBase64 设置 base64 编码的服务器公钥
+func (l *loginInfo) SetServerStaticBase64(d string) error {
+	if d == "" {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(d)
+	if err != nil {
+		return err
+	}
+	if len(data) != 32 {
+		return errors.New("server static length not 32 bit")
+	}
+	l.SetServerStatic(data)
+	return nil
+}
+
 func (l *loginInfo) SetStaticHdBase64Keys(pri, pub string) error {
 	priData, err := base64.StdEncoding.DecodeString(pri)
 	if err != nil {
@@ -110,7 +174,7 @@ func (l *loginInfo) GetLoginSettings() *handshake.WAHandshakeSettings {
 			Private: l.priKey,
 			Public:  l.pubKey,
 		},
-		PeerStatic: []byte{},
+		PeerStatic: append([]byte{}, l.GetServerStatic()...),
 	}
 }
 
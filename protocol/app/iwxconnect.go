@@ -12,10 +12,6 @@ type IWXConnect interface {
 	GetWXAccount() *WaApp
 	// 获取设置长链接ID
 	GetWxConnID() uint32
-	// 等待 waitTimes后发送心跳包
-	SendHeartBeatWaitingSeconds(seconds uint32)
-	// 等待 waitTimes后发送心跳包
-	SendHeartWaitingSeconds(seconds uint32)
 	// 添加到长链接请求队列
 	SendToWXLongReqQueue(wxLongReq IWSRequest)
 }
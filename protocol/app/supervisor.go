@@ -0,0 +1,273 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ConnState 连接的状态 由 Supervisor 维护 LoginStatus 保持原来的含义
+type ConnState int32
+
+const (
+	StateStopped ConnState = iota
+	StateConnecting
+	StateHandshaking
+	StateAuthenticating
+	StateOnline
+	StateBackoff
+	StateBanned
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateStopped:
+		return "Stopped"
+	case StateConnecting:
+		return "Connecting"
+	case StateHandshaking:
+		return "Handshaking"
+	case StateAuthenticating:
+		return "Authenticating"
+	case StateOnline:
+		return "Online"
+	case StateBackoff:
+		return "Backoff"
+	case StateBanned:
+		return "Banned"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int32(s))
+	}
+}
+
+var ErrInvalidTransition = errors.New("invalid connection state transition")
+
+// stateTransitions 允许的状态变化 Stopped 和 Banned 只能由 Start 离开
+var stateTransitions = map[ConnState][]ConnState{
+	StateStopped:        {StateConnecting},
+	StateConnecting:     {StateHandshaking, StateBackoff, StateStopped},
+	StateHandshaking:    {StateAuthenticating, StateBackoff, StateStopped},
+	StateAuthenticating: {StateOnline, StateBackoff, StateStopped, StateBanned},
+	StateOnline:         {StateBackoff, StateStopped, StateBanned},
+	StateBackoff:        {StateConnecting, StateStopped, StateBanned},
+	StateBanned:         {StateConnecting},
+}
+
+// CanTransition
+func CanTransition(from, to ConnState) bool {
+	for _, s := range stateTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ReconnectConfig
+type ReconnectConfig struct {
+	// Enabled 为 false 时失败后直接 Stopped 和以前掉线不重连一样
+	Enabled bool
+	// 第 n 次失败后等待 InitialBackoff * Multiplier^(n-1) 不超过 MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter 等待时间随机增减的比例 0.2 为 ±20%
+	Jitter float64
+	// RetryBudget 连续失败的次数超过后 Stopped 0 为不限制 上线后重新计算
+	RetryBudget int
+}
+
+// DefaultReconnectConfig 默认不自动重连 需要在配置中开启
+func DefaultReconnectConfig() ReconnectConfig {
+	return ReconnectConfig{
+		Enabled:        false,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryBudget:    10,
+	}
+}
+
+// Backoff 第 attempt 次失败后等待的时间 random 返回 [0, 1)
+func (c ReconnectConfig) Backoff(attempt int, random float64) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := c.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(c.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if c.MaxBackoff > 0 && d > float64(c.MaxBackoff) {
+		d = float64(c.MaxBackoff)
+	}
+	d += d * c.Jitter * (2*random - 1)
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// StateTransition 每次状态变化通知一次 Attempt 为连续失败的次数 Backoff 为进入 Backoff 时等待的时间
+// Terminal 为 true 时不会自动重连
+type StateTransition struct {
+	From     ConnState
+	To       ConnState
+	Reason   string
+	Attempt  int
+	Backoff  time.Duration
+	Terminal bool
+}
+
+// Supervisor 一个账号的连接状态机 失败后按退避时间调用 reconnect
+type Supervisor struct {
+	mutex   sync.Mutex
+	state   ConnState
+	config  ReconnectConfig
+	attempt int
+	// timer Backoff 时等待重连 generation 防止取消后的 timer 继续执行
+	timer      *time.Timer
+	generation int
+
+	reconnect func()
+	notify    func(StateTransition)
+	random    func() float64
+	afterFunc func(d time.Duration, f func()) *time.Timer
+}
+
+// NewSupervisor reconnect 在 Backoff 结束 进入 Connecting 后调用
+// notify 在持有锁时按顺序调用 不能阻塞 也不能调用 Supervisor 的方法
+func NewSupervisor(config ReconnectConfig, reconnect func(), notify func(StateTransition)) *Supervisor {
+	return &Supervisor{
+		config:    config,
+		reconnect: reconnect,
+		notify:    notify,
+		random:    rand.Float64,
+		afterFunc: time.AfterFunc,
+	}
+}
+
+// State
+func (s *Supervisor) State() ConnState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// Attempt 连续失败的次数
+func (s *Supervisor) Attempt() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.attempt
+}
+
+// Start 手动登录 从 Stopped Banned 开始时重新计算失败次数 Backoff 时取消等待立即连接
+// 已经在连接或在线时不变 返回 false
+func (s *Supervisor) Start(reason string) bool {
+	s.mutex.Lock()
+	if s.state != StateStopped && s.state != StateBanned && s.state != StateBackoff {
+		s.mutex.Unlock()
+		return false
+	}
+	if s.state != StateBackoff {
+		s.attempt = 0
+	}
+	s.setState(StateConnecting, reason, 0, false)
+	s.mutex.Unlock()
+	return true
+}
+
+// Transition 连接过程中的状态变化 相同的状态忽略 不允许的变化返回 ErrInvalidTransition
+func (s *Supervisor) Transition(to ConnState, reason string) error {
+	s.mutex.Lock()
+	if s.state == to {
+		s.mutex.Unlock()
+		return nil
+	}
+	if !CanTransition(s.state, to) || to == StateBackoff {
+		from := s.state
+		s.mutex.Unlock()
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	if to == StateOnline {
+		s.attempt = 0
+	}
+	s.setState(to, reason, 0, false)
+	s.mutex.Unlock()
+	return nil
+}
+
+// Fail 连接 握手 认证失败或者断开 按退避时间重连 没有在连接或在线时忽略
+func (s *Supervisor) Fail(reason string) {
+	s.mutex.Lock()
+	switch s.state {
+	case StateStopped, StateBanned, StateBackoff:
+		s.mutex.Unlock()
+		return
+	}
+	s.attempt++
+	switch {
+	case !s.config.Enabled:
+		s.setState(StateStopped, reason, 0, true)
+	case s.config.RetryBudget > 0 && s.attempt > s.config.RetryBudget:
+		s.setState(StateStopped, fmt.Sprintf("retry budget exhausted: %s", reason), 0, true)
+	default:
+		backoff := s.config.Backoff(s.attempt, s.random())
+		s.setState(StateBackoff, reason, backoff, false)
+		generation := s.generation
+		s.timer = s.afterFunc(backoff, func() { s.retry(generation) })
+	}
+	s.mutex.Unlock()
+}
+
+// Stop 终止的失败 不再重连 例如认证失败 被其他设备登录 或者退出登录
+func (s *Supervisor) Stop(reason string) {
+	s.terminate(StateStopped, reason)
+}
+
+// Ban 账号被封
+func (s *Supervisor) Ban(reason string) {
+	s.terminate(StateBanned, reason)
+}
+
+func (s *Supervisor) terminate(to ConnState, reason string) {
+	s.mutex.Lock()
+	if s.state == to || !CanTransition(s.state, to) {
+		s.mutex.Unlock()
+		return
+	}
+	s.setState(to, reason, 0, true)
+	s.mutex.Unlock()
+}
+
+// retry Backoff 结束
+func (s *Supervisor) retry(generation int) {
+	s.mutex.Lock()
+	if s.generation != generation || s.state != StateBackoff {
+		s.mutex.Unlock()
+		return
+	}
+	s.setState(StateConnecting, fmt.Sprintf("reconnect attempt %d", s.attempt), 0, false)
+	s.mutex.Unlock()
+	if s.reconnect != nil {
+		s.reconnect()
+	}
+}
+
+// setState 需要持有锁 离开 Backoff 时取消等待
+func (s *Supervisor) setState(to ConnState, reason string, backoff time.Duration, terminal bool) {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.generation++
+	t := StateTransition{From: s.state, To: to, Reason: reason, Attempt: s.attempt, Backoff: backoff, Terminal: terminal}
+	s.state = to
+	if s.notify != nil {
+		s.notify(t)
+	}
+}
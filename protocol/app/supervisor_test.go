@@ -0,0 +1,176 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTimers 记录 Backoff 的等待 由测试触发
+type fakeTimers struct {
+	waits []time.Duration
+	fire  []func()
+}
+
+func (f *fakeTimers) afterFunc(d time.Duration, fn func()) *time.Timer {
+	f.waits = append(f.waits, d)
+	f.fire = append(f.fire, fn)
+	return time.NewTimer(time.Hour)
+}
+
+func newTestSupervisor(config ReconnectConfig) (*Supervisor, *fakeTimers, *[]StateTransition, *int) {
+	var transitions []StateTransition
+	reconnects := 0
+	s := NewSupervisor(config, func() { reconnects++ }, func(t StateTransition) {
+		transitions = append(transitions, t)
+	})
+	timers := &fakeTimers{}
+	s.afterFunc = timers.afterFunc
+	// 没有随机增减
+	s.random = func() float64 { return 0.5 }
+	return s, timers, &transitions, &reconnects
+}
+
+// enabledReconnectConfig 默认不重连 测试中开启
+func enabledReconnectConfig() ReconnectConfig {
+	c := DefaultReconnectConfig()
+	c.Enabled = true
+	return c
+}
+
+func online(t *testing.T, s *Supervisor) {
+	t.Helper()
+	for _, to := range []ConnState{StateHandshaking, StateAuthenticating, StateOnline} {
+		if err := s.Transition(to, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSupervisor_Reconnect(t *testing.T) {
+	if DefaultReconnectConfig().Enabled {
+		t.Fatal("reconnect should be disabled by default")
+	}
+	s, timers, transitions, reconnects := newTestSupervisor(enabledReconnectConfig())
+	if err := s.Transition(StateOnline, ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Stopped -> Online err = %v", err)
+	}
+	s.Start("login")
+	online(t, s)
+
+	s.Fail("disconnected")
+	if s.State() != StateBackoff || timers.waits[0] != time.Second {
+		t.Fatalf("state = %s, wait = %v", s.State(), timers.waits)
+	}
+	// 已经在 Backoff 时忽略
+	s.Fail("disconnected")
+	timers.fire[0]()
+	if s.State() != StateConnecting || *reconnects != 1 {
+		t.Fatalf("state = %s, reconnects = %d", s.State(), *reconnects)
+	}
+	s.Fail("dial failed")
+	timers.fire[1]()
+	s.Fail("handshake failed")
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}; len(timers.waits) != 3 || timers.waits[2] != want[2] {
+		t.Fatalf("waits = %v, want %v", timers.waits, want)
+	}
+	timers.fire[2]()
+	online(t, s)
+	if s.Attempt() != 0 {
+		t.Fatalf("attempt = %d after online", s.Attempt())
+	}
+
+	var path []string
+	for _, tr := range *transitions {
+		path = append(path, tr.To.String())
+	}
+	want := "Connecting Handshaking Authenticating Online Backoff Connecting Backoff Connecting Backoff Connecting Handshaking Authenticating Online"
+	if got := strings.Join(path, " "); got != want {
+		t.Fatalf("transitions\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestSupervisor_Terminal(t *testing.T) {
+	s, timers, transitions, _ := newTestSupervisor(enabledReconnectConfig())
+	s.Start("login")
+	_ = s.Transition(StateHandshaking, "")
+	_ = s.Transition(StateAuthenticating, "")
+	s.Ban("login failure: 403")
+	s.Fail("disconnected")
+	if s.State() != StateBanned || len(timers.waits) != 0 {
+		t.Fatalf("state = %s, waits = %v", s.State(), timers.waits)
+	}
+	if last := (*transitions)[len(*transitions)-1]; !last.Terminal {
+		t.Fatalf("last transition %+v is not terminal", last)
+	}
+
+	// 手动登录后 Backoff 中停止 等待的重连不再执行
+	s.Start("login")
+	s.Fail("dial failed")
+	s.Stop("logout")
+	timers.fire[0]()
+	if s.State() != StateStopped {
+		t.Fatalf("state = %s, want Stopped", s.State())
+	}
+}
+
+func TestSupervisor_RetryBudget(t *testing.T) {
+	config := enabledReconnectConfig()
+	config.RetryBudget = 2
+	s, timers, transitions, reconnects := newTestSupervisor(config)
+	s.Start("login")
+	for i := 0; i < 3; i++ {
+		s.Fail("dial failed")
+		if i < 2 {
+			timers.fire[i]()
+		}
+	}
+	last := (*transitions)[len(*transitions)-1]
+	if s.State() != StateStopped || !last.Terminal || !strings.HasPrefix(last.Reason, "retry budget exhausted") {
+		t.Fatalf("state = %s, last = %+v", s.State(), last)
+	}
+	if *reconnects != 2 {
+		t.Fatalf("reconnects = %d, want 2", *reconnects)
+	}
+
+	config.Enabled = false
+	s, timers, _, _ = newTestSupervisor(config)
+	s.Start("login")
+	s.Fail("disconnected")
+	if s.State() != StateStopped || len(timers.waits) != 0 {
+		t.Fatalf("disabled: state = %s, waits = %v", s.State(), timers.waits)
+	}
+}
+
+func TestReconnectConfig_Backoff(t *testing.T) {
+	config := enabledReconnectConfig()
+	if d := config.Backoff(20, 0.5); d != config.MaxBackoff {
+		t.Fatalf("backoff = %v, want max %v", d, config.MaxBackoff)
+	}
+	if d := config.Backoff(1, 0); d != 800*time.Millisecond {
+		t.Fatalf("backoff with jitter = %v, want 800ms", d)
+	}
+	if d := config.Backoff(1, 0.999); d <= time.Second || d > 1200*time.Millisecond {
+		t.Fatalf("backoff with jitter = %v", d)
+	}
+}
+
+// 手动重新登录在 Backoff 中取消等待 已经在连接时不会再开始一次
+func TestSupervisor_StartDuringBackoff(t *testing.T) {
+	s, timers, _, reconnects := newTestSupervisor(enabledReconnectConfig())
+	if !s.Start("login") {
+		t.Fatal("Start from Stopped = false")
+	}
+	s.Fail("disconnected")
+	if !s.Start("retry login") {
+		t.Fatal("Start from Backoff = false")
+	}
+	if s.Start("retry login") {
+		t.Fatal("Start while Connecting = true")
+	}
+	timers.fire[0]()
+	if s.State() != StateConnecting || *reconnects != 0 {
+		t.Fatalf("state = %s, reconnects = %d", s.State(), *reconnects)
+	}
+}
@@ -1,9 +1,11 @@
 package app
 
 import (
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/gogf/gf/frame/g"
 	"github.com/gogf/gf/util/gconv"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -12,25 +14,32 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"ws-go/noise"
 	"ws-go/protocol/axolotl"
+	"ws-go/protocol/axolotl/store"
 	"ws-go/protocol/db"
 	"ws-go/protocol/define"
 	"ws-go/protocol/entity"
+	"ws-go/protocol/event"
 	"ws-go/protocol/handlers"
+	"ws-go/protocol/handshake"
 	_interface "ws-go/protocol/iface"
 	"ws-go/protocol/impl"
+	"ws-go/protocol/keepalive"
 	"ws-go/protocol/msg"
 	"ws-go/protocol/network"
 	"ws-go/protocol/newxxmp"
 	"ws-go/protocol/node"
+	"ws-go/protocol/stores"
 	"ws-go/protocol/utils/promise"
+	"ws-go/protocol/webhook"
 	"ws-go/waver"
 	"ws-go/wslog"
 )
 
-// WSAppEvent
+// WSAppEvent 只有新消息的回调 其他事件通过 Events() 订阅
 type WSAppEvent struct {
 	NewChatMessageNotify func(message *entity.ChatMessage)
 }
@@ -60,6 +69,8 @@ func (l LoginStatus) String() string {
 		return "HandshakeFailed"
 	case Banned:
 		return "Banned"
+	case NETNOT:
+		return "NETNOT"
 	default:
 		return ""
 	}
@@ -81,44 +92,92 @@ const (
 
 // NewWaAppCli
 func NewWaAppCli(info *AccountInfo) *WaApp {
-	// TODO 应该修改成针对不同的用户不同的版本
+	// 每个账号根据平台使用自己的字典
+	var codec *newxxmp.Codec
 	platform := info.clientPayload.UserAgent.GetPlatform().String()
 	//如果为安卓普通版
 	if platform == "ANDROID" {
-		newxxmp.SetWAXXMPVersion(waver.NewWA42())
+		codec = newxxmp.NewCodec(waver.NewWA42())
 	} else if platform == "PLATFORM_10" {
 		//安卓企业版
-		newxxmp.SetWAXXMPVersion(waver.NewBusinessWA42())
+		codec = newxxmp.NewCodec(waver.NewBusinessWA42())
 	} else {
 		return nil
 	}
 	// set log context
 	info.SetLogCtx(define.LOGKEYSUSERNAME, info.GetUserName())
 	// create whatsapp client
-	w := &WaApp{loginPromise: impl.NewResultPromise(), AccountInfo: info, WSAppEvent: &WSAppEvent{}}
-	// msg manager
-	msgManager := msg.NewManager()
-	w.msgManager = msgManager
+	w := &WaApp{loginPromise: impl.NewResultPromise(), AccountInfo: info, WSAppEvent: &WSAppEvent{}, codec: codec}
+	w.events = event.NewBus(info.GetUserName())
+	w.events.SetHistory(g.Cfg().GetInt("events.history", event.DefaultHistory))
 	// set axolotl manager
-	axolotlManager, err := axolotl.NewAxolotlManager(info.GetUserName(), info.staticPubKey, info.staticPriKey)
+	axolotlBackend, err := axolotl.OpenBackend(info.GetUserName())
+	if err != nil {
+		return nil
+	}
+	axolotlManager, err := axolotl.NewAxolotlManager(axolotlBackend, info.staticPubKey, info.staticPriKey)
 	if err != nil {
+		_ = axolotlBackend.Close()
 		return nil
 	}
 	w.axolotlManager = axolotlManager
+	// msg manager 发件箱
+	msgManager, err := msg.NewManager(info.GetUserName())
+	if err != nil {
+		wslog.GetLogger().Ctx(info.ctx).Error("open outbox error:", err)
+		_ = axolotlBackend.Close()
+		return nil
+	}
+	w.msgManager = msgManager
+	// 收到的消息
+	messageStores, err := stores.NewMessageStores(info.GetUserName())
+	if err != nil {
+		wslog.GetLogger().Ctx(info.ctx).Error("open message store error:", err)
+		_ = msgManager.Close()
+		_ = axolotlBackend.Close()
+		return nil
+	}
+	w.messageStores = messageStores
+	// webhook 推送
+	webhooks, err := webhook.Open(info.GetUserName(), w.events)
+	if err != nil {
+		wslog.GetLogger().Ctx(info.ctx).Error("open webhooks error:", err)
+		_ = messageStores.Close()
+		_ = msgManager.Close()
+		_ = axolotlBackend.Close()
+		return nil
+	}
+	w.webhooks = webhooks
+	// 身份密钥的信任策略
+	trustPolicy, err := axolotl.TrustPolicyOf(info.GetUserName())
+	if err != nil {
+		wslog.GetLogger().Ctx(info.ctx).Error("trust policy error:", err)
+		trustPolicy = store.TrustOnFirstUse
+	}
+	axolotlManager.IdentityStore.SetTrustPolicy(trustPolicy)
+	axolotlManager.IdentityStore.SetIdentityChangedHandler(w.onIdentityChanged)
 	// set network
 	//payLoad, _ := proto.Marshal(info.clientPayload)
 	w.netWork = network.NewNoiseClient(info.routingInfo, nil, noise.DHKey{}, w)
+	w.netWork.SetInboundQueue(g.Cfg().GetInt("network.inboundQueue", network.DefaultInboundQueue))
+	w.netWork.SetTransportType(g.Cfg().GetString("network.transport"))
+	w.netWork.SetFrameLimits(
+		g.Cfg().GetInt("network.maxFrameSize", network.MaxFrameSize),
+		g.Cfg().GetDuration("network.readTimeout"),
+		g.Cfg().GetDuration("network.writeTimeout", network.DefaultWriteTimeout),
+	)
 	segmentProcessor := w.netWork.GetSegment()
 	// set node processor
-	nodeProcessor := node.NewMainNodeProcessor()
+	nodeProcessor := node.NewMainNodeProcessor(codec.Encoder)
 	w.node = nodeProcessor
 	w.node.SetAxolotlManager(w.axolotlManager)
 	w.node.SetMsgManager(w.msgManager)
+	w.node.SetEventBus(w.events)
 	w.node.SetSegmentOutputProcessor(segmentProcessor)
 	// handles
 	handles := handlers.NewHandles()
 	// chat message handler
-	chatMessageHandler := handlers.NewChatMessageHandler(axolotlManager, nodeProcessor)
+	chatMessageHandler := handlers.NewChatMessageHandler(axolotlManager, nodeProcessor, messageStores)
 	chatMessageHandler.SetNotifyEvent(w)
 	handles.AddHandler(chatMessageHandler)
 	// notification handler
@@ -127,52 +186,210 @@ func NewWaAppCli(info *AccountInfo) *WaApp {
 	w.node.SetHandles(handles)
 	w.mutex = sync.Mutex{}
 	w.autoLogin = false
+	// 连接状态机 断开后按退避时间重连
+	w.supervisor = NewSupervisor(reconnectConfig(), w.reconnect, w.publishTransition)
+	// 空闲时发送 ping 没有回复时断开连接 由 supervisor 重连
+	w.keepalive = keepalive.New(keepaliveConfig(), keepalive.SystemClock, w.sendKeepalivePing, w.onPeerDead)
 	return w
 }
 
+// keepaliveConfig 读取配置 [keepalive]
+func keepaliveConfig() keepalive.Config {
+	c := keepalive.DefaultConfig()
+	c.Idle = g.Cfg().GetDuration("keepalive.idle", c.Idle)
+	c.Timeout = g.Cfg().GetDuration("keepalive.timeout", c.Timeout)
+	c.MaxMissed = g.Cfg().GetInt("keepalive.maxMissed", c.MaxMissed)
+	return c
+}
+
+// reconnectConfig 读取配置 [reconnect]
+func reconnectConfig() ReconnectConfig {
+	c := DefaultReconnectConfig()
+	c.Enabled = g.Cfg().GetBool("reconnect.enabled", c.Enabled)
+	c.InitialBackoff = g.Cfg().GetDuration("reconnect.initialBackoff", c.InitialBackoff)
+	c.MaxBackoff = g.Cfg().GetDuration("reconnect.maxBackoff", c.MaxBackoff)
+	c.Multiplier = g.Cfg().GetFloat64("reconnect.multiplier", c.Multiplier)
+	c.Jitter = g.Cfg().GetFloat64("reconnect.jitter", c.Jitter)
+	c.RetryBudget = g.Cfg().GetInt("reconnect.retryBudget", c.RetryBudget)
+	return c
+}
+
 // WaApp
 type WaApp struct {
 	*AccountInfo
 	*WSAppEvent
-	autoLogin   bool
+	autoLogin bool
+	// loginStatus 使用 GetLoginStatus storeLoginStatus 读写
 	loginStatus LoginStatus
 	// module
 	loginPromise   *impl.ResultPromise
 	netWork        *network.NoiseNetWork
 	axolotlManager *axolotl.Manager
 	msgManager     *msg.Manager
+	messageStores  *stores.MessageStores
 	node           *node.MainNodeProcessor
-	// 重新登录等待 防止在没有登录完成时重复登录
-	retryLoginWait sync.WaitGroup
+	// events 账号的事件 订阅者处理慢时丢弃 不会阻塞读取
+	events   *event.Bus
+	webhooks *webhook.Dispatcher
+	// codec 当前账号的编解码器
+	codec *newxxmp.Codec
+	// supervisor 连接状态和自动重连
+	supervisor *Supervisor
+	// keepalive 心跳和 ping 的往返时间
+	keepalive *keepalive.Keepalive
+	closeOnce sync.Once
 	// Mutex protects against data race conditions.
 	mutex sync.Mutex
 }
 
 func (w *WaApp) GetLoginStatus() LoginStatus {
 	if w != nil {
-		return w.loginStatus
+		return LoginStatus(atomic.LoadInt32((*int32)(&w.loginStatus)))
 	}
 	return Disconnect
 }
 
+// storeLoginStatus 网络线程和接口同时读写 不推送
+func (w *WaApp) storeLoginStatus(loginStatus LoginStatus) {
+	atomic.StoreInt32((*int32)(&w.loginStatus), int32(loginStatus))
+}
+
 func (w *WaApp) SetLoginStatusOne(loginStatus LoginStatus) {
-	w.loginStatus = loginStatus
+	w.storeLoginStatus(loginStatus)
+	w.publishLoginStatus("")
+}
+
+// ConnState 连接状态机的状态
+func (w *WaApp) ConnState() ConnState {
+	return w.supervisor.State()
+}
+
+// Reconnecting 正在连接或者等待自动重连
+func (w *WaApp) Reconnecting() bool {
+	switch w.ConnState() {
+	case StateConnecting, StateHandshaking, StateAuthenticating, StateBackoff:
+		return true
+	}
+	return false
+}
+
+// StopReconnect 退出登录时调用 之后断开不再自动重连
+func (w *WaApp) StopReconnect(reason string) {
+	w.supervisor.Stop(reason)
+}
+
+// publishTransition 状态机的每次变化
+func (w *WaApp) publishTransition(t StateTransition) {
+	wslog.GetLogger().Ctx(w.ctx).Info("connection state ", t.From, " -> ", t.To, " ", t.Reason)
+	w.events.Publish(&event.ConnectionTransition{
+		From:      t.From.String(),
+		To:        t.To.String(),
+		Reason:    t.Reason,
+		Attempt:   t.Attempt,
+		BackoffMs: t.Backoff.Milliseconds(),
+		Terminal:  t.Terminal,
+	})
+}
+
+// reconnect Backoff 结束后由 supervisor 调用
+func (w *WaApp) reconnect() {
+	w.netWork.Reset()
+	w.node.Reset()
+	w.WALogin()
+}
+
+// KeepaliveStats 心跳的往返时间和没有回复的次数
+func (w *WaApp) KeepaliveStats() keepalive.Stats {
+	return w.keepalive.Stats()
+}
+
+// sendKeepalivePing iq 超时或者发送失败时 done 返回错误
+func (w *WaApp) sendKeepalivePing(done func(err error)) {
+	w.node.SendIqPing().SetListenHandler(func(any promise.Any) { done(nil) }, done)
+}
+
+// onPeerDead 连续没有收到 pong 连接已经不可用 关闭后由 OnDisconnect 进入重连
+func (w *WaApp) onPeerDead(missed int) {
+	wslog.GetLogger().Ctx(w.ctx).Error("keepalive: no pong after ", missed, " pings, closing connection")
+	db.PushQueue(
+		db.PushMsg{
+			Time:     time.Now().Unix(),
+			UserName: w.clientPayload.GetUsername(),
+			Type:     db.System.Number(),
+			Data:     "send iq ping failure",
+			Text:     fmt.Sprintf("keepalive: no pong after %d pings", missed),
+		},
+	)
+	w.netWork.Close()
+}
+
+// transition 不允许的变化只记录
+func (w *WaApp) transition(to ConnState, reason string) {
+	if err := w.supervisor.Transition(to, reason); err != nil {
+		wslog.GetLogger().Ctx(w.ctx).Debug(err)
+	}
+}
+
+// Events 账号的事件
+func (w *WaApp) Events() *event.Bus {
+	return w.events
+}
+
+// Webhooks 账号的 webhook 和死信
+func (w *WaApp) Webhooks() *webhook.Dispatcher {
+	return w.webhooks
+}
+
+// publishLoginStatus
+func (w *WaApp) publishLoginStatus(text string) {
+	loginStatus := w.GetLoginStatus()
+	w.events.Publish(&event.ConnectionStateChanged{
+		State: loginStatus.String(),
+		Code:  int32(loginStatus),
+		Text:  text,
+	})
 }
 
 // SetLoginStatus 设置登录状态
 func (w *WaApp) SetLoginStatus(loginStatus LoginStatus) {
-	w.loginStatus = loginStatus
-	fmt.Println("账号[", w.GetUserName(), "]推送->loginStatus -> ", w.loginStatus)
+	w.storeLoginStatus(loginStatus)
+	fmt.Println("账号[", w.GetUserName(), "]推送->loginStatus -> ", w.GetLoginStatus())
+	w.publishLoginStatus("")
 	db.PushQueue(
 		db.PushMsg{
 			UserName: w.clientPayload.GetUsername(),
 			Time:     time.Now().Unix(),
 			Type:     db.Status.Number(),
-			Data:     w.loginStatus,
+			Data:     w.GetLoginStatus(),
 		},
 	)
 }
 
+// Close 账号退出或者登录失败后释放资源 停止重连 关闭连接和数据库 之后不能再登录
+func (w *WaApp) Close() {
+	w.closeOnce.Do(func() {
+		w.StopReconnect("closed")
+		w.keepalive.Stop()
+		w.NewtWorkClose()
+		w.netWork.CloseInbound()
+		w.webhooks.Close()
+		if err := w.webhooks.Store().Close(); err != nil {
+			wslog.GetLogger().Ctx(w.ctx).Error("close webhooks error:", err)
+		}
+		if err := w.messageStores.Close(); err != nil {
+			wslog.GetLogger().Ctx(w.ctx).Error("close message store error:", err)
+		}
+		if err := w.msgManager.Close(); err != nil {
+			wslog.GetLogger().Ctx(w.ctx).Error("close outbox error:", err)
+		}
+		if err := w.axolotlManager.Backend().Close(); err != nil {
+			wslog.GetLogger().Ctx(w.ctx).Error("close axolotl backend error:", err)
+		}
+		// 最后关闭 订阅的 SSE WebSocket 收到关闭后返回
+		w.events.Close()
+	})
+}
+
 func (w *WaApp) NewtWorkClose() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -185,14 +402,15 @@ func (w *WaApp) NewtWorkClose() {
 }
 
 func (w *WaApp) SetLoginStatusText(loginStatus LoginStatus, text string) {
-	w.loginStatus = loginStatus
-	log.Println("loginStatus -> ", w.loginStatus)
+	w.storeLoginStatus(loginStatus)
+	log.Println("loginStatus -> ", w.GetLoginStatus())
+	w.publishLoginStatus(text)
 	db.PushQueue(
 		db.PushMsg{
 			UserName: w.clientPayload.GetUsername(),
 			Time:     time.Now().Unix(),
 			Type:     db.Status.Number(),
-			Data:     w.loginStatus,
+			Data:     w.GetLoginStatus(),
 			Text:     text,
 		},
 	)
@@ -202,7 +420,7 @@ func (w *WaApp) SetLoginStatusText(loginStatus LoginStatus, text string) {
 func (w *WaApp) WALogin() _interface.IPromise {
 	w.mutex.Lock()
 	// 当socket 没有连接或登录状态不为在线时可执行登录0
-	if !w.netWork.Connected() || w.loginStatus != Online {
+	if !w.netWork.Connected() || w.GetLoginStatus() != Online {
 		// 使用异步登录
 		executor := func(resolve func(promise.Any), reject func(error)) {
 			// time out
@@ -210,20 +428,28 @@ func (w *WaApp) WALogin() _interface.IPromise {
 				<-time.After(time.Second * 30)
 				reject(errors.New("login time out"))
 			}()*/
+			// 手动登录时取消等待中的重连
+			w.supervisor.Start("login")
 			// update handshake settings
 			settings := w.GetLoginSettings()
 			// start
 			err := w.netWork.Connect(settings)
 			if err != nil {
-				w.loginStatus = Disconnect
+				w.storeLoginStatus(Disconnect)
 				w.NewtWorkClose()
+				w.supervisor.Fail(err.Error())
 				reject(err)
 				return
 			}
+			// 保存服务器公钥 下次登录使用 IK 握手 重启后从 redis 读取
+			w.SetServerStatic(settings.PeerStatic)
+			if err := w.SaveServerStatic(); err != nil {
+				wslog.GetLogger().Ctx(w.ctx).Error("save server static error:", err)
+			}
 		}
 		//初始化登录承诺超时30秒
 		w.loginPromise.SetPromise(promise.New(executor))
-	} else if w.netWork.Connected() && w.loginStatus == Online {
+	} else if w.netWork.Connected() && w.GetLoginStatus() == Online {
 		// TODO 已经在线状态
 		if w.loginPromise == nil {
 			w.loginPromise.SuccessResolve("success")
@@ -233,23 +459,14 @@ func (w *WaApp) WALogin() _interface.IPromise {
 	return w.loginPromise
 }
 
+// ResetNetWork 同 RetryLogin
 func (w *WaApp) ResetNetWork() _interface.IPromise {
-	// 不是掉线的不继续重新登录
-	if w.loginStatus == Drops {
-		// 重置网络
-		w.netWork.Reset()
-		// 重置 processor
-		w.node.Reset()
-		return w.WALogin()
-	}
-	return nil
+	return w.RetryLogin()
 }
 
+// SetNetWorkProxyLogin 修改代理后断开连接 supervisor 使用新的代理重连
 func (w *WaApp) SetNetWorkProxyLogin() {
-	// 防止重复重新登录
-	w.retryLoginWait.Add(1)
-	defer w.retryLoginWait.Done()
-	w.RetryLogin()
+	w.netWork.Close()
 }
 
 func (w *WaApp) HasUnsentPreKeys() string {
@@ -267,78 +484,20 @@ func (w *WaApp) HasUnsentPreKeys() string {
 	return "未知错误"
 }
 
-// RetryLogin 重新登录
+// RetryLogin 掉线后重新登录 由 supervisor 开始 取消等待中的重连
+// supervisor 已经在重连时返回这次登录的结果 不会同时建立两个连接
 func (w *WaApp) RetryLogin() _interface.IPromise {
-	// 不是掉线的不继续重新登录
-	if w.loginStatus == Drops {
-		w.loginPromise.Reject(errors.New("RetryLogin"))
-		// 重置网络
-		w.netWork.Reset()
-		// 重置 processor
-		w.node.Reset()
-		// 等待十秒
-		time.Sleep(time.Second * 10)
-		return w.WALogin()
-	}
-	return nil
-}
-
-/*// timingSendIqPing 定时发送 iq ping
-func (w *WaApp) timingSendIqPing() {
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				fmt.Println("run web error:timingSendIqPing")
-			}
-		}()
-
-		if w == nil && w.netWork == nil {
-			return
-		}
-		if w.loginStatus == Disconnect {
-			fmt.Println("賬號退出---")
-			return
-		}
-		// 检查网络连接情况
-		if !w.netWork.Connected() {
-			return
-		}
-		// 四分钟发送一次
-		<-time.After(time.Minute * 4)
-		// success
-		successFunc := func(any promise.Any) {
-			//log.Println("send iq ping resp")
-			wslog.GetLogger().Ctx(w.ctx).Info("send iq ping resp ok")
-			db.PushQueue(
-				db.PushMsg{
-					Time:     time.Now().Unix(),
-					UserName: w.clientPayload.GetUsername(),
-					Type:     db.System.Number(),
-					Data:     "send iq ping resp succeed",
-				},
-			)
-			w.timingSendIqPing()
-
-			wslog.GetLogger().Ctx(w.ctx).Info("继续发送 ping")
-
-		}
-		// failure
-		failureFunc := func(err error) {
-			wslog.GetLogger().Ctx(w.ctx).Error("send ping failure ")
-			db.PushQueue(
-				db.PushMsg{
-					Time:     time.Now().Unix(),
-					UserName: w.clientPayload.GetUsername(),
-					Type:     db.System.Number(),
-					Data:     "send iq ping failure",
-				},
-			)
-			//return
-		}
-		w.node.SendIqPing().
-			SetListenHandler(successFunc, failureFunc)
-	}()
-}*/
+	// 不是掉线也不在重连的不继续重新登录 重连期间状态可能是 NETNOT Disconnect
+	if w.GetLoginStatus() != Drops && !w.Reconnecting() {
+		return nil
+	}
+	if !w.supervisor.Start("retry login") {
+		return w.loginPromise
+	}
+	w.loginPromise.Reject(errors.New("RetryLogin"))
+	w.reconnect()
+	return w.loginPromise
+}
 
 // ===================== API =================================
 // AddGroupMember 添加群成员
@@ -558,9 +717,54 @@ func (w *WaApp) SendBusinessPresenceAvailable(name string) _interface.IPromise {
 	return w.node.SendBusinessPresenceAvailable(name)
 }
 
-// GetQueryMyMsg
-func (w *WaApp) GetQueryMyMsg() ([]msg.MySendMsg, error) {
-	return nil, nil
+// GetMyMsg 发送的消息和每个接收者的状态
+func (w *WaApp) GetMyMsg(id string) (*msg.MySendMsg, error) {
+	return w.msgManager.GetMsg(id)
+}
+
+// GetChatMyMsg 会话中发送的消息 按发送顺序倒序 before 为上一页最后一条消息的 id
+func (w *WaApp) GetChatMyMsg(chat string, isGroup bool, before string, limit int) ([]*msg.MySendMsg, error) {
+	id := node.NewJid(chat)
+	if isGroup {
+		chat = id.GroupId()
+	} else {
+		chat = id.Jid()
+	}
+	return w.msgManager.ChatMsgs(chat, before, limit)
+}
+
+// GetChats 有收到消息的会话
+func (w *WaApp) GetChats(limit int) ([]*stores.ChatSummary, error) {
+	return w.messageStores.Chats(limit)
+}
+
+// GetChatHistory 会话中收到的消息 按收到的顺序倒序 before 为上一页最后一条消息的 id
+func (w *WaApp) GetChatHistory(chat string, isGroup bool, before string, limit int) ([]*stores.StoredMessage, error) {
+	id := node.NewJid(chat)
+	if isGroup {
+		chat = id.GroupId()
+	} else {
+		chat = id.Jid()
+	}
+	return w.messageStores.ChatHistory(chat, before, limit)
+}
+
+// GetMessage 收到的消息
+func (w *WaApp) GetMessage(id string) (*stores.StoredMessage, error) {
+	return w.messageStores.GetMessage(id)
+}
+
+// GetQuarantined 无法解密的消息 sender 为空时返回所有的
+func (w *WaApp) GetQuarantined(sender string, limit int) ([]*stores.QuarantinedMessage, error) {
+	if sender != "" {
+		sender = node.NewJid(sender).Jid()
+	}
+	return w.messageStores.ListQuarantined(sender, limit)
+}
+
+// GetQuarantinedMessage 无法解密的消息 包括加密的数据和错误
+func (w *WaApp) GetQuarantinedMessage(id string) (*stores.QuarantinedMessage, error) {
+	return w.messageStores.GetQuarantined(id)
 }
 
 // ===================== System settings =======================
@@ -572,6 +776,21 @@ func (w *WaApp) GetNetWorkProxy() string {
 	return w.netWork.GetNetWorkProxyStr()
 }
 
+// InboundMetrics 收到的帧等待处理的数量和延迟
+func (w *WaApp) InboundMetrics() network.InboundMetrics {
+	return w.netWork.InboundMetrics()
+}
+
+// SetServerAddr 设置服务器地址 (测试时指向本地服务器)
+func (w *WaApp) SetServerAddr(addr string) {
+	w.netWork.SetServerAddr(addr)
+}
+
+// SetTransport 替换建立连接的方式 (测试时使用 network.PipeTransport)
+func (w *WaApp) SetTransport(transport network.Transport) {
+	w.netWork.SetTransport(transport)
+}
+
 // loginResultNotify 登录结果通知
 func (w *WaApp) loginResultNotify(loginSuccess bool) {
 	// TODO 通知登录结果
@@ -587,7 +806,7 @@ func (w *WaApp) loginResultNotify(loginSuccess bool) {
 		//log.Println("上线失败")
 		wslog.GetLogger().Ctx(w.ctx).Info("上线失败")
 		// 认证失败 不是返回的错误。握手失败或者socket断开才返回error
-		w.loginPromise.SuccessResolve(w.loginStatus)
+		w.loginPromise.SuccessResolve(w.GetLoginStatus())
 	}
 }
 
@@ -657,13 +876,21 @@ func (w *WaApp) handleStreamError(node newxxmp.Node) {
 		}
 	}()
 	log.Println("handleStreamError builder node ", node.GetString())
+	streamError := &event.StreamError{Code: node.GetAttributeByValue("code")}
+	if condition := node.GetChildrenIndex(0); condition != nil {
+		streamError.Condition = condition.GetTag()
+		streamError.Text = condition.GetAttributeByValue("type")
+	}
+	w.events.Publish(streamError)
 	//两个地方抢线
 	wslog.GetLogger().Ctx(w.ctx).Info("handleStreamError", w.clientPayload.GetUsername())
 	if node.GetChildrenByTag("conflict") != nil && node.GetChildrenByTag("conflict").GetAttributeByValue("type") != "" {
 		value := node.GetChildrenByTag("conflict").GetAttributeByValue("type")
 		if value == "replaced" {
 			wslog.GetLogger().Ctx(w.ctx).Info("账号被抢登录", w.clientPayload.GetUsername())
-			w.loginStatus = Drops
+			// 重连会和另一端互相抢线
+			w.supervisor.Stop("stream error: conflict replaced")
+			w.storeLoginStatus(Drops)
 			db.PushQueue(
 				db.PushMsg{
 					UserName: w.clientPayload.GetUsername(),
@@ -686,12 +913,16 @@ func (w *WaApp) handleTagFailure(node newxxmp.Node) {
 		reason := node.GetAttributeByValue("reason")
 		switch reason {
 		case "503": //TODO 登录频繁了？
+			w.supervisor.Fail("login failure: 503")
 		case "401":
+			w.supervisor.Stop("login failure: 401")
 			w.SetLoginStatus(AuthFailed)
 		case "403":
 			// 账号被封
+			w.supervisor.Ban("login failure: 403")
 			w.SetLoginStatus(Banned)
 		default:
+			w.supervisor.Fail("login failure: " + reason)
 			w.loginPromise.SuccessResolve(AuthFailed)
 		}
 
@@ -717,6 +948,8 @@ func (w *WaApp) handleTagSuccess(n *newxxmp.Node) {
 		}
 	}()
 	//wslog.GetLogger().Ctx(w.ctx).Info("go WhatsApp login:", n.Tag, "create:", n.GetAttribute("creation").Value())
+	w.transition(StateOnline, "success")
+	w.keepalive.Start()
 	// notify login success
 	w.loginResultNotify(true)
 	// 通知登录成功
@@ -747,7 +980,6 @@ func (w *WaApp) handleTagSuccess(n *newxxmp.Node) {
 		wslog.GetLogger().Ctx(w.ctx).Info("账号[", w.GetUserName(), "]链接存在=", iwxConnect.GetWxConnID())
 	}
 	//end
-	///w.timingSendIqPing()
 	// send available
 	w.node.SendPresenceAvailable()
 
@@ -789,24 +1021,28 @@ func (w *WaApp) NotifyHandleResult(any ...interface{}) {
 		switch result.(type) {
 		case error:
 		case *entity.ChatMessage:
-			if w.NewChatMessageNotify != nil {
-				message := result.(*entity.ChatMessage)
-				//如果是消息媒体类型
-				pushData := entity.RespMessage{
-					T:           message.T(),
-					Participant: message.Participant(),
-					From:        message.From(),
-					ContextType: message.ContextType(),
-					Id:          message.Id(),
-				}
+			message := result.(*entity.ChatMessage)
+			// 先保存 重复的消息(服务器重发)不再通知
+			if inserted, err := w.messageStores.SaveChatMessage(message); err != nil {
+				wslog.GetLogger().Ctx(w.ctx).Error("save message error:", message.Id(), err)
+			} else if !inserted {
+				return
+			}
+			//如果是消息媒体类型
+			pushData := entity.RespMessage{
+				T:           message.T(),
+				Participant: message.Participant(),
+				From:        message.From(),
+				ContextType: message.ContextType(),
+				Id:          message.Id(),
+			}
 
-				if message.ContextType() == "media" {
-					if message.GetMessage() != nil {
-						pushData.Message = entity.ParseProtoMessage(message.GetMessage())
-					}
-				} else {
-					pushData.Message = entity.ParseProtoMessage(message.GetMessage())
-				}
+			if message.ContextType() != "media" || message.GetMessage() != nil {
+				pushData.Message = entity.ParseProtoMessage(message.GetMessage())
+				pushData.Kind = pushData.Message.Kind()
+			}
+			w.events.Publish(&event.MessageReceived{Message: pushData, Chat: message})
+			if w.NewChatMessageNotify != nil {
 				MsgPost(gconv.String(db.PushMsg{Time: time.Now().Unix(), UserName: w.clientPayload.GetUsername(), Type: db.Msg.Number(), Data: pushData}))
 				db.PushQueue(
 					db.PushMsg{
@@ -818,6 +1054,18 @@ func (w *WaApp) NotifyHandleResult(any ...interface{}) {
 				)
 				w.NewChatMessageNotify(result.(*entity.ChatMessage))
 			}
+		case *entity.UndecryptableMessage:
+			e := result.(*entity.UndecryptableMessage)
+			wslog.GetLogger().Ctx(w.ctx).Println("message quarantined:", e.Id, e.Sender, e.ErrorClass)
+			w.events.Publish(&event.MessageUndecryptable{UndecryptableMessage: *e})
+			db.PushQueue(
+				db.PushMsg{
+					Time:     time.Now().Unix(),
+					UserName: w.clientPayload.GetUsername(),
+					Type:     db.Undecryptable.Number(),
+					Data:     e,
+				},
+			)
 		case *entity.Receipt:
 			fmt.Println("------------------------Receipt11369855", result.(*entity.Receipt))
 		case *entity.Notification:
@@ -825,6 +1073,74 @@ func (w *WaApp) NotifyHandleResult(any ...interface{}) {
 		}
 	}
 }
+
+// onIdentityChanged 联系人的身份密钥变化 在解密或者建立 session 时调用 不能阻塞
+func (w *WaApp) onIdentityChanged(change *store.IdentityChange) {
+	e := &entity.IdentityChanged{
+		Jid:      change.Name,
+		OldKey:   hex.EncodeToString(change.OldKey.PublicKey().Serialize()),
+		NewKey:   hex.EncodeToString(change.NewKey.PublicKey().Serialize()),
+		Policy:   string(change.Policy),
+		Verified: change.Verified,
+		Trusted:  change.Trusted,
+	}
+	wslog.GetLogger().Ctx(w.ctx).Println("identity changed:", e.Jid, "trusted:", e.Trusted)
+	w.events.Publish(&event.IdentityChanged{IdentityChanged: *e})
+	go db.PushQueue(
+		db.PushMsg{
+			Time:     time.Now().Unix(),
+			UserName: w.clientPayload.GetUsername(),
+			Type:     db.Identity.Number(),
+			Data:     e,
+		},
+	)
+}
+
+// ApproveIdentity 确认联系人新的身份密钥 newKey 为 IdentityChanged 中的 NewKey
+func (w *WaApp) ApproveIdentity(jid string, newKey string) error {
+	publicKey, err := hex.DecodeString(newKey)
+	if err != nil {
+		return err
+	}
+	key, err := store.DecodeIdentityKey(publicKey)
+	if err != nil {
+		return err
+	}
+	id := node.NewJid(jid)
+	return w.axolotlManager.ApproveIdentity(id.RawId(), key)
+}
+
+// SafetyNumber 和联系人的安全码
+func (w *WaApp) SafetyNumber(jid string) (*entity.SafetyNumber, error) {
+	id := node.NewJid(jid)
+	f, err := w.axolotlManager.Fingerprint(w.GetUserName(), id.RawId())
+	if err != nil {
+		return nil, err
+	}
+	verified, err := w.axolotlManager.IsIdentityVerified(id.RawId())
+	if err != nil {
+		return nil, err
+	}
+	return &entity.SafetyNumber{
+		Jid:          jid,
+		SafetyNumber: f.Display().DisplayText(),
+		Scan:         base64.StdEncoding.EncodeToString(f.Scan().Serialize()),
+		Verified:     verified,
+	}, nil
+}
+
+// VerifySafetyNumber scan 为扫描对方二维码得到的数据 匹配时保存为已经验证
+func (w *WaApp) VerifySafetyNumber(jid string, scan []byte) (bool, error) {
+	id := node.NewJid(jid)
+	return w.axolotlManager.VerifyFingerprint(w.GetUserName(), id.RawId(), scan)
+}
+
+// SetIdentityVerified 手动设置联系人的安全码是否已经验证
+func (w *WaApp) SetIdentityVerified(jid string, verified bool) error {
+	id := node.NewJid(jid)
+	return w.axolotlManager.SetIdentityVerified(id.RawId(), verified)
+}
+
 func MsgPost(data string) {
 	payload := strings.NewReader(data)
 	client := &http.Client{}
@@ -852,6 +1168,7 @@ func (w *WaApp) OnConnect() {
 			fmt.Println("run web error:OnConnect", err)
 		}
 	}()
+	w.transition(StateHandshaking, "connected")
 	// 设置登录状态为连接
 	w.SetLoginStatus(Connect)
 	//log.Println("连接成功")
@@ -860,6 +1177,8 @@ func (w *WaApp) OnConnect() {
 	// 重置 segment processor
 	segmentProcessor := w.netWork.GetSegment()
 	w.node.SetSegmentOutputProcessor(segmentProcessor)
+	// 掉线时 NewtWorkClose 关闭了发送队列
+	w.node.Reopen()
 }
 func (w *WaApp) OnRecvData(d []byte) {
 	defer func() {
@@ -867,31 +1186,46 @@ func (w *WaApp) OnRecvData(d []byte) {
 			fmt.Println("run web error:OnRecvData", err)
 		}
 	}()
-	decodeNode, err := newxxmp.XMMPDecodeNode(d)
+	w.keepalive.Touch()
+	decodeNode, err := w.codec.Decode(d)
 	if err != nil {
 		//log.Println("WaApp OnRecvData DecodeNode error:", err)
-		wslog.GetLogger().Ctx(w.ctx).Error("WaApp OnRecvData DecodeNode error:", err)
+		wslog.GetLogger().Ctx(w.ctx).Error("WaApp OnRecvData DecodeNode error:", err, hex.EncodeToString(d))
 		return
 	}
 	if decodeNode == nil {
 		wslog.GetLogger().Ctx(w.ctx).Error("WaApp decodeNode decodeNode error:", decodeNode, hex.EncodeToString(d))
 		return
 	}
-	//log.Println("DecodeNode:", decodeNode.GetString())
-	//wslog.GetLogger().Ctx(w.ctx).Info("onRecvData", "decode node", decodeNode.GetString())
-	go w.handleNodeTree(decodeNode)
+	// 开启 debug 日志后输出可读的 node
+	wslog.GetLogger().Ctx(w.ctx).Debug("onRecvData decode node\n", decodeNode)
+	// 按收到的顺序处理 不能使用协程
+	w.handleNodeTree(decodeNode)
 }
 func (w *WaApp) OnHandShakeFailed(err error) {
 	// 出现握手失败的表示认证失败
 	//log.Println("onHandShakeFailed ", "出现握手失败 -> ", err)
 	wslog.GetLogger().Ctx(w.ctx).Error("onHandShakeFailed ", "出现握手失败 -> ", err)
+	// 保存的服务器公钥可能已经更换 下次使用 XX 握手
+	if errors.Is(err, handshake.ErrIKFailed) {
+		if err := w.ClearServerStatic(); err != nil {
+			wslog.GetLogger().Ctx(w.ctx).Error("clear server static error:", err)
+		}
+	}
+	w.supervisor.Fail("handshake failed: " + err.Error())
 	w.SetLoginStatus(HandshakeFailed)
 	w.loginPromise.Reject(err)
 }
+
+// OnHandshakeSuccess 握手成功 等待服务器返回 success 或 failure
+func (w *WaApp) OnHandshakeSuccess() {
+	w.transition(StateAuthenticating, "handshake success")
+}
+
 func (w *WaApp) OnError(err error) {
 	log.Println("onError ", "发送错误", err.Error()) //
 	if strings.Index(err.Error(), "use of closed network connection") == -1 {
-		w.loginStatus = NETNOT
+		w.storeLoginStatus(NETNOT)
 		//登录成功开启
 		connectMgr := WXServer.GetWXConnectMgr()
 		//查询该链接是否存在
@@ -905,16 +1239,21 @@ func (w *WaApp) OnError(err error) {
 	w.loginPromise.Reject(err)
 }
 func (w *WaApp) OnDisconnect() {
-	//log.Println("断开连接", "当前用户状态:", w.loginStatus)
-	wslog.GetLogger().Ctx(w.ctx).Info("断开连接", "当前用户状态:", w.loginStatus)
+	// 只拒绝这次连接的登录 处理期间可能已经开始新的登录
+	loginPromise := w.loginPromise.GetPromise()
+	//log.Println("断开连接", "当前用户状态:", w.GetLoginStatus())
+	wslog.GetLogger().Ctx(w.ctx).Info("断开连接", "当前用户状态:", w.GetLoginStatus())
 	w.NewtWorkClose()
+	w.keepalive.Stop()
+	// 认证失败 被封 被抢登录 退出登录后 supervisor 已经停止 不会重连
+	w.supervisor.Fail("disconnected")
 	// 被禁止
-	if w.loginStatus == Banned {
+	if w.GetLoginStatus() == Banned {
 		//推送过去上线失败，断开连接
 		w.SetLoginStatusText(Banned, "Banned账号被封!")
 		return
 	}
-	if w.loginStatus == NETNOT {
+	if w.GetLoginStatus() == NETNOT {
 		w.SetLoginStatusText(NETNOT, "网络链接异常!")
 		return
 	}
@@ -923,13 +1262,12 @@ func (w *WaApp) OnDisconnect() {
 	if w.autoLogin {
 		return
 	}
-	/** 因客户要求把掉线自动重连去掉**/
-	if w.loginStatus == Drops {
-		w.retryLoginWait.Wait()
+	// 修改代理时已经设置为掉线 supervisor 重连
+	if w.GetLoginStatus() == Drops {
 		return
 	}
 	// 如果上次登录状态是在线的，断开连接后将状态重置为掉线
-	if w.loginStatus == Online {
+	if w.GetLoginStatus() == Online {
 		//推送过去上线失败，断开连接
 		w.SetLoginStatus(Drops)
 		//登录成功开启
@@ -939,33 +1277,13 @@ func (w *WaApp) OnDisconnect() {
 		if iwxConnect != nil {
 			iwxConnect.Stop()
 		}
-		log.Println("retryLoginResult is nil！")
-		/*w.SetLoginStatus(Drops)
-		log.Println("OnDisconnect", "开始重新尝试上线")
-		// 防止重复重新登录
-		w.retryLoginWait.Add(1)
-		defer w.retryLoginWait.Done()
-		// TODO 可进行重连
-		retryLoginResult := w.RetryLogin()
-		if retryLoginResult != nil {
-			_, err := retryLoginResult.GetResult()
-			if err != nil {
-				//重新上线失败，推送给客户端
-				wslog.GetLogger().Ctx(w.ctx).Error("retryLoginResult:", err)
-				//推送过去上线失败，断开连接
-				w.SetLoginStatusText(Disconnect, err.Error())
-				return
-			}
-			log.Println("重新上线成功！")
-		} else {
-			//推送过去上线失败，断开连接
-			w.SetLoginStatusText(Disconnect, "retryLoginResult is nil！")
-			log.Println("retryLoginResult is nil！")
-		}*/
+		// 自动重连由 supervisor 处理 见 [reconnect]
 	} else {
 		// 如果没有登录成功置登录为断开socket 连接
 		w.SetLoginStatusText(Disconnect, "handshake fail")
-		w.loginPromise.Reject(errors.New("handshake fail"))
+		if loginPromise != nil {
+			loginPromise.Reject(errors.New("handshake fail"))
+		}
 
 	}
 
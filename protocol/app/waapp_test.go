@@ -0,0 +1,409 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"ws-go/protocol/event"
+	"ws-go/protocol/handshake"
+	"ws-go/protocol/keepalive"
+	"ws-go/protocol/newxxmp"
+	"ws-go/protocol/testserver"
+)
+
+// newTestWaApp 创建一个连接本地测试服务器的 WaApp
+func newTestWaApp(t *testing.T, s *testserver.Server, username uint64) *WaApp {
+	t.Helper()
+	testserver.Workspace(t, testserver.Config)
+	if WXServer == nil {
+		ServerStart()
+	}
+	payload, staticKey := testserver.Account(t, username)
+	info := EmptyAccountInfo()
+	info.SetCliPayload(payload)
+	if err := info.SetStaticHdKeys(staticKey.Private, staticKey.Public); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWaAppCli(info)
+	if w == nil {
+		t.Fatal("NewWaAppCli returned nil")
+	}
+	w.SetServerAddr(s.Addr())
+	// 测试结束后关闭连接不再重连 否则会连接到之后的测试服务器
+	t.Cleanup(func() { w.StopReconnect("test done") })
+	return w
+}
+
+func newTestServer(t *testing.T, scenario testserver.Scenario) *testserver.Server {
+	t.Helper()
+	s, err := testserver.NewServer(scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func waitLoginResult(t *testing.T, w *WaApp) LoginStatus {
+	t.Helper()
+	result := make(chan interface{}, 1)
+	go func() {
+		any, err := w.WALogin().GetResult()
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- any
+	}()
+	select {
+	case r := <-result:
+		status, ok := r.(LoginStatus)
+		if !ok {
+			t.Fatalf("login result = %v", r)
+		}
+		return status
+	case <-time.After(10 * time.Second):
+		t.Fatal("login time out")
+	}
+	return 0
+}
+
+func waitLoginStatus(t *testing.T, w *WaApp, want LoginStatus) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for w.GetLoginStatus() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("login status = %s, want %s", w.GetLoginStatus(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitDisconnected 等待服务器关闭连接 连接未关闭时不能重新登录
+func waitDisconnected(t *testing.T, w *WaApp) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for w.netWork.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait disconnected time out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitAccepted(t *testing.T, s *testserver.Server) *testserver.Conn {
+	t.Helper()
+	select {
+	case c := <-s.Accepted():
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait accepted time out %v", s.Errors())
+	}
+	return nil
+}
+
+// streamErrorAfterOnline 等待客户端上线后发送 presence 再发送 stream:error
+// 避免 success 和 stream:error 同时被处理
+// 关闭连接前等待客户端处理完 stream:error 否则会先处理断开连接
+func streamErrorAfterOnline() testserver.Scenario {
+	return testserver.Sequence(
+		testserver.SendSuccess(),
+		testserver.ReadUntil("presence"),
+		testserver.SendStreamError("replaced"),
+		testserver.Drain(200*time.Millisecond),
+	)
+}
+
+func isTag(tag string) func(n *newxxmp.Node) bool {
+	return func(n *newxxmp.Node) bool {
+		return n.GetTag() == tag
+	}
+}
+
+func TestWaApp_LoginSuccess(t *testing.T) {
+	s := newTestServer(t, testserver.Success())
+	w := newTestWaApp(t, s, 8613800000001)
+	defer w.NewtWorkClose()
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	c := waitAccepted(t, s)
+	payload, err := c.ClientPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.GetUsername() != 8613800000001 {
+		t.Errorf("username = %d", payload.GetUsername())
+	}
+	// 登录成功后会发送 presence available
+	if _, err := c.WaitNode(isTag("presence"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaApp_LoginFailure(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   LoginStatus
+	}{
+		{"401", AuthFailed},
+		{"403", Banned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			s := newTestServer(t, testserver.Failure(tt.reason))
+			w := newTestWaApp(t, s, 8613800000002)
+			defer w.NewtWorkClose()
+
+			if status := waitLoginResult(t, w); status != tt.want {
+				t.Fatalf("login result = %s, want %s", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaApp_StreamErrorAndReconnect(t *testing.T) {
+	s := newTestServer(t, streamErrorAfterOnline())
+	w := newTestWaApp(t, s, 8613800000003)
+	defer w.NewtWorkClose()
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	// 被抢登录后为掉线状态 不会自动重连
+	waitLoginStatus(t, w, Drops)
+	waitDisconnected(t, w)
+	if state := w.ConnState(); state != StateStopped {
+		t.Fatalf("conn state = %s, want Stopped", state)
+	}
+
+	// 掉线后重新登录
+	s.SetScenario(testserver.Success())
+	retry := w.ResetNetWork()
+	if retry == nil {
+		t.Fatal("ResetNetWork returned nil")
+	}
+	any, err := retry.GetResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any != Online {
+		t.Fatalf("retry login result = %v, want Online", any)
+	}
+	if conns := s.Conns(); len(conns) != 2 {
+		t.Fatalf("server conns = %d, want 2", len(conns))
+	}
+}
+
+func TestWaApp_IqResult(t *testing.T) {
+	// 只回复 ping
+	s := newTestServer(t, testserver.IqResult(func(iq *newxxmp.Node) *newxxmp.Node {
+		if iq.GetChildrenByTag("ping") == nil {
+			return nil
+		}
+		return testserver.EmptyIqResult(iq)
+	}))
+	w := newTestWaApp(t, s, 8613800000004)
+	defer w.NewtWorkClose()
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	if _, err := w.node.SendIqPing().GetResult(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaApp_IqTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("iq ping time out is 10s")
+	}
+	s := newTestServer(t, testserver.Timeout())
+	w := newTestWaApp(t, s, 8613800000005)
+	defer w.NewtWorkClose()
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	if _, err := w.node.SendIqPing().GetResult(); err == nil {
+		t.Fatal("iq ping without reply should time out")
+	}
+}
+
+func TestWaApp_LoginResumeIK(t *testing.T) {
+	s := newTestServer(t, streamErrorAfterOnline())
+	w := newTestWaApp(t, s, 8613800000006)
+	defer w.NewtWorkClose()
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	if c := waitAccepted(t, s); c.HandshakePattern() != "XX" {
+		t.Fatalf("first login pattern = %s, want XX", c.HandshakePattern())
+	}
+	if !bytes.Equal(w.GetServerStatic(), s.StaticKey.Public) {
+		t.Fatalf("server static = %x, want %x", w.GetServerStatic(), s.StaticKey.Public)
+	}
+
+	// 掉线后重新登录使用保存的服务器公钥
+	waitLoginStatus(t, w, Drops)
+	waitDisconnected(t, w)
+	s.SetScenario(testserver.Success())
+	if any, err := w.ResetNetWork().GetResult(); err != nil || any != Online {
+		t.Fatalf("retry login result = %v, %v %v", any, err, s.Errors())
+	}
+	if c := waitAccepted(t, s); c.HandshakePattern() != "IK" {
+		t.Fatalf("second login pattern = %s, want IK", c.HandshakePattern())
+	}
+}
+
+// 服务器更换密钥后没有返回 XXfallback 清除保存的公钥 下次登录使用 XX
+func TestWaApp_LoginIKDropped(t *testing.T) {
+	s := newTestServer(t, streamErrorAfterOnline())
+	w := newTestWaApp(t, s, 8613800000009)
+	defer w.NewtWorkClose()
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	waitAccepted(t, s)
+	waitLoginStatus(t, w, Drops)
+	waitDisconnected(t, w)
+
+	s.SetDropIK(true)
+	if _, err := w.ResetNetWork().GetResult(); !errors.Is(err, handshake.ErrIKFailed) {
+		t.Fatalf("IK login err = %v, want %v", err, handshake.ErrIKFailed)
+	}
+	if len(w.GetServerStatic()) != 0 {
+		t.Fatalf("server static = %x, want cleared", w.GetServerStatic())
+	}
+	// 等待 OnDisconnect 处理完 否则会拒绝下一次登录
+	waitLoginStatus(t, w, Disconnect)
+
+	s.SetDropIK(false)
+	s.SetScenario(testserver.Success())
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online %v", status, s.Errors())
+	}
+	if c := waitAccepted(t, s); c.HandshakePattern() != "XX" {
+		t.Fatalf("login pattern = %s, want XX", c.HandshakePattern())
+	}
+}
+
+func TestWaApp_AutoReconnect(t *testing.T) {
+	// 第一次上线后服务器直接关闭连接
+	s := newTestServer(t, testserver.Sequence(testserver.SendSuccess(), testserver.ReadUntil("presence")))
+	w := newTestWaApp(t, s, 8613800000007)
+	config := enabledReconnectConfig()
+	config.InitialBackoff = 10 * time.Millisecond
+	w.supervisor = NewSupervisor(config, w.reconnect, w.publishTransition)
+	transitions := w.Events().Subscribe(64, event.TypeConnectionTransition)
+	defer transitions.Close()
+	defer w.NewtWorkClose()
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	waitAccepted(t, s)
+	s.SetScenario(testserver.IqResult(func(iq *newxxmp.Node) *newxxmp.Node {
+		if iq.GetChildrenByTag("ping") == nil {
+			return nil
+		}
+		return testserver.EmptyIqResult(iq)
+	}))
+	waitAccepted(t, s)
+
+	var path []string
+	want := "Connecting Handshaking Authenticating Online Backoff Connecting Handshaking Authenticating Online"
+	timeout := time.After(5 * time.Second)
+	for strings.Join(path, " ") != want {
+		select {
+		case env := <-transitions.C():
+			path = append(path, env.Event.(*event.ConnectionTransition).To)
+		case <-timeout:
+			t.Fatalf("transitions\n got %s\nwant %s", strings.Join(path, " "), want)
+		}
+	}
+	// 重连后发送队列可以继续使用
+	if _, err := w.node.SendIqPing().GetResult(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaApp_KeepaliveDeadPeer(t *testing.T) {
+	// 上线后服务器不再回复 连续没有 pong 后断开并重连
+	s := newTestServer(t, testserver.Timeout())
+	w := newTestWaApp(t, s, 8613800000008)
+	config := enabledReconnectConfig()
+	config.InitialBackoff = 10 * time.Millisecond
+	w.supervisor = NewSupervisor(config, w.reconnect, w.publishTransition)
+	w.keepalive = keepalive.New(keepalive.Config{Idle: 50 * time.Millisecond, Timeout: 50 * time.Millisecond, MaxMissed: 2},
+		keepalive.SystemClock, w.sendKeepalivePing, w.onPeerDead)
+	defer w.NewtWorkClose()
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	waitAccepted(t, s)
+	s.SetScenario(testserver.Success())
+	waitAccepted(t, s)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := w.KeepaliveStats()
+		if w.ConnState() == StateOnline && stats.Received > 0 {
+			if stats.TotalMissed < 2 {
+				t.Fatalf("keepalive stats = %+v", stats)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("conn state = %s, keepalive stats = %+v", w.ConnState(), stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWaApp_Close(t *testing.T) {
+	s := newTestServer(t, testserver.Success())
+	w := newTestWaApp(t, s, 8613800000007)
+
+	if status := waitLoginResult(t, w); status != Online {
+		t.Fatalf("login result = %s, want Online", status)
+	}
+	waitAccepted(t, s)
+	sub := w.Events().Subscribe(64)
+	w.Close()
+	// 重复调用不会 panic
+	w.Close()
+	if w.netWork.Connected() || w.ConnState() != StateStopped {
+		t.Fatalf("connected = %v, state = %s", w.netWork.Connected(), w.ConnState())
+	}
+	// 订阅的事件流在关闭后结束
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-sub.C():
+			closed = !ok
+		case <-timeout:
+			t.Fatal("event subscription not closed after Close")
+		}
+	}
+	// 关闭后不再重连
+	select {
+	case <-s.Accepted():
+		t.Fatal("reconnected after Close")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestLoginStatus_String(t *testing.T) {
+	for status := Online; status <= NETNOT; status++ {
+		if status.String() == "" {
+			t.Errorf("LoginStatus(%d) without name", status)
+		}
+	}
+}
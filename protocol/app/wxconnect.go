@@ -5,11 +5,8 @@ import (
 	"fmt"
 	"log"
 	"sync"
-	"time"
 	"ws-go/protocol/db"
 	"ws-go/protocol/entity"
-	"ws-go/protocol/utils/promise"
-	"ws-go/wslog"
 )
 
 // WXConnect 链接
@@ -22,14 +19,10 @@ type WXConnect struct {
 	wxConnID uint32
 	// 微信账号信息
 	wxAccount *WaApp
-	// 心跳定时器
-	heartBeatTimer *time.Timer
 	// 断开链接
 	ExitFlagChan chan bool
 	// 首次登录初始化只执行一次
-	onceInit sync.Once
-	// 失败次数
-	fullSum    int32
+	onceInit   sync.Once
 	wxConnLock sync.RWMutex //读写连接的读写锁
 }
 
@@ -40,8 +33,6 @@ func (wxconn *WXConnect) Stop() {
 		}
 	}()
 	wxconn.ExitFlagChan <- true
-	// 立即过期
-	wxconn.heartBeatTimer.Reset(0)
 	//移除管理器中的鏈接
 	wxconn.wxConnectMgr.Remove(wxconn)
 	if wxconn.GetWXAccount() != nil {
@@ -57,7 +48,6 @@ func NewWXConnect(wxAccount *WaApp, wxConnectMgr IWXConnectMgr) IWXConnect {
 		longReqQueue: make(chan IWSRequest, 1),
 		wxAccount:    wxAccount,
 		ExitFlagChan: make(chan bool, 1),
-		fullSum:      0,
 		wxConnLock:   sync.RWMutex{},
 	}
 	return wxconn
@@ -73,8 +63,7 @@ func (wxconn *WXConnect) Start() error {
 	}
 	// 开启任务管理器
 	fmt.Println("[" + userInfo.GetUserName() + "]开始任务状态！")
-	// 启动心跳
-	wxconn.heartBeatTimer = time.NewTimer(time.Minute * 1)
+	// 心跳由 WaApp 的 keepalive 处理
 	go wxconn.startInit()
 	go wxconn.startLongWriter()
 	// 添加鏈接至鏈接管理器
@@ -135,10 +124,6 @@ func (wxconn *WXConnect) startLongWriter() {
 		case <-wxconn.longReqQueue:
 			SetReqQueueList(wxconn)
 			continue
-		case <-wxconn.heartBeatTimer.C:
-			// 发送心跳包
-			SetHeartBeatRequest(wxconn)
-			continue
 		case <-wxconn.ExitFlagChan:
 			return
 		}
@@ -162,69 +147,6 @@ func SetReqQueueList(wxConn *WXConnect) {
 	}
 }
 
-func SetHeartBeatRequest(wxConn *WXConnect) {
-	w := wxConn.GetWXAccount()
-	if w == nil || w.netWork == nil {
-		wxConn.Stop()
-		return
-	}
-	if w.loginStatus == Disconnect {
-		//fmt.Println("账号退出---")
-		wxConn.Stop()
-		return
-	}
-	// 检查网络连接情况
-	if !w.netWork.Connected() {
-		wxConn.Stop()
-		return
-	}
-	fmt.Println(w.GetUserName() + "--->开始发送心跳包")
-	wxConn.wxConnectMgr.ShowConnectInfo()
-	// success
-	successFunc := func(any promise.Any) {
-		//log.Println("send iq ping resp")
-		wslog.GetLogger().Ctx(w.ctx).Info("send iq ping resp ok")
-		db.PushQueue(
-			db.PushMsg{
-				Time:     time.Now().Unix(),
-				UserName: w.clientPayload.GetUsername(),
-				Type:     db.System.Number(),
-				Data:     "send iq ping resp succeed",
-			},
-		)
-		wslog.GetLogger().Ctx(w.ctx).Info("继续发送 ping")
-		wxConn.fullSum = 0 //成功初始化为0
-	}
-	// failure
-	failureFunc := func(err error) {
-		db.PushQueue(
-			db.PushMsg{
-				Time:     time.Now().Unix(),
-				UserName: w.clientPayload.GetUsername(),
-				Type:     db.System.Number(),
-				Data:     "send iq ping failure",
-			},
-		)
-		wxConn.fullSum++
-		fmt.Println(w.GetUserName(), "心跳失败", wxConn.fullSum, "次")
-		if wxConn.fullSum <= 2 {
-			SetHeartBeatRequest(wxConn)
-			return
-		}
-		/*go wxConn.Stop()*/
-		wslog.GetLogger().Ctx(w.ctx).Error("send ping failure ")
-	}
-	w.node.SendIqPing().SetListenHandler(successFunc, failureFunc)
-	//fmt.Println("wxConn.fullSum")
-	if wxConn.fullSum >= 2 {
-		//关闭
-		wxConn.Stop()
-		return
-	}
-	//4分钟后在次发送
-	wxConn.SendHeartBeatWaitingSeconds(1)
-}
-
 // GetWXAccount 获取微信帐号信息
 func (wxconn *WXConnect) GetWXAccount() *WaApp {
 	return wxconn.wxAccount
@@ -238,14 +160,6 @@ func (wxconn *WXConnect) GetWxConnID() uint32 {
 	return wxconn.wxConnID
 }
 
-// SendHeartBeatWaitingSeconds 添加到微信心跳包队列
-func (wxconn *WXConnect) SendHeartBeatWaitingSeconds(seconds uint32) {
-	wxconn.heartBeatTimer.Reset(time.Minute * time.Duration(seconds))
-}
-func (wxconn *WXConnect) SendHeartWaitingSeconds(seconds uint32) {
-	wxconn.heartBeatTimer.Reset(time.Duration(seconds))
-}
-
 // SendToWXLongReqQueue 添加到请求队列
 func (wxconn *WXConnect) SendToWXLongReqQueue(wxLongReq IWSRequest) {
 	wxconn.longReqQueue <- wxLongReq
@@ -4,36 +4,25 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/gogf/gf/database/gdb"
-	"github.com/gogf/gf/os/gtime"
 	"github.com/gogf/gf/util/grand"
-	_ "github.com/mattn/go-sqlite3"
 	"log"
 	"math/rand"
-	"os"
 	"strings"
 	"sync"
 	"time"
 	"ws-go/libsignal/ecc"
 	"ws-go/libsignal/groups"
+	"ws-go/libsignal/keys/identity"
 	"ws-go/libsignal/keys/prekey"
 	"ws-go/libsignal/protocol"
 	"ws-go/libsignal/session"
 	"ws-go/libsignal/state/record"
 	"ws-go/protocol/axolotl/serializer"
 	"ws-go/protocol/axolotl/store"
-	"ws-go/protocol/define"
 )
 
 const (
 	maxKeys = 812
-
-	sqlCreateIdentities      = "CREATE TABLE identities (_id INTEGER PRIMARY KEY AUTOINCREMENT, recipient_id INTEGER UNIQUE, device_id INTEGER, registration_id INTEGER, public_key BLOB, private_key BLOB, next_prekey_id INTEGER, timestamp INTEGER)"
-	sqlCreateIdentitiesIndex = "CREATE UNIQUE INDEX IF NOT EXISTS identities_idx ON identities(recipient_id, device_id)"
-	sqlCreatePreKeys         = "CREATE TABLE prekeys (_id INTEGER PRIMARY KEY AUTOINCREMENT, prekey_id INTEGER UNIQUE, sent_to_server BOOLEAN, record BLOB, direct_distribution BOOLEAN, upload_timestamp INTEGER)"
-	sqlCreateSession         = "CREATE TABLE sessions (_id INTEGER PRIMARY KEY AUTOINCREMENT, recipient_id INTEGER, device_id INTEGER, record BLOB, timestamp INTEGER)"
-	sqlCreateSenderKeys      = "CREATE TABLE sender_keys (_id INTEGER PRIMARY KEY AUTOINCREMENT, group_id TEXT NOT NULL, sender_id INTEGER NOT NULL, record BLOB NOT NULL)"
-	sqlCreateSignedPreKeys   = "CREATE TABLE signed_prekeys (_id INTEGER PRIMARY KEY AUTOINCREMENT, prekey_id INTEGER UNIQUE, timestamp INTEGER, record BLOB)"
 )
 
 func init() {
@@ -41,7 +30,6 @@ func init() {
 }
 
 type Manager struct {
-	axolotlDB gdb.DB
 	*store.SignalStore
 	sessionCiphers map[string]*session.Cipher
 	groupSession   map[string]*groups.GroupCipher
@@ -49,141 +37,22 @@ type Manager struct {
 	Lock           sync.RWMutex
 }
 
-var createAxolotlTablesLock sync.Mutex
-
-// createAxolotlTables 新建表
-func createAxolotlTables(db gdb.DB) {
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println("run web error:createAxolotlTables", err)
-		}
-	}()
-	if db == nil {
-		return
-	}
-	createAxolotlTablesLock.Lock()
-	defer createAxolotlTablesLock.Unlock()
-	// create tables
-	// create identities table
-	_, err := db.Exec(sqlCreateIdentities)
-	if err != nil {
-		log.Println("createAxolotlTables: create identities", err)
-		return
-	}
-	// create identities index
-	_, err = db.Exec(sqlCreateIdentitiesIndex)
-	if err != nil {
-		log.Println("createAxolotlTables: create identities index", err)
-		return
-	}
-	// create preKeys tables
-	_, err = db.Exec(sqlCreatePreKeys)
-	if err != nil {
-		log.Println("createAxolotlTables: create preKeys", err)
-		return
-	}
-	// create session tables
-	_, err = db.Exec(sqlCreateSession)
-	if err != nil {
-		log.Println("createAxolotlTables: create session", err)
-		return
-	}
-	// create senderkeys table
-	_, err = db.Exec(sqlCreateSenderKeys)
-	if err != nil {
-		log.Println("createAxolotlTables: create senderkeys", err)
-		return
-	}
-	// create signed preKeys
-	_, err = db.Exec(sqlCreateSignedPreKeys)
-	if err != nil {
-		log.Println("createAxolotlTables: create senderkeys", err)
-		return
-	}
-}
-
-// checkAxolotlDatabase
-func checkAxolotlDatabase(u string) bool {
-	dbPath := fmt.Sprintf("%s/%s/axolotl", define.DefaultDbPath, u)
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		return false
-	}
-	return true
-}
-
-//判断文件或文件夹是否存在
-func isExist(path string) bool {
-	_, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false
-		}
-	}
-	return true
-}
-
-// initAxolotlDB
-func initAxolotlDB(u string) (gdb.DB, bool, error) {
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println("run web error:initAxolotlDB", err)
-		}
-	}()
-	var axolotlDb gdb.DB
-	dbPath := fmt.Sprintf("%s/%s/axolotl", define.DefaultDbPath, u)
-	// init databases
-	gdb.AddConfigNode(u, gdb.ConfigNode{
-		Type:    "sqlite",
-		Charset: "utf8",
-		Link:    dbPath,
-	})
-	// connect databases
-	if db, err := gdb.New(u); err != nil {
-		log.Println(err.Error())
-		return nil, false, err
-	} else {
-		axolotlDb = db
-	}
-	var needInit bool
-	// exist databases file
-	if !checkAxolotlDatabase(u) {
-		if !isExist(define.DefaultDbPath + "/" + u) {
-			err := os.Mkdir(define.DefaultDbPath+"/"+u, 0777)
-			if err != nil {
-				log.Println("mkdir error ", err.Error())
-				return nil, false, err
-			}
-		}
-		// create tables
-		createAxolotlTables(axolotlDb)
-		// needInit
-		needInit = true
+// NewAxolotlManager 数据保存在 backend 中 backend 没有本机身份密钥时生成新的密钥和 prekeys
+func NewAxolotlManager(backend store.Backend, staticPubKey string, staticPriKey string) (*Manager, error) {
+	if backend == nil {
+		return nil, errors.New("axolotl backend is nil")
 	}
-	return axolotlDb, needInit, nil
-}
-
-// NewAxolotlManager
-func NewAxolotlManager(u string, staticPubKey string, staticPriKey string) (*Manager, error) {
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println("run web error:NewAxolotlManager", err)
-		}
-	}()
 	m := &Manager{
 		sessionCiphers: make(map[string]*session.Cipher, 0),
 		groupSession:   make(map[string]*groups.GroupCipher, 0),
 		Lock:           sync.RWMutex{},
 	}
-	// init databases
-	db, needInit, err := initAxolotlDB(u)
+	signalStore, needInit, err := store.NewSignalStore(backend, staticPubKey, staticPriKey)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-
-	// set databases
-	m.axolotlDB = db
-	m.SignalStore = store.NewSignalStore(db, needInit, staticPubKey, staticPriKey)
+	m.SignalStore = signalStore
 	m.groupBuilder = groups.NewGroupSessionBuilder(m.SenderKeyStore, m.Serialize)
 	// gen keys
 	if needInit && m.GetAllPreKeys() <= 0 {
@@ -250,49 +119,40 @@ func (m *Manager) UpdatePreKeysSent(ids []int) error {
 			fmt.Println("run web error:UpdatePreKeysSent", err)
 		}
 	}()
-	if m.axolotlDB == nil {
-		return errors.New("data bases not init")
-	}
 	m.Lock.Lock()
 	defer m.Lock.Unlock()
-	preKeysModel := m.axolotlDB.Model("prekeys")
-	anyMap := gdb.Map{"sent_to_server": 1, "upload_timestamp": gtime.TimestampMilli()}
-	_, err := preKeysModel.
-		Where("prekey_id IN(?)", ids).
-		Data(anyMap).
-		Update()
+	preKeyIds := make([]uint32, 0, len(ids))
+	for _, id := range ids {
+		preKeyIds = append(preKeyIds, uint32(id))
+	}
+	return m.Backend().MarkPreKeysSent(preKeyIds)
+}
+
+// loadPreKeys unsent 为 true 时只返回没有上传的
+func (m *Manager) loadPreKeys(unsent bool) ([]*record.PreKey, error) {
+	list, err := m.Backend().LoadPreKeys(unsent)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	preKeys := make([]*record.PreKey, 0, len(list))
+	protoPreKeyRecordSerializer := &serializer.ProtoPreKeyRecordSerializer{}
+	for _, r := range list {
+		preKey, err := record.NewPreKeyFromBytes(r.Record, protoPreKeyRecordSerializer)
+		if err != nil {
+			return nil, err
+		}
+		preKeys = append(preKeys, preKey)
+	}
+	return preKeys, nil
 }
 
-func (m Manager) GetPreKeys() ([]*record.PreKey, error) {
+func (m *Manager) GetPreKeys() ([]*record.PreKey, error) {
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Println("run web error:GetPreKeys", err)
 		}
 	}()
-	preKeys := make([]*record.PreKey, 0)
-	if m.axolotlDB != nil {
-		preKeysModel := m.axolotlDB.Model("prekeys")
-		all, err := preKeysModel.FindAll()
-		if err != nil {
-			return nil, err
-		}
-		// all
-		protoPreKeyRecordSerializer := &serializer.ProtoPreKeyRecordSerializer{}
-		for _, r := range all {
-			if recordValue, ok := r["record"]; ok {
-				preKey, err := record.NewPreKeyFromBytes(recordValue.Bytes(), protoPreKeyRecordSerializer)
-				if err != nil {
-					return nil, err
-				}
-				preKeys = append(preKeys, preKey)
-			}
-		}
-	}
-	return preKeys, nil
+	return m.loadPreKeys(false)
 }
 
 // LoadUnSendPreKey
@@ -302,32 +162,12 @@ func (m *Manager) LoadUnSendPreKey() ([]*record.PreKey, error) {
 			fmt.Println("run web error:GetPreKeys", err)
 		}
 	}()
-	preKeys := make([]*record.PreKey, 0)
-	if m.axolotlDB != nil {
-		m.Lock.RLock()
-		defer m.Lock.RUnlock()
-		preKeysModel := m.axolotlDB.Model("prekeys")
-		all, err := preKeysModel.FindAll("sent_to_server = 0")
-		if err != nil {
-			return nil, err
-		}
-		// all
-		protoPreKeyRecordSerializer := &serializer.ProtoPreKeyRecordSerializer{}
-		for _, r := range all {
-			if recordValue, ok := r["record"]; ok {
-				preKey, err := record.NewPreKeyFromBytes(recordValue.Bytes(), protoPreKeyRecordSerializer)
-				if err != nil {
-					return nil, err
-				}
-				preKeys = append(preKeys, preKey)
-			}
-		}
-	}
-
-	return preKeys, nil
+	m.Lock.RLock()
+	defer m.Lock.RUnlock()
+	return m.loadPreKeys(true)
 }
 
-// GetUnSentPreKeysCount
+// GetAllPreKeys
 func (m *Manager) GetAllPreKeys() int {
 	defer func() {
 		if err := recover(); err != nil {
@@ -336,11 +176,7 @@ func (m *Manager) GetAllPreKeys() int {
 	}()
 	m.Lock.RLock()
 	defer m.Lock.RUnlock()
-	if m.axolotlDB == nil {
-		return -1
-	}
-	preKeysModel := m.axolotlDB.Model("prekeys")
-	all, err := preKeysModel.Count()
+	all, err := m.Backend().CountPreKeys(false)
 	if err != nil {
 		log.Println("AxolotlManager HasUnsentPreKeys error", err)
 		return -1
@@ -348,25 +184,29 @@ func (m *Manager) GetAllPreKeys() int {
 	return all
 }
 
-// GetUnSentPreKeysCount
+// GetUnSentPreKeysCount sent 为 0 时返回没有上传的数量 否则返回已上传的数量
 func (m *Manager) GetUnSentPreKeysCount(sent int) int {
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Println("run web error:GetPreKeys", err)
 		}
 	}()
-	if m.axolotlDB == nil {
-		return -1
-	}
 	m.Lock.RLock()
 	defer m.Lock.RUnlock()
-	preKeysModel := m.axolotlDB.Model("prekeys")
-	unSentCount, err := preKeysModel.Where("sent_to_server = ? AND direct_distribution = 0", sent).Count()
+	unSentCount, err := m.Backend().CountPreKeys(true)
+	if err != nil {
+		log.Println("AxolotlManager HasUnsentPreKeys error", err)
+		return -1
+	}
+	if sent == 0 {
+		return unSentCount
+	}
+	all, err := m.Backend().CountPreKeys(false)
 	if err != nil {
 		log.Println("AxolotlManager HasUnsentPreKeys error", err)
 		return -1
 	}
-	return unSentCount
+	return all - unSentCount
 }
 
 // HasUnSentPreKeys 有未发送的PreKeys
@@ -388,7 +228,19 @@ func (m *Manager) ContainsSession(id string) bool {
 	return m.SessionStore.ContainsSession(protocol.NewSignalAddress(id, 0))
 }
 
-// CreateSession
+// RemoteRegistrationId 当前 session 中对方的注册 id 没有 session 时返回 false
+func (m *Manager) RemoteRegistrationId(id string) (uint32, bool) {
+	if strings.Contains(id, "@") {
+		id = strings.Split(id, "@")[0]
+	}
+	address := protocol.NewSignalAddress(id, 0)
+	if !m.SessionStore.ContainsSession(address) {
+		return 0, false
+	}
+	return m.SessionStore.LoadSession(address).SessionState().RemoteRegistrationID(), true
+}
+
+// CreateSession 已经有 session 时旧的 session 归档到 PreviousSessionStates
 func (m *Manager) CreateSession(receiptId string, preKeyBundle *prekey.Bundle) error {
 
 	signalAddress := protocol.NewSignalAddress(receiptId, 0)
@@ -397,6 +249,18 @@ func (m *Manager) CreateSession(receiptId string, preKeyBundle *prekey.Bundle) e
 	return builder.ProcessBundle(preKeyBundle)
 }
 
+// ApproveIdentity TrustBlockOnChange 时确认联系人新的身份密钥
+// 之后重新获取 prekeys 调用 CreateSession 时旧的 session 会被归档
+func (m *Manager) ApproveIdentity(id string, key *identity.Key) error {
+	if key == nil {
+		return errors.New("identity key is nil")
+	}
+	return m.Transaction(func(tx *store.SignalStore) error {
+		tx.IdentityStore.SaveIdentity(protocol.NewSignalAddress(id, 0), key)
+		return nil
+	})
+}
+
 // CreateGroupSession
 func (m *Manager) CreateGroupSession(groupId, participantId string) (*protocol.SenderKeyDistributionMessage, error) {
 	signalAddress := protocol.NewSignalAddress(participantId, 0)
@@ -438,10 +302,7 @@ func (m *Manager) getGroupSessionCipher(groupId, participantId string) *groups.G
 	return cipher
 }
 func (m *Manager) getSessionBuilder(id string) *session.Builder {
-	signalAddress := protocol.NewSignalAddress(id, 0)
-	sessionBuilder := session.NewBuilder(
-		m.SessionStore, m.PreKeyStore, m.SignedPreKeyStore, m.IdentityStore, signalAddress, m.Serialize)
-	return sessionBuilder
+	return newSessionBuilder(m.SignalStore, id)
 }
 
 // getSessionCipher
@@ -462,59 +323,101 @@ func (m *Manager) getSessionCipher(id string) *session.Cipher {
 	return newCipher
 }
 
-// groupEncrypt
-func (m *Manager) groupEncrypt(id, participantId string, d []byte) (protocol.CiphertextMessage, error) {
-	cipher := m.getGroupSessionCipher(id, participantId)
-	if cipher == nil {
-		return nil, errors.New("create cipher error")
+// newSessionBuilder 使用 s 中的 store
+func newSessionBuilder(s *store.SignalStore, id string) *session.Builder {
+	signalAddress := protocol.NewSignalAddress(id, 0)
+	return session.NewBuilder(
+		s.SessionStore, s.PreKeyStore, s.SignedPreKeyStore, s.IdentityStore, signalAddress, s.Serialize)
+}
+
+// newSessionCipher 事务中每次新建 cipher 不使用缓存
+func newSessionCipher(s *store.SignalStore, id string) *session.Cipher {
+	if strings.Contains(id, "@") {
+		id = strings.Split(id, "@")[0]
 	}
+	return session.NewCipher(newSessionBuilder(s, id), protocol.NewSignalAddress(id, 0))
+}
 
+// newGroupSessionCipher
+func newGroupSessionCipher(s *store.SignalStore, groupId, participantId string) *groups.GroupCipher {
+	signalAddress := protocol.NewSignalAddress(participantId, 0)
+	senderKeyName := protocol.NewSenderKeyName(groupId, signalAddress)
+	builder := groups.NewGroupSessionBuilder(s.SenderKeyStore, s.Serialize)
+	return groups.NewGroupCipher(builder, senderKeyName, s.SenderKeyStore)
+}
+
+// groupEncrypt
+func groupEncrypt(s *store.SignalStore, id, participantId string, d []byte) (protocol.CiphertextMessage, error) {
+	cipher := newGroupSessionCipher(s, id, participantId)
 	log.Println(" cipher.Encrypt", hex.EncodeToString(d))
 	return cipher.Encrypt(d)
 }
 
 // uEncrypt
-func (m *Manager) uEncrypt(id string, d []byte) (protocol.CiphertextMessage, error) {
-	cipher := m.getSessionCipher(id)
-	if cipher == nil {
-		return nil, errors.New("create cipher error")
-	}
-
+func uEncrypt(s *store.SignalStore, id string, d []byte) (protocol.CiphertextMessage, error) {
+	cipher := newSessionCipher(s, id)
 	log.Println(" cipher.Encrypt", hex.EncodeToString(d))
 	return cipher.Encrypt(d)
 }
 
-// Encrypt
+// Encrypt session 的修改在一个事务中 加密失败时回滚
 func (m *Manager) Encrypt(id string, d []byte, isGroup bool, participantId ...string) (protocol.CiphertextMessage, error) {
 	//// 在进行 Proto 加密前 随机生成 0 ~ 16 Byte 进行数据填充
 	randomPaddingData := randomPadding()
 	// append randomPaddingData
 	d = append(d, randomPaddingData...)
 
-	//if is group
-	if isGroup && len(participantId) > 0 && participantId[0] != "" { //
-		return m.groupEncrypt(id, participantId[0], d)
-	} else {
-		return m.uEncrypt(id, d)
+	var message protocol.CiphertextMessage
+	err := m.Transaction(func(tx *store.SignalStore) error {
+		var err error
+		//if is group
+		if isGroup && len(participantId) > 0 && participantId[0] != "" { //
+			message, err = groupEncrypt(tx, id, participantId[0], d)
+		} else {
+			message, err = uEncrypt(tx, id, d)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// EncryptResend 对方请求重发 重建 session 和加密在一个事务中 和 Decrypt 串行执行
+// bundle 不为 nil 时 如果当前 session 中对方的注册 id 不是 regId 先使用 bundle 建立新的 session
+func (m *Manager) EncryptResend(id string, regId uint32, bundle *prekey.Bundle, d []byte) (protocol.CiphertextMessage, error) {
+	d = append(d, randomPadding()...)
+	var message protocol.CiphertextMessage
+	err := m.Transaction(func(tx *store.SignalStore) error {
+		address := protocol.NewSignalAddress(id, 0)
+		// 获取 prekeys 时解密可能已经建立了新的 session
+		current := regId != 0 && tx.SessionStore.ContainsSession(address) &&
+			tx.SessionStore.LoadSession(address).SessionState().RemoteRegistrationID() == regId
+		if bundle != nil && !current {
+			if err := newSessionBuilder(tx, id).ProcessBundle(bundle); err != nil {
+				return err
+			}
+		}
+		var err error
+		message, err = uEncrypt(tx, id, d)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return message, nil
 }
 
-func (m *Manager) decryptPreKeySignalMessageNew(from, participant string, d []byte) ([]byte, error) {
-	var (
-		c *session.Cipher
-	)
+func decryptPreKeySignalMessageNew(s *store.SignalStore, from, participant string, d []byte) ([]byte, error) {
 	// id
 	if participant != "" {
 		from = participant
 	}
 
-	c = m.getSessionCipher(from)
-	if c == nil {
-		return nil, errors.New("get cipher error is null")
-	}
-
+	c := newSessionCipher(s, from)
 	//get session builder
-	sessionBuilder := m.getSessionBuilder(from)
+	sessionBuilder := newSessionBuilder(s, from)
 	// serializer message
 	signalMessageSerializer := &serializer.ProtoSignalMessageSerializer{}
 	PreKeySignalMessageSerializer := &serializer.ProtoPreKeySignalMessageSerializer{}
@@ -522,57 +425,25 @@ func (m *Manager) decryptPreKeySignalMessageNew(from, participant string, d []by
 	if err != nil {
 		return nil, err
 	}
-	// process
+	// process 会删除使用过的 prekey
 	_, err = sessionBuilder.Process(preKeySignalMessage)
 	if err != nil {
 		log.Println("decryptPreKeySignalMessageNew error", err)
 	}
-	//log.Println("decryptPreKeySignalMessageNew unsignedPreKeyID:",unsignedPreKeyID.Value,err)
 
 	// decrypt
 	decryptedData, err := c.Decrypt(preKeySignalMessage.WhisperMessage())
 	return removePadding(decryptedData), err
 }
 
-//decryptPreKeySignalMessage
-func (m *Manager) decryptPreKeySignalMessage(from, participant string, d []byte) ([]byte, error) {
-	var (
-		c *session.Cipher
-	)
-	// id
-	if participant != "" {
-		from = participant
-	}
-
-	c = m.getSessionCipher(from)
-	if c == nil {
-		return nil, errors.New("get cipher error is null")
-	}
-	// decrypt
-	signalMessageSerializer := &serializer.ProtoSignalMessageSerializer{}
-	PreKeysignalMessageSerializer := &serializer.ProtoPreKeySignalMessageSerializer{}
-	preKeySignalMessage, err := protocol.NewPreKeySignalMessageFromBytes(d, PreKeysignalMessageSerializer, signalMessageSerializer)
-	if err != nil {
-		return nil, err
-	}
-	decryptedData, err := c.Decrypt(preKeySignalMessage.WhisperMessage())
-	return removePadding(decryptedData), err
-}
-
 // decryptSignalMessage
-func (m *Manager) decryptSignalMessage(from, participant string, d []byte) ([]byte, error) {
-	var (
-		c *session.Cipher
-	)
+func decryptSignalMessage(s *store.SignalStore, from, participant string, d []byte) ([]byte, error) {
 	// id
 	if participant != "" {
 		from = participant
 	}
 
-	c = m.getSessionCipher(from)
-	if c == nil {
-		return nil, errors.New("get cipher error is null")
-	}
+	c := newSessionCipher(s, from)
 	// decrypt
 	signalMessageSerializer := &serializer.ProtoSignalMessageSerializer{}
 	signalMessage, err := protocol.NewSignalMessageFromBytes(d, signalMessageSerializer)
@@ -584,9 +455,9 @@ func (m *Manager) decryptSignalMessage(from, participant string, d []byte) ([]by
 }
 
 // decryptSenderKeyMessage
-func (m *Manager) decryptSenderKeyMessage(from, participant string, d []byte) ([]byte, error) {
+func decryptSenderKeyMessage(s *store.SignalStore, from, participant string, d []byte) ([]byte, error) {
 	// get group cipher
-	cipher := m.getGroupSessionCipher(from, participant)
+	cipher := newGroupSessionCipher(s, from, participant)
 	// Serializer
 	senderKeyMessageSerializer := &serializer.ProtoSenderKeyMessageSerializer{}
 	senderKeyMessage, err := protocol.NewSenderKeyMessageFromBytes(d, senderKeyMessageSerializer)
@@ -597,20 +468,28 @@ func (m *Manager) decryptSenderKeyMessage(from, participant string, d []byte) ([
 	return removePadding(decryptedData), err
 }
 
-// Decrypt decryptMsg
+// Decrypt decryptMsg session prekey identity 的修改在一个事务中 解密失败时回滚
 func (m *Manager) Decrypt(from, participant string, d []byte, deType string) ([]byte, error) {
-	// decrypt
-	switch deType {
-	case "msg":
-		return m.decryptSignalMessage(from, participant, d)
-	case "pkmsg":
-		return m.decryptPreKeySignalMessageNew(from, participant, d)
-	case "skmsg":
-		return m.decryptSenderKeyMessage(from, participant, d)
-	case "frskmsg":
+	var decryptedData []byte
+	err := m.Transaction(func(tx *store.SignalStore) error {
+		var err error
+		// decrypt
+		switch deType {
+		case "msg":
+			decryptedData, err = decryptSignalMessage(tx, from, participant, d)
+		case "pkmsg":
+			decryptedData, err = decryptPreKeySignalMessageNew(tx, from, participant, d)
+		case "skmsg":
+			decryptedData, err = decryptSenderKeyMessage(tx, from, participant, d)
+		case "frskmsg":
 
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, nil
+	return decryptedData, nil
 }
 
 // randomPadding 随机填充
@@ -12,6 +12,7 @@ import (
 	"ws-go/libsignal/util/bytehelper"
 	"ws-go/libsignal/util/optional"
 	"ws-go/protocol/axolotl/serializer"
+	"ws-go/protocol/axolotl/store"
 )
 
 func TestManager_Decrypt(t *testing.T) {
@@ -20,7 +21,7 @@ func TestManager_Decrypt(t *testing.T) {
 }
 
 func TestManager_CreateGroupSession(t *testing.T) {
-	axolotlManager, _ := NewAxolotlManager("test")
+	axolotlManager, _ := NewAxolotlManager(store.NewMemoryBackend(), "", "")
 	data, _ := hex.DecodeString("3308e0dab8fa0210011a20dfb19187b6bada0861d391b9b34191c29f1a9a9fcf6a9801f38c8ad0e3394e63fd34e9e4deed4c9c92291fe2f1d300f67b31b7571d6764b2b7f7252729170f17cd5de5134b52d1c28ffde2d224caee5fe74c9d67f1b139ed501f325568eca206")
 	axolotlManager.ProcessGroupSession("8617607567005-1617889232@g.us", "8617607567005@s.whatsapp.net", data)
 }
@@ -28,7 +29,7 @@ func TestManager_CreateGroupSession(t *testing.T) {
 func TestDecryptMsg(t *testing.T) {
 	messageSerializer := serializer.ProtoPreKeySignalMessageSerializer{}
 	signalMessageSerializer := serializer.ProtoSignalMessageSerializer{}
-	axolotlManager, _ := NewAxolotlManager("test")
+	axolotlManager, _ := NewAxolotlManager(store.NewMemoryBackend(), "", "")
 	//axolotlManager.SessionStore.LoadSession(protocol.NewSignalAddress("aaa", 0))
 	//cipher := axolotlManager.getSessionCipher("aaa")
 
@@ -60,7 +61,7 @@ func TestQT(t *testing.T) {
 }
 
 func TestCreateSession(t *testing.T) {
-	axolotlManager, _ := NewAxolotlManager("test")
+	axolotlManager, _ := NewAxolotlManager(store.NewMemoryBackend(), "", "")
 	/*registrationID uint32,
 	deviceID uint32,
 	preKeyID *optional.Uint32,
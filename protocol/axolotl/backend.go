@@ -0,0 +1,196 @@
+package axolotl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"ws-go/protocol/axolotl/store"
+	"ws-go/protocol/define"
+
+	"github.com/gogf/gf/database/gdb"
+	"github.com/gogf/gf/frame/g"
+)
+
+// 配置文件中的 axolotl 存储后端 backend 为 sqlite(默认) memory mysql pgsql
+// link 为 mysql/pgsql 的连接 例如 root:123456@tcp(127.0.0.1:3306)/ws
+const (
+	BackendSQLite = "sqlite"
+	BackendMemory = "memory"
+	BackendMySQL  = "mysql"
+	BackendPgSQL  = "pgsql"
+
+	sharedGroupName = "axolotl-shared"
+)
+
+// 私钥和 record 的 KEK 环境变量优先 其次是 key 文件 都没有时明文保存
+// KEK 为 32 字节 可以是原始数据 base64 或 hex 文本
+// 已有的明文数据库配置 KEK 后仍然可以读取 使用 rekey 命令全部加密
+// 全部加密后配置 axolotl.requireSealed = true 拒绝读取明文 防止数据被降级为明文
+const (
+	EnvKEK     = "WS_AXOLOTL_KEK"
+	EnvKEKFile = "WS_AXOLOTL_KEK_FILE"
+)
+
+var (
+	sharedDB     gdb.DB
+	sharedDBLock sync.Mutex
+)
+
+// OpenBackend 根据配置打开账号 u 的存储后端 配置了 KEK 时加密保存
+func OpenBackend(u string) (store.Backend, error) {
+	sealer, err := LoadSealer()
+	if err != nil {
+		return nil, err
+	}
+	b, err := openBackend(u)
+	if err != nil {
+		return nil, err
+	}
+	return store.NewEncryptedBackend(b, sealer), nil
+}
+
+func openBackend(u string) (store.Backend, error) {
+	backend := g.Cfg().GetString("axolotl.backend", BackendSQLite)
+	switch backend {
+	case BackendSQLite:
+		return store.OpenSQLiteBackend(fmt.Sprintf("%s/%s/axolotl", define.DefaultDbPath, u))
+	case BackendMemory:
+		return store.NewMemoryBackend(), nil
+	case BackendMySQL, BackendPgSQL:
+		db, err := openSharedDB(backend, g.Cfg().GetString("axolotl.link"))
+		if err != nil {
+			return nil, err
+		}
+		return store.NewSharedSQLBackend(db, u)
+	}
+	return nil, fmt.Errorf("unknown axolotl backend %q", backend)
+}
+
+// TrustPolicyOf 账号 u 的信任策略 配置文件 axolotl.trust 中账号的配置优先 其次是 default
+func TrustPolicyOf(u string) (store.TrustPolicy, error) {
+	policy := g.Cfg().GetString("axolotl.trust." + u)
+	if policy == "" {
+		policy = g.Cfg().GetString("axolotl.trust.default")
+	}
+	return store.ParseTrustPolicy(policy)
+}
+
+// openSharedDB 所有账号共用一个数据库连接
+func openSharedDB(dbType, link string) (gdb.DB, error) {
+	sharedDBLock.Lock()
+	defer sharedDBLock.Unlock()
+	if sharedDB != nil {
+		return sharedDB, nil
+	}
+	gdb.SetConfigGroup(sharedGroupName, gdb.ConfigGroup{{
+		Type:    dbType,
+		Charset: "utf8",
+		Link:    link,
+	}})
+	db, err := gdb.New(sharedGroupName)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.CreateSharedTables(db); err != nil {
+		return nil, err
+	}
+	sharedDB = db
+	return sharedDB, nil
+}
+
+// LoadSealer 读取环境变量或配置文件 axolotl.kekFile 中的 KEK 没有配置时返回 nil
+func LoadSealer() (*store.Sealer, error) {
+	sealer, err := loadSealer()
+	if err != nil {
+		return nil, err
+	}
+	if g.Cfg().GetBool("axolotl.requireSealed") {
+		if sealer == nil {
+			return nil, errors.New("axolotl.requireSealed is set but no key-encryption key is configured")
+		}
+		sealer.SetRequireSealed(true)
+	}
+	return sealer, nil
+}
+
+func loadSealer() (*store.Sealer, error) {
+	if kek := os.Getenv(EnvKEK); kek != "" {
+		return ParseSealer([]byte(kek))
+	}
+	path := os.Getenv(EnvKEKFile)
+	if path == "" {
+		path = g.Cfg().GetString("axolotl.kekFile")
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return ReadSealer(path)
+}
+
+// ReadSealer 读取 key 文件
+func ReadSealer(path string) (*store.Sealer, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key-encryption key: %w", err)
+	}
+	return ParseSealer(d)
+}
+
+// ParseSealer d 为原始数据 base64 或 hex 文本
+func ParseSealer(d []byte) (*store.Sealer, error) {
+	kek, err := store.ParseKEK(d)
+	if err != nil {
+		return nil, err
+	}
+	return store.NewSealer(kek)
+}
+
+// Rekey 按配置的 axolotl.backend 离线修改所有账号的 KEK sqlite 为 dir 下的数据库
+// mysql pgsql 为共用数据库 memory 不保存 返回错误
+func Rekey(dir string, from, to *store.Sealer) (map[string]int, error) {
+	backend := g.Cfg().GetString("axolotl.backend", BackendSQLite)
+	switch backend {
+	case BackendSQLite:
+		return RekeyDir(dir, from, to)
+	case BackendMySQL, BackendPgSQL:
+		db, err := openSharedDB(backend, g.Cfg().GetString("axolotl.link"))
+		if err != nil {
+			return nil, err
+		}
+		return store.RekeyShared(db, from, to)
+	}
+	return nil, fmt.Errorf("rekey is not supported for axolotl backend %q", backend)
+}
+
+// RekeyDir 离线修改 dir 下所有账号 sqlite 数据库的 KEK from to 为 nil 表示明文
+// 返回每个账号修改的行数 程序运行时不要执行
+func RekeyDir(dir string, from, to *store.Sealer) (map[string]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name(), "axolotl")
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		b, err := store.OpenSQLiteBackend(path)
+		if err != nil {
+			return result, err
+		}
+		n, err := b.Rekey(from, to)
+		_ = b.Close()
+		if err != nil {
+			return result, fmt.Errorf("rekey %s: %w", entry.Name(), err)
+		}
+		result[entry.Name()] = n
+	}
+	return result, nil
+}
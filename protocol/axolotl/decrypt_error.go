@@ -0,0 +1,38 @@
+package axolotl
+
+import (
+	"strings"
+	"ws-go/libsignal/exception"
+)
+
+// DecryptErrorClass 解密失败的原因 保存在隔离的消息中
+type DecryptErrorClass string
+
+const (
+	DecryptNoSession         DecryptErrorClass = "no_session"
+	DecryptNoValidSession    DecryptErrorClass = "no_valid_session"
+	DecryptNoSenderKey       DecryptErrorClass = "no_sender_key"
+	DecryptUntrustedIdentity DecryptErrorClass = "untrusted_identity"
+	DecryptInvalidMessage    DecryptErrorClass = "invalid_message"
+)
+
+// ClassifyDecryptError libsignal 的错误大多只有文本 按文本前缀区分
+func ClassifyDecryptError(err error) DecryptErrorClass {
+	switch err.(type) {
+	case *exception.NoSessionException:
+		return DecryptNoSession
+	case *exception.NoValidSessions:
+		return DecryptNoValidSession
+	}
+	text := ""
+	if err != nil {
+		text = err.Error()
+	}
+	switch {
+	case strings.HasPrefix(text, "No sender key"):
+		return DecryptNoSenderKey
+	case strings.HasPrefix(text, "Untrusted identity"):
+		return DecryptUntrustedIdentity
+	}
+	return DecryptInvalidMessage
+}
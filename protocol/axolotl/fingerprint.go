@@ -0,0 +1,55 @@
+package axolotl
+
+import (
+	"ws-go/libsignal/fingerprint"
+	"ws-go/protocol/axolotl/store"
+)
+
+var fingerprintGenerator = fingerprint.NewNumericFingerprintGenerator(fingerprint.DefaultIterations)
+
+// Fingerprint 本机 localID 和联系人 id 的安全码 没有联系人的身份公钥时返回 store.ErrNoIdentity
+func (m *Manager) Fingerprint(localID, id string) (*fingerprint.Fingerprint, error) {
+	return createFingerprint(m.SignalStore, localID, id)
+}
+
+// VerifyFingerprint scanned 为扫描对方二维码得到的数据 匹配时保存为已经验证
+func (m *Manager) VerifyFingerprint(localID, id string, scanned []byte) (bool, error) {
+	var ok bool
+	err := m.Transaction(func(tx *store.SignalStore) error {
+		f, err := createFingerprint(tx, localID, id)
+		if err != nil {
+			return err
+		}
+		if ok, err = f.Scan().Compare(scanned); err != nil || !ok {
+			return err
+		}
+		return tx.Backend().StoreIdentityVerified(id, true)
+	})
+	return ok, err
+}
+
+// SetIdentityVerified 手动设置联系人的安全码是否已经验证
+func (m *Manager) SetIdentityVerified(id string, verified bool) error {
+	return m.Backend().StoreIdentityVerified(id, verified)
+}
+
+// IsIdentityVerified 联系人的安全码是否已经验证 身份密钥变化后清除
+func (m *Manager) IsIdentityVerified(id string) (bool, error) {
+	return m.Backend().LoadIdentityVerified(id)
+}
+
+func createFingerprint(s *store.SignalStore, localID, id string) (*fingerprint.Fingerprint, error) {
+	publicKey, err := s.Backend().LoadIdentity(id)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey == nil {
+		return nil, store.ErrNoIdentity
+	}
+	remoteKey, err := store.DecodeIdentityKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	localKey := s.IdentityStore.GetIdentityKeyPair().PublicKey()
+	return fingerprintGenerator.CreateFor(localID, id, localKey, remoteKey), nil
+}
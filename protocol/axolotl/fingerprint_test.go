@@ -0,0 +1,78 @@
+package axolotl
+
+import (
+	"errors"
+	"testing"
+	"ws-go/protocol/axolotl/store"
+)
+
+func TestManager_Fingerprint(t *testing.T) {
+	var changes []*store.IdentityChange
+	alice := newTestManager(t, store.NewMemoryBackend())
+	alice.IdentityStore.SetIdentityChangedHandler(func(change *store.IdentityChange) {
+		changes = append(changes, change)
+	})
+	bob := newTestManager(t, store.NewMemoryBackend())
+	if _, err := alice.Fingerprint(aliceID, bobID); !errors.Is(err, store.ErrNoIdentity) {
+		t.Fatalf("Fingerprint without identity err = %v", err)
+	}
+	if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+	exchange(t, alice, aliceID, bob, bobID, "hello")
+
+	aliceFingerprint, err := alice.Fingerprint(aliceID, bobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobFingerprint, err := bob.Fingerprint(bobID, aliceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceFingerprint.Display().DisplayText() != bobFingerprint.Display().DisplayText() {
+		t.Fatal("safety numbers don't match")
+	}
+
+	// 扫描自己的二维码不匹配
+	if ok, err := alice.VerifyFingerprint(aliceID, bobID, aliceFingerprint.Scan().Serialize()); err != nil || ok {
+		t.Fatalf("VerifyFingerprint own = %v, %v", ok, err)
+	}
+	if verified, _ := alice.IsIdentityVerified(bobID); verified {
+		t.Fatal("verified after mismatch")
+	}
+	if ok, err := alice.VerifyFingerprint(aliceID, bobID, bobFingerprint.Scan().Serialize()); err != nil || !ok {
+		t.Fatalf("VerifyFingerprint = %v, %v", ok, err)
+	}
+	if verified, err := alice.IsIdentityVerified(bobID); err != nil || !verified {
+		t.Fatalf("IsIdentityVerified = %v, %v", verified, err)
+	}
+	exchange(t, bob, bobID, alice, aliceID, "still verified")
+	if verified, _ := alice.IsIdentityVerified(bobID); !verified {
+		t.Fatal("verified cleared without identity change")
+	}
+
+	// bob 重新安装 已经验证的联系人密钥变化后不可信 确认后验证被清除
+	bob = newTestManager(t, store.NewMemoryBackend())
+	if err := alice.CreateSession(bobID, bundleOf(t, bob)); err == nil {
+		t.Fatal("changed identity of verified contact should not be trusted")
+	}
+	if len(changes) != 1 || !changes[0].Verified || changes[0].Trusted {
+		t.Fatalf("changes = %+v", changes)
+	}
+	if err := alice.ApproveIdentity(bobID, changes[0].NewKey); err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := alice.IsIdentityVerified(bobID); err != nil || verified {
+		t.Fatalf("IsIdentityVerified after change = %v, %v", verified, err)
+	}
+	if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := alice.Fingerprint(aliceID, bobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Display().DisplayText() == aliceFingerprint.Display().DisplayText() {
+		t.Fatal("safety number should change with identity")
+	}
+}
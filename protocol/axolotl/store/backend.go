@@ -0,0 +1,95 @@
+package store
+
+import "errors"
+
+// 存储后端 SignalStore 只负责序列化和缓存 数据的保存由 Backend 实现
+// 所有的 Load 方法在数据不存在时返回 nil, nil
+
+// ErrNoIdentity 没有保存联系人的身份公钥
+var ErrNoIdentity = errors.New("identity not found")
+
+// localIdentityName 本机身份密钥在 identities 表中的 recipient_id
+const localIdentityName = "-1"
+
+// LocalIdentity 本机的身份密钥
+type LocalIdentity struct {
+	RegistrationID uint32
+	PublicKey      []byte
+	PrivateKey     []byte
+}
+
+// PreKeyRecord 序列化后的 prekey
+type PreKeyRecord struct {
+	ID     uint32
+	Record []byte
+}
+
+// IdentityBackend identities
+type IdentityBackend interface {
+	LoadLocalIdentity() (*LocalIdentity, error)
+	StoreLocalIdentity(identity *LocalIdentity) error
+	// LoadIdentity 联系人的身份公钥
+	LoadIdentity(name string) ([]byte, error)
+	// StoreIdentity 公钥变化时清除 verified
+	StoreIdentity(name string, publicKey []byte) error
+	// LoadIdentityVerified 联系人的安全码是否已经验证
+	LoadIdentityVerified(name string) (bool, error)
+	// StoreIdentityVerified 没有保存联系人的公钥时返回 ErrNoIdentity
+	StoreIdentityVerified(name string, verified bool) error
+}
+
+// PreKeyBackend prekeys
+type PreKeyBackend interface {
+	LoadPreKey(id uint32) ([]byte, error)
+	StorePreKeys(keys []PreKeyRecord) error
+	ContainsPreKey(id uint32) (bool, error)
+	RemovePreKey(id uint32) error
+	// LoadPreKeys unsent 为 true 时只返回没有上传的
+	LoadPreKeys(unsent bool) ([]PreKeyRecord, error)
+	CountPreKeys(unsent bool) (int, error)
+	MarkPreKeysSent(ids []uint32) error
+}
+
+// SignedPreKeyBackend signed prekeys
+type SignedPreKeyBackend interface {
+	LoadSignedPreKey(id uint32) ([]byte, error)
+	LoadSignedPreKeys() ([]PreKeyRecord, error)
+	// StoreSignedPreKey 只保留最新的一个 signed prekey
+	StoreSignedPreKey(id uint32, record []byte) error
+	RemoveSignedPreKey(id uint32) error
+}
+
+// SessionBackend sessions
+type SessionBackend interface {
+	LoadSession(name string) ([]byte, error)
+	StoreSession(name string, record []byte) error
+	ContainsSession(name string) (bool, error)
+	DeleteSession(name string) error
+	DeleteAllSessions() error
+}
+
+// SenderKeyBackend 群聊 sender keys
+type SenderKeyBackend interface {
+	LoadSenderKey(groupID, sender string) ([]byte, error)
+	StoreSenderKey(groupID, sender string, record []byte) error
+}
+
+// Backend 一个账号的全部 signal 数据
+type Backend interface {
+	IdentityBackend
+	PreKeyBackend
+	SignedPreKeyBackend
+	SessionBackend
+	SenderKeyBackend
+	// Transaction f 中使用 tx 的修改在 f 返回 nil 时一起提交 否则全部回滚
+	Transaction(f func(tx Backend) error) error
+	Close() error
+}
+
+// cloneBytes 避免调用者修改保存的数据
+func cloneBytes(d []byte) []byte {
+	if d == nil {
+		return nil
+	}
+	return append([]byte{}, d...)
+}
@@ -0,0 +1,290 @@
+package store
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// MemoryBackend 数据只保存在内存中 用于测试
+type MemoryBackend struct {
+	mutex         sync.RWMutex
+	txMutex       sync.Mutex
+	localIdentity *LocalIdentity
+	identities    map[string][]byte
+	verified      map[string]bool
+	preKeys       map[uint32]*memoryPreKey
+	signedPreKeys map[uint32][]byte
+	sessions      map[string][]byte
+	senderKeys    map[string][]byte
+}
+
+type memoryPreKey struct {
+	record []byte
+	sent   bool
+}
+
+// NewMemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		identities:    make(map[string][]byte),
+		verified:      make(map[string]bool),
+		preKeys:       make(map[uint32]*memoryPreKey),
+		signedPreKeys: make(map[uint32][]byte),
+		sessions:      make(map[string][]byte),
+		senderKeys:    make(map[string][]byte),
+	}
+}
+
+func (m *MemoryBackend) LoadLocalIdentity() (*LocalIdentity, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.localIdentity == nil {
+		return nil, nil
+	}
+	return &LocalIdentity{
+		RegistrationID: m.localIdentity.RegistrationID,
+		PublicKey:      cloneBytes(m.localIdentity.PublicKey),
+		PrivateKey:     cloneBytes(m.localIdentity.PrivateKey),
+	}, nil
+}
+
+func (m *MemoryBackend) StoreLocalIdentity(identity *LocalIdentity) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.localIdentity = &LocalIdentity{
+		RegistrationID: identity.RegistrationID,
+		PublicKey:      cloneBytes(identity.PublicKey),
+		PrivateKey:     cloneBytes(identity.PrivateKey),
+	}
+	return nil
+}
+
+func (m *MemoryBackend) LoadIdentity(name string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return cloneBytes(m.identities[name]), nil
+}
+
+func (m *MemoryBackend) StoreIdentity(name string, publicKey []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !bytes.Equal(m.identities[name], publicKey) {
+		delete(m.verified, name)
+	}
+	m.identities[name] = cloneBytes(publicKey)
+	return nil
+}
+
+func (m *MemoryBackend) LoadIdentityVerified(name string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.verified[name], nil
+}
+
+func (m *MemoryBackend) StoreIdentityVerified(name string, verified bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.identities[name]; !ok {
+		return ErrNoIdentity
+	}
+	if verified {
+		m.verified[name] = true
+	} else {
+		delete(m.verified, name)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) LoadPreKey(id uint32) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if key, ok := m.preKeys[id]; ok {
+		return cloneBytes(key.record), nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryBackend) StorePreKeys(keys []PreKeyRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, key := range keys {
+		m.preKeys[key.ID] = &memoryPreKey{record: cloneBytes(key.Record)}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) ContainsPreKey(id uint32) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	_, ok := m.preKeys[id]
+	return ok, nil
+}
+
+func (m *MemoryBackend) RemovePreKey(id uint32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.preKeys, id)
+	return nil
+}
+
+func (m *MemoryBackend) LoadPreKeys(unsent bool) ([]PreKeyRecord, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	keys := make([]PreKeyRecord, 0, len(m.preKeys))
+	for id, key := range m.preKeys {
+		if unsent && key.sent {
+			continue
+		}
+		keys = append(keys, PreKeyRecord{ID: id, Record: cloneBytes(key.record)})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (m *MemoryBackend) CountPreKeys(unsent bool) (int, error) {
+	keys, err := m.LoadPreKeys(unsent)
+	return len(keys), err
+}
+
+func (m *MemoryBackend) MarkPreKeysSent(ids []uint32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, id := range ids {
+		if key, ok := m.preKeys[id]; ok {
+			key.sent = true
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) LoadSignedPreKey(id uint32) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return cloneBytes(m.signedPreKeys[id]), nil
+}
+
+func (m *MemoryBackend) LoadSignedPreKeys() ([]PreKeyRecord, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	keys := make([]PreKeyRecord, 0, len(m.signedPreKeys))
+	for id, record := range m.signedPreKeys {
+		keys = append(keys, PreKeyRecord{ID: id, Record: cloneBytes(record)})
+	}
+	return keys, nil
+}
+
+func (m *MemoryBackend) StoreSignedPreKey(id uint32, record []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.signedPreKeys = map[uint32][]byte{id: cloneBytes(record)}
+	return nil
+}
+
+func (m *MemoryBackend) RemoveSignedPreKey(id uint32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.signedPreKeys, id)
+	return nil
+}
+
+func (m *MemoryBackend) LoadSession(name string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return cloneBytes(m.sessions[name]), nil
+}
+
+func (m *MemoryBackend) StoreSession(name string, record []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions[name] = cloneBytes(record)
+	return nil
+}
+
+func (m *MemoryBackend) ContainsSession(name string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	_, ok := m.sessions[name]
+	return ok, nil
+}
+
+func (m *MemoryBackend) DeleteSession(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sessions, name)
+	return nil
+}
+
+func (m *MemoryBackend) DeleteAllSessions() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions = make(map[string][]byte)
+	return nil
+}
+
+func (m *MemoryBackend) LoadSenderKey(groupID, sender string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return cloneBytes(m.senderKeys[groupID+"::"+sender]), nil
+}
+
+func (m *MemoryBackend) StoreSenderKey(groupID, sender string, record []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.senderKeys[groupID+"::"+sender] = cloneBytes(record)
+	return nil
+}
+
+// Transaction 在副本上执行 f 成功后替换数据 同时只有一个事务
+func (m *MemoryBackend) Transaction(f func(tx Backend) error) error {
+	m.txMutex.Lock()
+	defer m.txMutex.Unlock()
+	tx := m.clone()
+	if err := f(tx); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.localIdentity = tx.localIdentity
+	m.identities = tx.identities
+	m.verified = tx.verified
+	m.preKeys = tx.preKeys
+	m.signedPreKeys = tx.signedPreKeys
+	m.sessions = tx.sessions
+	m.senderKeys = tx.senderKeys
+	return nil
+}
+
+func (m *MemoryBackend) clone() *MemoryBackend {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	c := NewMemoryBackend()
+	if m.localIdentity != nil {
+		local := *m.localIdentity
+		c.localIdentity = &local
+	}
+	for k, v := range m.identities {
+		c.identities[k] = v
+	}
+	for k, v := range m.verified {
+		c.verified[k] = v
+	}
+	for k, v := range m.preKeys {
+		key := *v
+		c.preKeys[k] = &key
+	}
+	for k, v := range m.signedPreKeys {
+		c.signedPreKeys[k] = v
+	}
+	for k, v := range m.sessions {
+		c.sessions[k] = v
+	}
+	for k, v := range m.senderKeys {
+		c.senderKeys[k] = v
+	}
+	return c
+}
+
+func (m *MemoryBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,522 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/database/gdb"
+	"github.com/gogf/gf/os/gtime"
+	"github.com/gogf/gf/util/gconv"
+	_ "github.com/mattn/go-sqlite3"
+	"ws-go/protocol/db/migrate"
+)
+
+// 表不存在时才新建 旧版本程序新建的数据库会直接记录为版本 1
+const (
+	sqlCreateIdentities      = "CREATE TABLE IF NOT EXISTS identities (_id INTEGER PRIMARY KEY AUTOINCREMENT, recipient_id INTEGER UNIQUE, device_id INTEGER, registration_id INTEGER, public_key BLOB, private_key BLOB, next_prekey_id INTEGER, timestamp INTEGER)"
+	sqlCreateIdentitiesIndex = "CREATE UNIQUE INDEX IF NOT EXISTS identities_idx ON identities(recipient_id, device_id)"
+	sqlCreatePreKeys         = "CREATE TABLE IF NOT EXISTS prekeys (_id INTEGER PRIMARY KEY AUTOINCREMENT, prekey_id INTEGER UNIQUE, sent_to_server BOOLEAN, record BLOB, direct_distribution BOOLEAN, upload_timestamp INTEGER)"
+	sqlCreateSession         = "CREATE TABLE IF NOT EXISTS sessions (_id INTEGER PRIMARY KEY AUTOINCREMENT, recipient_id INTEGER, device_id INTEGER, record BLOB, timestamp INTEGER)"
+	sqlCreateSenderKeys      = "CREATE TABLE IF NOT EXISTS sender_keys (_id INTEGER PRIMARY KEY AUTOINCREMENT, group_id TEXT NOT NULL, sender_id INTEGER NOT NULL, record BLOB NOT NULL)"
+	sqlCreateSignedPreKeys   = "CREATE TABLE IF NOT EXISTS signed_prekeys (_id INTEGER PRIMARY KEY AUTOINCREMENT, prekey_id INTEGER UNIQUE, timestamp INTEGER, record BLOB)"
+)
+
+// 安全码验证后 verified 为 1 公钥变化时清除
+const (
+	sqlAddIdentitiesVerified       = "ALTER TABLE identities ADD COLUMN verified BOOLEAN DEFAULT 0"
+	sqlAddSharedIdentitiesVerified = "ALTER TABLE identities ADD COLUMN verified SMALLINT DEFAULT 0"
+)
+
+// 多个账号共用一个数据库时的表 每个表都有 account 字段
+// %s 为不同数据库的二进制类型
+const (
+	sqlCreateSharedIdentities   = "CREATE TABLE IF NOT EXISTS identities (account VARCHAR(64) NOT NULL, recipient_id VARCHAR(128) NOT NULL, device_id INTEGER, registration_id BIGINT, public_key %[1]s, private_key %[1]s, next_prekey_id INTEGER, timestamp BIGINT, PRIMARY KEY (account, recipient_id))"
+	sqlCreateSharedPreKeys      = "CREATE TABLE IF NOT EXISTS prekeys (account VARCHAR(64) NOT NULL, prekey_id BIGINT NOT NULL, sent_to_server SMALLINT, record %[1]s, direct_distribution SMALLINT, upload_timestamp BIGINT, PRIMARY KEY (account, prekey_id))"
+	sqlCreateSharedSession      = "CREATE TABLE IF NOT EXISTS sessions (account VARCHAR(64) NOT NULL, recipient_id BIGINT NOT NULL, device_id INTEGER, record %[1]s, timestamp BIGINT, PRIMARY KEY (account, recipient_id))"
+	sqlCreateSharedSenderKeys   = "CREATE TABLE IF NOT EXISTS sender_keys (account VARCHAR(64) NOT NULL, group_id VARCHAR(128) NOT NULL, sender_id BIGINT NOT NULL, record %[1]s NOT NULL, PRIMARY KEY (account, group_id, sender_id))"
+	sqlCreateSharedSignedPreKey = "CREATE TABLE IF NOT EXISTS signed_prekeys (account VARCHAR(64) NOT NULL, prekey_id BIGINT NOT NULL, timestamp BIGINT, record %[1]s, PRIMARY KEY (account, prekey_id))"
+)
+
+// sqliteMigrations 每个账号的 sqlite 数据库 新的表结构修改在末尾追加版本
+var sqliteMigrations = []migrate.Migration{
+	{Version: 1, Description: "create axolotl tables", Up: migrate.Exec(
+		sqlCreateIdentities,
+		sqlCreateIdentitiesIndex,
+		sqlCreatePreKeys,
+		sqlCreateSession,
+		sqlCreateSenderKeys,
+		sqlCreateSignedPreKeys,
+	)},
+	{Version: 2, Description: "add identities verified", Up: migrate.Exec(
+		sqlAddIdentitiesVerified,
+	)},
+}
+
+// sharedMigrations 共用数据库 blob 为二进制字段的类型
+func sharedMigrations(blob string) []migrate.Migration {
+	return []migrate.Migration{
+		{Version: 1, Description: "create shared axolotl tables", Up: migrate.Exec(
+			fmt.Sprintf(sqlCreateSharedIdentities, blob),
+			fmt.Sprintf(sqlCreateSharedPreKeys, blob),
+			fmt.Sprintf(sqlCreateSharedSession, blob),
+			fmt.Sprintf(sqlCreateSharedSenderKeys, blob),
+			fmt.Sprintf(sqlCreateSharedSignedPreKey, blob),
+		)},
+		{Version: 2, Description: "add identities verified", Up: migrate.Exec(
+			sqlAddSharedIdentitiesVerified,
+		)},
+	}
+}
+
+// SQLBackend 使用 gdb 保存数据
+// account 为空时一个账号一个 sqlite 文件 否则所有的查询都带上 account 条件
+type SQLBackend struct {
+	db      gdb.DB
+	tx      *gdb.TX
+	account string
+}
+
+// OpenSQLiteBackend 打开账号的 sqlite 文件 打开时执行数据库迁移
+func OpenSQLiteBackend(path string) (*SQLBackend, error) {
+	db, err := migrate.OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate.Run(db, sqliteMigrations); err != nil {
+		_ = db.Close(context.Background())
+		return nil, fmt.Errorf("migrate axolotl %s: %w", path, err)
+	}
+	return &SQLBackend{db: db}, nil
+}
+
+// NewSharedSQLBackend 多个账号共用的 mysql/pgsql 数据库 数据按 account 区分
+// 表需要先使用 CreateSharedTables 新建 pgsql 需要引入驱动 _ "github.com/lib/pq"
+func NewSharedSQLBackend(db gdb.DB, account string) (*SQLBackend, error) {
+	if account == "" {
+		return nil, errors.New("shared backend account is empty")
+	}
+	return &SQLBackend{db: db, account: account}, nil
+}
+
+// CreateSharedTables 执行共用数据库的迁移
+// mysql 的 DDL 会隐式提交 迁移失败时需要手动检查表结构
+func CreateSharedTables(db gdb.DB) error {
+	var blob string
+	switch db.GetConfig().Type {
+	case "mysql", "mariadb", "tidb":
+		blob = "LONGBLOB"
+	case "pgsql":
+		blob = "BYTEA"
+	default:
+		blob = "BLOB"
+	}
+	if _, err := migrate.Run(db, sharedMigrations(blob)); err != nil {
+		return fmt.Errorf("migrate shared axolotl tables: %w", err)
+	}
+	return nil
+}
+
+// table 在事务中时使用事务的连接
+func (s *SQLBackend) table(table string) *gdb.Model {
+	if s.tx != nil {
+		return s.tx.Model(table)
+	}
+	return s.db.Model(table)
+}
+
+// model 带上 account 条件
+func (s *SQLBackend) model(table string) *gdb.Model {
+	m := s.table(table)
+	if s.account != "" {
+		m = m.Where("account=?", s.account)
+	}
+	return m
+}
+
+// row 插入的数据带上 account
+func (s *SQLBackend) row(data gdb.Map) gdb.Map {
+	if s.account != "" {
+		data["account"] = s.account
+	}
+	return data
+}
+
+// findBytes 查询一个字段 没有数据时返回 nil
+func findBytes(m *gdb.Model, field string) ([]byte, error) {
+	one, err := m.FindOne()
+	if err != nil {
+		return nil, err
+	}
+	if one.IsEmpty() {
+		return nil, nil
+	}
+	if v, ok := one[field]; ok {
+		return v.Bytes(), nil
+	}
+	return nil, nil
+}
+
+func (s *SQLBackend) LoadLocalIdentity() (*LocalIdentity, error) {
+	one, err := s.model("identities").Where("recipient_id=?", localIdentityName).FindOne()
+	if err != nil {
+		return nil, err
+	}
+	if one.IsEmpty() {
+		return nil, nil
+	}
+	return &LocalIdentity{
+		RegistrationID: one["registration_id"].Uint32(),
+		PublicKey:      one["public_key"].Bytes(),
+		PrivateKey:     one["private_key"].Bytes(),
+	}, nil
+}
+
+func (s *SQLBackend) StoreLocalIdentity(identity *LocalIdentity) error {
+	if _, err := s.model("identities").Where("recipient_id=?", localIdentityName).Delete(); err != nil {
+		return err
+	}
+	_, err := s.table("identities").Insert(s.row(gdb.Map{
+		"recipient_id":    localIdentityName,
+		"device_id":       0,
+		"registration_id": identity.RegistrationID,
+		"timestamp":       gtime.TimestampMilli(),
+		"public_key":      identity.PublicKey,
+		"private_key":     identity.PrivateKey,
+	}))
+	return err
+}
+
+func (s *SQLBackend) LoadIdentity(name string) ([]byte, error) {
+	return findBytes(s.model("identities").Where("recipient_id=?", name), "public_key")
+}
+
+func (s *SQLBackend) StoreIdentity(name string, publicKey []byte) error {
+	old, err := s.LoadIdentity(name)
+	if err != nil {
+		return err
+	}
+	// 公钥没有变化时保留 verified
+	if old != nil && bytes.Equal(old, publicKey) {
+		return nil
+	}
+	if _, err := s.model("identities").Where("recipient_id=?", name).Delete(); err != nil {
+		return err
+	}
+	_, err = s.table("identities").Insert(s.row(gdb.Map{
+		"recipient_id": name,
+		"device_id":    0,
+		"public_key":   publicKey,
+		"verified":     0,
+	}))
+	return err
+}
+
+func (s *SQLBackend) LoadIdentityVerified(name string) (bool, error) {
+	v, err := s.model("identities").Where("recipient_id=?", name).Value("verified")
+	if err != nil {
+		return false, err
+	}
+	return v.Int() == 1, nil
+}
+
+func (s *SQLBackend) StoreIdentityVerified(name string, verified bool) error {
+	n, err := s.model("identities").Where("recipient_id=?", name).Count()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoIdentity
+	}
+	_, err = s.model("identities").Where("recipient_id=?", name).Data(gdb.Map{"verified": gconv.Int(verified)}).Update()
+	return err
+}
+
+func (s *SQLBackend) LoadPreKey(id uint32) ([]byte, error) {
+	return findBytes(s.model("prekeys").Where("prekey_id=?", id), "record")
+}
+
+func (s *SQLBackend) StorePreKeys(keys []PreKeyRecord) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	list := make(gdb.List, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, s.row(gdb.Map{
+			"prekey_id":           key.ID,
+			"record":              key.Record,
+			"sent_to_server":      0,
+			"direct_distribution": 0,
+			"upload_timestamp":    gtime.TimestampMilli(),
+		}))
+	}
+	_, err := s.table("prekeys").Insert(list)
+	return err
+}
+
+func (s *SQLBackend) ContainsPreKey(id uint32) (bool, error) {
+	count, err := s.model("prekeys").Where("prekey_id=?", id).Count()
+	return count > 0, err
+}
+
+func (s *SQLBackend) RemovePreKey(id uint32) error {
+	_, err := s.model("prekeys").Where("prekey_id=?", id).Delete()
+	return err
+}
+
+// preKeysModel unsent 为 true 时只查询没有上传的
+func (s *SQLBackend) preKeysModel(unsent bool) *gdb.Model {
+	m := s.model("prekeys")
+	if unsent {
+		m = m.Where("sent_to_server = 0 AND direct_distribution = 0")
+	}
+	return m
+}
+
+func (s *SQLBackend) LoadPreKeys(unsent bool) ([]PreKeyRecord, error) {
+	all, err := s.preKeysModel(unsent).Order("prekey_id").FindAll()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]PreKeyRecord, 0, len(all))
+	for _, r := range all {
+		keys = append(keys, PreKeyRecord{ID: r["prekey_id"].Uint32(), Record: r["record"].Bytes()})
+	}
+	return keys, nil
+}
+
+func (s *SQLBackend) CountPreKeys(unsent bool) (int, error) {
+	return s.preKeysModel(unsent).Count()
+}
+
+func (s *SQLBackend) MarkPreKeysSent(ids []uint32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.model("prekeys").
+		Where("prekey_id IN(?)", ids).
+		Data(gdb.Map{"sent_to_server": 1, "upload_timestamp": gtime.TimestampMilli()}).
+		Update()
+	return err
+}
+
+func (s *SQLBackend) LoadSignedPreKey(id uint32) ([]byte, error) {
+	return findBytes(s.model("signed_prekeys").Where("prekey_id=?", id), "record")
+}
+
+func (s *SQLBackend) LoadSignedPreKeys() ([]PreKeyRecord, error) {
+	all, err := s.model("signed_prekeys").FindAll()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]PreKeyRecord, 0, len(all))
+	for _, r := range all {
+		keys = append(keys, PreKeyRecord{ID: r["prekey_id"].Uint32(), Record: r["record"].Bytes()})
+	}
+	return keys, nil
+}
+
+func (s *SQLBackend) StoreSignedPreKey(id uint32, record []byte) error {
+	// Delete 需要条件 共用数据库时是 account
+	m := s.model("signed_prekeys")
+	if s.account == "" {
+		m = m.Where("1=1")
+	}
+	if _, err := m.Delete(); err != nil {
+		return err
+	}
+	_, err := s.table("signed_prekeys").Insert(s.row(gdb.Map{
+		"prekey_id": id,
+		"timestamp": gtime.TimestampMilli(),
+		"record":    record,
+	}))
+	return err
+}
+
+func (s *SQLBackend) RemoveSignedPreKey(id uint32) error {
+	_, err := s.model("signed_prekeys").Where("prekey_id=?", id).Delete()
+	return err
+}
+
+func (s *SQLBackend) LoadSession(name string) ([]byte, error) {
+	return findBytes(s.model("sessions").Where("recipient_id=?", gconv.Int64(name)), "record")
+}
+
+func (s *SQLBackend) StoreSession(name string, record []byte) error {
+	count, err := s.model("sessions").Where("recipient_id=?", gconv.Int64(name)).Count()
+	if err != nil {
+		return err
+	}
+	// 存在时只更新 record
+	if count > 0 {
+		_, err = s.model("sessions").
+			Where("recipient_id=?", gconv.Int64(name)).
+			Data(gdb.Map{"record": record}).
+			Update()
+		return err
+	}
+	_, err = s.table("sessions").Insert(s.row(gdb.Map{
+		"recipient_id": gconv.Int64(name),
+		"record":       record,
+		"device_id":    0,
+		"timestamp":    gtime.Timestamp(),
+	}))
+	return err
+}
+
+func (s *SQLBackend) ContainsSession(name string) (bool, error) {
+	count, err := s.model("sessions").Where("recipient_id=?", gconv.Int64(name)).Count()
+	return count > 0, err
+}
+
+func (s *SQLBackend) DeleteSession(name string) error {
+	_, err := s.model("sessions").Where("recipient_id=?", gconv.Int64(name)).Delete()
+	return err
+}
+
+func (s *SQLBackend) DeleteAllSessions() error {
+	m := s.model("sessions")
+	if s.account == "" {
+		m = m.Where("1=1")
+	}
+	_, err := m.Delete()
+	return err
+}
+
+func (s *SQLBackend) LoadSenderKey(groupID, sender string) ([]byte, error) {
+	return findBytes(s.model("sender_keys").Where("group_id=? AND sender_id=?", groupID, gconv.Int64(sender)), "record")
+}
+
+func (s *SQLBackend) StoreSenderKey(groupID, sender string, record []byte) error {
+	senderID := gconv.Int64(sender)
+	count, err := s.model("sender_keys").Where("group_id=? AND sender_id=?", groupID, senderID).Count()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		_, err = s.model("sender_keys").
+			Where("group_id=? AND sender_id=?", groupID, senderID).
+			Data(gdb.Map{"record": record}).
+			Update()
+		return err
+	}
+	_, err = s.table("sender_keys").Insert(s.row(gdb.Map{
+		"group_id":  groupID,
+		"sender_id": senderID,
+		"record":    record,
+	}))
+	return err
+}
+
+// Transaction 已经在事务中时直接执行 f
+func (s *SQLBackend) Transaction(f func(tx Backend) error) error {
+	if s.tx != nil {
+		return f(s)
+	}
+	return s.db.Transaction(context.Background(), func(ctx context.Context, tx *gdb.TX) error {
+		return f(&SQLBackend{db: s.db, tx: tx, account: s.account})
+	})
+}
+
+// Close 共用的数据库由调用者关闭
+func (s *SQLBackend) Close() error {
+	if s.account != "" || s.tx != nil {
+		return nil
+	}
+	return s.db.Close(context.Background())
+}
+
+// sealedColumn 加密保存的字段 keys 为共用数据库中除 account 外的主键 也是加密的附加数据
+type sealedColumn struct {
+	table  string
+	field  string
+	column string
+	keys   []string
+}
+
+var sealedColumns = []sealedColumn{
+	{"identities", "private_key", columnPrivateKey, []string{"recipient_id"}},
+	{"prekeys", "record", columnPreKey, []string{"prekey_id"}},
+	{"signed_prekeys", "record", columnSignedPreKey, []string{"prekey_id"}},
+	{"sessions", "record", columnSession, []string{"recipient_id"}},
+	{"sender_keys", "record", columnSenderKey, []string{"group_id", "sender_id"}},
+}
+
+// Rekey 使用 from 解密 to 重新加密所有加密的字段 nil 表示明文 返回修改的行数
+// 在一个事务中执行 任何一行解密失败时全部回滚
+func (s *SQLBackend) Rekey(from, to *Sealer) (int, error) {
+	n := 0
+	err := s.Transaction(func(b Backend) error {
+		tx := b.(*SQLBackend)
+		n = 0
+		for _, c := range sealedColumns {
+			keys, fields := c.keys, c.keys
+			if tx.account == "" {
+				keys = []string{"_id"}
+				fields = append(keys, c.keys...)
+			}
+			rows, err := tx.model(c.table).Fields(strings.Join(append(fields, c.field), ",")).FindAll()
+			if err != nil {
+				return err
+			}
+			for _, r := range rows {
+				d := r[c.field].Bytes()
+				if len(d) == 0 {
+					continue
+				}
+				parts := make([]string, 0, len(c.keys))
+				for _, k := range c.keys {
+					parts = append(parts, r[k].String())
+				}
+				key := rowKey(parts...)
+				plain, err := from.Open(c.column, key, d)
+				if err != nil {
+					return fmt.Errorf("%s: %w", c.column, err)
+				}
+				sealed, err := to.Seal(c.column, key, plain)
+				if err != nil {
+					return err
+				}
+				where := gdb.Map{}
+				for _, k := range keys {
+					where[k] = r[k].Val()
+				}
+				if _, err := tx.model(c.table).Where(where).Data(gdb.Map{c.field: sealed}).Update(); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// RekeyShared 修改共用数据库中所有账号的 KEK 每个账号一个事务 返回每个账号修改的行数
+func RekeyShared(db gdb.DB, from, to *Sealer) (map[string]int, error) {
+	accounts := make(map[string]bool)
+	for _, c := range sealedColumns {
+		values, err := db.GetArray(fmt.Sprintf("SELECT DISTINCT account FROM %s", c.table))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			accounts[v.String()] = true
+		}
+	}
+	result := make(map[string]int)
+	for account := range accounts {
+		b, err := NewSharedSQLBackend(db, account)
+		if err != nil {
+			return result, err
+		}
+		n, err := b.Rekey(from, to)
+		if err != nil {
+			return result, fmt.Errorf("rekey %s: %w", account, err)
+		}
+		result[account] = n
+	}
+	return result, nil
+}
@@ -0,0 +1,361 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"ws-go/libsignal/protocol"
+	"ws-go/libsignal/state/record"
+	"ws-go/protocol/axolotl/serializer"
+	"ws-go/protocol/db/migrate"
+
+	"github.com/gogf/gf/database/gdb"
+)
+
+// openSharedSQLite 使用 sqlite 测试共用数据库的表
+func openSharedSQLite(t *testing.T, account string) *SQLBackend {
+	t.Helper()
+	group := "axolotl-shared-test:" + t.Name()
+	gdb.SetConfigGroup(group, gdb.ConfigGroup{{
+		Type: "sqlite",
+		Link: filepath.Join(t.TempDir(), "shared"),
+	}})
+	db, err := gdb.New(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateSharedTables(db); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSharedSQLBackend(db, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func testBackends(t *testing.T) map[string]Backend {
+	sqlite, err := OpenSQLiteBackend(filepath.Join(t.TempDir(), "8613800000000", "axolotl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Backend{
+		"memory": NewMemoryBackend(),
+		"sqlite": sqlite,
+		"shared": openSharedSQLite(t, "8613800000000"),
+	}
+}
+
+func TestBackend(t *testing.T) {
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			defer b.Close()
+			testBackend(t, b)
+		})
+	}
+}
+
+func testBackend(t *testing.T, b Backend) {
+	// identities
+	if local, err := b.LoadLocalIdentity(); err != nil || local != nil {
+		t.Fatalf("LoadLocalIdentity = %v, %v, want nil", local, err)
+	}
+	want := &LocalIdentity{RegistrationID: 0x7ed158fe, PublicKey: []byte{5, 1, 2}, PrivateKey: []byte{3, 4}}
+	if err := b.StoreLocalIdentity(want); err != nil {
+		t.Fatal(err)
+	}
+	local, err := b.LoadLocalIdentity()
+	if err != nil || local == nil {
+		t.Fatalf("LoadLocalIdentity = %v, %v", local, err)
+	}
+	if local.RegistrationID != want.RegistrationID ||
+		!bytes.Equal(local.PublicKey, want.PublicKey) || !bytes.Equal(local.PrivateKey, want.PrivateKey) {
+		t.Fatalf("LoadLocalIdentity = %+v, want %+v", local, want)
+	}
+	if err := b.StoreIdentity("8613800000001", []byte{5, 9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreIdentity("8613800000001", []byte{5, 10}); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := b.LoadIdentity("8613800000001"); err != nil || !bytes.Equal(key, []byte{5, 10}) {
+		t.Fatalf("LoadIdentity = %x, %v", key, err)
+	}
+	if key, err := b.LoadIdentity("8613800000002"); err != nil || key != nil {
+		t.Fatalf("LoadIdentity missing = %x, %v", key, err)
+	}
+
+	// verified 公钥不变时保留 变化后清除
+	if err := b.StoreIdentityVerified("8613800000002", true); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("StoreIdentityVerified missing err = %v", err)
+	}
+	if verified, err := b.LoadIdentityVerified("8613800000002"); err != nil || verified {
+		t.Fatalf("LoadIdentityVerified missing = %v, %v", verified, err)
+	}
+	if err := b.StoreIdentityVerified("8613800000001", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreIdentity("8613800000001", []byte{5, 10}); err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := b.LoadIdentityVerified("8613800000001"); err != nil || !verified {
+		t.Fatalf("LoadIdentityVerified = %v, %v, want true", verified, err)
+	}
+	if err := b.StoreIdentity("8613800000001", []byte{5, 11}); err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := b.LoadIdentityVerified("8613800000001"); err != nil || verified {
+		t.Fatalf("LoadIdentityVerified after change = %v, %v, want false", verified, err)
+	}
+	if err := b.StoreIdentity("8613800000001", []byte{5, 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// prekeys
+	if err := b.StorePreKeys([]PreKeyRecord{{ID: 3, Record: []byte{3}}, {ID: 1, Record: []byte{1}}, {ID: 2, Record: []byte{2}}}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.ContainsPreKey(2); err != nil || !ok {
+		t.Fatalf("ContainsPreKey = %v, %v", ok, err)
+	}
+	if err := b.MarkPreKeysSent([]uint32{1}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.CountPreKeys(true); err != nil || n != 2 {
+		t.Fatalf("CountPreKeys(unsent) = %d, %v, want 2", n, err)
+	}
+	if n, err := b.CountPreKeys(false); err != nil || n != 3 {
+		t.Fatalf("CountPreKeys = %d, %v, want 3", n, err)
+	}
+	unsent, err := b.LoadPreKeys(true)
+	if err != nil || len(unsent) != 2 || unsent[0].ID != 2 || unsent[1].ID != 3 {
+		t.Fatalf("LoadPreKeys(unsent) = %+v, %v", unsent, err)
+	}
+	if err := b.RemovePreKey(2); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := b.LoadPreKey(2); err != nil || record != nil {
+		t.Fatalf("LoadPreKey removed = %x, %v", record, err)
+	}
+	if record, err := b.LoadPreKey(3); err != nil || !bytes.Equal(record, []byte{3}) {
+		t.Fatalf("LoadPreKey = %x, %v", record, err)
+	}
+
+	// signed prekeys 只保留最新的
+	if err := b.StoreSignedPreKey(0, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreSignedPreKey(1, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := b.LoadSignedPreKeys()
+	if err != nil || len(signed) != 1 || signed[0].ID != 1 {
+		t.Fatalf("LoadSignedPreKeys = %+v, %v", signed, err)
+	}
+	if record, err := b.LoadSignedPreKey(0); err != nil || record != nil {
+		t.Fatalf("LoadSignedPreKey replaced = %x, %v", record, err)
+	}
+
+	// sessions
+	if err := b.StoreSession("8613800000001", []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreSession("8613800000001", []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := b.LoadSession("8613800000001"); err != nil || !bytes.Equal(record, []byte{2}) {
+		t.Fatalf("LoadSession = %x, %v", record, err)
+	}
+	if err := b.DeleteSession("8613800000001"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.ContainsSession("8613800000001"); err != nil || ok {
+		t.Fatalf("ContainsSession deleted = %v, %v", ok, err)
+	}
+	_ = b.StoreSession("8613800000002", []byte{2})
+	if err := b.DeleteAllSessions(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.ContainsSession("8613800000002"); err != nil || ok {
+		t.Fatalf("ContainsSession after DeleteAllSessions = %v, %v", ok, err)
+	}
+
+	// sender keys
+	group := "8613800000000-1624957782@g.us"
+	if err := b.StoreSenderKey(group, "8613800000001", []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreSenderKey(group, "8613800000001", []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := b.LoadSenderKey(group, "8613800000001"); err != nil || !bytes.Equal(record, []byte{2}) {
+		t.Fatalf("LoadSenderKey = %x, %v", record, err)
+	}
+	if record, err := b.LoadSenderKey(group, "8613800000002"); err != nil || record != nil {
+		t.Fatalf("LoadSenderKey missing = %x, %v", record, err)
+	}
+}
+
+func TestSharedSQLBackend_Accounts(t *testing.T) {
+	a := openSharedSQLite(t, "8613800000001")
+	b, err := NewSharedSQLBackend(a.db, "8613800000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = a.StoreLocalIdentity(&LocalIdentity{RegistrationID: 1})
+	_ = a.StorePreKeys([]PreKeyRecord{{ID: 1, Record: []byte{1}}})
+	_ = a.StoreSignedPreKey(1, []byte{1})
+	_ = a.StoreSession("8613800000003", []byte{1})
+	// 另一个账号看不到这些数据
+	if local, _ := b.LoadLocalIdentity(); local != nil {
+		t.Fatalf("LoadLocalIdentity = %+v, want nil", local)
+	}
+	if n, _ := b.CountPreKeys(false); n != 0 {
+		t.Fatalf("CountPreKeys = %d, want 0", n)
+	}
+	_ = b.StoreSignedPreKey(2, []byte{2})
+	_ = b.DeleteAllSessions()
+	if signed, _ := a.LoadSignedPreKeys(); len(signed) != 1 || signed[0].ID != 1 {
+		t.Fatalf("LoadSignedPreKeys = %+v", signed)
+	}
+	if ok, _ := a.ContainsSession("8613800000003"); !ok {
+		t.Fatal("session deleted by other account")
+	}
+	if _, err := NewSharedSQLBackend(a.db, ""); err == nil {
+		t.Fatal("empty account should fail")
+	}
+}
+
+func TestNewSignalStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "axolotl")
+	b, err := OpenSQLiteBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, needInit, err := NewSignalStore(b, "", "")
+	if err != nil || !needInit {
+		t.Fatalf("NewSignalStore = %v, %v, want needInit", needInit, err)
+	}
+	regID := s.IdentityStore.GetLocalRegistrationId()
+	publicKey := s.IdentityStore.GetIdentityKeyPair().PublicKey().Serialize()
+	if s.SignedPreKeyStore.LoadSignedPreKey(0) == nil {
+		t.Fatal("signed prekey not stored")
+	}
+	_ = b.Close()
+
+	// 重新打开使用保存的密钥
+	b, err = OpenSQLiteBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	s, needInit, err = NewSignalStore(b, "", "")
+	if err != nil || needInit {
+		t.Fatalf("NewSignalStore reopen = %v, %v, want existing", needInit, err)
+	}
+	if got := s.IdentityStore.GetLocalRegistrationId(); got != regID {
+		t.Fatalf("registration id = %d, want %d", got, regID)
+	}
+	if got := s.IdentityStore.GetIdentityKeyPair().PublicKey().Serialize(); !bytes.Equal(got, publicKey) {
+		t.Fatalf("identity key = %x, want %x", got, publicKey)
+	}
+}
+
+func TestOpenSQLiteBackend_Migrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "axolotl")
+	b, err := OpenSQLiteBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := migrate.Version(b.db); err != nil || v != migrate.Latest(sqliteMigrations) {
+		t.Fatalf("version = %d, %v", v, err)
+	}
+	_ = b.Close()
+
+	// 比程序新的数据库不能打开
+	group := "axolotl-migrate-test:" + path
+	gdb.SetConfigGroup(group, gdb.ConfigGroup{{Type: "sqlite", Link: path}})
+	db, err := gdb.New(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(context.Background())
+	if _, err := db.Exec("INSERT INTO schema_version (version) VALUES (?)", migrate.Latest(sqliteMigrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSQLiteBackend(path); !errors.Is(err, migrate.ErrTooNew) {
+		t.Fatalf("OpenSQLiteBackend err = %v, want ErrTooNew", err)
+	}
+}
+
+func TestOpenSQLiteBackend_Legacy(t *testing.T) {
+	// 旧版本程序新建的表 没有版本记录
+	path := filepath.Join(t.TempDir(), "axolotl")
+	group := "axolotl-legacy-test:" + path
+	gdb.SetConfigGroup(group, gdb.ConfigGroup{{Type: "sqlite", Link: path}})
+	db, err := gdb.New(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(context.Background())
+	for _, s := range []string{
+		"CREATE TABLE identities (_id INTEGER PRIMARY KEY AUTOINCREMENT, recipient_id INTEGER UNIQUE, device_id INTEGER, registration_id INTEGER, public_key BLOB, private_key BLOB, next_prekey_id INTEGER, timestamp INTEGER)",
+		"CREATE TABLE prekeys (_id INTEGER PRIMARY KEY AUTOINCREMENT, prekey_id INTEGER UNIQUE, sent_to_server BOOLEAN, record BLOB, direct_distribution BOOLEAN, upload_timestamp INTEGER)",
+		"CREATE TABLE sessions (_id INTEGER PRIMARY KEY AUTOINCREMENT, recipient_id INTEGER, device_id INTEGER, record BLOB, timestamp INTEGER)",
+		"CREATE TABLE sender_keys (_id INTEGER PRIMARY KEY AUTOINCREMENT, group_id TEXT NOT NULL, sender_id INTEGER NOT NULL, record BLOB NOT NULL)",
+		"CREATE TABLE signed_prekeys (_id INTEGER PRIMARY KEY AUTOINCREMENT, prekey_id INTEGER UNIQUE, timestamp INTEGER, record BLOB)",
+		"INSERT INTO identities (recipient_id, device_id, registration_id, public_key, private_key) VALUES (-1, 0, 42, x'05', x'01')",
+	} {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := OpenSQLiteBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	local, err := b.LoadLocalIdentity()
+	if err != nil || local == nil || local.RegistrationID != 42 {
+		t.Fatalf("LoadLocalIdentity = %+v, %v", local, err)
+	}
+	// 旧的数据库升级后增加 verified
+	if verified, err := b.LoadIdentityVerified(localIdentityName); err != nil || verified {
+		t.Fatalf("LoadIdentityVerified = %v, %v", verified, err)
+	}
+}
+
+func TestInMemorySession_ExactAddress(t *testing.T) {
+	newSerializer := serializer.NewProtoSerializer()
+	s := NewInMemorySession(newSerializer, nil)
+	stored := record.NewSession(newSerializer.Session, newSerializer.State)
+	s.StoreSession(protocol.NewSignalAddress("8613800000001", 0), stored)
+
+	// 被包含的 jid 和其他设备不能匹配
+	for _, address := range []*protocol.SignalAddress{
+		protocol.NewSignalAddress("861380000000", 0),
+		protocol.NewSignalAddress("8613800000001", 1),
+	} {
+		if s.ContainsSession(address) {
+			t.Fatalf("ContainsSession(%s) = true", address)
+		}
+		if s.LoadSession(address) == stored {
+			t.Fatalf("LoadSession(%s) returned the session of 8613800000001", address)
+		}
+	}
+	if !s.ContainsSession(protocol.NewSignalAddress("8613800000001", 0)) {
+		t.Fatal("ContainsSession(8613800000001) = false")
+	}
+
+	// 同一个地址再次保存时替换
+	replaced := record.NewSession(newSerializer.Session, newSerializer.State)
+	s.StoreSession(protocol.NewSignalAddress("8613800000001", 0), replaced)
+	if got := s.LoadSession(protocol.NewSignalAddress("8613800000001", 0)); got != replaced {
+		t.Fatal("LoadSession did not return the replaced session")
+	}
+	if len(s.sessions) != 1 {
+		t.Fatalf("cached %d sessions, want 1", len(s.sessions))
+	}
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/util/gconv"
+)
+
+// 私钥和 record 字段使用 KEK(key-encryption key) 加密保存
+// 格式 sealedMagic | nonce | AES-256-GCM 密文 附加数据为表名 字段名和行的主键 密文不能在字段或行之间替换
+// 'W' 在 protobuf 中是无效的 wire type 没有前缀的数据按明文读取 兼容加密前的数据库
+
+var sealedMagic = []byte("WAE1")
+
+const kekSize = 32
+
+// 加密的字段
+const (
+	columnPrivateKey   = "identities.private_key"
+	columnPreKey       = "prekeys.record"
+	columnSignedPreKey = "signed_prekeys.record"
+	columnSession      = "sessions.record"
+	columnSenderKey    = "sender_keys.record"
+)
+
+var (
+	// ErrNoKEK 数据已经加密 但是没有配置 KEK
+	ErrNoKEK = errors.New("axolotl data is encrypted but no key-encryption key is configured")
+	// ErrDecrypt KEK 不正确或者数据被修改
+	ErrDecrypt = errors.New("axolotl data decrypt failed")
+	// ErrNotSealed 要求加密时读到明文 数据可能被降级为明文
+	ErrNotSealed = errors.New("axolotl data is not encrypted")
+)
+
+// Sealer 使用 KEK 加密和解密字段 nil 时不加密
+type Sealer struct {
+	aead cipher.AEAD
+	// requireSealed 为 true 时拒绝读取明文
+	requireSealed bool
+}
+
+// NewSealer kek 为 32 字节
+func NewSealer(kek []byte) (*Sealer, error) {
+	if len(kek) != kekSize {
+		return nil, fmt.Errorf("key-encryption key must be %d bytes, got %d", kekSize, len(kek))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// ParseKEK 支持 32 字节的原始数据 base64 或 hex 文本
+func ParseKEK(d []byte) ([]byte, error) {
+	if len(d) == kekSize {
+		return d, nil
+	}
+	text := strings.TrimSpace(string(d))
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == kekSize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(text); err == nil && len(key) == kekSize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key-encryption key must be %d bytes raw, base64 or hex", kekSize)
+}
+
+// SetRequireSealed 数据库全部加密(rekey)后设置 读到明文时返回 ErrNotSealed
+func (s *Sealer) SetRequireSealed(require bool) {
+	s.requireSealed = require
+}
+
+// IsSealed 数据是否加密
+func IsSealed(d []byte) bool {
+	return bytes.HasPrefix(d, sealedMagic)
+}
+
+// sealedAAD 附加数据 key 为 rowKey 返回的主键
+func sealedAAD(column, key string) []byte {
+	return []byte(column + "\x00" + key)
+}
+
+// rowKey 和 SQLBackend 保存的主键相同 Rekey 时由数据库中的行得到同样的 key
+func rowKey(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+func idKey(id uint32) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// recipientKey recipient_id 按整数保存
+func recipientKey(name string) string {
+	return strconv.FormatInt(gconv.Int64(name), 10)
+}
+
+// Seal s 为 nil 时返回明文 key 为行的主键
+func (s *Sealer) Seal(column, key string, plain []byte) ([]byte, error) {
+	if s == nil || plain == nil {
+		return plain, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, sealedMagic...), nonce...)
+	return s.aead.Seal(out, nonce, plain, sealedAAD(column, key)), nil
+}
+
+// Open 没有加密的数据直接返回 SetRequireSealed 后返回 ErrNotSealed
+func (s *Sealer) Open(column, key string, d []byte) ([]byte, error) {
+	if !IsSealed(d) {
+		if s != nil && s.requireSealed {
+			return nil, ErrNotSealed
+		}
+		return d, nil
+	}
+	if s == nil {
+		return nil, ErrNoKEK
+	}
+	d = d[len(sealedMagic):]
+	if len(d) < s.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plain, err := s.aead.Open(nil, d[:s.aead.NonceSize()], d[s.aead.NonceSize():], sealedAAD(column, key))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// EncryptedBackend 保存前加密私钥和 record 读取后解密 InMemory* 不需要修改
+type EncryptedBackend struct {
+	Backend
+	sealer *Sealer
+}
+
+// NewEncryptedBackend sealer 为 nil 时只检查数据是否已经加密
+func NewEncryptedBackend(b Backend, sealer *Sealer) *EncryptedBackend {
+	return &EncryptedBackend{Backend: b, sealer: sealer}
+}
+
+func (e *EncryptedBackend) open(column, key string, d []byte, err error) ([]byte, error) {
+	if err != nil || d == nil {
+		return d, err
+	}
+	return e.sealer.Open(column, key, d)
+}
+
+func (e *EncryptedBackend) LoadLocalIdentity() (*LocalIdentity, error) {
+	local, err := e.Backend.LoadLocalIdentity()
+	if err != nil || local == nil {
+		return local, err
+	}
+	local.PrivateKey, err = e.sealer.Open(columnPrivateKey, localIdentityName, local.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return local, nil
+}
+
+func (e *EncryptedBackend) StoreLocalIdentity(identity *LocalIdentity) error {
+	privateKey, err := e.sealer.Seal(columnPrivateKey, localIdentityName, identity.PrivateKey)
+	if err != nil {
+		return err
+	}
+	sealed := *identity
+	sealed.PrivateKey = privateKey
+	return e.Backend.StoreLocalIdentity(&sealed)
+}
+
+func (e *EncryptedBackend) LoadPreKey(id uint32) ([]byte, error) {
+	d, err := e.Backend.LoadPreKey(id)
+	return e.open(columnPreKey, idKey(id), d, err)
+}
+
+func (e *EncryptedBackend) StorePreKeys(keys []PreKeyRecord) error {
+	sealed := make([]PreKeyRecord, 0, len(keys))
+	for _, key := range keys {
+		record, err := e.sealer.Seal(columnPreKey, idKey(key.ID), key.Record)
+		if err != nil {
+			return err
+		}
+		sealed = append(sealed, PreKeyRecord{ID: key.ID, Record: record})
+	}
+	return e.Backend.StorePreKeys(sealed)
+}
+
+func (e *EncryptedBackend) LoadPreKeys(unsent bool) ([]PreKeyRecord, error) {
+	keys, err := e.Backend.LoadPreKeys(unsent)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if keys[i].Record, err = e.sealer.Open(columnPreKey, idKey(keys[i].ID), keys[i].Record); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (e *EncryptedBackend) LoadSignedPreKey(id uint32) ([]byte, error) {
+	d, err := e.Backend.LoadSignedPreKey(id)
+	return e.open(columnSignedPreKey, idKey(id), d, err)
+}
+
+func (e *EncryptedBackend) LoadSignedPreKeys() ([]PreKeyRecord, error) {
+	keys, err := e.Backend.LoadSignedPreKeys()
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if keys[i].Record, err = e.sealer.Open(columnSignedPreKey, idKey(keys[i].ID), keys[i].Record); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (e *EncryptedBackend) StoreSignedPreKey(id uint32, record []byte) error {
+	sealed, err := e.sealer.Seal(columnSignedPreKey, idKey(id), record)
+	if err != nil {
+		return err
+	}
+	return e.Backend.StoreSignedPreKey(id, sealed)
+}
+
+func (e *EncryptedBackend) LoadSession(name string) ([]byte, error) {
+	d, err := e.Backend.LoadSession(name)
+	return e.open(columnSession, recipientKey(name), d, err)
+}
+
+func (e *EncryptedBackend) StoreSession(name string, record []byte) error {
+	sealed, err := e.sealer.Seal(columnSession, recipientKey(name), record)
+	if err != nil {
+		return err
+	}
+	return e.Backend.StoreSession(name, sealed)
+}
+
+func (e *EncryptedBackend) LoadSenderKey(groupID, sender string) ([]byte, error) {
+	d, err := e.Backend.LoadSenderKey(groupID, sender)
+	return e.open(columnSenderKey, rowKey(groupID, recipientKey(sender)), d, err)
+}
+
+func (e *EncryptedBackend) StoreSenderKey(groupID, sender string, record []byte) error {
+	sealed, err := e.sealer.Seal(columnSenderKey, rowKey(groupID, recipientKey(sender)), record)
+	if err != nil {
+		return err
+	}
+	return e.Backend.StoreSenderKey(groupID, sender, sealed)
+}
+
+func (e *EncryptedBackend) Transaction(f func(tx Backend) error) error {
+	return e.Backend.Transaction(func(tx Backend) error {
+		return f(&EncryptedBackend{Backend: tx, sealer: e.sealer})
+	})
+}
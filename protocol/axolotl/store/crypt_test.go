@@ -0,0 +1,212 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func testSealer(t *testing.T, b byte) *Sealer {
+	t.Helper()
+	s, err := NewSealer(bytes.Repeat([]byte{b}, kekSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestEncryptedBackend(t *testing.T) {
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			defer b.Close()
+			testBackend(t, NewEncryptedBackend(b, testSealer(t, 1)))
+		})
+	}
+}
+
+func TestEncryptedBackend_Sealed(t *testing.T) {
+	raw, err := OpenSQLiteBackend(filepath.Join(t.TempDir(), "8613800000000", "axolotl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	b := NewEncryptedBackend(raw, testSealer(t, 1))
+	secret := []byte("session secret")
+	if err := b.StoreLocalIdentity(&LocalIdentity{RegistrationID: 1, PublicKey: []byte{5, 1}, PrivateKey: secret}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreSession("8613800000001", secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StorePreKeys([]PreKeyRecord{{ID: 1, Record: secret}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreSignedPreKey(1, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreSenderKey("group", "8613800000001", secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// 数据库中只有密文 公钥不加密
+	local, _ := raw.LoadLocalIdentity()
+	session, _ := raw.LoadSession("8613800000001")
+	preKey, _ := raw.LoadPreKey(1)
+	signedPreKey, _ := raw.LoadSignedPreKey(1)
+	senderKey, _ := raw.LoadSenderKey("group", "8613800000001")
+	for i, d := range [][]byte{local.PrivateKey, session, preKey, signedPreKey, senderKey} {
+		if !IsSealed(d) || bytes.Contains(d, secret) {
+			t.Fatalf("column %d stored in plaintext: %q", i, d)
+		}
+	}
+	if !bytes.Equal(local.PublicKey, []byte{5, 1}) {
+		t.Fatalf("public key = %x", local.PublicKey)
+	}
+
+	// 字段不能互相替换
+	if err := raw.StoreSession("8613800000002", preKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LoadSession("8613800000002"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("swapped column err = %v, want ErrDecrypt", err)
+	}
+	// 同一个字段也不能在行之间替换
+	if err := raw.StoreSession("8613800000003", session); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LoadSession("8613800000003"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("swapped row err = %v, want ErrDecrypt", err)
+	}
+	if err := raw.StoreSenderKey("group", "8613800000003", senderKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LoadSenderKey("group", "8613800000003"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("swapped sender key err = %v, want ErrDecrypt", err)
+	}
+	// KEK 错误 或者没有配置 KEK
+	if _, err := NewEncryptedBackend(raw, testSealer(t, 2)).LoadSession("8613800000001"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("wrong key err = %v, want ErrDecrypt", err)
+	}
+	if _, err := NewEncryptedBackend(raw, nil).LoadLocalIdentity(); !errors.Is(err, ErrNoKEK) {
+		t.Fatalf("no key err = %v, want ErrNoKEK", err)
+	}
+}
+
+func TestEncryptedBackend_Plaintext(t *testing.T) {
+	raw := NewMemoryBackend()
+	if err := raw.StoreSession("8613800000001", []byte{8, 1}); err != nil {
+		t.Fatal(err)
+	}
+	// 加密前保存的明文可以直接读取
+	b := NewEncryptedBackend(raw, testSealer(t, 1))
+	if d, err := b.LoadSession("8613800000001"); err != nil || !bytes.Equal(d, []byte{8, 1}) {
+		t.Fatalf("LoadSession = %x, %v", d, err)
+	}
+	// 全部加密后不再接受明文
+	sealer := testSealer(t, 1)
+	sealer.SetRequireSealed(true)
+	if _, err := NewEncryptedBackend(raw, sealer).LoadSession("8613800000001"); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("plaintext err = %v, want ErrNotSealed", err)
+	}
+}
+
+func TestSQLBackend_Rekey(t *testing.T) {
+	sqlite, err := OpenSQLiteBackend(filepath.Join(t.TempDir(), "8613800000000", "axolotl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, raw := range map[string]*SQLBackend{"sqlite": sqlite, "shared": openSharedSQLite(t, "8613800000000")} {
+		t.Run(name, func(t *testing.T) {
+			defer raw.Close()
+			secret := []byte("session secret")
+			if err := raw.StoreLocalIdentity(&LocalIdentity{RegistrationID: 1, PublicKey: []byte{5, 1}, PrivateKey: secret}); err != nil {
+				t.Fatal(err)
+			}
+			if err := raw.StoreIdentity("8613800000001", []byte{5, 2}); err != nil {
+				t.Fatal(err)
+			}
+			if err := raw.StoreSession("8613800000001", secret); err != nil {
+				t.Fatal(err)
+			}
+			if err := raw.StoreSenderKey("group", "8613800000001", secret); err != nil {
+				t.Fatal(err)
+			}
+			old, next := testSealer(t, 1), testSealer(t, 2)
+
+			// 明文 -> old -> next
+			if n, err := raw.Rekey(nil, old); err != nil || n != 3 {
+				t.Fatalf("Rekey(nil, old) = %d, %v", n, err)
+			}
+			if _, err := raw.Rekey(next, nil); !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("Rekey with wrong key err = %v, want ErrDecrypt", err)
+			}
+			if _, err := raw.Rekey(old, next); err != nil {
+				t.Fatal(err)
+			}
+			b := NewEncryptedBackend(raw, next)
+			if d, err := b.LoadSession("8613800000001"); err != nil || !bytes.Equal(d, secret) {
+				t.Fatalf("LoadSession = %q, %v", d, err)
+			}
+			if d, err := b.LoadSenderKey("group", "8613800000001"); err != nil || !bytes.Equal(d, secret) {
+				t.Fatalf("LoadSenderKey = %q, %v", d, err)
+			}
+			local, err := b.LoadLocalIdentity()
+			if err != nil || !bytes.Equal(local.PrivateKey, secret) {
+				t.Fatalf("LoadLocalIdentity = %+v, %v", local, err)
+			}
+			if _, err := NewEncryptedBackend(raw, old).LoadSession("8613800000001"); !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("old key err = %v, want ErrDecrypt", err)
+			}
+			if key, err := b.LoadIdentity("8613800000001"); err != nil || !bytes.Equal(key, []byte{5, 2}) {
+				t.Fatalf("LoadIdentity = %x, %v", key, err)
+			}
+		})
+	}
+}
+
+func TestRekeyShared(t *testing.T) {
+	first := openSharedSQLite(t, "8613800000000")
+	second, err := NewSharedSQLBackend(first.db, "8613800000009")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("session secret")
+	for _, b := range []*SQLBackend{first, second} {
+		if err := b.StoreSession("8613800000001", secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := testSealer(t, 1)
+	result, err := RekeyShared(first.db, nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["8613800000000"] != 1 || result["8613800000009"] != 1 || len(result) != 2 {
+		t.Fatalf("RekeyShared = %v", result)
+	}
+	for _, b := range []*SQLBackend{first, second} {
+		if d, _ := b.LoadSession("8613800000001"); !IsSealed(d) {
+			t.Fatalf("%s session stored in plaintext", b.account)
+		}
+		if d, err := NewEncryptedBackend(b, key).LoadSession("8613800000001"); err != nil || !bytes.Equal(d, secret) {
+			t.Fatalf("%s LoadSession = %q, %v", b.account, d, err)
+		}
+	}
+}
+
+func TestParseKEK(t *testing.T) {
+	raw := bytes.Repeat([]byte{0xab}, kekSize)
+	for _, text := range []string{
+		string(raw),
+		"q6urq6urq6urq6urq6urq6urq6urq6urq6urq6urq6s=\n",
+		"abababababababababababababababababababababababababababababababab",
+	} {
+		if key, err := ParseKEK([]byte(text)); err != nil || !bytes.Equal(key, raw) {
+			t.Fatalf("ParseKEK(%q) = %x, %v", text, key, err)
+		}
+	}
+	if _, err := ParseKEK([]byte("short")); err == nil {
+		t.Fatal("ParseKEK short key should fail")
+	}
+}
@@ -2,14 +2,8 @@ package store
 
 import (
 	"encoding/base64"
-	"encoding/hex"
 	"fmt"
-	"github.com/gogf/gf/database/gdb"
-	"github.com/gogf/gf/frame/g"
-	"github.com/gogf/gf/os/gtime"
-	"github.com/gogf/gf/util/gconv"
 	"log"
-	"strings"
 	"sync"
 	"ws-go/libsignal/ecc"
 	groupRecord "ws-go/libsignal/groups/state/record"
@@ -25,22 +19,25 @@ import (
 // Define some in-memory stores for testing.
 
 // IdentityKeyStore
-func NewInMemoryIdentityKey(db gdb.DB, identityKey *identity.KeyPair, localRegistrationID uint32) *InMemoryIdentityKey {
+func NewInMemoryIdentityKey(backend IdentityBackend, identityKey *identity.KeyPair, localRegistrationID uint32) *InMemoryIdentityKey {
 	i := &InMemoryIdentityKey{
-		identityStore:       db,
-		trustedKeys:         make(map[*protocol.SignalAddress]*identity.Key),
+		identityStore:       backend,
+		trustedKeys:         make(map[string]*identity.Key),
 		identityKeyPair:     identityKey,
 		localRegistrationID: localRegistrationID,
+		policy:              TrustOnFirstUse,
 		Lock:                sync.RWMutex{},
 	}
 	return i
 }
 
 type InMemoryIdentityKey struct {
-	identityStore       gdb.DB
-	trustedKeys         map[*protocol.SignalAddress]*identity.Key
+	identityStore       IdentityBackend
+	trustedKeys         map[string]*identity.Key
 	identityKeyPair     *identity.KeyPair
 	localRegistrationID uint32
+	policy              TrustPolicy
+	onChange            IdentityChangedHandler
 	Lock                sync.RWMutex
 }
 
@@ -73,67 +70,59 @@ func (i *InMemoryIdentityKey) SaveIdentity(address *protocol.SignalAddress, iden
 			fmt.Println("run web error:SaveIdentity", err)
 		}
 	}()
-	i.trustedKeys[address] = identityKey
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
 	if i.identityStore != nil {
-		identitiesModel := i.identityStore.Model("identities")
-		// set inset Data
-		insetData := gdb.Map{}
-		insetData["recipient_id"] = address.Name()
-		insetData["device_id"] = 0
+		var publicKey []byte
 		if identityKey != nil && identityKey.PublicKey() != nil {
-			insetData["public_key"] = identityKey.PublicKey().Serialize()
-		}
-		count, err := identitiesModel.Where("recipient_id =?", address.Name()).Count()
-		if err != nil {
-			log.Println("countIdentity error", err)
-			return
+			publicKey = identityKey.PublicKey().Serialize()
 		}
-		if count > 0 {
-			_, err := identitiesModel.Where("recipient_id =?", address.Name()).Delete()
-			if err != nil {
-				log.Println("DeleteIdentity error", err)
-				return
-			}
-		}
-		// insert
-		_, err = identitiesModel.Insert(insetData)
-		if err != nil {
+		if err := i.identityStore.StoreIdentity(address.Name(), publicKey); err != nil {
 			log.Println("SaveIdentity error", err)
 			return
 		}
 	}
+	i.trustedKeys[address.Name()] = identityKey
 }
 
+// IsTrustedIdentity 按照信任策略检查联系人的密钥 地址完全相同才匹配 查询出错时不可信
 func (i *InMemoryIdentityKey) IsTrustedIdentity(address *protocol.SignalAddress, identityKey *identity.Key) bool {
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Println("run web error:IsTrustedIdentity", err)
 		}
 	}()
-	for signalAddress, trusted := range i.trustedKeys {
-		if strings.Contains(signalAddress.Name(), address.Name()) {
-			return trusted == nil || trusted.Fingerprint() == identityKey.Fingerprint()
-		}
+	i.Lock.RLock()
+	policy, onChange := i.policy, i.onChange
+	trusted, err := i.loadTrustedKey(address.Name())
+	i.Lock.RUnlock()
+	if err != nil {
+		log.Println("IsTrustedIdentity err", err)
+		return false
 	}
-
-	// query data base
-	if i.identityStore != nil {
-		identitiesModel := i.identityStore.Model("identities")
-		oneRecord, err := identitiesModel.Where("recipient_id=?", address.Name()).FindOne()
-		if err != nil {
-			log.Println("IsTrustedIdentity err", err)
-			return true
-		}
-		// public keys
-		if v, ok := oneRecord["public_key"]; ok {
-			dePubKey, _ := ecc.DecodePoint(v.Bytes(), 0)
-			djbECPublicKey := ecc.NewDjbECPublicKey(dePubKey.PublicKey())
-			trusted := identity.NewKey(djbECPublicKey)
-			return trusted == nil || trusted.Fingerprint() == identityKey.Fingerprint()
-		}
+	// 第一次使用 或者密钥没有变化
+	if trusted == nil || trusted.Fingerprint() == identityKey.Fingerprint() {
+		return true
 	}
-
-	return true
+	change := &IdentityChange{
+		Name:     address.Name(),
+		OldKey:   trusted,
+		NewKey:   identityKey,
+		Policy:   policy,
+		Verified: i.isVerified(address.Name()),
+	}
+	switch policy {
+	case TrustAlways:
+		change.Trusted = true
+	case TrustBlockOnChange:
+		change.Trusted = false
+	default:
+		change.Trusted = !change.Verified
+	}
+	if onChange != nil {
+		onChange(change)
+	}
+	return change.Trusted
 }
 
 // queryMyIdentityKeys
@@ -145,29 +134,29 @@ func (i *InMemoryIdentityKey) queryMyIdentityKeys() error {
 	}()
 	// query data base
 	if i.identityStore != nil {
-		identitiesModel := i.identityStore.Model("identities")
-		oneRecord, err := identitiesModel.Where("recipient_id=?", -1).FindOne()
+		local, err := i.identityStore.LoadLocalIdentity()
 		if err != nil {
 			log.Println("GetLocalRegistrationId err", err)
 			return err
 		}
-		// registration id
-		if v, ok := oneRecord["registration_id"]; ok {
-			i.localRegistrationID = v.Uint32()
+		if local == nil {
+			return nil
 		}
+		// registration id
+		i.localRegistrationID = local.RegistrationID
 
 		var (
 			djbECPublicKey  *ecc.DjbECPublicKey
 			djbECPrivateKey *ecc.DjbECPrivateKey
 		)
 		// public keys
-		if v, ok := oneRecord["public_key"]; ok {
-			dePubKey, _ := ecc.DecodePoint(v.Bytes(), 0)
+		if len(local.PublicKey) > 0 {
+			dePubKey, _ := ecc.DecodePoint(local.PublicKey, 0)
 			djbECPublicKey = ecc.NewDjbECPublicKey(dePubKey.PublicKey())
 		}
 		// pri keys
-		if v, ok := oneRecord["private_key"]; ok {
-			djbECPrivateKey = ecc.NewDjbECPrivateKey(bytehelper.SliceToArray(v.Bytes()))
+		if len(local.PrivateKey) > 0 {
+			djbECPrivateKey = ecc.NewDjbECPrivateKey(bytehelper.SliceToArray(local.PrivateKey))
 		}
 		// set identity keys
 		i.identityKeyPair = identity.NewKeyPair(identity.NewKey(djbECPublicKey), djbECPrivateKey)
@@ -183,48 +172,36 @@ func (i *InMemoryIdentityKey) saveMyIdentityKeys(pair *identity.KeyPair, regId u
 		}
 	}()
 	if i.identityStore != nil {
-		identitiesModel := i.identityStore.Model("identities")
-		// set inset Data
-		insetData := gdb.Map{}
-		insetData["recipient_id"] = -1
-		insetData["device_id"] = 0
-		// registration id
-		insetData["registration_id"] = regId
-		// update time
-		insetData["timestamp"] = gtime.TimestampMilli()
+		local := &LocalIdentity{RegistrationID: regId}
 		// public key
 		if pair != nil && pair.PublicKey() != nil {
-			insetData["public_key"] = pair.PublicKey().PublicKey().Serialize()
+			local.PublicKey = pair.PublicKey().PublicKey().Serialize()
 		}
 		// pri key
 		if pair != nil && pair.PrivateKey() != nil {
-			insetData["private_key"] = bytehelper.ArrayToSlice(pair.PrivateKey().Serialize())
+			local.PrivateKey = bytehelper.ArrayToSlice(pair.PrivateKey().Serialize())
 		}
-		// insert
-		_, err := identitiesModel.Insert(insetData)
-		if err != nil {
+		if err := i.identityStore.StoreLocalIdentity(local); err != nil {
 			return err
 		}
-
-		// set vars
-		i.localRegistrationID = regId
-		i.identityKeyPair = pair
 	}
-
+	// set vars
+	i.localRegistrationID = regId
+	i.identityKeyPair = pair
 	return nil
 }
 
 // PreKeyStore
-func NewInMemoryPreKey(db gdb.DB) *InMemoryPreKey {
+func NewInMemoryPreKey(backend PreKeyBackend) *InMemoryPreKey {
 	return &InMemoryPreKey{
-		storesDB: db,
+		storesDB: backend,
 		store:    make(map[uint32]*record.PreKey),
 		Lock:     sync.RWMutex{},
 	}
 }
 
 type InMemoryPreKey struct {
-	storesDB gdb.DB
+	storesDB PreKeyBackend
 	store    map[uint32]*record.PreKey
 	Lock     sync.RWMutex
 }
@@ -235,25 +212,23 @@ func (i *InMemoryPreKey) LoadPreKey(preKeyID uint32) *record.PreKey {
 			fmt.Println("run web error:LoadPreKey", err)
 		}
 	}()
+	i.Lock.RLock()
+	defer i.Lock.RUnlock()
 	if i.storesDB != nil {
-		preKeysModel := i.storesDB.Model("prekeys")
-		result, err := preKeysModel.FindOne("prekey_id=?", preKeyID)
+		recordData, err := i.storesDB.LoadPreKey(preKeyID)
 		if err != nil {
 			log.Println("InMemoryPreKey LoadPreKey error", err)
 			return i.store[preKeyID]
 		}
 		// is  empty
-		if result.IsEmpty() {
+		if recordData == nil {
 			return i.store[preKeyID]
 		}
-		// record
-		if recordValue, ok := result["record"]; ok {
-			preKey, err := record.NewPreKeyFromBytes(recordValue.Bytes(), &serializer.ProtoPreKeyRecordSerializer{})
-			if err != nil {
-				return i.store[preKeyID]
-			}
-			return preKey
+		preKey, err := record.NewPreKeyFromBytes(recordData, &serializer.ProtoPreKeyRecordSerializer{})
+		if err != nil {
+			return i.store[preKeyID]
 		}
+		return preKey
 	}
 	return i.store[preKeyID]
 }
@@ -261,30 +236,18 @@ func (i *InMemoryPreKey) LoadPreKey(preKeyID uint32) *record.PreKey {
 func (i *InMemoryPreKey) StorePreKeyIds(preKeys []*record.PreKey) {
 	defer func() {
 		if err := recover(); err != nil {
-			i.Lock.Unlock()
 			fmt.Println("run web error:StorePreKey", err)
 		}
 	}()
-	list := g.Slice{}
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
+	list := make([]PreKeyRecord, 0, len(preKeys))
 	for _, key := range preKeys {
-		i.Lock.Lock()
 		i.store[key.ID().Value] = key
-		if i.storesDB != nil {
-			anyMap := g.Map{
-				"prekey_id":           key.ID().Value,
-				"record":              key.Serialize(),
-				"sent_to_server":      0,
-				"direct_distribution": false,
-				"upload_timestamp":    gtime.TimestampMilli(),
-			}
-			list = append(list, anyMap)
-		}
-		i.Lock.Unlock()
+		list = append(list, PreKeyRecord{ID: key.ID().Value, Record: key.Serialize()})
 	}
 	if i.storesDB != nil && len(list) > 0 {
-		preKeysModel := i.storesDB.Model("prekeys")
-		_, err := preKeysModel.Insert(list)
-		if err != nil {
+		if err := i.storesDB.StorePreKeys(list); err != nil {
 			log.Println("go InMemoryPreKey StorePreKey error", err)
 			return
 		}
@@ -301,15 +264,7 @@ func (i *InMemoryPreKey) StorePreKey(preKeyID uint32, preKeyRecord *record.PreKe
 	defer i.Lock.Unlock()
 	i.store[preKeyID] = preKeyRecord
 	if i.storesDB != nil {
-		preKeysModel := i.storesDB.Model("prekeys")
-		anyMap := gdb.Map{ //并发
-			"prekey_id":           preKeyID,
-			"record":              preKeyRecord.Serialize(),
-			"sent_to_server":      0,
-			"direct_distribution": false,
-			"upload_timestamp":    gtime.TimestampMilli(),
-		}
-		_, err := preKeysModel.Insert(anyMap) //并发读
+		err := i.storesDB.StorePreKeys([]PreKeyRecord{{ID: preKeyID, Record: preKeyRecord.Serialize()}})
 		if err != nil {
 			log.Println("InMemoryPreKey StorePreKey error", err)
 			return
@@ -327,13 +282,12 @@ func (i *InMemoryPreKey) ContainsPreKey(preKeyID uint32) bool {
 	defer i.Lock.RUnlock()
 	_, ok := i.store[preKeyID]
 	if i.storesDB != nil {
-		preKeysModel := i.storesDB.Model("prekeys")
-		isExist, err := preKeysModel.Count("prekey_id=?", preKeyID)
+		isExist, err := i.storesDB.ContainsPreKey(preKeyID)
 		if err != nil {
 			log.Println("InMemoryPreKey ContainsPreKey error", err)
 			return false
 		}
-		if isExist > 0 {
+		if isExist {
 			return true
 		}
 	}
@@ -346,11 +300,11 @@ func (i *InMemoryPreKey) RemovePreKey(preKeyID uint32) {
 			fmt.Println("run web error:RemovePreKey", err)
 		}
 	}()
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
 	delete(i.store, preKeyID)
 	if i.storesDB != nil {
-		preKeysModel := i.storesDB.Model("prekeys")
-		_, err := preKeysModel.Delete("prekey_id=?", preKeyID)
-		if err != nil {
+		if err := i.storesDB.RemovePreKey(preKeyID); err != nil {
 			log.Println("InMemoryPreKey RemovePreKey error", err)
 			return
 		}
@@ -358,9 +312,9 @@ func (i *InMemoryPreKey) RemovePreKey(preKeyID uint32) {
 }
 
 // SessionStore
-func NewInMemorySession(serializer *serialize.Serializer, db gdb.DB) *InMemorySession {
+func NewInMemorySession(serializer *serialize.Serializer, backend SessionBackend) *InMemorySession {
 	return &InMemorySession{
-		storesDB:   db,
+		storesDB:   backend,
 		sessions:   make(map[*protocol.SignalAddress]*record.Session),
 		serializer: serializer,
 		Lock:       sync.RWMutex{},
@@ -368,7 +322,7 @@ func NewInMemorySession(serializer *serialize.Serializer, db gdb.DB) *InMemorySe
 }
 
 type InMemorySession struct {
-	storesDB   gdb.DB
+	storesDB   SessionBackend
 	sessions   map[*protocol.SignalAddress]*record.Session
 	serializer *serialize.Serializer
 	Lock       sync.RWMutex
@@ -382,42 +336,27 @@ func (i *InMemorySession) LoadSession(address *protocol.SignalAddress) *record.S
 	}()
 	i.Lock.Lock()
 	defer i.Lock.Unlock()
-	for signalAddress, session := range i.sessions {
-		if strings.Contains(signalAddress.Name(), address.Name()) {
-			return session
-		}
+	if _, session := i.lookup(address); session != nil {
+		return session
 	}
 
 	if i.storesDB != nil {
-		sessionsModel := i.storesDB.Model("sessions")
-		sessionsModel.Where("recipient_id=?", gconv.Int64(address.Name()))
-		session, err := sessionsModel.FindOne()
-		if err != nil {
-			session := record.NewSession(i.serializer.Session, i.serializer.State)
-			i.sessions[address] = session
-			return session
-		}
+		recordData, err := i.storesDB.LoadSession(address.Name())
 		// is empty
-		if session.IsEmpty() {
+		if err != nil || recordData == nil {
 			session := record.NewSession(i.serializer.Session, i.serializer.State)
 			i.sessions[address] = session
 			return session
 		}
-		// get record
-		if v, ok := session["record"]; !ok {
-			session := record.NewSession(i.serializer.Session, i.serializer.State)
-			i.sessions[address] = session
-			return session
-		} else {
-			protoSessionSerializer := serializer.ProtoSessionSerializer{}
-			protoStateSerializer := serializer.ProtoStateSerializer{}
-			log.Println("LoadSession:", hex.EncodeToString(v.Bytes()))
-			session, err := record.NewSessionFromBytes(v.Bytes(), &protoSessionSerializer, &protoStateSerializer)
-			if err != nil {
-				return nil
-			}
-			return session
+		protoSessionSerializer := serializer.ProtoSessionSerializer{}
+		protoStateSerializer := serializer.ProtoStateSerializer{}
+		session, err := record.NewSessionFromBytes(recordData, &protoSessionSerializer, &protoStateSerializer)
+		if err != nil {
+			return nil
 		}
+		// 缓存后 builder 和 cipher 修改的是同一个 session
+		i.sessions[address] = session
+		return session
 	}
 
 	session := record.NewSession(i.serializer.Session, i.serializer.State)
@@ -425,6 +364,17 @@ func (i *InMemorySession) LoadSession(address *protocol.SignalAddress) *record.S
 	return session
 }
 
+// lookup 名称和设备 id 都相同的 session 需要持有锁
+// 一个 jid 不能匹配到包含它的另一个 jid
+func (i *InMemorySession) lookup(address *protocol.SignalAddress) (*protocol.SignalAddress, *record.Session) {
+	for signalAddress, session := range i.sessions {
+		if signalAddress.Name() == address.Name() && signalAddress.DeviceID() == address.DeviceID() {
+			return signalAddress, session
+		}
+	}
+	return nil, nil
+}
+
 func (i *InMemorySession) GetSubDeviceSessions(name string) []uint32 {
 	defer func() {
 		if err := recover(); err != nil {
@@ -448,49 +398,22 @@ func (i *InMemorySession) StoreSession(remoteAddress *protocol.SignalAddress, re
 			fmt.Println("run web error:StoreSession", err)
 		}
 	}()
-	i.Lock.RLock()
-	defer i.Lock.RUnlock()
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
+	// 同一个地址只保留一个 session
+	if signalAddress, _ := i.lookup(remoteAddress); signalAddress != nil {
+		delete(i.sessions, signalAddress)
+	}
 	i.sessions[remoteAddress] = record
 	if i.storesDB != nil {
-		sessionsModel := i.storesDB.Model("sessions")
-		sessionsModel.Where("recipient_id=?", gconv.Int64(remoteAddress.Name()))
-		isExist, err := sessionsModel.Count()
-		if err != nil {
-			return
-		}
 		// recipient_id
 		name := remoteAddress.Name()
 		// record bytes
 		recordData := record.Serialize()
-		log.Println("StoreSession recordData:", hex.EncodeToString(recordData))
-		// if exist only update if not exist inset
-		if isExist > 0 {
-			anyMap := gdb.Map{
-				"record": recordData,
-			}
-			_, err = sessionsModel.Data(anyMap).
-				Where("recipient_id", gconv.Int64(name)).
-				Update()
-
-			if err != nil {
-				log.Println("StoreSession update error recipient_id = ", name, err)
-			}
-		} else {
-			anyMap := gdb.Map{
-				"recipient_id": gconv.Int64(name),
-				"record":       recordData,
-				"device_id":    0,
-				"timestamp":    gtime.Timestamp(),
-			}
-			_, err = sessionsModel.Insert(anyMap)
-			/**/
-			if err != nil {
-				log.Println("StoreSession Save error recipient_id = ", name, err)
-			}
+		if err := i.storesDB.StoreSession(name, recordData); err != nil {
+			log.Println("StoreSession Save error recipient_id = ", name, err)
 		}
-
 	}
-	log.Println("StoreSession", hex.EncodeToString(record.Serialize()))
 }
 
 func (i *InMemorySession) ContainsSession(remoteAddress *protocol.SignalAddress) bool {
@@ -499,26 +422,19 @@ func (i *InMemorySession) ContainsSession(remoteAddress *protocol.SignalAddress)
 			fmt.Println("run web error:ContainsSession", err)
 		}
 	}()
-	for signalAddress, _ := range i.sessions {
-		if strings.Contains(signalAddress.Name(), remoteAddress.Name()) {
-			return true
-		}
+	i.Lock.RLock()
+	defer i.Lock.RUnlock()
+	if _, session := i.lookup(remoteAddress); session != nil {
+		return true
 	}
 
 	if i.storesDB != nil {
-		i.Lock.RLock()
-		sessionsModel := i.storesDB.Model("sessions")
-		sessionsModel.Where("recipient_id", gconv.Int64(remoteAddress.Name()))
-		isExist, err := sessionsModel.Count()
-		i.Lock.RUnlock()
+		isExist, err := i.storesDB.ContainsSession(remoteAddress.Name())
 		if err != nil {
 			log.Println("ContainsSession where error", err)
 			return false
 		}
-		// is exist
-		if isExist > 0 {
-			return true
-		}
+		return isExist
 	}
 	return false
 }
@@ -529,9 +445,16 @@ func (i *InMemorySession) DeleteSession(remoteAddress *protocol.SignalAddress) {
 			fmt.Println("run web error:DeleteSession", err)
 		}
 	}()
-	i.Lock.RLock()
-	defer i.Lock.RUnlock()
-	delete(i.sessions, remoteAddress)
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
+	if signalAddress, _ := i.lookup(remoteAddress); signalAddress != nil {
+		delete(i.sessions, signalAddress)
+	}
+	if i.storesDB != nil {
+		if err := i.storesDB.DeleteSession(remoteAddress.Name()); err != nil {
+			log.Println("DeleteSession error", err)
+		}
+	}
 }
 
 func (i *InMemorySession) DeleteAllSessions() {
@@ -540,20 +463,27 @@ func (i *InMemorySession) DeleteAllSessions() {
 			fmt.Println("run web error:DeleteAllSessions", err)
 		}
 	}()
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
 	i.sessions = make(map[*protocol.SignalAddress]*record.Session)
+	if i.storesDB != nil {
+		if err := i.storesDB.DeleteAllSessions(); err != nil {
+			log.Println("DeleteAllSessions error", err)
+		}
+	}
 }
 
 // SignedPreKeyStore
-func NewInMemorySignedPreKey(db gdb.DB) *InMemorySignedPreKey {
+func NewInMemorySignedPreKey(backend SignedPreKeyBackend) *InMemorySignedPreKey {
 	return &InMemorySignedPreKey{
-		signedDatabase: db,
+		signedDatabase: backend,
 		store:          make(map[uint32]*record.SignedPreKey),
 		Lock:           sync.RWMutex{},
 	}
 }
 
 type InMemorySignedPreKey struct {
-	signedDatabase gdb.DB
+	signedDatabase SignedPreKeyBackend
 	store          map[uint32]*record.SignedPreKey
 	Lock           sync.RWMutex
 }
@@ -566,20 +496,15 @@ func (i *InMemorySignedPreKey) LoadSignedPreKey(signedPreKeyID uint32) *record.S
 	}()
 	i.Lock.RLock()
 	defer i.Lock.RUnlock()
-	//if sPreKeys,ok := i.store[signedPreKeyID];ok {
-	//	return sPreKeys
-	//}
 	// query data base
 	if i.signedDatabase != nil {
-		signedPreKeysModel := i.signedDatabase.Model("signed_prekeys")
-		oneRecord, err := signedPreKeysModel.FindOne("prekey_id=?", signedPreKeyID)
+		recordData, err := i.signedDatabase.LoadSignedPreKey(signedPreKeyID)
 		if err != nil {
 			log.Println("LoadSignedPreKey error", err)
 			return nil
 		}
-		// record
-		if recordValue, ok := oneRecord["record"]; ok {
-			sPreKeys, err := record.NewSignedPreKeyFromBytes(recordValue.Bytes(), &serializer.ProtoSignedPreKeyRecordSerializer{})
+		if recordData != nil {
+			sPreKeys, err := record.NewSignedPreKeyFromBytes(recordData, &serializer.ProtoSignedPreKeyRecordSerializer{})
 			if err != nil {
 				log.Println("LoadSignedPreKey error", err)
 				return nil
@@ -591,8 +516,30 @@ func (i *InMemorySignedPreKey) LoadSignedPreKey(signedPreKeyID uint32) *record.S
 }
 
 func (i *InMemorySignedPreKey) LoadSignedPreKeys() []*record.SignedPreKey {
-
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("run web error:LoadSignedPreKeys", err)
+		}
+	}()
+	i.Lock.RLock()
+	defer i.Lock.RUnlock()
 	var preKeys []*record.SignedPreKey
+	if i.signedDatabase != nil {
+		list, err := i.signedDatabase.LoadSignedPreKeys()
+		if err != nil {
+			log.Println("LoadSignedPreKeys error", err)
+			return nil
+		}
+		for _, r := range list {
+			sPreKeys, err := record.NewSignedPreKeyFromBytes(r.Record, &serializer.ProtoSignedPreKeyRecordSerializer{})
+			if err != nil {
+				log.Println("LoadSignedPreKeys error", err)
+				continue
+			}
+			preKeys = append(preKeys, sPreKeys)
+		}
+		return preKeys
+	}
 
 	for _, record := range i.store {
 		preKeys = append(preKeys, record)
@@ -607,29 +554,10 @@ func (i *InMemorySignedPreKey) StoreSignedPreKey(signedPreKeyID uint32, record *
 			fmt.Println("run web error:StoreSignedPreKey", err)
 		}
 	}()
-	//i.store[signedPreKeyID] = record
-	i.Lock.RLock()
-	defer i.Lock.RUnlock()
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
 	if i.signedDatabase != nil {
-		signedPreKeysModel := i.signedDatabase.Model("signed_prekeys")
-		saveData := gdb.Map{}
-		saveData["prekey_id"] = signedPreKeyID
-		saveData["timestamp"] = gtime.TimestampMilli()
-		saveData["record"] = record.Serialize()
-		count, err := signedPreKeysModel.Count()
-		if err != nil {
-			log.Println("StoreSignedPreKey count error", err)
-			return
-		}
-		if count > 0 {
-			_, err = signedPreKeysModel.Delete()
-			if err != nil {
-				log.Println("StoreSignedPreKey delete error", err)
-				return
-			}
-		}
-		_, err = signedPreKeysModel.Insert(saveData)
-		if err != nil {
+		if err := i.signedDatabase.StoreSignedPreKey(signedPreKeyID, record.Serialize()); err != nil {
 			log.Println("StoreSignedPreKey Insert error", err)
 			return
 		}
@@ -637,8 +565,7 @@ func (i *InMemorySignedPreKey) StoreSignedPreKey(signedPreKeyID uint32, record *
 }
 
 func (i *InMemorySignedPreKey) ContainsSignedPreKey(signedPreKeyID uint32) bool {
-	_, ok := i.store[signedPreKeyID]
-	return ok
+	return i.LoadSignedPreKey(signedPreKeyID) != nil
 }
 
 func (i *InMemorySignedPreKey) RemoveSignedPreKey(signedPreKeyID uint32) {
@@ -647,28 +574,26 @@ func (i *InMemorySignedPreKey) RemoveSignedPreKey(signedPreKeyID uint32) {
 			fmt.Println("run web error:RemoveSignedPreKey", err)
 		}
 	}()
-	i.Lock.RLock()
-	defer i.Lock.RUnlock()
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
 	delete(i.store, signedPreKeyID)
 	if i.signedDatabase != nil {
-		signedPreKeysModel := i.signedDatabase.Model("signed_prekeys")
-		_, err := signedPreKeysModel.Delete("prekey_id=?", signedPreKeyID)
-		if err != nil {
+		if err := i.signedDatabase.RemoveSignedPreKey(signedPreKeyID); err != nil {
 			log.Println("RemoveSignedPreKey error ", err)
 		}
 	}
 }
 
-func NewInMemorySenderKey(db gdb.DB) *InMemorySenderKey {
+func NewInMemorySenderKey(backend SenderKeyBackend) *InMemorySenderKey {
 	return &InMemorySenderKey{
-		storesDB: db,
+		storesDB: backend,
 		store:    make(map[*protocol.SenderKeyName]*groupRecord.SenderKey),
 		Lock:     sync.RWMutex{},
 	}
 }
 
 type InMemorySenderKey struct {
-	storesDB gdb.DB
+	storesDB SenderKeyBackend
 	store    map[*protocol.SenderKeyName]*groupRecord.SenderKey
 	Lock     sync.RWMutex
 }
@@ -679,40 +604,12 @@ func (i *InMemorySenderKey) StoreSenderKey(senderKeyName *protocol.SenderKeyName
 			fmt.Println("run web error:StoreSenderKey", err)
 		}
 	}()
-	//i.storeDataBase[senderKeyName] = keyRecord
 	if i.storesDB != nil {
-		senderKeyModel := i.storesDB.Model("sender_keys")
-		senderId := gconv.Int64(senderKeyName.Sender().Name())
-		senderKeyModel.Where("group_id=? AND sender_id=?", senderKeyName.GroupID(), senderId)
-		i.Lock.RLock()
-		isExist, err := senderKeyModel.Count()
-		i.Lock.RUnlock()
+		i.Lock.Lock()
+		defer i.Lock.Unlock()
+		err := i.storesDB.StoreSenderKey(senderKeyName.GroupID(), senderKeyName.Sender().Name(), keyRecord.Serialize())
 		if err != nil {
-			log.Println("StoreSenderKey databases error", err)
-			return
-		}
-		recordData := keyRecord.Serialize()
-		// is Exist
-		if isExist > 0 {
-			// update
-			anyMap := gdb.Map{"record": recordData}
-			_, err = senderKeyModel.Data(anyMap).
-				Where("group_id=? AND sender_id=?", senderKeyName.GroupID(), senderId).
-				Update()
-
-			if err != nil {
-				log.Println("StoreSenderKey update error group_id = ", senderKeyName.GroupID(), err)
-			}
-		} else {
-			anyMap := gdb.Map{
-				"group_id":  senderKeyName.GroupID(),
-				"sender_id": senderId,
-				"record":    recordData,
-			}
-			_, err = senderKeyModel.Insert(anyMap)
-			if err != nil {
-				log.Println("StoreSenderKey Save error group_id = ", senderKeyName.GroupID(), err)
-			}
+			log.Println("StoreSenderKey Save error group_id = ", senderKeyName.GroupID(), err)
 		}
 	}
 }
@@ -725,46 +622,32 @@ func (i *InMemorySenderKey) LoadSenderKey(senderKeyName *protocol.SenderKeyName)
 	}()
 	i.Lock.RLock()
 	defer i.Lock.RUnlock()
-	// 先在内存中查找
-	//for v, key := range i.storeDataBase {
-	//	if strings.Contains(v.GroupID(), senderKeyName.GroupID()) &&
-	//		strings.Contains(v.Sender().Name(), senderKeyName.Sender().Name()) {
-	//		return key
-	//	}
-	//}
-	// id
-	groupId := senderKeyName.GroupID()
-	senderId := gconv.Int64(senderKeyName.Sender().Name())
 	// stores database
 	if i.storesDB != nil {
-		senderKeysModel := i.storesDB.Model("sender_keys")
-		senderKeysModel.Where("group_id=? AND sender_id=?", groupId, senderId)
-		oneData, err := senderKeysModel.FindOne()
+		recordData, err := i.storesDB.LoadSenderKey(senderKeyName.GroupID(), senderKeyName.Sender().Name())
 		if err != nil {
 			log.Println("LoadSenderKey findOne error", err)
 			return nil
 		}
 		// is Empty
-		if oneData.IsEmpty() {
+		if recordData == nil {
 			return nil
 		}
-		// recordValue
-		if recordValue, ok := oneData["record"]; ok {
-			senderKeySessionSerializer := &serializer.ProtoSenderKeySessionSerializer{}
-			sendKeyStateSerializer := &serializer.ProtoSenderKeyStateSerializer{}
-			senderKey, err := groupRecord.NewSenderKeyFromBytes(recordValue.Bytes(), senderKeySessionSerializer, sendKeyStateSerializer)
-			if err != nil {
-				return nil
-			}
-			return senderKey
+		senderKeySessionSerializer := &serializer.ProtoSenderKeySessionSerializer{}
+		sendKeyStateSerializer := &serializer.ProtoSenderKeyStateSerializer{}
+		senderKey, err := groupRecord.NewSenderKeyFromBytes(recordData, senderKeySessionSerializer, sendKeyStateSerializer)
+		if err != nil {
+			return nil
 		}
+		return senderKey
 	}
 	return nil
 }
 
 // SignalStore
 type SignalStore struct {
-	storeDataBase     gdb.DB
+	backend           Backend
+	txLock            sync.Mutex
 	SessionStore      *InMemorySession
 	PreKeyStore       *InMemoryPreKey
 	SignedPreKeyStore *InMemorySignedPreKey
@@ -774,6 +657,11 @@ type SignalStore struct {
 	Serialize *serialize.Serializer
 }
 
+// Backend 保存数据的后端
+func (s *SignalStore) Backend() Backend {
+	return s.backend
+}
+
 func (s *SignalStore) initSignalStore(staticPubKey string, staticPriKey string) error {
 	defer func() {
 		if r := recover(); r != nil {
@@ -839,36 +727,31 @@ func (s *SignalStore) initSignalStore(staticPubKey string, staticPriKey string)
 	return nil
 }
 
-// NewSignalStore
-func NewSignalStore(db gdb.DB, needInit bool, staticPubKey string, staticPriKey string) *SignalStore {
+// NewSignalStore 后端中没有本机身份密钥时生成新的密钥 needInit 为 true
+func NewSignalStore(backend Backend, staticPubKey string, staticPriKey string) (s *SignalStore, needInit bool, err error) {
 	// serialize
 	newProtoSerializer := serializer.NewProtoSerializer()
 	// create instance
-	s := &SignalStore{
-		storeDataBase:     db,
-		SessionStore:      NewInMemorySession(newProtoSerializer, db),
-		PreKeyStore:       NewInMemoryPreKey(db),
-		SignedPreKeyStore: NewInMemorySignedPreKey(db),
-		IdentityStore:     NewInMemoryIdentityKey(db, nil, 0),
-		SenderKeyStore:    NewInMemorySenderKey(db),
+	s = &SignalStore{
+		backend:           backend,
+		SessionStore:      NewInMemorySession(newProtoSerializer, backend),
+		PreKeyStore:       NewInMemoryPreKey(backend),
+		SignedPreKeyStore: NewInMemorySignedPreKey(backend),
+		IdentityStore:     NewInMemoryIdentityKey(backend, nil, 0),
+		SenderKeyStore:    NewInMemorySenderKey(backend),
 
 		Serialize: newProtoSerializer,
 	}
+	local, err := backend.LoadLocalIdentity()
+	if err != nil {
+		return nil, false, err
+	}
 	// need init
-	if needInit {
-		_ = s.initSignalStore(staticPubKey, staticPriKey)
-	}
-
-	// Generate a registration id
-	//
-	//priKeyData, _ := hex.DecodeString("70d39c86f3e17e3ac93da9a53541dcdda5647bf618463499b48b0d61e5aa2c7a")
-	//djbECPrivateKey := ecc.NewDjbECPrivateKey(bytehelper.SliceToArray(priKeyData))
-	//
-	//pubKeyData, _ := hex.DecodeString("053bbb934014e04dd100a874e5a328b536788d4fe7aba4f092b301d6584e2ac81e")
-	//dePubKey, _ := ecc.DecodePoint(pubKeyData, 0)
-	//djbECPublicKey := ecc.NewDjbECPublicKey(dePubKey.PublicKey())
-	//log.Println(pubKeyData)
-	//
-	//newKeyPair := identity.NewKeyPair(identity.NewKey(djbECPublicKey), djbECPrivateKey)
-	return s
+	if local == nil {
+		if err := s.initSignalStore(staticPubKey, staticPriKey); err != nil {
+			return nil, false, err
+		}
+		needInit = true
+	}
+	return s, needInit, nil
 }
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"sync"
+	"ws-go/libsignal/keys/identity"
+	"ws-go/libsignal/protocol"
+	"ws-go/libsignal/state/record"
+)
+
+// txBackend 记录事务中的第一个错误
+// InMemory* 只打印后端的错误 事务结束时需要根据错误回滚
+type txBackend struct {
+	Backend
+	mutex sync.Mutex
+	err   error
+}
+
+func (t *txBackend) check(err error) error {
+	if err != nil {
+		t.mutex.Lock()
+		if t.err == nil {
+			t.err = err
+		}
+		t.mutex.Unlock()
+	}
+	return err
+}
+
+func (t *txBackend) Err() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.err
+}
+
+func (t *txBackend) LoadLocalIdentity() (*LocalIdentity, error) {
+	local, err := t.Backend.LoadLocalIdentity()
+	return local, t.check(err)
+}
+
+func (t *txBackend) StoreLocalIdentity(identity *LocalIdentity) error {
+	return t.check(t.Backend.StoreLocalIdentity(identity))
+}
+
+func (t *txBackend) LoadIdentity(name string) ([]byte, error) {
+	d, err := t.Backend.LoadIdentity(name)
+	return d, t.check(err)
+}
+
+func (t *txBackend) StoreIdentity(name string, publicKey []byte) error {
+	return t.check(t.Backend.StoreIdentity(name, publicKey))
+}
+
+func (t *txBackend) LoadIdentityVerified(name string) (bool, error) {
+	verified, err := t.Backend.LoadIdentityVerified(name)
+	return verified, t.check(err)
+}
+
+func (t *txBackend) StoreIdentityVerified(name string, verified bool) error {
+	return t.check(t.Backend.StoreIdentityVerified(name, verified))
+}
+
+func (t *txBackend) LoadPreKey(id uint32) ([]byte, error) {
+	d, err := t.Backend.LoadPreKey(id)
+	return d, t.check(err)
+}
+
+func (t *txBackend) StorePreKeys(keys []PreKeyRecord) error {
+	return t.check(t.Backend.StorePreKeys(keys))
+}
+
+func (t *txBackend) ContainsPreKey(id uint32) (bool, error) {
+	ok, err := t.Backend.ContainsPreKey(id)
+	return ok, t.check(err)
+}
+
+func (t *txBackend) RemovePreKey(id uint32) error {
+	return t.check(t.Backend.RemovePreKey(id))
+}
+
+func (t *txBackend) LoadPreKeys(unsent bool) ([]PreKeyRecord, error) {
+	keys, err := t.Backend.LoadPreKeys(unsent)
+	return keys, t.check(err)
+}
+
+func (t *txBackend) CountPreKeys(unsent bool) (int, error) {
+	n, err := t.Backend.CountPreKeys(unsent)
+	return n, t.check(err)
+}
+
+func (t *txBackend) MarkPreKeysSent(ids []uint32) error {
+	return t.check(t.Backend.MarkPreKeysSent(ids))
+}
+
+func (t *txBackend) LoadSignedPreKey(id uint32) ([]byte, error) {
+	d, err := t.Backend.LoadSignedPreKey(id)
+	return d, t.check(err)
+}
+
+func (t *txBackend) LoadSignedPreKeys() ([]PreKeyRecord, error) {
+	keys, err := t.Backend.LoadSignedPreKeys()
+	return keys, t.check(err)
+}
+
+func (t *txBackend) StoreSignedPreKey(id uint32, record []byte) error {
+	return t.check(t.Backend.StoreSignedPreKey(id, record))
+}
+
+func (t *txBackend) RemoveSignedPreKey(id uint32) error {
+	return t.check(t.Backend.RemoveSignedPreKey(id))
+}
+
+func (t *txBackend) LoadSession(name string) ([]byte, error) {
+	d, err := t.Backend.LoadSession(name)
+	return d, t.check(err)
+}
+
+func (t *txBackend) StoreSession(name string, record []byte) error {
+	return t.check(t.Backend.StoreSession(name, record))
+}
+
+func (t *txBackend) ContainsSession(name string) (bool, error) {
+	ok, err := t.Backend.ContainsSession(name)
+	return ok, t.check(err)
+}
+
+func (t *txBackend) DeleteSession(name string) error {
+	return t.check(t.Backend.DeleteSession(name))
+}
+
+func (t *txBackend) DeleteAllSessions() error {
+	return t.check(t.Backend.DeleteAllSessions())
+}
+
+func (t *txBackend) LoadSenderKey(groupID, sender string) ([]byte, error) {
+	d, err := t.Backend.LoadSenderKey(groupID, sender)
+	return d, t.check(err)
+}
+
+func (t *txBackend) StoreSenderKey(groupID, sender string, record []byte) error {
+	return t.check(t.Backend.StoreSenderKey(groupID, sender, record))
+}
+
+// Transaction f 中使用 tx 的 store 修改 session prekey identity 等数据
+// f 返回错误或者后端出错时全部回滚 成功后清空缓存 之后从后端重新读取
+// 事务中的密钥变化在提交后通知 回滚时只通知没有信任的变化 新的密钥没有保存 需要用户确认
+func (s *SignalStore) Transaction(f func(tx *SignalStore) error) error {
+	changes, err := s.transaction(f)
+	s.IdentityStore.Lock.RLock()
+	onChange := s.IdentityStore.onChange
+	s.IdentityStore.Lock.RUnlock()
+	if onChange != nil {
+		for _, change := range changes {
+			if err == nil || !change.Trusted {
+				onChange(change)
+			}
+		}
+	}
+	return err
+}
+
+// transaction 返回事务中的密钥变化 同一个联系人只保留最后一次
+func (s *SignalStore) transaction(f func(tx *SignalStore) error) ([]*IdentityChange, error) {
+	s.txLock.Lock()
+	defer s.txLock.Unlock()
+	identityKeyPair := s.IdentityStore.GetIdentityKeyPair()
+	registrationID := s.IdentityStore.GetLocalRegistrationId()
+	s.IdentityStore.Lock.RLock()
+	policy := s.IdentityStore.policy
+	s.IdentityStore.Lock.RUnlock()
+	var (
+		changes      []*IdentityChange
+		changesMutex sync.Mutex
+	)
+	err := s.backend.Transaction(func(b Backend) error {
+		changes = nil
+		tb := &txBackend{Backend: b}
+		// 事务中的 store 有自己的缓存 回滚时直接丢弃
+		tx := &SignalStore{
+			backend:           tb,
+			SessionStore:      NewInMemorySession(s.Serialize, tb),
+			PreKeyStore:       NewInMemoryPreKey(tb),
+			SignedPreKeyStore: NewInMemorySignedPreKey(tb),
+			IdentityStore:     NewInMemoryIdentityKey(tb, identityKeyPair, registrationID),
+			SenderKeyStore:    NewInMemorySenderKey(tb),
+			Serialize:         s.Serialize,
+		}
+		tx.IdentityStore.policy = policy
+		tx.IdentityStore.onChange = func(change *IdentityChange) {
+			changesMutex.Lock()
+			defer changesMutex.Unlock()
+			for i, c := range changes {
+				if c.Name == change.Name {
+					changes = append(changes[:i], changes[i+1:]...)
+					break
+				}
+			}
+			changes = append(changes, change)
+		}
+		if err := f(tx); err != nil {
+			return err
+		}
+		return tb.Err()
+	})
+	if err == nil {
+		s.clearCache()
+	}
+	return changes, err
+}
+
+// clearCache 事务提交后缓存中的数据可能已经过期
+func (s *SignalStore) clearCache() {
+	s.SessionStore.Lock.Lock()
+	s.SessionStore.sessions = make(map[*protocol.SignalAddress]*record.Session)
+	s.SessionStore.Lock.Unlock()
+	s.PreKeyStore.Lock.Lock()
+	s.PreKeyStore.store = make(map[uint32]*record.PreKey)
+	s.PreKeyStore.Lock.Unlock()
+	s.IdentityStore.Lock.Lock()
+	s.IdentityStore.trustedKeys = make(map[string]*identity.Key)
+	s.IdentityStore.Lock.Unlock()
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"ws-go/libsignal/ecc"
+	"ws-go/libsignal/keys/identity"
+)
+
+// TrustPolicy 联系人身份密钥的信任策略 每个账号可以不同
+type TrustPolicy string
+
+const (
+	// TrustOnFirstUse 第一次使用的密钥可信 密钥变化后接受新的密钥
+	// 已经验证安全码的联系人密钥变化后不可信
+	TrustOnFirstUse TrustPolicy = "tofu"
+	// TrustAlways 任何密钥都可信
+	TrustAlways TrustPolicy = "always"
+	// TrustBlockOnChange 密钥变化后不可信 需要调用 ApproveIdentity 确认新的密钥
+	TrustBlockOnChange TrustPolicy = "block"
+)
+
+// ParseTrustPolicy 空字符串为 TrustOnFirstUse
+func ParseTrustPolicy(s string) (TrustPolicy, error) {
+	switch p := TrustPolicy(s); p {
+	case "":
+		return TrustOnFirstUse, nil
+	case TrustOnFirstUse, TrustAlways, TrustBlockOnChange:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown trust policy %q", s)
+}
+
+// IdentityChange 联系人的身份密钥变化 Trusted 为 false 时新的密钥被拒绝
+// Verified 为变化前是否验证过安全码 保存新的密钥后验证会被清除
+type IdentityChange struct {
+	Name     string
+	OldKey   *identity.Key
+	NewKey   *identity.Key
+	Policy   TrustPolicy
+	Verified bool
+	Trusted  bool
+}
+
+// IdentityChangedHandler 在 IsTrustedIdentity 中调用 不能再调用 store 的方法
+type IdentityChangedHandler func(change *IdentityChange)
+
+// SetTrustPolicy 设置信任策略
+func (i *InMemoryIdentityKey) SetTrustPolicy(policy TrustPolicy) {
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
+	i.policy = policy
+}
+
+// TrustPolicy 当前的信任策略
+func (i *InMemoryIdentityKey) TrustPolicy() TrustPolicy {
+	i.Lock.RLock()
+	defer i.Lock.RUnlock()
+	return i.policy
+}
+
+// SetIdentityChangedHandler 设置密钥变化的通知
+func (i *InMemoryIdentityKey) SetIdentityChangedHandler(handler IdentityChangedHandler) {
+	i.Lock.Lock()
+	defer i.Lock.Unlock()
+	i.onChange = handler
+}
+
+// isVerified 查询出错时按照已经验证处理
+func (i *InMemoryIdentityKey) isVerified(name string) bool {
+	if i.identityStore == nil {
+		return false
+	}
+	verified, err := i.identityStore.LoadIdentityVerified(name)
+	if err != nil {
+		log.Println("LoadIdentityVerified err", err)
+		return true
+	}
+	return verified
+}
+
+// loadTrustedKey 先查缓存再查数据库 没有保存过时返回 nil
+func (i *InMemoryIdentityKey) loadTrustedKey(name string) (*identity.Key, error) {
+	if trusted, ok := i.trustedKeys[name]; ok {
+		return trusted, nil
+	}
+	if i.identityStore == nil {
+		return nil, nil
+	}
+	publicKey, err := i.identityStore.LoadIdentity(name)
+	if err != nil || len(publicKey) == 0 {
+		return nil, err
+	}
+	return DecodeIdentityKey(publicKey)
+}
+
+// DecodeIdentityKey publicKey 为带类型前缀的公钥
+func DecodeIdentityKey(publicKey []byte) (*identity.Key, error) {
+	dePubKey, err := ecc.DecodePoint(publicKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	return identity.NewKey(ecc.NewDjbECPublicKey(dePubKey.PublicKey())), nil
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"ws-go/libsignal/keys/identity"
+	"ws-go/libsignal/protocol"
+	"ws-go/libsignal/util/keyhelper"
+)
+
+func newIdentityKey(t *testing.T) *identity.Key {
+	t.Helper()
+	pair, err := keyhelper.GenerateIdentityKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair.PublicKey()
+}
+
+func TestIsTrustedIdentity(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      TrustPolicy
+		verified    bool
+		wantChanged bool
+	}{
+		{"tofu", TrustOnFirstUse, false, true},
+		{"tofu-verified", TrustOnFirstUse, true, false},
+		{"always", TrustAlways, false, true},
+		{"always-verified", TrustAlways, true, true},
+		{"block", TrustBlockOnChange, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes []*IdentityChange
+			i := NewInMemoryIdentityKey(NewMemoryBackend(), nil, 0)
+			i.SetTrustPolicy(tt.policy)
+			i.SetIdentityChangedHandler(func(change *IdentityChange) {
+				changes = append(changes, change)
+			})
+			address := protocol.NewSignalAddress("8613800000001", 0)
+			oldKey, newKey := newIdentityKey(t), newIdentityKey(t)
+
+			// 第一次使用
+			if !i.IsTrustedIdentity(address, oldKey) {
+				t.Fatal("first use should be trusted")
+			}
+			i.SaveIdentity(address, oldKey)
+			if err := i.identityStore.StoreIdentityVerified(address.Name(), tt.verified); err != nil {
+				t.Fatal(err)
+			}
+			if !i.IsTrustedIdentity(address, oldKey) {
+				t.Fatal("saved key should be trusted")
+			}
+			// 地址完全相同才匹配
+			if !i.IsTrustedIdentity(protocol.NewSignalAddress("861380000000", 0), newKey) {
+				t.Fatal("prefix address should be first use")
+			}
+			if len(changes) != 0 {
+				t.Fatalf("changes = %d before key change", len(changes))
+			}
+
+			if got := i.IsTrustedIdentity(address, newKey); got != tt.wantChanged {
+				t.Fatalf("changed key trusted = %v, want %v", got, tt.wantChanged)
+			}
+			if len(changes) != 1 {
+				t.Fatalf("changes = %d, want 1", len(changes))
+			}
+			change := changes[0]
+			if change.Name != "8613800000001" || change.Policy != tt.policy || change.Trusted != tt.wantChanged ||
+				change.Verified != tt.verified ||
+				change.OldKey.Fingerprint() != oldKey.Fingerprint() || change.NewKey.Fingerprint() != newKey.Fingerprint() {
+				t.Fatalf("change = %+v", change)
+			}
+
+			// 重新读取数据库
+			reopened := NewInMemoryIdentityKey(i.identityStore, nil, 0)
+			reopened.SetTrustPolicy(tt.policy)
+			if got := reopened.IsTrustedIdentity(address, newKey); got != tt.wantChanged {
+				t.Fatalf("reopened changed key trusted = %v, want %v", got, tt.wantChanged)
+			}
+		})
+	}
+}
+
+type errIdentityBackend struct {
+	IdentityBackend
+}
+
+func (errIdentityBackend) LoadIdentity(name string) ([]byte, error) {
+	return nil, errors.New("db closed")
+}
+
+func TestSignalStore_TransactionIdentityChange(t *testing.T) {
+	s, _, err := NewSignalStore(NewMemoryBackend(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []*IdentityChange
+	s.IdentityStore.SetIdentityChangedHandler(func(change *IdentityChange) {
+		changes = append(changes, change)
+	})
+	address := protocol.NewSignalAddress("8613800000001", 0)
+	oldKey, newKey := newIdentityKey(t), newIdentityKey(t)
+	s.IdentityStore.SaveIdentity(address, oldKey)
+
+	changed := func(tx *SignalStore) {
+		tx.IdentityStore.IsTrustedIdentity(address, newKey)
+		tx.IdentityStore.IsTrustedIdentity(address, newKey)
+		if len(changes) != 0 {
+			t.Fatal("identity change notified before commit")
+		}
+	}
+	// 回滚时不通知信任的变化
+	rollback := errors.New("rollback")
+	if err := s.Transaction(func(tx *SignalStore) error {
+		changed(tx)
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("Transaction err = %v", err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("changes = %d after rollback", len(changes))
+	}
+	// 没有信任的变化回滚后也需要通知 等待用户确认
+	s.IdentityStore.SetTrustPolicy(TrustBlockOnChange)
+	if err := s.Transaction(func(tx *SignalStore) error {
+		changed(tx)
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("Transaction err = %v", err)
+	}
+	if len(changes) != 1 || changes[0].Trusted {
+		t.Fatalf("changes = %+v after blocked rollback", changes)
+	}
+	changes = nil
+	s.IdentityStore.SetTrustPolicy(TrustOnFirstUse)
+	// 提交后通知一次
+	if err := s.Transaction(func(tx *SignalStore) error {
+		changed(tx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Name != address.Name() || changes[0].NewKey != newKey {
+		t.Fatalf("changes = %+v after commit", changes)
+	}
+}
+
+func TestIsTrustedIdentity_BackendError(t *testing.T) {
+	i := NewInMemoryIdentityKey(errIdentityBackend{NewMemoryBackend()}, nil, 0)
+	if i.IsTrustedIdentity(protocol.NewSignalAddress("8613800000001", 0), newIdentityKey(t)) {
+		t.Fatal("backend error should not be trusted")
+	}
+}
+
+func TestParseTrustPolicy(t *testing.T) {
+	for s, want := range map[string]TrustPolicy{"": TrustOnFirstUse, "tofu": TrustOnFirstUse, "always": TrustAlways, "block": TrustBlockOnChange} {
+		if got, err := ParseTrustPolicy(s); err != nil || got != want {
+			t.Fatalf("ParseTrustPolicy(%q) = %q, %v", s, got, err)
+		}
+	}
+	if _, err := ParseTrustPolicy("never"); err == nil {
+		t.Fatal("unknown policy should fail")
+	}
+}
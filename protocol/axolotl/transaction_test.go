@@ -0,0 +1,256 @@
+package axolotl
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"ws-go/libsignal/keys/prekey"
+	"ws-go/libsignal/protocol"
+	"ws-go/libsignal/util/optional"
+	"ws-go/protocol/axolotl/store"
+)
+
+const (
+	aliceID = "8613800000001"
+	bobID   = "8613800000002"
+)
+
+var errCrash = errors.New("injected crash")
+
+// faultBackend fault 为方法名时这个方法返回错误 模拟写入一半时程序崩溃
+type faultBackend struct {
+	store.Backend
+	fault *string
+}
+
+func (f *faultBackend) fail(method string) error {
+	if *f.fault == method {
+		return errCrash
+	}
+	return nil
+}
+
+func (f *faultBackend) RemovePreKey(id uint32) error {
+	if err := f.fail("RemovePreKey"); err != nil {
+		return err
+	}
+	return f.Backend.RemovePreKey(id)
+}
+
+func (f *faultBackend) StoreSession(name string, record []byte) error {
+	if err := f.fail("StoreSession"); err != nil {
+		return err
+	}
+	return f.Backend.StoreSession(name, record)
+}
+
+func (f *faultBackend) StoreIdentity(name string, publicKey []byte) error {
+	if err := f.fail("StoreIdentity"); err != nil {
+		return err
+	}
+	return f.Backend.StoreIdentity(name, publicKey)
+}
+
+func (f *faultBackend) Transaction(fn func(tx store.Backend) error) error {
+	return f.Backend.Transaction(func(tx store.Backend) error {
+		return fn(&faultBackend{Backend: tx, fault: f.fault})
+	})
+}
+
+func newTestManager(t *testing.T, b store.Backend) *Manager {
+	t.Helper()
+	m, err := NewAxolotlManager(b, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// bundleOf 使用第一个没有上传的 prekey
+func bundleOf(t *testing.T, m *Manager) *prekey.Bundle {
+	t.Helper()
+	preKeys, err := m.LoadUnSendPreKey()
+	if err != nil || len(preKeys) == 0 {
+		t.Fatalf("LoadUnSendPreKey = %d, %v", len(preKeys), err)
+	}
+	signedPreKey := m.SignedPreKeyStore.LoadSignedPreKey(0)
+	return prekey.NewBundle(
+		m.IdentityStore.GetLocalRegistrationId(),
+		0,
+		optional.NewOptionalUint32(preKeys[0].ID().Value),
+		signedPreKey.ID(),
+		preKeys[0].KeyPair().PublicKey(),
+		signedPreKey.KeyPair().PublicKey(),
+		signedPreKey.Signature(),
+		m.IdentityStore.GetIdentityKeyPair().PublicKey(),
+	)
+}
+
+// exchange from 加密 to 解密
+func exchange(t *testing.T, from *Manager, fromID string, to *Manager, toID string, text string) {
+	t.Helper()
+	message, err := from.Encrypt(toID, []byte(text), false)
+	if err != nil {
+		t.Fatalf("encrypt %s: %v", text, err)
+	}
+	d, err := to.Decrypt(fromID, "", message.Serialize(), protocol.GetEncTypeString(message.Type()))
+	if err != nil {
+		t.Fatalf("decrypt %s: %v", text, err)
+	}
+	if string(d) != text {
+		t.Fatalf("decrypt = %q, want %q", d, text)
+	}
+}
+
+// crashBackends 每种后端 reopen 模拟崩溃后重新启动
+func crashBackends(t *testing.T) map[string]func() store.Backend {
+	memory := store.NewMemoryBackend()
+	path := filepath.Join(t.TempDir(), bobID, "axolotl")
+	return map[string]func() store.Backend{
+		"memory": func() store.Backend { return memory },
+		"sqlite": func() store.Backend {
+			b, err := store.OpenSQLiteBackend(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		},
+		"encrypted": func() store.Backend {
+			b, err := store.OpenSQLiteBackend(path + "-encrypted")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealer, err := ParseSealer(bytes.Repeat([]byte{1}, 32))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store.NewEncryptedBackend(b, sealer)
+		},
+	}
+}
+
+func TestManager_DecryptCrash(t *testing.T) {
+	for _, method := range []string{"RemovePreKey", "StoreSession", "StoreIdentity"} {
+		for name, open := range crashBackends(t) {
+			t.Run(method+"/"+name, func(t *testing.T) {
+				var fault string
+				alice := newTestManager(t, store.NewMemoryBackend())
+				bob := newTestManager(t, &faultBackend{Backend: open(), fault: &fault})
+				bundle := bundleOf(t, bob)
+				preKeyID := bundle.PreKeyID().Value
+				if err := alice.CreateSession(bobID, bundle); err != nil {
+					t.Fatal(err)
+				}
+				message, err := alice.Encrypt(bobID, []byte("hello"), false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if message.Type() != protocol.PREKEY_TYPE {
+					t.Fatalf("message type = %d, want pkmsg", message.Type())
+				}
+
+				// 解密过程中出错 全部回滚
+				fault = method
+				if _, err := bob.Decrypt(aliceID, "", message.Serialize(), "pkmsg"); !errors.Is(err, errCrash) {
+					t.Fatalf("decrypt err = %v, want crash", err)
+				}
+				fault = ""
+				_ = bob.Backend().Close()
+
+				// 重新启动后 prekey 还在 没有保存 session
+				bob = newTestManager(t, &faultBackend{Backend: open(), fault: &fault})
+				defer bob.Backend().Close()
+				if !bob.PreKeyStore.ContainsPreKey(preKeyID) {
+					t.Fatal("prekey removed after rollback")
+				}
+				if bob.ContainsSession(aliceID) {
+					t.Fatal("session stored after rollback")
+				}
+
+				// 重新解密同一条消息 之后的消息都可以正常收发
+				d, err := bob.Decrypt(aliceID, "", message.Serialize(), "pkmsg")
+				if err != nil || string(d) != "hello" {
+					t.Fatalf("retry decrypt = %q, %v", d, err)
+				}
+				if bob.PreKeyStore.ContainsPreKey(preKeyID) {
+					t.Fatal("prekey not removed after decrypt")
+				}
+				exchange(t, bob, bobID, alice, aliceID, "reply")
+				exchange(t, alice, aliceID, bob, bobID, "again")
+				exchange(t, bob, bobID, alice, aliceID, "bye")
+			})
+		}
+	}
+}
+
+func TestManager_EncryptCrash(t *testing.T) {
+	var fault string
+	alice := newTestManager(t, &faultBackend{Backend: store.NewMemoryBackend(), fault: &fault})
+	bob := newTestManager(t, store.NewMemoryBackend())
+	if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+	exchange(t, alice, aliceID, bob, bobID, "hello")
+	exchange(t, bob, bobID, alice, aliceID, "reply")
+
+	// 加密时保存 session 出错 session 不变
+	fault = "StoreSession"
+	if _, err := alice.Encrypt(bobID, []byte("lost"), false); !errors.Is(err, errCrash) {
+		t.Fatalf("encrypt err = %v, want crash", err)
+	}
+	fault = ""
+	exchange(t, alice, aliceID, bob, bobID, "again")
+	exchange(t, bob, bobID, alice, aliceID, "bye")
+}
+
+func TestManager_DecryptError(t *testing.T) {
+	alice := newTestManager(t, store.NewMemoryBackend())
+	bob := newTestManager(t, store.NewMemoryBackend())
+	if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+	exchange(t, alice, aliceID, bob, bobID, "hello")
+	message, err := alice.Encrypt(bobID, []byte("tampered"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 修改后的消息解密失败 不影响之后的消息
+	d := message.Serialize()
+	d[len(d)-1] ^= 0xff
+	if _, err := bob.Decrypt(aliceID, "", d, "msg"); err == nil {
+		t.Fatal("decrypt tampered message should fail")
+	}
+	exchange(t, alice, aliceID, bob, bobID, "again")
+	exchange(t, bob, bobID, alice, aliceID, "reply")
+}
+
+// bob 重新安装后请求重发 alice 使用新的 bundle 建立 session 再加密
+func TestManager_EncryptResend(t *testing.T) {
+	alice, bob := newTestManager(t, store.NewMemoryBackend()), newTestManager(t, store.NewMemoryBackend())
+	oldBundle := bundleOf(t, bob)
+	if err := alice.CreateSession(bobID, oldBundle); err != nil {
+		t.Fatal(err)
+	}
+	bob = newTestManager(t, store.NewMemoryBackend())
+	regId := bob.IdentityStore.GetLocalRegistrationId()
+	decrypt := func(message protocol.CiphertextMessage, text string) {
+		t.Helper()
+		d, err := bob.Decrypt(aliceID, "", message.Serialize(), protocol.GetEncTypeString(message.Type()))
+		if err != nil || string(d) != text {
+			t.Fatalf("decrypt = %q, %v want %q", d, err, text)
+		}
+	}
+
+	message, err := alice.EncryptResend(bobID, regId, bundleOf(t, bob), []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt(message, "hello")
+	// session 已经是请求的注册 id 时不使用过期的 bundle
+	message, err = alice.EncryptResend(bobID, regId, oldBundle, []byte("again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt(message, "again")
+}
@@ -0,0 +1,113 @@
+package axolotl
+
+import (
+	"testing"
+	"ws-go/libsignal/protocol"
+	"ws-go/protocol/axolotl/store"
+)
+
+// reinstall alice 重新安装后使用新的身份密钥给 bob 发送 pkmsg
+func reinstall(t *testing.T, bob *Manager) (*Manager, protocol.CiphertextMessage) {
+	t.Helper()
+	alice := newTestManager(t, store.NewMemoryBackend())
+	if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+	message, err := alice.Encrypt(bobID, []byte("new identity"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alice, message
+}
+
+func TestManager_TrustPolicy(t *testing.T) {
+	tests := []struct {
+		policy     store.TrustPolicy
+		wantDecode bool
+		wantEvents int
+	}{
+		{store.TrustOnFirstUse, true, 1},
+		{store.TrustAlways, true, 1},
+		{store.TrustBlockOnChange, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			var changes []*store.IdentityChange
+			bob := newTestManager(t, store.NewMemoryBackend())
+			bob.IdentityStore.SetTrustPolicy(tt.policy)
+			bob.IdentityStore.SetIdentityChangedHandler(func(change *store.IdentityChange) {
+				changes = append(changes, change)
+			})
+			alice := newTestManager(t, store.NewMemoryBackend())
+			if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+				t.Fatal(err)
+			}
+			exchange(t, alice, aliceID, bob, bobID, "hello")
+			exchange(t, bob, bobID, alice, aliceID, "reply")
+
+			alice, message := reinstall(t, bob)
+			d, err := bob.Decrypt(aliceID, "", message.Serialize(), "pkmsg")
+			if decoded := err == nil && string(d) == "new identity"; decoded != tt.wantDecode {
+				t.Fatalf("decrypt = %q, %v, want decoded %v", d, err, tt.wantDecode)
+			}
+			if len(changes) != tt.wantEvents {
+				t.Fatalf("changes = %d, want %d", len(changes), tt.wantEvents)
+			}
+			if !tt.wantDecode {
+				// 确认新的密钥后可以正常收发
+				if err := bob.ApproveIdentity(aliceID, changes[0].NewKey); err != nil {
+					t.Fatal(err)
+				}
+				if d, err := bob.Decrypt(aliceID, "", message.Serialize(), "pkmsg"); err != nil || string(d) != "new identity" {
+					t.Fatalf("decrypt after approve = %q, %v", d, err)
+				}
+			}
+			exchange(t, bob, bobID, alice, aliceID, "welcome back")
+			exchange(t, alice, aliceID, bob, bobID, "thanks")
+		})
+	}
+}
+
+func TestManager_RefreshIdentity(t *testing.T) {
+	for _, policy := range []store.TrustPolicy{store.TrustOnFirstUse, store.TrustBlockOnChange} {
+		t.Run(string(policy), func(t *testing.T) {
+			var changes []*store.IdentityChange
+			alice := newTestManager(t, store.NewMemoryBackend())
+			alice.IdentityStore.SetTrustPolicy(policy)
+			alice.IdentityStore.SetIdentityChangedHandler(func(change *store.IdentityChange) {
+				changes = append(changes, change)
+			})
+			bob := newTestManager(t, store.NewMemoryBackend())
+			if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+				t.Fatal(err)
+			}
+			exchange(t, alice, aliceID, bob, bobID, "hello")
+
+			// bob 重新安装 alice 收到 identity 通知后重新获取 prekeys
+			bob = newTestManager(t, store.NewMemoryBackend())
+			err := alice.CreateSession(bobID, bundleOf(t, bob))
+			if len(changes) != 1 || changes[0].Name != bobID {
+				t.Fatalf("changes = %v", changes)
+			}
+			if policy == store.TrustBlockOnChange {
+				if err == nil {
+					t.Fatal("changed identity should be blocked")
+				}
+				if err := alice.ApproveIdentity(bobID, changes[0].NewKey); err != nil {
+					t.Fatal(err)
+				}
+				err = alice.CreateSession(bobID, bundleOf(t, bob))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 旧的 session 被归档
+			sessionRecord := alice.SessionStore.LoadSession(protocol.NewSignalAddress(bobID, 0))
+			if n := len(sessionRecord.PreviousSessionStates()); n != 1 {
+				t.Fatalf("previous session states = %d, want 1", n)
+			}
+			exchange(t, alice, aliceID, bob, bobID, "again")
+			exchange(t, bob, bobID, alice, aliceID, "reply")
+		})
+	}
+}
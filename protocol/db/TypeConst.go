@@ -11,6 +11,10 @@ const (
 	Reads TypeEnum = 4000
 	//状态消息
 	Status TypeEnum = 3000
+	//联系人身份密钥变化
+	Identity TypeEnum = 5000
+	//消息解密失败已隔离
+	Undecryptable TypeEnum = 6000
 )
 
 func (p TypeEnum) Number() int {
@@ -23,6 +27,10 @@ func (p TypeEnum) Number() int {
 		return 3000
 	case Reads:
 		return 4000
+	case Identity:
+		return 5000
+	case Undecryptable:
+		return 6000
 	default:
 		return -1
 	}
@@ -0,0 +1,98 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gogf/gf/database/gdb"
+	"github.com/gogf/gf/os/gtime"
+)
+
+// 数据库的版本保存在 schema_version 表中 每个迁移一行
+const (
+	versionTable          = "schema_version"
+	sqlCreateVersionTable = "CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL PRIMARY KEY, description VARCHAR(255), applied_at BIGINT)"
+)
+
+// ErrTooNew 数据库的版本比程序支持的版本新
+var ErrTooNew = errors.New("database schema is newer than this binary")
+
+// Migration 一个版本的迁移 在事务中执行
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *gdb.TX) error
+}
+
+// Exec 依次执行 sql 的迁移
+func Exec(sqlList ...string) func(tx *gdb.TX) error {
+	return func(tx *gdb.TX) error {
+		for _, s := range sqlList {
+			if _, err := tx.Exec(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Latest 迁移列表的最新版本
+func Latest(migrations []Migration) int {
+	latest := 0
+	for _, m := range migrations {
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+	return latest
+}
+
+// Version 数据库当前的版本 没有迁移过时为 0
+func Version(db gdb.DB) (int, error) {
+	if _, err := db.Exec(sqlCreateVersionTable); err != nil {
+		return 0, err
+	}
+	v, err := db.GetValue("SELECT MAX(version) FROM " + versionTable)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int(), nil
+}
+
+// Run 执行数据库版本之后的迁移 返回迁移后的版本
+// migrations 的版本必须从 1 开始连续递增 每个迁移和版本记录在同一个事务中
+func Run(db gdb.DB, migrations []Migration) (int, error) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return 0, fmt.Errorf("migration %d: version must be %d", m.Version, i+1)
+		}
+	}
+	current, err := Version(db)
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	latest := Latest(migrations)
+	if current > latest {
+		return current, fmt.Errorf("%w: database version %d, supported %d", ErrTooNew, current, latest)
+	}
+	for _, m := range migrations[current:] {
+		m := m
+		err := db.Transaction(context.Background(), func(ctx context.Context, tx *gdb.TX) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			_, err := tx.Insert(versionTable, gdb.Map{
+				"version":     m.Version,
+				"description": m.Description,
+				"applied_at":  gtime.TimestampMilli(),
+			})
+			return err
+		})
+		if err != nil {
+			return current, fmt.Errorf("migration %d %s: %w", m.Version, m.Description, err)
+		}
+		current = m.Version
+	}
+	return current, nil
+}
@@ -0,0 +1,100 @@
+package migrate
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogf/gf/database/gdb"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) gdb.DB {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+var testMigrations = []Migration{
+	{Version: 1, Description: "create a", Up: Exec("CREATE TABLE a (id INTEGER)")},
+	{Version: 2, Description: "add a.name", Up: Exec("ALTER TABLE a ADD COLUMN name TEXT")},
+}
+
+func TestRun(t *testing.T) {
+	db := openTestDB(t)
+	v, err := Run(db, testMigrations[:1])
+	if err != nil || v != 1 {
+		t.Fatalf("Run = %d, %v, want 1", v, err)
+	}
+	// 再次打开时只执行新的迁移
+	v, err = Run(db, testMigrations)
+	if err != nil || v != 2 {
+		t.Fatalf("Run = %d, %v, want 2", v, err)
+	}
+	v, err = Run(db, testMigrations)
+	if err != nil || v != 2 {
+		t.Fatalf("Run again = %d, %v, want 2", v, err)
+	}
+	if _, err := db.Exec("INSERT INTO a (id, name) VALUES (1, 'a')"); err != nil {
+		t.Fatal(err)
+	}
+	count, err := db.GetCount("SELECT COUNT(*) FROM schema_version")
+	if err != nil || count != 2 {
+		t.Fatalf("schema_version rows = %d, %v, want 2", count, err)
+	}
+}
+
+func TestRun_Rollback(t *testing.T) {
+	db := openTestDB(t)
+	migrations := append(testMigrations[:1:1], Migration{
+		Version:     2,
+		Description: "broken",
+		Up:          Exec("CREATE TABLE b (id INTEGER)", "NOT SQL"),
+	})
+	v, err := Run(db, migrations)
+	if err == nil || v != 1 {
+		t.Fatalf("Run = %d, %v, want error at version 1", v, err)
+	}
+	// 失败的迁移没有留下表和版本
+	if _, err := db.Exec("SELECT * FROM b"); err == nil {
+		t.Fatal("table b exists after rollback")
+	}
+	if v, err := Version(db); err != nil || v != 1 {
+		t.Fatalf("Version = %d, %v, want 1", v, err)
+	}
+}
+
+func TestRun_TooNew(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Run(db, testMigrations); err != nil {
+		t.Fatal(err)
+	}
+	v, err := Run(db, testMigrations[:1])
+	if !errors.Is(err, ErrTooNew) {
+		t.Fatalf("Run err = %v, want ErrTooNew", err)
+	}
+	if v != 2 {
+		t.Fatalf("Run version = %d, want 2", v)
+	}
+}
+
+func TestRun_InvalidVersions(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Run(db, testMigrations[1:]); err == nil {
+		t.Fatal("migrations not starting at 1 should fail")
+	}
+}
+
+// 共用一个分组的两个文件互不影响
+func TestOpenSQLite_SeparateFiles(t *testing.T) {
+	a, b := openTestDB(t), openTestDB(t)
+	if v, err := Run(a, testMigrations); err != nil || v != 2 {
+		t.Fatalf("Run = %d, %v, want 2", v, err)
+	}
+	if v, err := Version(b); err != nil || v != 0 {
+		t.Fatalf("Version = %d, %v, want 0", v, err)
+	}
+}
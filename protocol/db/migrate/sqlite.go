@@ -0,0 +1,32 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/gogf/gf/database/gdb"
+)
+
+// sqliteGroup 所有 sqlite 文件共用一个配置分组 文件路径使用 schema 区分
+// gdb 查询时不加锁读取分组配置 运行中不能再 SetConfigGroup 否则和其他账号的查询冲突
+const sqliteGroup = "sqlite"
+
+func init() {
+	gdb.SetConfigGroup(sqliteGroup, gdb.ConfigGroup{{
+		Type:    "sqlite",
+		Charset: "utf8",
+	}})
+}
+
+// OpenSQLite 打开 sqlite 文件 目录不存在时创建
+func OpenSQLite(path string) (gdb.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	db, err := gdb.New(sqliteGroup)
+	if err != nil {
+		return nil, err
+	}
+	db.SetSchema(path)
+	return db, nil
+}
@@ -2,8 +2,43 @@ package define
 
 type MsgStatus int
 
+// 状态只会增大 Failed 除外
 const (
-	Sent MsgStatus = iota // 发送
-	Ack  MsgStatus = 1    // 确认送达
-	Read MsgStatus = 2    // 已读
+	Failed    MsgStatus = -1 // 发送失败
+	Sent      MsgStatus = 0  // 发送
+	Ack       MsgStatus = 1  // 服务器确认
+	Delivered MsgStatus = 2  // 已送达
+	Read      MsgStatus = 3  // 已读
+	Played    MsgStatus = 4  // 已播放
 )
+
+func (s MsgStatus) String() string {
+	switch s {
+	case Failed:
+		return "failed"
+	case Sent:
+		return "sent"
+	case Ack:
+		return "server_ack"
+	case Delivered:
+		return "delivered"
+	case Read:
+		return "read"
+	case Played:
+		return "played"
+	}
+	return "unknown"
+}
+
+// ReceiptStatus receipt 的 type 对应的状态 不影响消息状态的返回 false
+func ReceiptStatus(receiptType string) (MsgStatus, bool) {
+	switch receiptType {
+	case "", "inactive":
+		return Delivered, true
+	case "read":
+		return Read, true
+	case "played":
+		return Played, true
+	}
+	return Sent, false
+}
@@ -0,0 +1,20 @@
+package entity
+
+// IdentityChanged 联系人的身份密钥变化 Trusted 为 false 时新的密钥被拒绝 需要确认
+// Verified 为变化前是否验证过安全码 变化后验证被清除
+type IdentityChanged struct {
+	Jid      string `json:"jid"`
+	OldKey   string `json:"oldKey"`
+	NewKey   string `json:"newKey"`
+	Policy   string `json:"policy"`
+	Verified bool   `json:"verified"`
+	Trusted  bool   `json:"trusted"`
+}
+
+// SafetyNumber 和联系人的安全码 Scan 为二维码数据的 base64
+type SafetyNumber struct {
+	Jid          string `json:"jid"`
+	SafetyNumber string `json:"safetyNumber"`
+	Scan         string `json:"scan"`
+	Verified     bool   `json:"verified"`
+}
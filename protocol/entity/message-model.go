@@ -1,16 +1,47 @@
 package entity
 
 import (
-	"errors"
-	"fmt"
 	"io"
 	"ws-go/protocol/waproto"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// MessageKind 规范化后的消息类型 保存在消息库中 不能修改已有的值
+type MessageKind string
+
+const (
+	MessageKindText          MessageKind = "text"
+	MessageKindImage         MessageKind = "image"
+	MessageKindVideo         MessageKind = "video"
+	MessageKindAudio         MessageKind = "audio"
+	MessageKindDocument      MessageKind = "document"
+	MessageKindSticker       MessageKind = "sticker"
+	MessageKindLocation      MessageKind = "location"
+	MessageKindLiveLocation  MessageKind = "liveLocation"
+	MessageKindContact       MessageKind = "contact"
+	MessageKindContactsArray MessageKind = "contactsArray"
+	MessageKindReaction      MessageKind = "reaction"
+	MessageKindRevoke        MessageKind = "revoke"
+	MessageKindProtocol      MessageKind = "protocol"
+	MessageKindUnknown       MessageKind = "unknown"
 )
 
-func ParseProtoMessage(msg *waproto.Message) interface{} {
+// TypedMessage 规范化后的消息 具体类型为下面的 XxxMessage
+type TypedMessage interface {
+	Kind() MessageKind
+}
+
+// ParseProtoMessage 把 waproto.Message 转换为对应的类型 不支持的类型返回 UnknownMessage 保留原始的 protobuf
+func ParseProtoMessage(msg *waproto.Message) TypedMessage {
+	msg = UnwrapMessage(msg)
 	switch {
+	case msg.GetProtocolMessage() != nil:
+		return getProtocolMessage(msg)
+
 	case msg.GetAudioMessage() != nil:
 		return getAudioMessage(msg)
+
 	case msg.GetImageMessage() != nil:
 		return getImageMessage(msg)
 
@@ -37,21 +68,33 @@ func ParseProtoMessage(msg *waproto.Message) interface{} {
 
 	case msg.GetContactMessage() != nil:
 		return getContactMessage(msg)
-	default:
-		//cannot match message
-		fmt.Println(msg)
-		return errors.New("message type not implemented")
+
+	case msg.GetContactsArrayMessage() != nil:
+		return getContactsArrayMessage(msg)
+	}
+	if reaction := getReactionMessage(msg); reaction != nil {
+		return *reaction
+	}
+	return getUnknownMessage(msg)
+}
+
+// IsSenderKeyDistributionOnly 只有群聊的 sender key 没有内容 发送者在群里的第一条消息会单独带一个
+func IsSenderKeyDistributionOnly(msg *waproto.Message) bool {
+	if msg.GetSenderKeyDistributionMessage() == nil && msg.GetFastRatchetKeySenderKeyDistributionMessage() == nil {
+		return false
 	}
+	content := *msg
+	content.SenderKeyDistributionMessage = nil
+	content.FastRatchetKeySenderKeyDistributionMessage = nil
+	return content.Size() == 0
 }
 
 func getContactMessage(msg *waproto.Message) ContactMessage {
 	contact := msg.GetContactMessage()
 	contactMessage := ContactMessage{
-		//Info: getMessageInfo(msg),
 		DisplayName: contact.GetDisplayName(),
 		Vcard:       contact.GetVcard(),
-
-		//ContextInfo: getMessageContext(contact.GetContextInfo()),
+		ContextInfo: getMessageContext(contact.GetContextInfo()),
 	}
 
 	return contactMessage
@@ -69,17 +112,54 @@ type ContactMessage struct {
 	ContextInfo ContextInfo
 }
 
+// Kind
+func (ContactMessage) Kind() MessageKind {
+	return MessageKindContact
+}
+
+func getContactsArrayMessage(msg *waproto.Message) ContactsArrayMessage {
+	contacts := msg.GetContactsArrayMessage()
+	contactsArrayMessage := ContactsArrayMessage{
+		DisplayName: contacts.GetDisplayName(),
+		ContextInfo: getMessageContext(contacts.GetContextInfo()),
+	}
+	for _, contact := range contacts.GetContacts() {
+		contactsArrayMessage.Contacts = append(contactsArrayMessage.Contacts, ContactMessage{
+			DisplayName: contact.GetDisplayName(),
+			Vcard:       contact.GetVcard(),
+		})
+	}
+
+	return contactsArrayMessage
+}
+
+/*
+ContactsArrayMessage 一次发送的多个联系人
+*/
+type ContactsArrayMessage struct {
+	Info        MessageInfo
+	DisplayName string
+	Contacts    []ContactMessage
+	ContextInfo ContextInfo
+}
+
+// Kind
+func (ContactsArrayMessage) Kind() MessageKind {
+	return MessageKindContactsArray
+}
+
 func getStickerMessage(msg *waproto.Message) StickerMessage {
 	sticker := msg.GetStickerMessage()
 	stickerMessage := StickerMessage{
-		//Info:          getMessageInfo(msg),
-		url:           sticker.GetUrl(),
-		mediaKey:      sticker.GetMediaKey(),
 		Type:          sticker.GetMimetype(),
-		fileEncSha256: sticker.GetFileEncSha256(),
-		fileSha256:    sticker.GetFileSha256(),
-		fileLength:    sticker.GetFileLength(),
-		//ContextInfo:   getMessageContext(sticker.GetContextInfo()),
+		IsAnimated:    sticker.GetIsAnimated(),
+		Url:           sticker.GetUrl(),
+		DirectPath:    sticker.GetDirectPath(),
+		MediaKey:      sticker.GetMediaKey(),
+		FileEncSha256: sticker.GetFileEncSha256(),
+		FileSha256:    sticker.GetFileSha256(),
+		FileLength:    sticker.GetFileLength(),
+		ContextInfo:   getMessageContext(sticker.GetContextInfo()),
 	}
 
 	return stickerMessage
@@ -92,19 +172,25 @@ type StickerMessage struct {
 	Info          MessageInfo
 	Type          string
 	Content       io.Reader
-	url           string
-	mediaKey      []byte
-	fileEncSha256 []byte
-	fileSha256    []byte
-	fileLength    uint64
+	IsAnimated    bool
+	Url           string
+	DirectPath    string
+	MediaKey      []byte
+	FileEncSha256 []byte
+	FileSha256    []byte
+	FileLength    uint64
 
 	ContextInfo ContextInfo
 }
 
+// Kind
+func (StickerMessage) Kind() MessageKind {
+	return MessageKindSticker
+}
+
 func GetLiveLocationMessage(msg *waproto.Message) LiveLocationMessage {
 	loc := msg.GetLiveLocationMessage()
 	liveLocationMessage := LiveLocationMessage{
-		//Info:                              getMessageInfo(msg),
 		DegreesLatitude:                   loc.GetDegreesLatitude(),
 		DegreesLongitude:                  loc.GetDegreesLongitude(),
 		AccuracyInMeters:                  loc.GetAccuracyInMeters(),
@@ -113,7 +199,7 @@ func GetLiveLocationMessage(msg *waproto.Message) LiveLocationMessage {
 		Caption:                           loc.GetCaption(),
 		SequenceNumber:                    loc.GetSequenceNumber(),
 		JpegThumbnail:                     loc.GetJpegThumbnail(),
-		//ContextInfo:                       getMessageContext(loc.GetContextInfo()),
+		ContextInfo:                       getMessageContext(loc.GetContextInfo()),
 	}
 
 	return liveLocationMessage
@@ -135,17 +221,21 @@ type LiveLocationMessage struct {
 	ContextInfo                       ContextInfo
 }
 
+// Kind
+func (LiveLocationMessage) Kind() MessageKind {
+	return MessageKindLiveLocation
+}
+
 func GetLocationMessage(msg *waproto.Message) LocationMessage {
 	loc := msg.GetLocationMessage()
 	locationMessage := LocationMessage{
-		//Info:             getMessageInfo(msg),
 		DegreesLatitude:  loc.GetDegreesLatitude(),
 		DegreesLongitude: loc.GetDegreesLongitude(),
 		Name:             loc.GetName(),
 		Address:          loc.GetAddress(),
 		Url:              loc.GetUrl(),
 		JpegThumbnail:    loc.GetJpegThumbnail(),
-		//ContextInfo:      getMessageContext(loc.GetContextInfo()),
+		ContextInfo:      getMessageContext(loc.GetContextInfo()),
 	}
 
 	return locationMessage
@@ -165,39 +255,61 @@ type LocationMessage struct {
 	ContextInfo      ContextInfo
 }
 
+// Kind
+func (LocationMessage) Kind() MessageKind {
+	return MessageKindLocation
+}
+
 func getTextMessage(msg *waproto.Message) TextMessage {
 	text := TextMessage{Text: msg.GetConversation()}
 	if msg.GetConversation() == "" {
-		text = TextMessage{Text: msg.ExtendedTextMessage.GetText()}
+		ext := msg.GetExtendedTextMessage()
+		text = TextMessage{
+			Text:         ext.GetText(),
+			MatchedText:  ext.GetMatchedText(),
+			CanonicalUrl: ext.GetCanonicalUrl(),
+			Title:        ext.GetTitle(),
+			Description:  ext.GetDescription(),
+			ContextInfo:  getMessageContext(ext.GetContextInfo()),
+		}
 	}
 	return text
 }
 
 /*
-TextMessage represents a text message.
+TextMessage represents a text message. 链接预览时 MatchedText 为消息中的链接
 */
 type TextMessage struct {
-	Info        MessageInfo
-	Text        string
-	ContextInfo ContextInfo
+	Info         MessageInfo
+	Text         string
+	MatchedText  string
+	CanonicalUrl string
+	Title        string
+	Description  string
+	ContextInfo  ContextInfo
+}
+
+// Kind
+func (TextMessage) Kind() MessageKind {
+	return MessageKindText
 }
 
 func getDocumentMessage(msg *waproto.Message) DocumentMessage {
 	doc := msg.GetDocumentMessage()
 
 	documentMessage := DocumentMessage{
-		//Info:          getMessageInfo(msg),
 		Title:         doc.GetTitle(),
 		PageCount:     doc.GetPageCount(),
 		Type:          doc.GetMimetype(),
 		FileName:      doc.GetFileName(),
 		Thumbnail:     doc.GetJpegThumbnail(),
 		Url:           doc.GetUrl(),
+		DirectPath:    doc.GetDirectPath(),
 		MediaKey:      doc.GetMediaKey(),
 		FileEncSha256: doc.GetFileEncSha256(),
 		FileSha256:    doc.GetFileSha256(),
 		FileLength:    doc.GetFileLength(),
-		//ContextInfo:   getMessageContext(doc.GetContextInfo()),
+		ContextInfo:   getMessageContext(doc.GetContextInfo()),
 	}
 
 	return documentMessage
@@ -217,6 +329,7 @@ type DocumentMessage struct {
 	Thumbnail     []byte
 	Content       io.Reader
 	Url           string
+	DirectPath    string
 	MediaKey      []byte
 	FileEncSha256 []byte
 	FileSha256    []byte
@@ -224,22 +337,27 @@ type DocumentMessage struct {
 	ContextInfo   ContextInfo
 }
 
+// Kind
+func (DocumentMessage) Kind() MessageKind {
+	return MessageKindDocument
+}
+
 func getVideoMessage(msg *waproto.Message) VideoMessage {
 	vid := msg.GetVideoMessage()
 
 	videoMessage := VideoMessage{
-		//Info:          getMessageInfo(msg),
 		Caption:       vid.GetCaption(),
 		Thumbnail:     vid.GetJpegThumbnail(),
 		GifPlayback:   vid.GetGifPlayback(),
 		Url:           vid.GetUrl(),
+		DirectPath:    vid.GetDirectPath(),
 		MediaKey:      vid.GetMediaKey(),
 		Length:        vid.GetSeconds(),
 		Type:          vid.GetMimetype(),
 		FileEncSha256: vid.GetFileEncSha256(),
 		FileSha256:    vid.GetFileSha256(),
 		FileLength:    vid.GetFileLength(),
-		//ContextInfo:   getMessageContext(vid.GetContextInfo()),
+		ContextInfo:   getMessageContext(vid.GetContextInfo()),
 	}
 
 	return videoMessage
@@ -258,6 +376,7 @@ type VideoMessage struct {
 	Content       io.Reader
 	GifPlayback   bool
 	Url           string
+	DirectPath    string
 	MediaKey      []byte
 	FileEncSha256 []byte
 	FileSha256    []byte
@@ -265,19 +384,24 @@ type VideoMessage struct {
 	ContextInfo   ContextInfo
 }
 
+// Kind
+func (VideoMessage) Kind() MessageKind {
+	return MessageKindVideo
+}
+
 func getImageMessage(msg *waproto.Message) ImageMessage {
 	image := msg.GetImageMessage()
 	imageMessage := ImageMessage{
-		//Info:          getMessageInfo(msg),
 		Caption:       image.GetCaption(),
 		Thumbnail:     image.GetJpegThumbnail(),
 		Url:           image.GetUrl(),
+		DirectPath:    image.GetDirectPath(),
 		MediaKey:      image.GetMediaKey(),
 		Type:          image.GetMimetype(),
 		FileEncSha256: image.GetFileEncSha256(),
 		FileSha256:    image.GetFileSha256(),
 		FileLength:    image.GetFileLength(),
-		//ContextInfo:   getMessageContext(image.GetContextInfo()),
+		ContextInfo:   getMessageContext(image.GetContextInfo()),
 	}
 	return imageMessage
 }
@@ -293,6 +417,7 @@ type ImageMessage struct {
 	Type          string
 	Content       io.Reader
 	Url           string
+	DirectPath    string
 	MediaKey      []byte
 	FileEncSha256 []byte
 	FileSha256    []byte
@@ -300,32 +425,63 @@ type ImageMessage struct {
 	ContextInfo   ContextInfo
 }
 
+// Kind
+func (ImageMessage) Kind() MessageKind {
+	return MessageKindImage
+}
+
 func getAudioMessage(msg *waproto.Message) AudioMessage {
 	aud := msg.GetAudioMessage()
 	audioMessage := AudioMessage{
-		//Info:          getMessageInfo(msg),
 		Url:           aud.GetUrl(),
+		DirectPath:    aud.GetDirectPath(),
 		MediaKey:      aud.GetMediaKey(),
 		Length:        aud.GetSeconds(),
 		Type:          aud.GetMimetype(),
+		Ptt:           aud.GetPtt(),
 		FileEncSha256: aud.GetFileEncSha256(),
 		FileSha256:    aud.GetFileSha256(),
 		FileLength:    aud.GetFileLength(),
-		//ContextInfo:   getMessageContext(aud.GetContextInfo()),
+		ContextInfo:   getMessageContext(aud.GetContextInfo()),
 	}
 
 	return audioMessage
 }
 
+// getMessageContext 引用 转发和 @ 的信息 引用的消息同样转换为对应的类型
+func getMessageContext(ctx *waproto.ContextInfo) ContextInfo {
+	if ctx == nil {
+		return ContextInfo{}
+	}
+	context := ContextInfo{
+		QuotedMessageID: ctx.GetStanzaId(),
+		QuotedMessage:   ctx.GetQuotedMessage(),
+		Participant:     ctx.GetParticipant(),
+		RemoteJid:       ctx.GetRemoteJid(),
+		MentionedJid:    ctx.GetMentionedJid(),
+		IsForwarded:     ctx.GetIsForwarded(),
+		ForwardingScore: ctx.GetForwardingScore(),
+		Expiration:      ctx.GetExpiration(),
+	}
+	if ctx.GetQuotedMessage() != nil {
+		context.Quoted = ParseProtoMessage(ctx.GetQuotedMessage())
+	}
+	return context
+}
+
 /*
-ContextInfo表示每条消息的ContextInfo
+ContextInfo表示每条消息的ContextInfo Quoted 为引用的消息 QuotedMessage 为引用的原始消息
 */
 type ContextInfo struct {
-	QuotedMessageID string //StanzaId
-	QuotedMessage   *waproto.Message
+	QuotedMessageID string           //StanzaId
+	QuotedMessage   *waproto.Message `json:"-"`
+	Quoted          TypedMessage
 	Participant     string
+	RemoteJid       string
+	MentionedJid    []string
 	IsForwarded     bool
 	ForwardingScore uint32
+	Expiration      uint32
 }
 
 type MessageStatus int
@@ -356,7 +512,7 @@ type MessageInfo struct {
 
 /*
 AudioMessage表示音频消息。媒体启动/下载和媒体验证需要未报告的字段。
-提供io读卡器作为消息发送的内容
+提供io读卡器作为消息发送的内容 Ptt 为语音消息
 */
 type AudioMessage struct {
 	Info          MessageInfo
@@ -365,9 +521,109 @@ type AudioMessage struct {
 	Content       io.Reader
 	Ptt           bool
 	Url           string
+	DirectPath    string
 	MediaKey      []byte
 	FileEncSha256 []byte
 	FileSha256    []byte
 	FileLength    uint64
 	ContextInfo   ContextInfo
 }
+
+// Kind
+func (AudioMessage) Kind() MessageKind {
+	return MessageKindAudio
+}
+
+// MessageKey 消息的 key 撤回和表情回应时指向原消息
+type MessageKey struct {
+	RemoteJid   string
+	FromMe      bool
+	Id          string
+	Participant string
+}
+
+func getMessageKey(key *waproto.MessageKey) MessageKey {
+	return MessageKey{
+		RemoteJid:   key.GetRemoteJid(),
+		FromMe:      key.GetFromMe(),
+		Id:          key.GetId(),
+		Participant: key.GetParticipant(),
+	}
+}
+
+func getProtocolMessage(msg *waproto.Message) TypedMessage {
+	protocol := msg.GetProtocolMessage()
+	if protocol.GetType() == waproto.ProtocolMessage_REVOKE {
+		return RevokeMessage{Key: getMessageKey(protocol.GetKey())}
+	}
+	return ProtocolMessage{
+		Type:                protocol.GetType().String(),
+		Key:                 getMessageKey(protocol.GetKey()),
+		EphemeralExpiration: protocol.GetEphemeralExpiration(),
+	}
+}
+
+/*
+RevokeMessage 撤回 Key 为被撤回的消息
+*/
+type RevokeMessage struct {
+	Info MessageInfo
+	Key  MessageKey
+}
+
+// Kind
+func (RevokeMessage) Kind() MessageKind {
+	return MessageKindRevoke
+}
+
+/*
+ProtocolMessage 撤回以外的协议消息 例如阅后即焚的设置 Type 为 ProtocolMessage_PROTOCOL_MESSAGE_TYPE 的名称
+*/
+type ProtocolMessage struct {
+	Info                MessageInfo
+	Type                string
+	Key                 MessageKey
+	EphemeralExpiration uint32
+}
+
+// Kind
+func (ProtocolMessage) Kind() MessageKind {
+	return MessageKindProtocol
+}
+
+/*
+ReactionMessage 表情回应 Text 为空时表示取消回应 Key 为回应的消息
+*/
+type ReactionMessage struct {
+	Info              MessageInfo
+	Key               MessageKey
+	Text              string
+	SenderTimestampMs int64
+}
+
+// Kind
+func (ReactionMessage) Kind() MessageKind {
+	return MessageKindReaction
+}
+
+func getUnknownMessage(msg *waproto.Message) UnknownMessage {
+	unknown := UnknownMessage{Message: msg}
+	if msg != nil {
+		unknown.Raw, _ = proto.Marshal(msg)
+	}
+	return unknown
+}
+
+/*
+UnknownMessage 还不支持的类型 Raw 为原始的 protobuf
+*/
+type UnknownMessage struct {
+	Info    MessageInfo
+	Raw     []byte
+	Message *waproto.Message `json:"-"`
+}
+
+// Kind
+func (UnknownMessage) Kind() MessageKind {
+	return MessageKindUnknown
+}
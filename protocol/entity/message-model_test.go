@@ -0,0 +1,120 @@
+package entity
+
+import (
+	"testing"
+	"ws-go/protocol/waproto"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// unmarshal 和收到的消息一样解析 新的字段保存在 XXX_unrecognized 中
+func unmarshal(t *testing.T, b []byte) *waproto.Message {
+	msg := &waproto.Message{}
+	if err := proto.Unmarshal(b, msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func marshal(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func TestParseProtoMessage_Media(t *testing.T) {
+	msg := &waproto.Message{ImageMessage: &waproto.ImageMessage{
+		Mimetype:   proto.String("image/jpeg"),
+		Caption:    proto.String("look"),
+		DirectPath: proto.String("/v/t62.7118-24/1.enc"),
+		MediaKey:   []byte{1, 2, 3},
+		FileLength: proto.Uint64(2048),
+		ContextInfo: &waproto.ContextInfo{
+			StanzaId:      proto.String("3EB0Q"),
+			Participant:   proto.String("8613800000001@s.whatsapp.net"),
+			QuotedMessage: &waproto.Message{Conversation: proto.String("quoted")},
+			MentionedJid:  []string{"8613800000002@s.whatsapp.net"},
+		},
+	}}
+	image, ok := ParseProtoMessage(msg).(ImageMessage)
+	if !ok || image.Kind() != MessageKindImage {
+		t.Fatalf("ParseProtoMessage = %#v", ParseProtoMessage(msg))
+	}
+	if image.Caption != "look" || image.DirectPath != "/v/t62.7118-24/1.enc" || image.FileLength != 2048 {
+		t.Fatalf("image = %+v", image)
+	}
+	ctx := image.ContextInfo
+	if ctx.QuotedMessageID != "3EB0Q" || len(ctx.MentionedJid) != 1 {
+		t.Fatalf("context = %+v", ctx)
+	}
+	if quoted, ok := ctx.Quoted.(TextMessage); !ok || quoted.Text != "quoted" {
+		t.Fatalf("quoted = %#v", ctx.Quoted)
+	}
+}
+
+func TestParseProtoMessage_Protocol(t *testing.T) {
+	key := &waproto.MessageKey{RemoteJid: proto.String("8613800000001@s.whatsapp.net"), FromMe: proto.Bool(false), Id: proto.String("3EB0R")}
+	revoke := &waproto.Message{ProtocolMessage: &waproto.ProtocolMessage{Key: key, Type: waproto.ProtocolMessage_REVOKE.Enum()}}
+	if r, ok := ParseProtoMessage(revoke).(RevokeMessage); !ok || r.Key.Id != "3EB0R" {
+		t.Fatalf("revoke = %#v", ParseProtoMessage(revoke))
+	}
+	setting := &waproto.Message{ProtocolMessage: &waproto.ProtocolMessage{
+		Type:                waproto.ProtocolMessage_EPHEMERAL_SETTING.Enum(),
+		EphemeralExpiration: proto.Uint32(604800),
+	}}
+	if p, ok := ParseProtoMessage(setting).(ProtocolMessage); !ok || p.Type != "EPHEMERAL_SETTING" || p.EphemeralExpiration != 604800 {
+		t.Fatalf("protocol = %#v", ParseProtoMessage(setting))
+	}
+}
+
+func TestParseProtoMessage_Unrecognized(t *testing.T) {
+	key := marshal(t, &waproto.MessageKey{RemoteJid: proto.String("8613800000001@s.whatsapp.net"), Id: proto.String("3EB0R")})
+	var reaction []byte
+	reaction = appendBytesField(reaction, fieldReactionKey, key)
+	reaction = appendBytesField(reaction, fieldReactionText, []byte("👍"))
+	reaction = protowire.AppendTag(reaction, fieldReactionSenderTimestampMs, protowire.VarintType)
+	reaction = protowire.AppendVarint(reaction, 1624957782000)
+	msg := unmarshal(t, appendBytesField(nil, fieldReactionMessage, reaction))
+	r, ok := ParseProtoMessage(msg).(ReactionMessage)
+	if !ok || r.Key.Id != "3EB0R" || r.Text != "👍" || r.SenderTimestampMs != 1624957782000 {
+		t.Fatalf("reaction = %#v", ParseProtoMessage(msg))
+	}
+
+	// 阅后即焚的外层
+	inner := marshal(t, &waproto.Message{Conversation: proto.String("secret")})
+	msg = unmarshal(t, appendBytesField(nil, fieldEphemeralMessage, appendBytesField(nil, fieldFutureProofMessage, inner)))
+	if text, ok := ParseProtoMessage(msg).(TextMessage); !ok || text.Text != "secret" {
+		t.Fatalf("ephemeral = %#v", ParseProtoMessage(msg))
+	}
+
+	// 不支持的类型保留原始数据
+	msg = unmarshal(t, appendBytesField(nil, 99, []byte("future")))
+	unknown, ok := ParseProtoMessage(msg).(UnknownMessage)
+	if !ok || len(unknown.Raw) == 0 {
+		t.Fatalf("unknown = %#v", ParseProtoMessage(msg))
+	}
+	if v, ok := bytesField(unknown.Raw, 99); !ok || string(v) != "future" {
+		t.Fatalf("raw = %x", unknown.Raw)
+	}
+}
+
+func TestIsSenderKeyDistributionOnly(t *testing.T) {
+	skdm := &waproto.SenderKeyDistributionMessage{GroupId: proto.String("8613800000000-1624957782@g.us")}
+	if !IsSenderKeyDistributionOnly(&waproto.Message{SenderKeyDistributionMessage: skdm}) {
+		t.Fatal("sender key only not detected")
+	}
+	if IsSenderKeyDistributionOnly(&waproto.Message{SenderKeyDistributionMessage: skdm, Conversation: proto.String("hi")}) {
+		t.Fatal("message with content detected as sender key only")
+	}
+	if IsSenderKeyDistributionOnly(nil) {
+		t.Fatal("nil message detected as sender key only")
+	}
+}
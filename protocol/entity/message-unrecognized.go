@@ -0,0 +1,116 @@
+package entity
+
+import (
+	"ws-go/protocol/waproto"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 当前的 def.proto 中还没有的字段 解析时在 XXX_unrecognized 中
+const (
+	fieldViewOnceMessage  protowire.Number = 37
+	fieldEphemeralMessage protowire.Number = 40
+	fieldReactionMessage  protowire.Number = 46
+	// FutureProofMessage.message
+	fieldFutureProofMessage protowire.Number = 1
+	// ReactionMessage
+	fieldReactionKey               protowire.Number = 1
+	fieldReactionText              protowire.Number = 2
+	fieldReactionSenderTimestampMs protowire.Number = 4
+	// 最多去掉的外层
+	maxUnwrapDepth = 3
+)
+
+// UnwrapMessage 去掉阅后即焚和一次性查看的外层 没有外层时返回 msg
+func UnwrapMessage(msg *waproto.Message) *waproto.Message {
+	for i := 0; i < maxUnwrapDepth; i++ {
+		wrapper, ok := bytesField(unrecognized(msg), fieldEphemeralMessage)
+		if !ok {
+			wrapper, ok = bytesField(unrecognized(msg), fieldViewOnceMessage)
+		}
+		if !ok {
+			return msg
+		}
+		data, ok := bytesField(wrapper, fieldFutureProofMessage)
+		if !ok {
+			return msg
+		}
+		inner := &waproto.Message{}
+		if err := proto.Unmarshal(data, inner); err != nil {
+			return msg
+		}
+		msg = inner
+	}
+	return msg
+}
+
+// getReactionMessage 没有表情回应时返回 nil
+func getReactionMessage(msg *waproto.Message) *ReactionMessage {
+	data, ok := bytesField(unrecognized(msg), fieldReactionMessage)
+	if !ok {
+		return nil
+	}
+	reaction := &ReactionMessage{}
+	if key, ok := bytesField(data, fieldReactionKey); ok {
+		k := &waproto.MessageKey{}
+		if err := proto.Unmarshal(key, k); err != nil {
+			return nil
+		}
+		reaction.Key = getMessageKey(k)
+	}
+	if text, ok := bytesField(data, fieldReactionText); ok {
+		reaction.Text = string(text)
+	}
+	if ts, ok := varintField(data, fieldReactionSenderTimestampMs); ok {
+		reaction.SenderTimestampMs = int64(ts)
+	}
+	return reaction
+}
+
+func unrecognized(msg *waproto.Message) []byte {
+	if msg == nil {
+		return nil
+	}
+	return msg.XXX_unrecognized
+}
+
+// bytesField 第一个编号为 num 的 bytes 字段
+func bytesField(b []byte, num protowire.Number) ([]byte, bool) {
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return nil, false
+		}
+		b = b[l:]
+		if n == num && typ == protowire.BytesType {
+			v, l := protowire.ConsumeBytes(b)
+			return v, l >= 0
+		}
+		if l = protowire.ConsumeFieldValue(n, typ, b); l < 0 {
+			return nil, false
+		}
+		b = b[l:]
+	}
+	return nil, false
+}
+
+// varintField 第一个编号为 num 的 varint 字段
+func varintField(b []byte, num protowire.Number) (uint64, bool) {
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return 0, false
+		}
+		b = b[l:]
+		if n == num && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(b)
+			return v, l >= 0
+		}
+		if l = protowire.ConsumeFieldValue(n, typ, b); l < 0 {
+			return 0, false
+		}
+		b = b[l:]
+	}
+	return 0, false
+}
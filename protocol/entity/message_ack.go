@@ -0,0 +1,18 @@
+package entity
+
+// MessageAck 服务器收到发送的消息后返回的 ack Error 不为空时发送失败
+type MessageAck struct {
+	MsgId string
+	From  string
+	Class string
+	Error string
+}
+
+func NewMessageAck(msgId, from, class, err string) *MessageAck {
+	return &MessageAck{
+		MsgId: msgId,
+		From:  from,
+		Class: class,
+		Error: err,
+	}
+}
@@ -5,6 +5,17 @@ type Receipt struct {
 	MsgId       string
 	ReceiptType string
 	Participant string
+	// ListIds 一个回执确认多条消息时 list 中其他消息的 id
+	ListIds []string
+	// Retry type 为 retry 时对方解密失败的信息
+	Retry *ReceiptRetry
+}
+
+// ReceiptRetry <retry count id t v/> 和对方的 <registration>
+type ReceiptRetry struct {
+	Count          int
+	T              string
+	RegistrationId uint32
 }
 
 func NewReceipt(recipientId, msgId, eType, p string) *Receipt {
@@ -15,3 +26,8 @@ func NewReceipt(recipientId, msgId, eType, p string) *Receipt {
 		Participant: p,
 	}
 }
+
+// MsgIds 回执确认的所有消息
+func (r *Receipt) MsgIds() []string {
+	return append([]string{r.MsgId}, r.ListIds...)
+}
@@ -17,8 +17,10 @@ func (d *decMsgContent) GetContent() *waproto.WAMessage {
 	return d.Msg
 }
 
+// RespMessage Message 为 ParseProtoMessage 的结果 Kind 为消息类型
 type RespMessage struct {
-	Message     interface{}
+	Kind        MessageKind
+	Message     TypedMessage
 	Participant string
 	From        string
 	ContextType string
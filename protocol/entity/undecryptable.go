@@ -0,0 +1,13 @@
+package entity
+
+// UndecryptableMessage 重试后仍然解密失败 消息已经隔离 收到对方新的 session 或 sender key 时重新解密
+// Sender 为需要密钥的一方 群消息时是 participant
+type UndecryptableMessage struct {
+	Id          string `json:"id"`
+	Chat        string `json:"chat"`
+	Participant string `json:"participant,omitempty"`
+	Sender      string `json:"sender"`
+	ErrorClass  string `json:"errorClass"`
+	Error       string `json:"error"`
+	T           string `json:"t"`
+}
@@ -0,0 +1,203 @@
+package event
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuffer 订阅的默认缓冲
+const DefaultBuffer = 256
+
+// Envelope 发布的事件 Seq 在一个账号内递增
+type Envelope struct {
+	Seq     uint64 `json:"seq"`
+	Account string `json:"account"`
+	Type    Type   `json:"type"`
+	Time    int64  `json:"time"`
+	Event   Event  `json:"event"`
+}
+
+// Bus 每个账号一个 Publish 不会阻塞 订阅者处理不过来时丢弃事件并计数
+// 最近的 DefaultHistory 个事件保存在内存中 SubscribeSince 可以从断开的位置继续
+type Bus struct {
+	account string
+	seq     uint64
+	mutex   sync.Mutex
+	subs    map[*Subscription]struct{}
+	history *history
+	closed  bool
+}
+
+// NewBus
+func NewBus(account string) *Bus {
+	return &Bus{account: account, subs: make(map[*Subscription]struct{}), history: newHistory(DefaultHistory)}
+}
+
+// SetHistory 修改保存的事件数 已经保存的事件丢弃
+func (b *Bus) SetHistory(size int) {
+	if size <= 0 {
+		size = DefaultHistory
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.history = newHistory(size)
+}
+
+// Account
+func (b *Bus) Account() string {
+	return b.account
+}
+
+// Publish 发给所有订阅了该类型的订阅者 b 为 nil 或者已经关闭时忽略
+func (b *Bus) Publish(e Event) *Envelope {
+	if b == nil || e == nil {
+		return nil
+	}
+	// 写锁保证保存和发送的顺序和 Seq 一致
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.seq++
+	env := &Envelope{
+		Seq:     b.seq,
+		Account: b.account,
+		Type:    e.Type(),
+		Time:    time.Now().UnixNano() / int64(time.Millisecond),
+		Event:   e,
+	}
+	b.history.add(env)
+	for s := range b.subs {
+		s.deliver(env)
+	}
+	return env
+}
+
+// Subscribe types 为空时订阅所有类型 buffer 小于等于 0 时使用 DefaultBuffer
+func (b *Bus) Subscribe(buffer int, types ...Type) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	s := newSubscription(b, buffer, types)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// SubscribeSince 先发送 Seq 大于 seq 的保存的事件 再接收新的事件 中间不会遗漏或重复
+// ok 为 false 时 seq 之后的部分事件已经不在缓冲中 seq 比当前的 Seq 大时(例如程序重启)也返回 false
+func (b *Bus) SubscribeSince(seq uint64, buffer int, types ...Type) (s *Subscription, ok bool) {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	from := seq
+	if seq > b.seq {
+		from = 0
+	}
+	envs, ok := b.history.since(from)
+	if seq > b.seq {
+		ok = false
+	}
+	s = newSubscription(b, buffer+len(envs), types)
+	if b.closed {
+		close(s.ch)
+		return s, ok
+	}
+	for _, env := range envs {
+		s.deliver(env)
+	}
+	b.subs[s] = struct{}{}
+	return s, ok
+}
+
+// SubscribeFunc 在单独的 goroutine 中按顺序调用 f 直到取消订阅
+func (b *Bus) SubscribeFunc(buffer int, f func(env *Envelope), types ...Type) *Subscription {
+	s := b.Subscribe(buffer, types...)
+	go func() {
+		for env := range s.ch {
+			f(env)
+		}
+	}()
+	return s
+}
+
+// Close 关闭所有订阅 之后的 Publish 被忽略
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Subscription 事件从 C 读取 Close 后 C 被关闭
+type Subscription struct {
+	bus     *Bus
+	types   map[Type]bool
+	ch      chan *Envelope
+	dropped uint64
+}
+
+func newSubscription(b *Bus, buffer int, types []Type) *Subscription {
+	s := &Subscription{bus: b, ch: make(chan *Envelope, buffer)}
+	if len(types) > 0 {
+		s.types = make(map[Type]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	return s
+}
+
+// C
+func (s *Subscription) C() <-chan *Envelope {
+	return s.ch
+}
+
+// Dropped 缓冲满时丢弃的事件数
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close 取消订阅
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// deliver 在持有 Bus 的锁时调用 不会和 close 同时执行
+func (s *Subscription) deliver(env *Envelope) {
+	if s.types != nil && !s.types[env.Type] {
+		return
+	}
+	select {
+	case s.ch <- env:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
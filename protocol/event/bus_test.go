@@ -0,0 +1,175 @@
+package event
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBus_Filter(t *testing.T) {
+	bus := NewBus("8613800000000")
+	all := bus.Subscribe(0)
+	receipts := bus.Subscribe(0, TypeReceiptUpdated)
+	defer all.Close()
+	defer receipts.Close()
+
+	bus.Publish(&ChatStateChanged{From: "8613800000001@s.whatsapp.net", State: "composing"})
+	bus.Publish(&ReceiptUpdated{MsgIds: []string{"3EB0"}, Status: 3})
+
+	for i, want := range []Type{TypeChatStateChanged, TypeReceiptUpdated} {
+		env := <-all.C()
+		if env.Type != want || env.Seq != uint64(i+1) || env.Account != "8613800000000" {
+			t.Fatalf("all[%d] = %+v", i, env)
+		}
+	}
+	env := <-receipts.C()
+	if e, ok := env.Event.(*ReceiptUpdated); !ok || e.MsgIds[0] != "3EB0" {
+		t.Fatalf("receipts = %+v", env)
+	}
+	select {
+	case env := <-receipts.C():
+		t.Fatalf("unexpected %+v", env)
+	default:
+	}
+}
+
+func TestBus_SlowConsumer(t *testing.T) {
+	bus := NewBus("8613800000000")
+	slow := bus.Subscribe(2)
+	defer slow.Close()
+	done := make(chan struct{})
+	go func() {
+		// 没有读取 Publish 也不能阻塞
+		for i := 0; i < 100; i++ {
+			bus.Publish(&StreamError{Code: "515"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a slow subscriber")
+	}
+	if slow.Dropped() != 98 || len(slow.C()) != 2 {
+		t.Fatalf("dropped = %d, buffered = %d", slow.Dropped(), len(slow.C()))
+	}
+}
+
+func TestBus_Close(t *testing.T) {
+	bus := NewBus("8613800000000")
+	s := bus.Subscribe(1)
+	s.Close()
+	s.Close()
+	if _, ok := <-s.C(); ok {
+		t.Fatal("channel not closed after unsubscribe")
+	}
+	other := bus.Subscribe(1)
+	bus.Close()
+	if _, ok := <-other.C(); ok {
+		t.Fatal("channel not closed after bus close")
+	}
+	if env := bus.Publish(&StreamError{}); env != nil {
+		t.Fatalf("Publish after close = %+v", env)
+	}
+	if _, ok := <-bus.Subscribe(1).C(); ok {
+		t.Fatal("subscribe after close returned an open channel")
+	}
+	// nil bus 不发布
+	var nilBus *Bus
+	if env := nilBus.Publish(&StreamError{}); env != nil {
+		t.Fatal("nil bus published")
+	}
+}
+
+func TestBus_SubscribeFunc(t *testing.T) {
+	bus := NewBus("8613800000000")
+	var (
+		mutex sync.Mutex
+		got   []string
+		wg    sync.WaitGroup
+	)
+	wg.Add(2)
+	s := bus.SubscribeFunc(0, func(env *Envelope) {
+		mutex.Lock()
+		got = append(got, env.Event.(*CallOffered).CallId)
+		mutex.Unlock()
+		wg.Done()
+	}, TypeCallOffered)
+	defer s.Close()
+	bus.Publish(&CallOffered{CallId: "1"})
+	bus.Publish(&ChatStateChanged{State: "paused"})
+	bus.Publish(&CallOffered{CallId: "2"})
+	wg.Wait()
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Fatalf("got = %v", got)
+	}
+}
+
+func TestType_JSON(t *testing.T) {
+	env := &Envelope{Seq: 1, Type: TypeGroupParticipantsChanged, Event: &GroupParticipantsChanged{Group: "g", Action: "add"}}
+	d, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Type Type `json:"type"`
+	}
+	if err := json.Unmarshal(d, &decoded); err != nil || decoded.Type != TypeGroupParticipantsChanged {
+		t.Fatalf("decoded = %+v, %v (%s)", decoded, err, d)
+	}
+	if _, err := ParseType("Unknown"); err == nil {
+		t.Fatal("ParseType accepted an unknown name")
+	}
+}
+
+func TestBus_SubscribeSince(t *testing.T) {
+	bus := NewBus("8613800000000")
+	bus.SetHistory(4)
+	for i := 0; i < 6; i++ {
+		bus.Publish(&StreamError{Code: "515"})
+	}
+	seqs := func(s *Subscription) []uint64 {
+		var got []uint64
+		for len(s.C()) > 0 {
+			got = append(got, (<-s.C()).Seq)
+		}
+		return got
+	}
+	tests := []struct {
+		name string
+		seq  uint64
+		want []uint64
+		ok   bool
+	}{
+		{"in buffer", 3, []uint64{4, 5, 6}, true},
+		{"oldest kept", 2, []uint64{3, 4, 5, 6}, true},
+		{"overwritten", 1, []uint64{3, 4, 5, 6}, false},
+		{"up to date", 6, nil, true},
+		// 程序重启后 Seq 从 1 开始
+		{"from the future", 100, []uint64{3, 4, 5, 6}, false},
+	}
+	for _, tt := range tests {
+		s, ok := bus.SubscribeSince(tt.seq, 1)
+		got := seqs(s)
+		s.Close()
+		if ok != tt.ok || len(got) != len(tt.want) {
+			t.Fatalf("%s: got %v, %v want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%s: got %v want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+
+	// 保存的事件之后继续接收新的事件
+	s, _ := bus.SubscribeSince(5, 1, TypeStreamError)
+	defer s.Close()
+	bus.Publish(&StreamError{Code: "516"})
+	if got := seqs(s); len(got) != 2 || got[0] != 6 || got[1] != 7 {
+		t.Fatalf("got %v", got)
+	}
+}
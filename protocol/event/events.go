@@ -0,0 +1,164 @@
+package event
+
+import (
+	"fmt"
+	"ws-go/protocol/define"
+	"ws-go/protocol/entity"
+)
+
+// Type 事件类型 json 中为名称
+type Type int
+
+const (
+	TypeConnectionStateChanged Type = iota + 1
+	TypeMessageReceived
+	TypeReceiptUpdated
+	TypeGroupParticipantsChanged
+	TypeIdentityChanged
+	TypeCallOffered
+	TypeChatStateChanged
+	TypeStreamError
+	TypeMessageUndecryptable
+	TypeConnectionTransition
+)
+
+var typeNames = map[Type]string{
+	TypeConnectionStateChanged:   "ConnectionStateChanged",
+	TypeMessageReceived:          "MessageReceived",
+	TypeReceiptUpdated:           "ReceiptUpdated",
+	TypeGroupParticipantsChanged: "GroupParticipantsChanged",
+	TypeIdentityChanged:          "IdentityChanged",
+	TypeCallOffered:              "CallOffered",
+	TypeChatStateChanged:         "ChatStateChanged",
+	TypeStreamError:              "StreamError",
+	TypeMessageUndecryptable:     "MessageUndecryptable",
+	TypeConnectionTransition:     "ConnectionTransition",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Type(%d)", int(t))
+}
+
+// ParseType 按名称查找类型
+func ParseType(name string) (Type, error) {
+	for t, n := range typeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event type %q", name)
+}
+
+func (t Type) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *Type) UnmarshalText(text []byte) error {
+	v, err := ParseType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+// Event 所有事件都实现 Type
+type Event interface {
+	Type() Type
+}
+
+// ConnectionStateChanged 登录状态变化 State 为 LoginStatus 的名称
+type ConnectionStateChanged struct {
+	State string `json:"state"`
+	Code  int32  `json:"code"`
+	Text  string `json:"text,omitempty"`
+}
+
+func (*ConnectionStateChanged) Type() Type { return TypeConnectionStateChanged }
+
+// MessageReceived 解密后的新消息
+type MessageReceived struct {
+	Message entity.RespMessage  `json:"message"`
+	Chat    *entity.ChatMessage `json:"-"`
+}
+
+func (*MessageReceived) Type() Type { return TypeMessageReceived }
+
+// ReceiptUpdated 对方的送达 已读 播放回执 Recipient 为单聊的对方或者群成员
+type ReceiptUpdated struct {
+	MsgIds      []string         `json:"msgIds"`
+	Chat        string           `json:"chat"`
+	Recipient   string           `json:"recipient"`
+	Status      define.MsgStatus `json:"status"`
+	ReceiptType string           `json:"receiptType"`
+}
+
+func (*ReceiptUpdated) Type() Type { return TypeReceiptUpdated }
+
+// GroupParticipantsChanged 群成员变化 Action 为 add remove promote demote 等 By 为操作者
+type GroupParticipantsChanged struct {
+	Group        string   `json:"group"`
+	Action       string   `json:"action"`
+	Participants []string `json:"participants"`
+	By           string   `json:"by,omitempty"`
+}
+
+func (*GroupParticipantsChanged) Type() Type { return TypeGroupParticipantsChanged }
+
+// IdentityChanged 联系人的身份密钥变化
+type IdentityChanged struct {
+	entity.IdentityChanged
+}
+
+func (*IdentityChanged) Type() Type { return TypeIdentityChanged }
+
+// CallOffered 来电
+type CallOffered struct {
+	From   string `json:"from"`
+	CallId string `json:"callId"`
+	Video  bool   `json:"video"`
+	T      string `json:"t"`
+}
+
+func (*CallOffered) Type() Type { return TypeCallOffered }
+
+// ChatStateChanged 对方正在输入 State 为 composing 或 paused Media 为 audio 时是正在录音
+type ChatStateChanged struct {
+	From        string `json:"from"`
+	Participant string `json:"participant,omitempty"`
+	State       string `json:"state"`
+	Media       string `json:"media,omitempty"`
+}
+
+func (*ChatStateChanged) Type() Type { return TypeChatStateChanged }
+
+// StreamError 服务器返回 stream:error Condition 为第一个子节点 例如 conflict
+type StreamError struct {
+	Code      string `json:"code,omitempty"`
+	Condition string `json:"condition,omitempty"`
+	Text      string `json:"text,omitempty"`
+}
+
+func (*StreamError) Type() Type { return TypeStreamError }
+
+// MessageUndecryptable 消息无法解密 已经隔离 之后解密成功时发送 MessageReceived
+type MessageUndecryptable struct {
+	entity.UndecryptableMessage
+}
+
+func (*MessageUndecryptable) Type() Type { return TypeMessageUndecryptable }
+
+// ConnectionTransition 连接状态机的状态变化 Terminal 为 true 时不会自动重连
+type ConnectionTransition struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason,omitempty"`
+	Attempt   int    `json:"attempt"`
+	BackoffMs int64  `json:"backoffMs,omitempty"`
+	Terminal  bool   `json:"terminal,omitempty"`
+}
+
+func (*ConnectionTransition) Type() Type { return TypeConnectionTransition }
@@ -0,0 +1,43 @@
+package event
+
+// DefaultHistory 每个账号保存的最近事件数
+const DefaultHistory = 1024
+
+// history 固定大小的环形缓冲 保存最近发布的事件 用于断线后按 Seq 续传
+type history struct {
+	buf  []*Envelope
+	next int
+	full bool
+}
+
+func newHistory(size int) *history {
+	return &history{buf: make([]*Envelope, size)}
+}
+
+func (h *history) add(env *Envelope) {
+	h.buf[h.next] = env
+	h.next++
+	if h.next == len(h.buf) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// since Seq 大于 seq 的事件 ok 为 false 时 seq 之后的事件已经被覆盖 返回的是保存的所有事件
+func (h *history) since(seq uint64) (envs []*Envelope, ok bool) {
+	start, n := 0, h.next
+	if h.full {
+		start, n = h.next, len(h.buf)
+	}
+	for i := 0; i < n; i++ {
+		env := h.buf[(start+i)%len(h.buf)]
+		if env.Seq > seq {
+			envs = append(envs, env)
+		}
+	}
+	// 第一个保存的事件不是 seq 的下一个
+	if len(envs) > 0 && envs[0].Seq > seq+1 {
+		return envs, false
+	}
+	return envs, true
+}
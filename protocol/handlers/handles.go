@@ -38,35 +38,79 @@ func (h *Handlers) Close() {
 	defer h.lock.RUnlock()
 }
 
+// Reopen
+func (h *Handlers) Reopen() {
+	h.lock.RLock()
+	for _, handler := range h.hs {
+		handler.Reopen()
+	}
+	defer h.lock.RUnlock()
+}
+
 func newBaseHandler(tag string, limit ...int) *baseHandler {
 	return &baseHandler{
 		tag:        tag,
 		queueClose: false,
 		queue:      gqueue.New(limit...),
+		limit:      limit,
 		event:      nil,
 	}
 }
 
 type baseHandler struct {
 	tag string
+	// mutex 保护 queue queueClose 接收线程 Add 和重新连接时的 Close Reopen 同时调用
+	mutex sync.Mutex
 	// 队列是否关闭
 	queueClose bool
 
 	queue *gqueue.Queue
+	limit []int
 	event iface.HandleCallBackEvent
 }
 
 func (b *baseHandler) Add(i interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 	if !b.queueClose {
 		b.queue.Push(i)
 	}
 }
 func (b *baseHandler) Close() {
+	b.closeQueue(nil)
+}
+
+// closeQueue queue 不为空时只在还是当前队列时关闭
+func (b *baseHandler) closeQueue(queue *gqueue.Queue) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if queue != nil && queue != b.queue {
+		return
+	}
 	if !b.queueClose {
 		b.queue.Close()
 		b.queueClose = true
 	}
 }
+
+// currentQueue 处理线程开始时的队列
+func (b *baseHandler) currentQueue() *gqueue.Queue {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.queue
+}
+
+// reopen 队列关闭后创建新的队列 返回是否重新创建
+func (b *baseHandler) reopen() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.queueClose {
+		return false
+	}
+	b.queue = gqueue.New(b.limit...)
+	b.queueClose = false
+	return true
+}
 func (b *baseHandler) SetNotifyEvent(event iface.HandleCallBackEvent) {
 	b.event = event
 }
@@ -11,16 +11,18 @@ import (
 	"ws-go/protocol/entity"
 	"ws-go/protocol/iface"
 	"ws-go/protocol/node"
+	"ws-go/protocol/stores"
 	"ws-go/protocol/waproto"
 )
 
-// NewChatMessageHandler 创建 chat message handler
-func NewChatMessageHandler(axolotl *axolotl.Manager, api iface.INodeApi) iface.Handler {
+// NewChatMessageHandler 创建 chat message handler messageStores 不为 nil 时重试失败的消息被隔离
+func NewChatMessageHandler(axolotl *axolotl.Manager, api iface.INodeApi, messageStores *stores.MessageStores) iface.Handler {
 	handler := &ChatMessageHandler{
-		baseHandler: newBaseHandler(define.HandlerChatMessage, 10),
-		NodeApi:     api,
-		Axolotl:     axolotl,
-		retryList:   gmap.NewStrAnyMap(true),
+		baseHandler:   newBaseHandler(define.HandlerChatMessage, 10),
+		NodeApi:       api,
+		Axolotl:       axolotl,
+		retryList:     gmap.NewStrAnyMap(true),
+		messageStores: messageStores,
 	}
 	// run loop queue
 	go handler.LoopQueue()
@@ -34,6 +36,16 @@ type ChatMessageHandler struct {
 	Axolotl *axolotl.Manager
 	// 重试列表
 	retryList *gmap.StrAnyMap
+	// 解密失败的消息隔离在这里 收到新的密钥时重新解密 为 nil 时不隔离
+	messageStores *stores.MessageStores
+	// 处理当前消息时收到的新密钥 处理完后重新解密隔离的消息
+	arrivals []keyArrival
+}
+
+// keyArrival 收到 sender 新的 session 或者 chat 中的 sender key
+type keyArrival struct {
+	sender string
+	chat   string
 }
 
 // AddHandleTask add chat message decrypt task
@@ -56,6 +68,13 @@ func (c *ChatMessageHandler) Close() {
 	c.baseHandler.Close()
 }
 
+// Reopen 重新连接后重新开始处理队列
+func (c *ChatMessageHandler) Reopen() {
+	if c.reopen() {
+		go c.LoopQueue()
+	}
+}
+
 // LoopQueue
 func (c *ChatMessageHandler) LoopQueue() {
 	defer func() {
@@ -64,17 +83,18 @@ func (c *ChatMessageHandler) LoopQueue() {
 			log.Printf("LoopQueue panic: %v\n", r)
 		}
 	}()
+	// Reopen 会替换 queue 只处理开始时的队列
+	queue := c.currentQueue()
 	for {
-		v := c.queue.Pop()
+		v := queue.Pop()
 		if v == nil {
-			if !c.queueClose {
-				c.queue.Close()
-			}
+			c.closeQueue(queue)
 			break
 		} else {
 			// chat message
 			if message, ok := v.(*entity.ChatMessage); ok {
 				c.handleChatMessage(message)
+				c.redecryptQuarantined()
 			}
 			// wa message
 			if wamessage, ok := v.(*proto.Message); ok {
@@ -85,8 +105,8 @@ func (c *ChatMessageHandler) LoopQueue() {
 	}
 }
 
-// sendRetry
-func (c *ChatMessageHandler) sendRetry(message *entity.ChatMessage) {
+// sendRetry 两次重试后仍然失败时隔离
+func (c *ChatMessageHandler) sendRetry(message *entity.ChatMessage, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			//打印错误堆栈信息
@@ -110,7 +130,9 @@ func (c *ChatMessageHandler) sendRetry(message *entity.ChatMessage) {
 	if retryInfo.Count().Val() >= 2 {
 		// remove retry
 		c.retryList.Remove(message.Id())
-		c.NodeApi.SendNormalAndRead(message.Id(), message.From(), message.Participant())
+		c.quarantine(message, err)
+		// 没有解密 只发送送达回执 不标记为已读
+		c.NodeApi.SendNormalReceipt(message.Id(), message.From(), message.Participant())
 		return
 	}
 	// send retry
@@ -141,6 +163,7 @@ func (c *ChatMessageHandler) handleWaMessage(decryptedData []byte, msgInfo *enti
 		participant = node.NewJid(msgInfo.Participant())
 		c.Axolotl.ProcessGroupSession(
 			groupId.RawId(), participant.RawId(), skmsg.GetSENDER_KEY())
+		c.keysArrived(msgInfo.Participant(), msgInfo.From())
 		//return
 	}
 	if message.GetExtendedTextMessage() != nil && message.GetExtendedTextMessage().GetText() != "" {
@@ -152,12 +175,18 @@ func (c *ChatMessageHandler) handleWaMessage(decryptedData []byte, msgInfo *enti
 	if waMessage.GetCONVERSATION() != "" {
 		log.Println("收到消息 Msg:", waMessage.GetCONVERSATION())
 	}
+	// 只有 sender key 时内容在同一个 stanza 的另一个 enc 中 不通知
+	if entity.IsSenderKeyDistributionOnly(message) && len(msgInfo.EncList()) > 1 {
+		return
+	}
 	// set content
 	msgInfo.SetContent(waMessage)
 	//set message
 	msgInfo.SetMessage(message)
 	// send receipt
 	c.NodeApi.SendNormalAndRead(msgInfo.Id(), msgInfo.From(), msgInfo.Participant())
+	// 重新解密成功
+	c.removeQuarantined(msgInfo.Id())
 	// notify
 	c.notify(msgInfo)
 }
@@ -177,17 +206,19 @@ func (c *ChatMessageHandler) chatMessageDecryptFailure(message *entity.ChatMessa
 		add := (err.(*exception.NoSessionException)).Addr()
 		err := c.NodeApi.GetPreKeys(false, add)
 		if err != nil {
+			// 不发送回执 服务器还会重新发送
+			c.quarantine(message, err)
 			return
 		}
 		c.handleChatMessage(message)
 		break
 	case *exception.NoValidSessions:
 		// 发送重试
-		c.sendRetry(message)
+		c.sendRetry(message, err)
 		break
 	default:
 		// 发送重试
-		c.sendRetry(message)
+		c.sendRetry(message, err)
 	}
 }
 
@@ -201,9 +232,7 @@ func (c *ChatMessageHandler) handleChatMessage(message *entity.ChatMessage) {
 	for _, enc := range message.EncList() {
 		// decrypt
 		//wslog.GetLogger().Debug("ReceiveHandleMessage:data", hex.EncodeToString(enc.Data()))
-		jid := node.NewJid(message.From())
-		participant := node.NewJid(message.Participant())
-		decryptedData, err := c.Axolotl.Decrypt(jid.RawId(), participant.RawId(), enc.Data(), enc.EncType())
+		decryptedData, err := c.decrypt(message, enc)
 		if err != nil {
 			c.chatMessageDecryptFailure(message, err)
 			break
@@ -214,3 +243,119 @@ func (c *ChatMessageHandler) handleChatMessage(message *entity.ChatMessage) {
 		c.handleWaMessage(decryptedData, message)
 	}
 }
+
+// decrypt pkmsg 解密成功时和对方有了新的 session
+func (c *ChatMessageHandler) decrypt(message *entity.ChatMessage, enc *entity.Enc) ([]byte, error) {
+	jid := node.NewJid(message.From())
+	participant := node.NewJid(message.Participant())
+	decryptedData, err := c.Axolotl.Decrypt(jid.RawId(), participant.RawId(), enc.Data(), enc.EncType())
+	if err == nil && enc.EncType() == "pkmsg" {
+		c.keysArrived(senderOf(message), "")
+	}
+	return decryptedData, err
+}
+
+// senderOf 需要密钥的一方 群消息时是 participant
+func senderOf(message *entity.ChatMessage) string {
+	if message.Participant() != "" {
+		return message.Participant()
+	}
+	return message.From()
+}
+
+// quarantine 保存解密失败的消息 第一次隔离时通知
+func (c *ChatMessageHandler) quarantine(message *entity.ChatMessage, err error) {
+	if c.messageStores == nil {
+		return
+	}
+	errorClass := axolotl.ClassifyDecryptError(err)
+	inserted, qerr := c.messageStores.Quarantine(message, string(errorClass), errorText(err))
+	if qerr != nil {
+		log.Println("quarantine message error", message.Id(), qerr)
+		return
+	}
+	if !inserted {
+		return
+	}
+	c.notify(&entity.UndecryptableMessage{
+		Id:          message.Id(),
+		Chat:        message.From(),
+		Participant: message.Participant(),
+		Sender:      senderOf(message),
+		ErrorClass:  string(errorClass),
+		Error:       errorText(err),
+		T:           message.T(),
+	})
+}
+
+// removeQuarantined 消息解密成功时删除隔离的记录
+func (c *ChatMessageHandler) removeQuarantined(id string) {
+	if c.messageStores == nil {
+		return
+	}
+	if _, err := c.messageStores.RemoveQuarantined(id); err != nil {
+		log.Println("remove quarantined message error", id, err)
+	}
+}
+
+// keysArrived 当前消息处理完后在 redecryptQuarantined 中重新解密 chat 为空时重新解密 sender 的所有消息
+func (c *ChatMessageHandler) keysArrived(sender, chat string) {
+	if c.messageStores == nil || sender == "" {
+		return
+	}
+	c.arrivals = append(c.arrivals, keyArrival{sender: sender, chat: chat})
+}
+
+// redecryptQuarantined 在 LoopQueue 中调用 和收到的消息按顺序解密
+// 重新解密时收到的新密钥也在这里处理 每条消息最多重新解密一次
+func (c *ChatMessageHandler) redecryptQuarantined() {
+	tried := make(map[string]bool)
+	for len(c.arrivals) > 0 {
+		arrival := c.arrivals[0]
+		c.arrivals = c.arrivals[1:]
+		messages, err := c.messageStores.QuarantinedFrom(arrival.sender, arrival.chat)
+		if err != nil {
+			log.Println("load quarantined messages error", arrival.sender, err)
+			continue
+		}
+		for _, q := range messages {
+			if tried[q.Id] {
+				continue
+			}
+			tried[q.Id] = true
+			c.redecrypt(q)
+		}
+	}
+}
+
+// redecrypt 失败时不再发送 retry 回执 只记录次数
+func (c *ChatMessageHandler) redecrypt(q *stores.QuarantinedMessage) {
+	message := q.ChatMessage()
+	var lastErr error
+	for _, enc := range message.EncList() {
+		decryptedData, err := c.decrypt(message, enc)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.handleWaMessage(decryptedData, message)
+	}
+	// 成功时 handleWaMessage 已经删除
+	if message.GetMessage() != nil {
+		return
+	}
+	errorClass, errText := q.ErrorClass, q.Error
+	if lastErr != nil {
+		errorClass, errText = string(axolotl.ClassifyDecryptError(lastErr)), errorText(lastErr)
+	}
+	if err := c.messageStores.QuarantineAttempt(q.Id, errorClass, errText); err != nil {
+		log.Println("quarantine attempt error", q.Id, err)
+	}
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"ws-go/libsignal/keys/prekey"
+	"ws-go/libsignal/util/optional"
+	"ws-go/protocol/axolotl"
+	"ws-go/protocol/axolotl/store"
+	"ws-go/protocol/define"
+	"ws-go/protocol/entity"
+	"ws-go/protocol/node"
+	"ws-go/protocol/stores"
+	"ws-go/protocol/waproto"
+
+	"github.com/gogf/gf/container/gmap"
+	"github.com/gogf/gf/container/gtype"
+	"github.com/golang/protobuf/proto"
+)
+
+// fakeNodeApi 记录发送的回执
+type fakeNodeApi struct {
+	mutex    sync.Mutex
+	receipts []string
+}
+
+func (f *fakeNodeApi) record(s string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.receipts = append(f.receipts, s)
+}
+
+func (f *fakeNodeApi) SendNormalAndRead(id, to, participant string) { f.record("read:" + id) }
+func (f *fakeNodeApi) SendNormalReceipt(id, to, participant string) { f.record("delivery:" + id) }
+func (f *fakeNodeApi) SendReceiptRetry(to, id, participant, t string, count gtype.Int32) {
+	f.record("retry:" + id)
+}
+func (f *fakeNodeApi) GetPreKeys(bool, ...string) error { return nil }
+
+// fakeNotify 收集通知
+type fakeNotify chan interface{}
+
+func (f fakeNotify) NotifyHandleResult(any ...interface{}) {
+	f <- any[0].(*HandleResult).GetResult()
+}
+
+func (f fakeNotify) next(t *testing.T) interface{} {
+	t.Helper()
+	select {
+	case v := <-f:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("no notify")
+		return nil
+	}
+}
+
+func newTestAxolotl(t *testing.T) *axolotl.Manager {
+	t.Helper()
+	m, err := axolotl.NewAxolotlManager(store.NewMemoryBackend(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func bundleOf(t *testing.T, m *axolotl.Manager) *prekey.Bundle {
+	t.Helper()
+	preKeys, err := m.LoadUnSendPreKey()
+	if err != nil || len(preKeys) == 0 {
+		t.Fatalf("LoadUnSendPreKey = %d, %v", len(preKeys), err)
+	}
+	signedPreKey := m.SignedPreKeyStore.LoadSignedPreKey(0)
+	return prekey.NewBundle(
+		m.IdentityStore.GetLocalRegistrationId(),
+		0,
+		optional.NewOptionalUint32(preKeys[0].ID().Value),
+		signedPreKey.ID(),
+		preKeys[0].KeyPair().PublicKey(),
+		signedPreKey.KeyPair().PublicKey(),
+		signedPreKey.Signature(),
+		m.IdentityStore.GetIdentityKeyPair().PublicKey(),
+	)
+}
+
+func groupMessage(id, group, participant string, encs ...*entity.Enc) *entity.ChatMessage {
+	m := entity.EmptyChatMessage()
+	m.SetId(id)
+	m.SetFrom(group)
+	m.SetParticipant(participant)
+	m.SetT("1624957782")
+	m.SetContextType("text")
+	m.SetEncList(encs)
+	return m
+}
+
+func TestChatMessageHandler_Quarantine(t *testing.T) {
+	const (
+		group = "8613800000000-1624957782@g.us"
+		alice = "8613800000001@s.whatsapp.net"
+		bob   = "8613800000002@s.whatsapp.net"
+	)
+	groupId, aliceId, bobId := node.NewJid(group), node.NewJid(alice), node.NewJid(bob)
+	aliceAxolotl, bobAxolotl := newTestAxolotl(t), newTestAxolotl(t)
+	if err := aliceAxolotl.CreateSession(bobId.RawId(), bundleOf(t, bobAxolotl)); err != nil {
+		t.Fatal(err)
+	}
+	skdm, err := aliceAxolotl.CreateGroupSession(groupId.RawId(), aliceId.RawId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	skmsg := func(text string) *entity.Enc {
+		d, _ := proto.Marshal(&waproto.Message{Conversation: proto.String(text)})
+		c, err := aliceAxolotl.Encrypt(groupId.RawId(), d, true, aliceId.RawId())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entity.NewEnc(c.Serialize(), "skmsg", "2")
+	}
+
+	messageStores, err := stores.OpenMessageStores(filepath.Join(t.TempDir(), "messages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, notify := &fakeNodeApi{}, make(fakeNotify, 10)
+	c := &ChatMessageHandler{
+		baseHandler:   newBaseHandler(define.HandlerChatMessage),
+		NodeApi:       api,
+		Axolotl:       bobAxolotl,
+		retryList:     gmap.NewStrAnyMap(true),
+		messageStores: messageStores,
+	}
+	c.SetNotifyEvent(notify)
+
+	// 没有 sender key 两次重试后隔离 只发送送达回执
+	first := groupMessage("3EB0G1", group, alice, skmsg("first"))
+	for i := 0; i < 3; i++ {
+		c.handleChatMessage(first)
+		c.redecryptQuarantined()
+	}
+	undecryptable, ok := notify.next(t).(*entity.UndecryptableMessage)
+	if !ok || undecryptable.Id != "3EB0G1" || undecryptable.Sender != alice || undecryptable.ErrorClass != string(axolotl.DecryptNoSenderKey) {
+		t.Fatalf("notify = %+v", undecryptable)
+	}
+	if q, err := messageStores.GetQuarantined("3EB0G1"); err != nil || q.Chat != group {
+		t.Fatalf("GetQuarantined = %+v, %v", q, err)
+	}
+
+	// 收到 sender key 后重新解密 sender key 和内容在同一个 pkmsg 中
+	skdmData, _ := waproto.CreatePBWAMessageSkMsg(groupId.GroupId(), skdm.Serialize())
+	content, _ := proto.Marshal(&waproto.Message{Conversation: proto.String("second")})
+	pkmsg, err := aliceAxolotl.Encrypt(bobId.RawId(), append(skdmData, content...), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.handleChatMessage(groupMessage("3EB0G2", group, alice, entity.NewEnc(pkmsg.Serialize(), "pkmsg", "2")))
+	c.redecryptQuarantined()
+	texts := map[string]string{}
+	for i := 0; i < 2; i++ {
+		message := notify.next(t).(*entity.ChatMessage)
+		texts[message.Id()] = message.GetMessage().GetConversation()
+	}
+	if texts["3EB0G1"] != "first" || texts["3EB0G2"] != "second" {
+		t.Fatalf("delivered = %v", texts)
+	}
+	if all, err := messageStores.ListQuarantined("", 0); err != nil || len(all) != 0 {
+		t.Fatalf("ListQuarantined = %v, %v", all, err)
+	}
+	want := []string{"retry:3EB0G1", "retry:3EB0G1", "delivery:3EB0G1", "read:3EB0G2", "read:3EB0G1"}
+	if len(api.receipts) != len(want) {
+		t.Fatalf("receipts = %v want %v", api.receipts, want)
+	}
+	for i := range want {
+		if api.receipts[i] != want[i] {
+			t.Fatalf("receipts = %v want %v", api.receipts, want)
+		}
+	}
+}
@@ -42,14 +42,18 @@ func (n *NotificationHandler) AddHandleTask(i interface{}) error {
 	return nil
 }
 
+// Reopen
+func (n *NotificationHandler) Reopen() {
+	n.reopen()
+}
+
 func (n *NotificationHandler) LoopQueue() {
 
+	queue := n.currentQueue()
 	for {
-		v := n.queue.Pop()
+		v := queue.Pop()
 		if v == nil {
-			if !n.queueClose {
-				n.Close()
-			}
+			n.closeQueue(queue)
 			break
 		} else {
 			// chat message
@@ -3,6 +3,7 @@ package handshake
 import (
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"github.com/golang/protobuf/proto"
 	"log"
 	"ws-go/noise"
@@ -13,6 +14,13 @@ import (
 var DHString = []byte{69, 68, 0, 1}
 var InitString = []byte("WA")
 
+var (
+	ErrEmptyPayload     = errors.New("No information to Payload")
+	ErrEmptyServerHello = errors.New("server hello is empty")
+	// ErrIKFailed 使用缓存的服务器公钥握手失败 服务器没有返回 XXfallback 公钥可能已经更换
+	ErrIKFailed = errors.New("IK handshake failed")
+)
+
 type VerifyCallbackFunc func(publicKey []byte, data []byte) error
 
 // WAProtocolVersion noise 握手版本
@@ -66,10 +74,9 @@ func (w *WAHandshake) RunHandshake(segment _interface.SegmentProcessor) error {
 	// 开始进行握手
 	if len(w.PeerStatic) == 0 {
 		return w.startHandshakeXX()
-	} else {
-		//TODO 当有服务器秘钥使用 IX进行握手
 	}
-	return nil
+	// 有服务器公钥使用 IK 进行握手 服务器拒绝时回退到 XXfallback
+	return w.startHandshakeIK()
 }
 
 // GetCipherStateGroup 握手成功后的密钥对
@@ -158,7 +165,7 @@ func (w *WAHandshake) startHandshakeXX() error {
 
 	//最后发送握手完成包
 	if len(w.Payload) == 0 {
-		return errors.New("No information to Payload")
+		return ErrEmptyPayload
 	}
 
 	payload, csIn, csOut, err := state.WriteMessage([]byte{}, w.Payload)
@@ -181,6 +188,8 @@ func (w *WAHandshake) startHandshakeXX() error {
 		w.csIn = csOut
 		w.csOut = csIn
 	}
+	// 保存服务器公钥 下次登录使用 IK 握手
+	w.savePeerStatic(state.PeerStatic())
 
 	//d, err := w.netWorkSegment.ReadInputSegmentData()
 	//if err != nil {
@@ -194,6 +203,137 @@ func (w *WAHandshake) startHandshakeXX() error {
 	}*/
 	return nil
 }
+
+// startHandshakeIK 使用缓存的服务器公钥进行 IK 握手
+// 服务器无法解密时会返回 XXfallback 的第一条消息
+func (w *WAHandshake) startHandshakeIK() error {
+	if len(w.Payload) == 0 {
+		return ErrEmptyPayload
+	}
+	state, err := noise.NewHandshakeState(noise.Config{
+		StaticKeypair: w.StaticKey,
+		Initiator:     true,
+		Pattern:       noise.HandshakeIK,
+		CipherSuite:   w.cipherSuite(),
+		PeerStatic:    w.PeerStatic,
+		Prologue:      w.prologue(),
+		Random:        rand.Reader,
+	})
+	if err != nil {
+		return err
+	}
+	// e, es, s, ss + payload
+	outData, _, _, err := state.WriteMessage(nil, w.Payload)
+	if err != nil {
+		return err
+	}
+	clientHelloProtoData, err := serializationClientHello(outData[:32], outData[32:80], outData[80:])
+	if err != nil {
+		return err
+	}
+	if err := w.netWorkSegment.WriteSegmentOutputData(clientHelloProtoData); err != nil {
+		return err
+	}
+
+	serverHelloData, err := w.netWorkSegment.ReadInputSegmentData()
+	if err != nil {
+		return w.ikFailed(err)
+	}
+	serverHello, err := unmarshalServerHello(serverHelloData)
+	if err != nil {
+		return w.ikFailed(err)
+	}
+	if serverHello == nil {
+		return w.ikFailed(ErrEmptyServerHello)
+	}
+	// 服务器返回了静态公钥 说明缓存的公钥已经失效
+	if len(serverHello.Static) > 0 {
+		log.Println("server rejected IK handshake, fallback to XX")
+		return w.startHandshakeXXFallback(state.LocalEphemeral(), serverHello)
+	}
+
+	// e, ee, se + payload
+	message := append(append([]byte{}, serverHello.Ephemeral...), serverHello.Payload...)
+	_, csIn, csOut, err := state.ReadMessage(nil, message)
+	if err != nil {
+		return w.ikFailed(err)
+	}
+	if csOut != nil && csIn != nil {
+		w.csIn = csOut
+		w.csOut = csIn
+	}
+	return nil
+}
+
+// ikFailed 清除缓存的服务器公钥 下次使用 XX 握手
+func (w *WAHandshake) ikFailed(err error) error {
+	w.PeerStatic = nil
+	return fmt.Errorf("%w: %v", ErrIKFailed, err)
+}
+
+// startHandshakeXXFallback IK 失败后 服务器作为发起方 客户端使用 IK 中的临时密钥继续握手
+func (w *WAHandshake) startHandshakeXXFallback(ephemeral noise.DHKey, serverHello *waproto.HandshakeMessage_ServerHello) error {
+	state, err := noise.NewHandshakeState(noise.Config{
+		StaticKeypair:    w.StaticKey,
+		EphemeralKeypair: ephemeral,
+		Initiator:        false,
+		Pattern:          noise.HandshakeXXfallback,
+		CipherSuite:      w.cipherSuite(),
+		Prologue:         w.prologue(),
+		Random:           rand.Reader,
+	})
+	if err != nil {
+		return err
+	}
+	// e, ee, s, se + 证书
+	message := append(append([]byte{}, serverHello.Ephemeral...), serverHello.Static...)
+	message = append(message, serverHello.Payload...)
+	outData, _, _, err := state.ReadMessage(nil, message)
+	if err != nil {
+		return err
+	}
+	if len(state.PeerStatic()) > 0 {
+		_ = w.processCallback(state.PeerStatic(), outData)
+	}
+
+	// s, es + payload
+	payload, csIn, csOut, err := state.WriteMessage(nil, w.Payload)
+	if err != nil {
+		return err
+	}
+	clientFinishData, err := createClientFinishData(payload[:48], payload[48:])
+	if err != nil {
+		return err
+	}
+	if err := w.netWorkSegment.WriteSegmentOutputData(clientFinishData); err != nil {
+		return err
+	}
+	// 服务器是发起方 csIn 用于服务器到客户端
+	if csOut != nil && csIn != nil {
+		w.csIn = csIn
+		w.csOut = csOut
+	}
+	w.savePeerStatic(state.PeerStatic())
+	return nil
+}
+
+// savePeerStatic 保存服务器公钥
+func (w *WAHandshake) savePeerStatic(peerStatic []byte) {
+	if len(peerStatic) == 0 || w.WAHandshakeSettings == nil {
+		return
+	}
+	w.PeerStatic = append([]byte{}, peerStatic...)
+}
+
+// prologue {87, 65, 5, 2}
+func (w *WAHandshake) prologue() []byte {
+	return append(append([]byte{}, InitString...), w.WaProtocolVersion.VersionMajor, w.WaProtocolVersion.VersionMinor)
+}
+
+func (w *WAHandshake) cipherSuite() noise.CipherSuite {
+	return noise.NewCipherSuite(noise.DH25519, noise.CipherAESGCM, noise.HashSHA256)
+}
+
 func (w *WAHandshake) processCallback(publicKey []byte, payload []byte) error {
 	if w.VerifyCallback == nil {
 		return nil
@@ -223,8 +363,8 @@ func serializationClientHello(ephemeral, static, payload []byte) ([]byte, error)
 	message := &waproto.HandshakeMessage{}
 	message.ClientHello = &waproto.HandshakeMessage_ClientHello{
 		Ephemeral: ephemeral,
-		Static:    nil,
-		Payload:   nil,
+		Static:    static,
+		Payload:   payload,
 	}
 	return proto.Marshal(message)
 }
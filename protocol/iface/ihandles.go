@@ -9,12 +9,15 @@ type IHandlers interface {
 	GetHandler(s string) (handler Handler, fund bool)
 	AddHandler(handler Handler)
 	Close()
+	// Reopen 重新连接后恢复 Close 关闭的队列
+	Reopen()
 }
 
 type Handler interface {
 	IBaseHandler
 	AddHandleTask(i interface{}) error
 	Close()
+	Reopen()
 }
 
 type HandleCallBackEvent interface {
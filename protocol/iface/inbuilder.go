@@ -1,5 +1,7 @@
 package iface
 
+import "ws-go/protocol/newxxmp"
+
 type INodeOther interface {
 	GetIqId() int32
 }
@@ -8,5 +10,5 @@ type INodeOther interface {
 type NodeBuilder interface {
 	IPromise
 	// build node to ixxmp data
-	Builder() ([]byte, error)
+	Builder(encoder *newxxmp.Encoder) ([]byte, error)
 }
@@ -8,3 +8,8 @@ type NetWorkHandler interface {
 	OnError(err error)
 	OnDisconnect()
 }
+
+// HandshakeSuccessHandler 可选 握手成功后 开始接收数据前调用
+type HandshakeSuccessHandler interface {
+	OnHandshakeSuccess()
+}
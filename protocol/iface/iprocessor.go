@@ -16,6 +16,7 @@ type IBuildProcessor interface {
 // 对外提供Api 接口
 type INodeApi interface {
 	SendNormalAndRead(id, to, participant string)
+	SendNormalReceipt(id, to, participant string)
 	SendReceiptRetry(to, id, participant, t string, count gtype.Int32)
 	GetPreKeys(bool, ...string) error
 }
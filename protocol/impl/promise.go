@@ -2,12 +2,15 @@ package impl
 
 import (
 	"errors"
+	"sync"
 	_interface "ws-go/protocol/iface"
 	"ws-go/protocol/utils/promise"
 )
 
+// ResultPromise 登录时替换 Promise 网络线程同时 resolve 或 reject 使用锁保护
 type ResultPromise struct {
 	*promise.Promise
+	mutex sync.RWMutex
 }
 
 func NewResultPromise() *ResultPromise {
@@ -15,36 +18,56 @@ func NewResultPromise() *ResultPromise {
 }
 
 func (r *ResultPromise) SetPromise(promise *promise.Promise) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	r.Promise = promise
 }
 
 func (r *ResultPromise) GetPromise() *promise.Promise {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	return r.Promise
 }
 
+// SuccessResolve 没有设置 Promise 时忽略
+func (r *ResultPromise) SuccessResolve(resolution promise.Any) {
+	if p := r.GetPromise(); p != nil {
+		p.SuccessResolve(resolution)
+	}
+}
+
+// Reject 没有设置 Promise 时忽略
+func (r *ResultPromise) Reject(err error) {
+	if p := r.GetPromise(); p != nil {
+		p.Reject(err)
+	}
+}
+
 // GetResult 等待返回数据阻塞
 func (r *ResultPromise) GetResult() (promise.Any, error) {
-	if r.Promise == nil {
+	p := r.GetPromise()
+	if p == nil {
 		return nil, errors.New("promise not set")
 	}
-	return r.Promise.Await()
+	return p.Await()
 }
 
 // SetNewListenHandler 用于回调 无阻塞
 func (r *ResultPromise) SetNewListenHandler(handler *_interface.PromiseHandler) {
-	if r.Promise == nil && handler == nil {
+	p := r.GetPromise()
+	if p == nil && handler == nil {
 		return
 	}
 	// 回调成功
 	if handler.SuccessFunc != nil {
-		r.Promise.Then(func(data promise.Any) promise.Any {
+		p.Then(func(data promise.Any) promise.Any {
 			handler.SuccessFunc(data)
 			return nil
 		})
 	}
 	// 回调报错
 	if handler.FailureFunc != nil {
-		r.Promise.Catch(func(err error) error {
+		p.Catch(func(err error) error {
 			handler.FailureFunc(err)
 			return err
 		})
@@ -53,19 +76,20 @@ func (r *ResultPromise) SetNewListenHandler(handler *_interface.PromiseHandler)
 
 // SetCallBack 用于回调 无阻塞
 func (r *ResultPromise) SetListenHandler(success func(any promise.Any), failure func(err error)) {
-	if r.Promise == nil {
+	p := r.GetPromise()
+	if p == nil {
 		return
 	}
 	// 回调成功
 	if success != nil {
-		r.Promise.Then(func(data promise.Any) promise.Any {
+		p.Then(func(data promise.Any) promise.Any {
 			success(data)
 			return nil
 		})
 	}
 	// 回调报错
 	if failure != nil {
-		r.Promise.Catch(func(err error) error {
+		p.Catch(func(err error) error {
 			failure(err)
 			return err
 		})
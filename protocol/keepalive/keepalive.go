@@ -0,0 +1,278 @@
+// Package keepalive 连接空闲时发送 ping 测量往返时间 连续没有回复时认为连接已断开
+package keepalive
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultIdle      = 30 * time.Second
+	DefaultTimeout   = 10 * time.Second
+	DefaultMaxMissed = 3
+)
+
+// Clock 测试时使用假的时钟
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer
+type Timer interface {
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SystemClock
+var SystemClock Clock = systemClock{}
+
+// Config
+type Config struct {
+	// Idle 超过 Idle 没有收到任何帧时发送 ping
+	Idle time.Duration
+	// Timeout 等待 pong 的时间
+	Timeout time.Duration
+	// MaxMissed 连续没有回复的 ping 达到 MaxMissed 且期间没有收到任何帧时认为连接已断开
+	MaxMissed int
+}
+
+// DefaultConfig
+func DefaultConfig() Config {
+	return Config{Idle: DefaultIdle, Timeout: DefaultTimeout, MaxMissed: DefaultMaxMissed}
+}
+
+// Stats 时间单位为毫秒 SmoothedRTTMs 和 TCP 的 SRTT 一样按 1/8 更新
+type Stats struct {
+	Running       bool  `json:"running"`
+	IdleMs        int64 `json:"idleMs"`
+	Sent          int   `json:"sent"`
+	Received      int   `json:"received"`
+	Missed        int   `json:"missed"`
+	TotalMissed   int   `json:"totalMissed"`
+	LastRTTMs     int64 `json:"lastRttMs"`
+	SmoothedRTTMs int64 `json:"smoothedRttMs"`
+	MinRTTMs      int64 `json:"minRttMs"`
+	MaxRTTMs      int64 `json:"maxRttMs"`
+}
+
+// Pinger 发送一个 ping 收到回复或者失败后调用 done 可以在任意 goroutine 中调用
+type Pinger func(done func(err error))
+
+// Keepalive 一个连接一个 Start 之后只有一个等待中的 timer 空闲等待或者 pong 超时
+type Keepalive struct {
+	mutex  sync.Mutex
+	config Config
+	clock  Clock
+	ping   Pinger
+	onDead func(missed int)
+
+	running     bool
+	lastInbound time.Time
+	timer       Timer
+	// pingSeq 当前 ping 的序号 回复或超时后不再处理同一个 ping
+	pingSeq  int
+	inflight bool
+	sentAt   time.Time
+
+	sent, received      int
+	missed, totalMissed int
+	lastRTT, srtt       time.Duration
+	minRTT, maxRTT      time.Duration
+}
+
+// New onDead 在连续 MaxMissed 个 ping 没有回复后调用一次 之后停止 需要重新 Start
+func New(config Config, clock Clock, ping Pinger, onDead func(missed int)) *Keepalive {
+	if config.Idle <= 0 {
+		config.Idle = DefaultIdle
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxMissed <= 0 {
+		config.MaxMissed = DefaultMaxMissed
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &Keepalive{config: config, clock: clock, ping: ping, onDead: onDead}
+}
+
+// Start 登录成功后调用 重新计算连续没有回复的次数
+func (k *Keepalive) Start() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.running = true
+	k.inflight = false
+	k.missed = 0
+	k.lastInbound = k.clock.Now()
+	k.pingSeq++
+	k.scheduleIdle()
+}
+
+// Stop 连接断开后调用 之后的回复被忽略
+func (k *Keepalive) Stop() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.stop()
+}
+
+// Touch 收到任意一帧
+func (k *Keepalive) Touch() {
+	k.mutex.Lock()
+	k.lastInbound = k.clock.Now()
+	k.mutex.Unlock()
+}
+
+// Stats
+func (k *Keepalive) Stats() Stats {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	s := Stats{
+		Running:       k.running,
+		Sent:          k.sent,
+		Received:      k.received,
+		Missed:        k.missed,
+		TotalMissed:   k.totalMissed,
+		LastRTTMs:     k.lastRTT.Milliseconds(),
+		SmoothedRTTMs: k.srtt.Milliseconds(),
+		MinRTTMs:      k.minRTT.Milliseconds(),
+		MaxRTTMs:      k.maxRTT.Milliseconds(),
+	}
+	if k.running {
+		s.IdleMs = k.clock.Now().Sub(k.lastInbound).Milliseconds()
+	}
+	return s
+}
+
+// tick 空闲等待结束 期间收到过帧时继续等待
+func (k *Keepalive) tick(seq int) {
+	k.mutex.Lock()
+	if !k.running || k.inflight || seq != k.pingSeq {
+		k.mutex.Unlock()
+		return
+	}
+	if k.clock.Now().Sub(k.lastInbound) < k.config.Idle {
+		k.scheduleIdle()
+		k.mutex.Unlock()
+		return
+	}
+	k.sendPing()
+}
+
+// sendPing 需要持有锁 发送前释放
+func (k *Keepalive) sendPing() {
+	k.pingSeq++
+	seq := k.pingSeq
+	k.inflight = true
+	k.sentAt = k.clock.Now()
+	k.sent++
+	k.schedule(k.config.Timeout)
+	k.mutex.Unlock()
+	k.ping(func(err error) { k.pong(seq, err) })
+}
+
+// pong ping 的结果 超时后到达的回复被忽略
+func (k *Keepalive) pong(seq int, err error) {
+	k.mutex.Lock()
+	if !k.running || !k.inflight || seq != k.pingSeq {
+		k.mutex.Unlock()
+		return
+	}
+	if err != nil {
+		k.miss()
+		return
+	}
+	k.inflight = false
+	k.received++
+	k.missed = 0
+	// pong 也是收到的帧
+	k.lastInbound = k.clock.Now()
+	rtt := k.clock.Now().Sub(k.sentAt)
+	k.lastRTT = rtt
+	if k.srtt == 0 {
+		k.srtt = rtt
+	} else {
+		k.srtt += (rtt - k.srtt) / 8
+	}
+	if k.minRTT == 0 || rtt < k.minRTT {
+		k.minRTT = rtt
+	}
+	if rtt > k.maxRTT {
+		k.maxRTT = rtt
+	}
+	k.scheduleIdle()
+	k.mutex.Unlock()
+}
+
+// timeout 等待 pong 超时
+func (k *Keepalive) timeout(seq int) {
+	k.mutex.Lock()
+	if !k.running || !k.inflight || seq != k.pingSeq {
+		k.mutex.Unlock()
+		return
+	}
+	k.miss()
+}
+
+// miss 需要持有锁 返回前释放 期间收到过帧说明连接正常 不计算
+func (k *Keepalive) miss() {
+	k.inflight = false
+	k.totalMissed++
+	if k.lastInbound.After(k.sentAt) {
+		k.missed = 0
+		k.scheduleIdle()
+		k.mutex.Unlock()
+		return
+	}
+	k.missed++
+	if k.missed < k.config.MaxMissed {
+		// 立即重新发送
+		k.sendPing()
+		return
+	}
+	missed := k.missed
+	k.stop()
+	k.mutex.Unlock()
+	if k.onDead != nil {
+		k.onDead(missed)
+	}
+}
+
+// scheduleIdle 需要持有锁 从最后收到帧的时间开始等待 Idle
+func (k *Keepalive) scheduleIdle() {
+	d := k.config.Idle - k.clock.Now().Sub(k.lastInbound)
+	if d < 0 {
+		d = 0
+	}
+	k.schedule(d)
+}
+
+// schedule 需要持有锁 替换等待中的 timer inflight 时为 pong 超时
+func (k *Keepalive) schedule(d time.Duration) {
+	if k.timer != nil {
+		k.timer.Stop()
+	}
+	seq := k.pingSeq
+	if k.inflight {
+		k.timer = k.clock.AfterFunc(d, func() { k.timeout(seq) })
+		return
+	}
+	k.timer = k.clock.AfterFunc(d, func() { k.tick(seq) })
+}
+
+func (k *Keepalive) stop() {
+	k.running = false
+	k.inflight = false
+	k.pingSeq++
+	if k.timer != nil {
+		k.timer.Stop()
+		k.timer = nil
+	}
+}
@@ -0,0 +1,169 @@
+package keepalive
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeClock Advance 时按时间顺序执行到期的 timer
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.stopped {
+			continue
+		}
+		t.stopped = true
+		c.now = t.at
+		t.f()
+	}
+	c.now = end
+}
+
+// fakePeer 记录 ping 由测试决定回复
+type fakePeer struct {
+	pending []func(err error)
+	dead    int
+}
+
+func (p *fakePeer) ping(done func(err error)) {
+	p.pending = append(p.pending, done)
+}
+
+func (p *fakePeer) reply(err error) {
+	done := p.pending[0]
+	p.pending = p.pending[1:]
+	done(err)
+}
+
+func newTestKeepalive() (*Keepalive, *fakeClock, *fakePeer) {
+	clock := newFakeClock()
+	peer := &fakePeer{}
+	k := New(Config{Idle: 30 * time.Second, Timeout: 10 * time.Second, MaxMissed: 3}, clock, peer.ping, func(missed int) {
+		peer.dead = missed
+	})
+	return k, clock, peer
+}
+
+func TestKeepalive_PingOnlyWhenIdle(t *testing.T) {
+	k, clock, peer := newTestKeepalive()
+	k.Start()
+	// 一直有数据时不发送 ping
+	for i := 0; i < 10; i++ {
+		clock.Advance(20 * time.Second)
+		k.Touch()
+	}
+	if len(peer.pending) != 0 {
+		t.Fatalf("sent %d pings on a busy link", len(peer.pending))
+	}
+	clock.Advance(30 * time.Second)
+	if len(peer.pending) != 1 {
+		t.Fatalf("sent %d pings after idle, want 1", len(peer.pending))
+	}
+	clock.Advance(200 * time.Millisecond)
+	peer.reply(nil)
+	clock.Advance(30 * time.Second)
+	clock.Advance(400 * time.Millisecond)
+	peer.reply(nil)
+
+	stats := k.Stats()
+	if stats.Sent != 2 || stats.Received != 2 || stats.Missed != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats.LastRTTMs != 400 || stats.MinRTTMs != 200 || stats.MaxRTTMs != 400 || stats.SmoothedRTTMs != 225 {
+		t.Fatalf("rtt stats = %+v", stats)
+	}
+}
+
+func TestKeepalive_DeadPeer(t *testing.T) {
+	k, clock, peer := newTestKeepalive()
+	k.Start()
+	clock.Advance(30 * time.Second)
+	// 第一个 ping 超时 立即发送第二个 第二个失败 发送第三个
+	clock.Advance(10 * time.Second)
+	if len(peer.pending) != 2 {
+		t.Fatalf("pings = %d, want 2", len(peer.pending))
+	}
+	peer.pending[1](errors.New("iq time out"))
+	if peer.dead != 0 {
+		t.Fatal("dead before MaxMissed")
+	}
+	clock.Advance(10 * time.Second)
+	if peer.dead != 3 {
+		t.Fatalf("dead = %d, want 3", peer.dead)
+	}
+	if stats := k.Stats(); stats.Running || stats.TotalMissed != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	// 超时后到达的回复被忽略
+	peer.reply(nil)
+	clock.Advance(time.Hour)
+	if stats := k.Stats(); stats.Received != 0 || stats.Sent != 3 {
+		t.Fatalf("stats after stop = %+v", stats)
+	}
+}
+
+func TestKeepalive_InboundResetsMissed(t *testing.T) {
+	k, clock, peer := newTestKeepalive()
+	k.Start()
+	clock.Advance(30 * time.Second)
+	clock.Advance(10 * time.Second)
+	// 第二个 ping 等待期间收到其他数据 连接正常
+	clock.Advance(5 * time.Second)
+	k.Touch()
+	clock.Advance(5 * time.Second)
+	if stats := k.Stats(); stats.Missed != 0 || stats.TotalMissed != 2 || peer.dead != 0 {
+		t.Fatalf("stats = %+v dead = %d", stats, peer.dead)
+	}
+	// 从最后收到数据开始计算空闲时间
+	clock.Advance(24 * time.Second)
+	if len(peer.pending) != 2 {
+		t.Fatalf("pings = %d, want 2", len(peer.pending))
+	}
+	clock.Advance(time.Second)
+	if len(peer.pending) != 3 {
+		t.Fatalf("pings = %d, want 3", len(peer.pending))
+	}
+
+	k.Stop()
+	clock.Advance(time.Hour)
+	if len(peer.pending) != 3 || peer.dead != 0 {
+		t.Fatalf("pings after stop = %d dead = %d", len(peer.pending), peer.dead)
+	}
+}
@@ -1,23 +1,41 @@
 package msg
 
 import (
+	"context"
 	"errors"
-	"github.com/gogf/gcache-adapter/adapter"
-	"github.com/gogf/gf/encoding/gjson"
-	"github.com/gogf/gf/frame/g"
-	"github.com/gogf/gf/os/gcache"
+	"fmt"
+	"ws-go/protocol/db/migrate"
 	"ws-go/protocol/define"
+
+	"github.com/gogf/gf/database/gdb"
+	"github.com/gogf/gf/os/gtime"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // errors
-var IdEmptyErr = errors.New("The message ID is empty")
+var (
+	IdEmptyErr = errors.New("The message ID is empty")
+	// ErrMsgNotFound 不是本账号通过程序发送的消息 例如其他设备发送的
+	ErrMsgNotFound = errors.New("message not found in outbox")
+)
+
+// RecipientStatus 每个接收者的状态 群消息每个成员一行
+type RecipientStatus struct {
+	Recipient string
+	Status    define.MsgStatus
+	UpdatedAt int64
+}
 
 type MySendMsg struct {
-	Id      string
-	To      string
-	Content string
-	MsgType string
-	Status  define.MsgStatus
+	Id        string
+	To        string
+	Content   string
+	MsgType   string
+	Status    define.MsgStatus
+	Timestamp int64
+	// Error 服务器 ack 返回的错误码 或者加密 写入连接失败的原因
+	Error      string
+	Recipients []RecipientStatus
 }
 
 // CreateNewMsg
@@ -32,53 +50,246 @@ func (m *MySendMsg) ChangeStatus(new define.MsgStatus) {
 	m.Status = new
 }
 
+// outboxMigrations 发件箱的迁移 新的表结构修改在末尾追加版本
+var outboxMigrations = []migrate.Migration{
+	{Version: 1, Description: "create outbox tables", Up: migrate.Exec(`CREATE TABLE IF NOT EXISTS "outbox_messages" (
+	"id"	TEXT NOT NULL PRIMARY KEY,
+	"chat"	TEXT NOT NULL,
+	"msg_type"	TEXT,
+	"content"	TEXT,
+	"status"	INTEGER NOT NULL DEFAULT 0,
+	"error"	TEXT,
+	"timestamp"	INTEGER NOT NULL,
+	"updated_at"	INTEGER NOT NULL
+);`, `CREATE INDEX IF NOT EXISTS "outbox_messages_chat" ON "outbox_messages" ("chat", "timestamp");`,
+		`CREATE TABLE IF NOT EXISTS "outbox_recipients" (
+	"msg_id"	TEXT NOT NULL,
+	"recipient"	TEXT NOT NULL,
+	"status"	INTEGER NOT NULL,
+	"updated_at"	INTEGER NOT NULL,
+	PRIMARY KEY ("msg_id", "recipient")
+);`)},
+}
+
+// Manager 发送的消息和接收者的回执 保存在账号目录的 sqlite 中
 type Manager struct {
-	msgCache *gcache.Cache
-	//MsgList *gmap.StrAnyMap
+	dbSource gdb.DB
+}
+
+// NewManager 数据库版本比程序新时返回错误
+func NewManager(u string) (*Manager, error) {
+	return OpenManager(fmt.Sprintf("%s/%s/outbox", define.DefaultDbPath, u))
+}
+
+// OpenManager 打开发件箱数据库并执行迁移
+func OpenManager(dbPath string) (*Manager, error) {
+	db, err := migrate.OpenSQLite(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate.Run(db, outboxMigrations); err != nil {
+		_ = db.Close(context.Background())
+		return nil, fmt.Errorf("migrate outbox %s: %w", dbPath, err)
+	}
+	return &Manager{dbSource: db}, nil
 }
 
-// NewManager
-func NewManager() *Manager {
-	m := &Manager{msgCache: gcache.New()}
-	// change cache adapter
-	m.msgCache.SetAdapter(adapter.NewRedis(g.Redis()))
-	return m
+// Close
+func (m *Manager) Close() error {
+	return m.dbSource.Close(context.Background())
 }
 
 // AddNewMsg add new send message
 func (m *Manager) AddMySendMsg(id string, newMsg *MySendMsg) error {
-	if id == "" && newMsg == nil {
-		return errors.New("")
+	if id == "" {
+		return IdEmptyErr
+	}
+	if newMsg == nil {
+		return errors.New("send message is nil")
 	}
-	// save
+	// save MsgStatus 实现了 Stringer gdb 会保存为文本 写入时都转换为 int
+	now := gtime.Timestamp()
 	newMsg.Id = id
-	return nil //m.msgCache.Set(id, newMsg, 0)
+	newMsg.Status = define.Sent
+	newMsg.Timestamp = now
+	_, err := m.dbSource.Model("outbox_messages").Insert(gdb.Map{
+		"id":         id,
+		"chat":       newMsg.To,
+		"msg_type":   newMsg.MsgType,
+		"content":    newMsg.Content,
+		"status":     int(define.Sent),
+		"timestamp":  now,
+		"updated_at": now,
+	})
+	return err
 }
 
-// UpdateMsgStatus 更改消息状态
+// UpdateMsgStatus 更改消息状态 状态只会增大 已经失败的消息不再修改
 func (m *Manager) UpdateMsgStatus(id string, status define.MsgStatus) error {
 	if id == "" {
 		return IdEmptyErr
 	}
-	// 从缓存中取出我发送的消息
-	v, err := m.msgCache.Get(id)
+	r, err := m.dbSource.Model("outbox_messages").
+		Data(gdb.Map{"status": int(status), "updated_at": gtime.Timestamp()}).
+		Where("id=? AND status>=0 AND status<?", id, int(status)).
+		Update()
+	if err != nil {
+		return err
+	}
+	return m.checkAffected(id, r)
+}
+
+// ServerAck 服务器收到消息 errCode 不为空时发送失败
+func (m *Manager) ServerAck(id, errCode string) error {
+	if errCode == "" {
+		return m.UpdateMsgStatus(id, define.Ack)
+	}
+	// 已经送达的消息不会再失败
+	return m.fail(id, errCode, define.Ack)
+}
+
+// MarkFailed 加密或者写入连接失败 服务器已经确认的消息不修改
+func (m *Manager) MarkFailed(id, reason string) error {
+	return m.fail(id, reason, define.Sent)
+}
+
+// fail 状态不超过 upTo 时改为失败
+func (m *Manager) fail(id, reason string, upTo define.MsgStatus) error {
+	if id == "" {
+		return IdEmptyErr
+	}
+	r, err := m.dbSource.Model("outbox_messages").
+		Data(gdb.Map{"status": int(define.Failed), "error": reason, "updated_at": gtime.Timestamp()}).
+		Where("id=? AND status>=0 AND status<=?", id, int(upTo)).
+		Update()
 	if err != nil {
 		return err
 	}
-	// 断言
-	if s, ok := v.(string); ok {
-		msg := &MySendMsg{}
-		err := gjson.DecodeTo(s, msg)
+	return m.checkAffected(id, r)
+}
+
+// UpdateReceipt 接收者的回执 recipient 为单聊的对方或者群成员
+// 消息的状态为所有接收者中最大的状态
+func (m *Manager) UpdateReceipt(id, recipient string, status define.MsgStatus) error {
+	if id == "" {
+		return IdEmptyErr
+	}
+	return m.dbSource.Transaction(context.Background(), func(ctx context.Context, tx *gdb.TX) error {
+		one, err := tx.Model("outbox_messages").Fields("status").Where("id=?", id).FindOne()
 		if err != nil {
 			return err
 		}
-		// 更改状态
-		msg.ChangeStatus(status)
-		// 更新缓存
-		_, _, err = m.msgCache.Update(id, msg)
+		if one.IsEmpty() {
+			return ErrMsgNotFound
+		}
+		now := gtime.Timestamp()
+		_, err = tx.Exec(`INSERT INTO outbox_recipients (msg_id, recipient, status, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (msg_id, recipient) DO UPDATE SET status=excluded.status, updated_at=excluded.updated_at
+			WHERE excluded.status > outbox_recipients.status`, id, recipient, int(status), now)
 		if err != nil {
 			return err
 		}
+		// 收到回执说明已经发送成功 失败的状态也一起修改
+		if define.MsgStatus(one["status"].Int()) < status {
+			_, err = tx.Model("outbox_messages").
+				Data(gdb.Map{"status": int(status), "updated_at": now}).
+				Where("id=?", id).
+				Update()
+		}
+		return err
+	})
+}
+
+// GetMsg 消息和每个接收者的状态
+func (m *Manager) GetMsg(id string) (*MySendMsg, error) {
+	if id == "" {
+		return nil, IdEmptyErr
+	}
+	one, err := m.dbSource.Model("outbox_messages").Where("id=?", id).FindOne()
+	if err != nil {
+		return nil, err
+	}
+	if one.IsEmpty() {
+		return nil, ErrMsgNotFound
+	}
+	msg := recordToMsg(one)
+	if msg.Recipients, err = m.recipients(id); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ChatMsgs 会话中发送的消息 按发送顺序倒序 before 为上一页最后一条消息的 id 为空时从最新的开始
+// 时间戳只精确到秒 同一秒发送的消息使用 rowid 区分
+func (m *Manager) ChatMsgs(chat, before string, limit int) ([]*MySendMsg, error) {
+	model := m.dbSource.Model("outbox_messages").Where("chat=?", chat)
+	if before != "" {
+		one, err := m.dbSource.Model("outbox_messages").Fields("rowid").Where("id=? AND chat=?", before, chat).FindOne()
+		if err != nil {
+			return nil, err
+		}
+		if one.IsEmpty() {
+			return nil, ErrMsgNotFound
+		}
+		model = model.Where("rowid<?", one["rowid"].Int64())
+	}
+	if limit > 0 {
+		model = model.Limit(limit)
+	}
+	all, err := model.Order("rowid DESC").All()
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*MySendMsg, 0, len(all))
+	for _, one := range all {
+		msg := recordToMsg(one)
+		if msg.Recipients, err = m.recipients(msg.Id); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (m *Manager) recipients(id string) ([]RecipientStatus, error) {
+	all, err := m.dbSource.Model("outbox_recipients").Where("msg_id=?", id).Order("recipient").All()
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]RecipientStatus, 0, len(all))
+	for _, one := range all {
+		recipients = append(recipients, RecipientStatus{
+			Recipient: one["recipient"].String(),
+			Status:    define.MsgStatus(one["status"].Int()),
+			UpdatedAt: one["updated_at"].Int64(),
+		})
+	}
+	return recipients, nil
+}
+
+// checkAffected 没有修改时区分消息不存在和状态没有变化
+func (m *Manager) checkAffected(id string, r interface{ RowsAffected() (int64, error) }) error {
+	if n, err := r.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	count, err := m.dbSource.Model("outbox_messages").Where("id=?", id).Count()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrMsgNotFound
 	}
 	return nil
 }
+
+func recordToMsg(one gdb.Record) *MySendMsg {
+	return &MySendMsg{
+		Id:        one["id"].String(),
+		To:        one["chat"].String(),
+		Content:   one["content"].String(),
+		MsgType:   one["msg_type"].String(),
+		Status:    define.MsgStatus(one["status"].Int()),
+		Timestamp: one["timestamp"].Int64(),
+		Error:     one["error"].String(),
+	}
+}
@@ -0,0 +1,220 @@
+package msg
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"ws-go/protocol/define"
+)
+
+func openTestManager(t *testing.T) (*Manager, string) {
+	path := filepath.Join(t.TempDir(), "outbox")
+	m, err := OpenManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m, path
+}
+
+func TestManager_Receipts(t *testing.T) {
+	m, _ := openTestManager(t)
+	to := "8613800000001@s.whatsapp.net"
+	sent := CreateMySendMsg(to, "hello", "text")
+	if err := m.AddMySendMsg("3EB0000000000001", sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Id != "3EB0000000000001" || sent.Status != define.Sent || sent.Timestamp == 0 {
+		t.Fatalf("AddMySendMsg = %+v", sent)
+	}
+
+	steps := []struct {
+		name   string
+		update func() error
+		want   define.MsgStatus
+	}{
+		{"server ack", func() error { return m.ServerAck(sent.Id, "") }, define.Ack},
+		{"delivered", func() error { return m.UpdateReceipt(sent.Id, to, define.Delivered) }, define.Delivered},
+		{"read", func() error { return m.UpdateReceipt(sent.Id, to, define.Read) }, define.Read},
+		// 回执顺序错乱时状态不会变小
+		{"late delivered", func() error { return m.UpdateReceipt(sent.Id, to, define.Delivered) }, define.Read},
+		{"late ack error", func() error { return m.ServerAck(sent.Id, "479") }, define.Read},
+		{"played", func() error { return m.UpdateReceipt(sent.Id, to, define.Played) }, define.Played},
+	}
+	for _, step := range steps {
+		if err := step.update(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		got, err := m.GetMsg(sent.Id)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got.Status != step.want {
+			t.Fatalf("%s: status = %v, want %v", step.name, got.Status, step.want)
+		}
+	}
+	got, _ := m.GetMsg(sent.Id)
+	if got.To != to || got.Content != "hello" || got.MsgType != "text" || got.Error != "" {
+		t.Fatalf("GetMsg = %+v", got)
+	}
+	if len(got.Recipients) != 1 || got.Recipients[0].Recipient != to || got.Recipients[0].Status != define.Played {
+		t.Fatalf("Recipients = %+v", got.Recipients)
+	}
+}
+
+func TestManager_GroupReceipts(t *testing.T) {
+	m, _ := openTestManager(t)
+	group := "8613800000000-1624957782@g.us"
+	sent := CreateMySendMsg(group, "hi", "text")
+	if err := m.AddMySendMsg("3EB0000000000002", sent); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.UpdateReceipt(sent.Id, "8613800000001@s.whatsapp.net", define.Read)
+	_ = m.UpdateReceipt(sent.Id, "8613800000002@s.whatsapp.net", define.Delivered)
+	got, err := m.GetMsg(sent.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != define.Read {
+		t.Fatalf("status = %v, want read", got.Status)
+	}
+	want := []RecipientStatus{
+		{Recipient: "8613800000001@s.whatsapp.net", Status: define.Read},
+		{Recipient: "8613800000002@s.whatsapp.net", Status: define.Delivered},
+	}
+	if len(got.Recipients) != len(want) {
+		t.Fatalf("Recipients = %+v", got.Recipients)
+	}
+	for i, r := range got.Recipients {
+		if r.Recipient != want[i].Recipient || r.Status != want[i].Status {
+			t.Fatalf("Recipients[%d] = %+v, want %+v", i, r, want[i])
+		}
+	}
+}
+
+func TestManager_Failed(t *testing.T) {
+	m, _ := openTestManager(t)
+	sent := CreateMySendMsg("8613800000001@s.whatsapp.net", "hello", "text")
+	if err := m.AddMySendMsg("3EB0000000000003", sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ServerAck(sent.Id, "479"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := m.GetMsg(sent.Id)
+	if got.Status != define.Failed || got.Error != "479" {
+		t.Fatalf("GetMsg = %+v", got)
+	}
+	// 失败后不会被服务器 ack 覆盖
+	_ = m.ServerAck(sent.Id, "")
+	if got, _ = m.GetMsg(sent.Id); got.Status != define.Failed {
+		t.Fatalf("status = %v, want failed", got.Status)
+	}
+}
+
+func TestManager_NotFound(t *testing.T) {
+	m, _ := openTestManager(t)
+	if err := m.UpdateReceipt("unknown", "8613800000001@s.whatsapp.net", define.Read); !errors.Is(err, ErrMsgNotFound) {
+		t.Fatalf("UpdateReceipt err = %v", err)
+	}
+	if err := m.ServerAck("unknown", ""); !errors.Is(err, ErrMsgNotFound) {
+		t.Fatalf("ServerAck err = %v", err)
+	}
+	if _, err := m.GetMsg("unknown"); !errors.Is(err, ErrMsgNotFound) {
+		t.Fatalf("GetMsg err = %v", err)
+	}
+	if err := m.AddMySendMsg("", CreateMySendMsg("a", "", "text")); !errors.Is(err, IdEmptyErr) {
+		t.Fatalf("AddMySendMsg err = %v", err)
+	}
+}
+
+func TestManager_ChatMsgs(t *testing.T) {
+	m, path := openTestManager(t)
+	to := "8613800000001@s.whatsapp.net"
+	for _, id := range []string{"A1", "A2", "A3"} {
+		if err := m.AddMySendMsg(id, CreateMySendMsg(to, id, "text")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = m.AddMySendMsg("B1", CreateMySendMsg("8613800000002@s.whatsapp.net", "", "text"))
+	_ = m.UpdateReceipt("A2", to, define.Delivered)
+
+	// 重新打开保留数据
+	_ = m.Close()
+	m, err := OpenManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	msgs, err := m.ChatMsgs(to, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Id != "A3" || msgs[1].Id != "A2" {
+		t.Fatalf("ChatMsgs = %+v", msgs)
+	}
+	if msgs[1].Status != define.Delivered || len(msgs[1].Recipients) != 1 {
+		t.Fatalf("ChatMsgs[1] = %+v", msgs[1])
+	}
+	if msgs, err = m.ChatMsgs(to, msgs[1].Id, 0); err != nil || len(msgs) != 1 || msgs[0].Id != "A1" {
+		t.Fatalf("ChatMsgs before = %+v, %v", msgs, err)
+	}
+	if _, err = m.ChatMsgs(to, "B1", 0); !errors.Is(err, ErrMsgNotFound) {
+		t.Fatalf("ChatMsgs before other chat err = %v", err)
+	}
+}
+
+// 同一秒发送的多条消息分页时不会遗漏
+func TestManager_ChatMsgsSameSecond(t *testing.T) {
+	m, _ := openTestManager(t)
+	defer m.Close()
+	to := "8613800000001@s.whatsapp.net"
+	ids := []string{"S1", "S2", "S3", "S4", "S5"}
+	for _, id := range ids {
+		if err := m.AddMySendMsg(id, CreateMySendMsg(to, id, "text")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	before := ""
+	for {
+		msgs, err := m.ChatMsgs(to, before, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, msg := range msgs {
+			got = append(got, msg.Id)
+		}
+		before = msgs[len(msgs)-1].Id
+	}
+	want := "S5 S4 S3 S2 S1"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("pages = %v, want %s", got, want)
+	}
+}
+
+func TestReceiptStatus(t *testing.T) {
+	tests := []struct {
+		receiptType string
+		want        define.MsgStatus
+		ok          bool
+	}{
+		{"", define.Delivered, true},
+		{"inactive", define.Delivered, true},
+		{"read", define.Read, true},
+		{"played", define.Played, true},
+		{"read-self", define.Sent, false},
+		{"retry", define.Sent, false},
+		{"sender", define.Sent, false},
+	}
+	for _, tt := range tests {
+		got, ok := define.ReceiptStatus(tt.receiptType)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ReceiptStatus(%q) = %v, %v", tt.receiptType, got, ok)
+		}
+	}
+}
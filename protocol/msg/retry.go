@@ -0,0 +1,116 @@
+package msg
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetryCacheSize 保存明文的最近发送的消息数
+	DefaultRetryCacheSize = 512
+	// DefaultRetryCacheTTL 超过这个时间的 retry 回执不再重发
+	DefaultRetryCacheTTL = 10 * time.Minute
+	// DefaultMaxResend 每个接收者最多重发的次数
+	DefaultMaxResend = 3
+)
+
+var (
+	// ErrRetryNotCached 消息不是最近发送的 或者已经过期
+	ErrRetryNotCached = errors.New("retry message not cached")
+	// ErrResendLimit 超过重发次数
+	ErrResendLimit = errors.New("resend limit reached")
+)
+
+// SentPlaintext 发送的消息加密前的数据 收到 retry 回执时重新加密后发送
+type SentPlaintext struct {
+	Id           string
+	To           string
+	MsgType      string
+	VerifiedName uint64
+	Plaintext    []byte
+	// Group 为 true 时 To 为群 Sender 为自己的 id 重发时附带 sender key
+	Group  bool
+	Sender string
+
+	sentAt time.Time
+	resent map[string]int
+}
+
+// RetryCache 按发送顺序淘汰 超过 size 时删除最早的
+type RetryCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+// NewRetryCache size 和 ttl 小于等于 0 时使用默认值
+func NewRetryCache(size int, ttl time.Duration) *RetryCache {
+	if size <= 0 {
+		size = DefaultRetryCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultRetryCacheTTL
+	}
+	return &RetryCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Add 相同 id 时替换
+func (c *RetryCache) Add(p *SentPlaintext) {
+	if p == nil || p.Id == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p.sentAt = c.now()
+	p.resent = make(map[string]int)
+	if e, ok := c.entries[p.Id]; ok {
+		c.order.Remove(e)
+	}
+	c.entries[p.Id] = c.order.PushBack(p)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Front())
+	}
+}
+
+// Resend 记录一次对 recipient 的重发 返回第几次重发 超过 max 时返回 ErrResendLimit
+func (c *RetryCache) Resend(id, recipient string, max int) (*SentPlaintext, int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, 0, ErrRetryNotCached
+	}
+	p := e.Value.(*SentPlaintext)
+	if c.now().Sub(p.sentAt) > c.ttl {
+		c.remove(e)
+		return nil, 0, ErrRetryNotCached
+	}
+	if p.resent[recipient] >= max {
+		return nil, p.resent[recipient], ErrResendLimit
+	}
+	p.resent[recipient]++
+	return p, p.resent[recipient], nil
+}
+
+// Len
+func (c *RetryCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+func (c *RetryCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*SentPlaintext).Id)
+}
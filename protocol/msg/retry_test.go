@@ -0,0 +1,52 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryCache_Resend(t *testing.T) {
+	c := NewRetryCache(4, time.Minute)
+	c.Add(&SentPlaintext{Id: "3EB0A1", To: "8613800000001@s.whatsapp.net", Plaintext: []byte("hello")})
+	for i := 1; i <= 2; i++ {
+		p, n, err := c.Resend("3EB0A1", "8613800000001@s.whatsapp.net", 2)
+		if err != nil || n != i || string(p.Plaintext) != "hello" {
+			t.Fatalf("Resend %d = %v, %d, %v", i, p, n, err)
+		}
+	}
+	if _, _, err := c.Resend("3EB0A1", "8613800000001@s.whatsapp.net", 2); !errors.Is(err, ErrResendLimit) {
+		t.Fatalf("third Resend err = %v", err)
+	}
+	// 群消息每个成员单独计数
+	if _, n, err := c.Resend("3EB0A1", "8613800000002@s.whatsapp.net", 2); err != nil || n != 1 {
+		t.Fatalf("other recipient = %d, %v", n, err)
+	}
+	if _, _, err := c.Resend("missing", "8613800000001@s.whatsapp.net", 2); !errors.Is(err, ErrRetryNotCached) {
+		t.Fatalf("missing err = %v", err)
+	}
+}
+
+func TestRetryCache_Bounded(t *testing.T) {
+	now := time.Unix(1624957782, 0)
+	c := NewRetryCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		c.Add(&SentPlaintext{Id: fmt.Sprint(i)})
+	}
+	if c.Len() != 2 {
+		t.Fatalf("Len = %d", c.Len())
+	}
+	// 最早的被淘汰
+	if _, _, err := c.Resend("0", "r", 1); !errors.Is(err, ErrRetryNotCached) {
+		t.Fatalf("evicted err = %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, _, err := c.Resend("2", "r", 1); !errors.Is(err, ErrRetryNotCached) {
+		t.Fatalf("expired err = %v", err)
+	}
+	if c.Len() != 1 {
+		t.Fatalf("Len after expire = %d", c.Len())
+	}
+}
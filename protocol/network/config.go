@@ -13,10 +13,88 @@ var (
 	NetProxyEmptyError = errors.New("The network proxy string is empty")
 )
 
+// DefaultServerAddr whatsapp server addr
+const DefaultServerAddr = "g.whatsapp.net:443"
+
+// DefaultWriteTimeout 写入一帧的超时
+const DefaultWriteTimeout = 30 * time.Second
+
 // NoiseClientConfig
 type NoiseClientConfig struct {
 	// NetProxy 网络代理
 	NetWorkProxy string
+	// ServerAddr 服务器地址 为空时使用 DefaultServerAddr
+	ServerAddr string
+	// TransportType 为空时没有代理使用 tcp4 有代理使用 tcp6
+	TransportType string
+	// Transport 不为空时忽略 ServerAddr TransportType 和代理 测试时使用 PipeTransport
+	Transport Transport
+	// MaxFrameSize 收发的帧的最大长度 为 0 时使用 MaxFrameSize
+	MaxFrameSize int
+	// ReadTimeout 为 0 时读取不超时 WriteTimeout 为 0 时使用 DefaultWriteTimeout
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// SetFrameLimits 下次连接时生效
+func (c *NoiseClientConfig) SetFrameLimits(maxFrameSize int, readTimeout, writeTimeout time.Duration) {
+	c.MaxFrameSize = maxFrameSize
+	c.ReadTimeout = readTimeout
+	c.WriteTimeout = writeTimeout
+}
+
+// GetWriteTimeout
+func (c *NoiseClientConfig) GetWriteTimeout() time.Duration {
+	if c.WriteTimeout <= 0 {
+		return DefaultWriteTimeout
+	}
+	return c.WriteTimeout
+}
+
+// SetTransportType TransportTCP4 TransportTCP6 TransportTCP TransportWebSocket
+func (c *NoiseClientConfig) SetTransportType(transportType string) {
+	c.TransportType = transportType
+}
+
+// SetTransport
+func (c *NoiseClientConfig) SetTransport(transport Transport) {
+	c.Transport = transport
+}
+
+// GetTransport
+func (c *NoiseClientConfig) GetTransport() (Transport, error) {
+	if c.Transport != nil {
+		return c.Transport, nil
+	}
+	proxyDialer, err := c.GetNetWorkProxy()
+	if err != nil && err != NetProxyEmptyError {
+		return nil, err
+	}
+	transportType := c.TransportType
+	if transportType == "" {
+		transportType = TransportTCP4
+		if proxyDialer != nil {
+			transportType = TransportTCP6
+		}
+	}
+	addr := c.ServerAddr
+	if addr == "" && transportType != TransportWebSocket {
+		addr = DefaultServerAddr
+	}
+	return NewTransport(transportType, addr, proxyDialer)
+}
+
+// SetServerAddr
+func (c *NoiseClientConfig) SetServerAddr(addr string) {
+	c.ServerAddr = addr
+}
+
+// GetServerAddr
+func (c *NoiseClientConfig) GetServerAddr() string {
+	if c.ServerAddr == "" {
+		return DefaultServerAddr
+	}
+	return c.ServerAddr
 }
 
 // SetNetWorkProxy
@@ -1,9 +1,12 @@
 package gosocket
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
+	"ws-go/protocol/network"
 )
 
 // TCPClient TCP客户端描述
@@ -27,7 +30,8 @@ func CreateTCPClient(handler tcpEventHandler) *TCPClient {
 
 // Connect 连接到服务器
 func (client *TCPClient) Connect(addr string, port uint16) error {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", addr, port))
+	transport := &network.TCPTransport{Network: network.TransportTCP, Addr: net.JoinHostPort(addr, strconv.Itoa(int(port)))}
+	conn, err := transport.Dial(context.Background())
 	if err != nil {
 		return err
 	}
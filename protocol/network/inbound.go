@@ -0,0 +1,169 @@
+package network
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultInboundQueue 等待处理的帧数 队列满时读取 socket 的 goroutine 等待
+const DefaultInboundQueue = 256
+
+// InboundMetrics 时间单位为毫秒
+type InboundMetrics struct {
+	Depth     int    `json:"depth"`
+	Capacity  int    `json:"capacity"`
+	Received  uint64 `json:"received"`
+	Processed uint64 `json:"processed"`
+	// Blocked 队列满时读取等待的次数
+	Blocked uint64 `json:"blocked"`
+	// LagMs 最后一帧从收到到开始处理等待的时间
+	LagMs    int64 `json:"lagMs"`
+	MaxLagMs int64 `json:"maxLagMs"`
+	// BusyMs 当前帧已经处理的时间 空闲时为 0
+	BusyMs int64 `json:"busyMs"`
+}
+
+type inboundFrame struct {
+	data []byte
+	at   time.Time
+	// fn 不为空时是 Post 的回调 不是收到的帧
+	fn func()
+}
+
+// Inbound 一个账号收到的帧在一个 goroutine 中按顺序解码和分发
+// session 的 ratchet 和回执的处理依赖收到的顺序 不能并发
+type Inbound struct {
+	queue   chan inboundFrame
+	handler func(d []byte)
+	done    chan struct{}
+	once    sync.Once
+	now     func() time.Time
+
+	received  uint64
+	processed uint64
+	blocked   uint64
+	lag       int64
+	maxLag    int64
+	// busySince 当前帧开始处理的时间 UnixNano 空闲时为 0
+	busySince int64
+}
+
+// NewInbound capacity 小于等于 0 时使用 DefaultInboundQueue
+func NewInbound(capacity int, handler func(d []byte)) *Inbound {
+	if capacity <= 0 {
+		capacity = DefaultInboundQueue
+	}
+	p := &Inbound{
+		queue:   make(chan inboundFrame, capacity),
+		handler: handler,
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}
+	go p.run()
+	return p
+}
+
+// Push 队列满时等待 关闭后返回 false
+func (p *Inbound) Push(d []byte) bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	atomic.AddUint64(&p.received, 1)
+	f := inboundFrame{data: d, at: p.now()}
+	select {
+	case p.queue <- f:
+		return true
+	default:
+	}
+	atomic.AddUint64(&p.blocked, 1)
+	select {
+	case p.queue <- f:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// Post 在已经收到的帧处理完后调用 fn 队列满时等待 关闭后返回 false
+func (p *Inbound) Post(fn func()) bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	select {
+	case p.queue <- inboundFrame{at: p.now(), fn: fn}:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// Close 不再接收新的帧 已经在队列中的处理完后退出
+func (p *Inbound) Close() {
+	p.once.Do(func() { close(p.done) })
+}
+
+// Metrics
+func (p *Inbound) Metrics() InboundMetrics {
+	m := InboundMetrics{
+		Depth:     len(p.queue),
+		Capacity:  cap(p.queue),
+		Received:  atomic.LoadUint64(&p.received),
+		Processed: atomic.LoadUint64(&p.processed),
+		Blocked:   atomic.LoadUint64(&p.blocked),
+		LagMs:     time.Duration(atomic.LoadInt64(&p.lag)).Milliseconds(),
+		MaxLagMs:  time.Duration(atomic.LoadInt64(&p.maxLag)).Milliseconds(),
+	}
+	if since := atomic.LoadInt64(&p.busySince); since != 0 {
+		m.BusyMs = p.now().Sub(time.Unix(0, since)).Milliseconds()
+	}
+	return m
+}
+
+func (p *Inbound) run() {
+	for {
+		select {
+		case f := <-p.queue:
+			p.dispatch(f)
+		case <-p.done:
+			for {
+				select {
+				case f := <-p.queue:
+					p.dispatch(f)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Inbound) dispatch(f inboundFrame) {
+	if f.fn != nil {
+		f.fn()
+		return
+	}
+	start := p.now()
+	lag := int64(start.Sub(f.at))
+	atomic.StoreInt64(&p.lag, lag)
+	if lag > atomic.LoadInt64(&p.maxLag) {
+		atomic.StoreInt64(&p.maxLag, lag)
+	}
+	atomic.StoreInt64(&p.busySince, start.UnixNano())
+	defer func() {
+		atomic.StoreInt64(&p.busySince, 0)
+		atomic.AddUint64(&p.processed, 1)
+		if r := recover(); r != nil {
+			// 一帧出错不影响后面的帧
+			log.Printf("inbound dispatch panic: %v\n", r)
+		}
+	}()
+	if p.handler != nil {
+		p.handler(f.data)
+	}
+}
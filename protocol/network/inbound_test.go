@@ -0,0 +1,98 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInbound_Order(t *testing.T) {
+	got := make(chan byte, 100)
+	p := NewInbound(4, func(d []byte) {
+		if d[0] == 3 {
+			panic("bad frame")
+		}
+		got <- d[0]
+	})
+	for i := 0; i < 100; i++ {
+		if !p.Push([]byte{byte(i)}) {
+			t.Fatal("Push returned false")
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if i == 3 {
+			// panic 的帧被跳过
+			continue
+		}
+		select {
+		case b := <-got:
+			if b != byte(i) {
+				t.Fatalf("frame %d dispatched at %d", b, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("frame %d not dispatched", i)
+		}
+	}
+	// Post 的回调在之前的帧处理完后调用
+	posted := make(chan struct{})
+	p.Push([]byte{100})
+	p.Post(func() { close(posted) })
+	select {
+	case <-posted:
+		if len(got) != 1 {
+			t.Fatal("Post called before the pushed frame")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Post not called")
+	}
+	p.Close()
+	if p.Push([]byte{0}) {
+		t.Fatal("Push after Close returned true")
+	}
+	if p.Post(func() {}) {
+		t.Fatal("Post after Close returned true")
+	}
+}
+
+func TestInbound_BackPressure(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	p := NewInbound(2, func(d []byte) {
+		started <- struct{}{}
+		<-release
+	})
+	// 第一帧在处理中 后面两帧在队列中
+	p.Push([]byte{0})
+	<-started
+	p.Push([]byte{1})
+	p.Push([]byte{2})
+	pushed := make(chan bool)
+	go func() { pushed <- p.Push([]byte{3}) }()
+	select {
+	case <-pushed:
+		t.Fatal("Push did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m := p.Metrics()
+	if m.Depth != 2 || m.Capacity != 2 || m.Received != 4 || m.Blocked != 1 || m.Processed != 0 || m.BusyMs < 50 {
+		t.Fatalf("Metrics = %+v", m)
+	}
+	close(release)
+	if !<-pushed {
+		t.Fatal("blocked Push returned false")
+	}
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+	// Close 之前的帧都会处理
+	p.Close()
+	deadline := time.Now().Add(time.Second)
+	for p.Metrics().Processed != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Metrics = %+v", p.Metrics())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if m := p.Metrics(); m.Depth != 0 || m.BusyMs != 0 || m.MaxLagMs < 50 {
+		t.Fatalf("Metrics = %+v", m)
+	}
+}
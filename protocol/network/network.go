@@ -1,13 +1,12 @@
 package network
 
 import (
-	"bytes"
-	"errors"
-	"fmt"
+	"context"
 	"io"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"ws-go/noise"
 	"ws-go/protocol/handshake"
 	"ws-go/protocol/iface"
@@ -29,124 +28,26 @@ func (n *NetWork) SetConn(c net.Conn) {
 	n.Conn = c
 }
 
-// WASegment whatsapp
-type WASegment struct {
-	iface.INetWork
-	csIn, csOut *noise.CipherState
-}
-
-// WriteSegmentOutputData
-func (w *WASegment) WriteSegmentOutputData(d []byte) error {
-	if w.csOut != nil {
-		d = w.csOut.Encrypt([]byte{}, []byte{}, d)
-	}
-
-	dataLength := len(d)
-	if dataLength > 16777216 {
-		panic(errors.New("data too large to write; length > 16777216"))
-	}
-
-	buffer := bytes.Buffer{}
-	headerLen := w.writHeaderLen(dataLength)
-	buffer.Write(headerLen)
-	buffer.Write(d)
-	//处理下
-	if w.GetConn() != nil && buffer.Bytes() != nil {
-		_, err := w.GetConn().Write(buffer.Bytes())
-		if err != nil {
-			return err
-		}
-	}
-	//wslog.GetLogger().Debug("WriteSegmentOutputData => ", len(d), hex.EncodeToString(buffer.Bytes()))
-	return nil
-}
-
-// ReadInputSegmentData
-func (w *WASegment) ReadInputSegmentData() ([]byte, error) {
-	DataLen, err := w.readHeaderLen()
-	if err != nil {
-		return nil, err
-	}
-
-	buffer := make([]byte, DataLen)
-	// read A complete package
-	if _, err := io.ReadFull(w.GetConn(), buffer); err != nil {
-		return nil, err
-	}
-	//var nn int
-	//for {
-	//	n, err = w.GetConn().Read(buffer[nn:])
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//	// continue read
-	//	log.Printf("read n %d,%d,%d\n", n, nn, DataLen)
-	//	if uint32(n) > DataLen {
-	//		break
-	//	}
-	//	DataLen = DataLen - uint32(n)
-	//	nn = nn + n
-	//	if DataLen <= 0 {
-	//		break
-	//	}
-	//}
-
-	// decrypt
-	if w.csIn != nil {
-		buffer, err = w.csIn.Decrypt([]byte{}, nil, buffer)
-	}
-
-	//wslog.GetLogger().Debug("ReadInputSegmentData => ", len(buffer), hex.EncodeToString(buffer))
-	return buffer, err
-}
-
-// SetCiphersStateGroup
-func (w *WASegment) SetCiphersStateGroup(csIn, csOut *noise.CipherState) {
-	if csIn != nil && csOut != nil {
-		w.csIn = csIn
-		w.csOut = csOut
-	}
-}
-
-// readHeaderLen 取包的长度
-func (w *WASegment) readHeaderLen() (uint32, error) {
-	wslog.GetLogger().Debug("start read header len.")
-	DataLenBytes := make([]byte, 3)
-	n, err := w.GetConn().Read(DataLenBytes)
-	if err != nil {
-		return 0, err
-	}
-
-	if n != 3 {
-		return 0, errors.New("header len != 3")
-	}
-
-	b := uint32(DataLenBytes[2]) | uint32(DataLenBytes[1])<<8 | uint32(DataLenBytes[0])<<16
-	wslog.GetLogger().Debug("Parse the length of the packet:", b)
-	return b, nil
-}
-
-// writeHeaderLen
-func (w *WASegment) writHeaderLen(i int) []byte {
-	wslog.GetLogger().Debug("Length of write data packet body ", i)
-	bArr := make([]byte, 3)
-	bArr[2] = byte(i)
-	bArr[1] = (byte)(i >> 8)
-	bArr[0] = (byte)(i >> 16)
-	return bArr
-
-}
-
+// noiseState connected 由接收线程和调用方同时读写 使用原子操作
 type noiseState struct {
 	handshake bool
-	connected bool
+	connected int32
 }
 
 func (n *noiseState) Connected() bool {
-	return n.connected
+	return atomic.LoadInt32(&n.connected) == 1
 }
 func (n *noiseState) SetConnected(connected bool) {
-	n.connected = connected
+	var v int32
+	if connected {
+		v = 1
+	}
+	atomic.StoreInt32(&n.connected, v)
+}
+
+// disconnect 只有一个调用方可以从连接变为断开
+func (n *noiseState) disconnect() bool {
+	return atomic.CompareAndSwapInt32(&n.connected, 1, 0)
 }
 
 // NewNoiseClient
@@ -169,6 +70,7 @@ func NewNoiseClient(routingInfo, p []byte, sk noise.DHKey, events iface.NetWorkH
 			},
 		},
 	}
+	noiseClient.inbound = NewInbound(DefaultInboundQueue, noiseClient.dispatch)
 	return noiseClient
 }
 
@@ -182,10 +84,29 @@ type NoiseNetWork struct {
 	// segmentProcessor handler recv and write data
 	segmentProcessor iface.SegmentProcessor
 	handshake        iface.IHandshake
+	// inbound 收到的帧按顺序处理 重新连接时不变
+	inbound *Inbound
 	// waitGroup
 	wg sync.WaitGroup
 }
 
+// SetInboundQueue 修改等待处理的帧数 需要在 Connect 之前调用
+func (n *NoiseNetWork) SetInboundQueue(size int) {
+	old := n.inbound
+	n.inbound = NewInbound(size, n.dispatch)
+	old.Close()
+}
+
+// CloseInbound 账号退出后停止处理收到的帧 之后不能再使用
+func (n *NoiseNetWork) CloseInbound() {
+	n.inbound.Close()
+}
+
+// InboundMetrics 收到的帧的队列长度和延迟
+func (n *NoiseNetWork) InboundMetrics() InboundMetrics {
+	return n.inbound.Metrics()
+}
+
 func (n *NoiseNetWork) GetSegment() iface.SegmentProcessor {
 	return n.segmentProcessor
 }
@@ -203,30 +124,25 @@ func (n *NoiseNetWork) Connect(handshakeSettings ...interface{}) error {
 	if len(handshakeSettings) > 0 && handshakeSettings[0] != nil {
 		n.handshake.UpdateHandshakeSettings(handshakeSettings[0])
 	}
-	// proxy
-	proxyDialer, err := n.GetNetWorkProxy()
-	if err != nil && err != NetProxyEmptyError {
+	transport, err := n.GetTransport()
+	if err != nil {
 		return err
 	}
-	// whatsapp server addr
-	serverAddr := "g.whatsapp.net:443"
-	// proxyDialer not nil use network Proxy
-	if proxyDialer != nil {
-		n.c, err = proxyDialer.Dial("tcp6", serverAddr)
-		if err != nil {
-			fmt.Println("--->", err.Error())
-			return err
-		}
-	} else {
-		n.c, err = net.Dial("tcp4", serverAddr)
-		if err != nil {
-			return err
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDialTimeout)
+	defer cancel()
+	n.c, err = transport.Dial(ctx)
+	if err != nil {
+		return err
 	}
 	//set segmentProcessor Conn
 	if netWork, ok := n.segmentProcessor.(iface.INetWork); ok {
 		netWork.SetConn(n.c)
 	}
+	if segment, ok := n.segmentProcessor.(*WASegment); ok {
+		segment.MaxFrameSize = n.MaxFrameSize
+		segment.ReadTimeout = n.ReadTimeout
+		segment.WriteTimeout = n.GetWriteTimeout()
+	}
 	// set connect successful
 	n.noiseState.SetConnected(true)
 	// call handler
@@ -236,49 +152,49 @@ func (n *NoiseNetWork) Connect(handshakeSettings ...interface{}) error {
 
 // Close
 func (n *NoiseNetWork) Close() {
-	if !n.connected {
-		return
-	}
 	if n == nil || n.c == nil {
 		log.Println("NoiseNetWork 关闭失败!")
 		return
 	}
+	// 接收线程和调用方可能同时关闭 只通知一次
+	if !n.disconnect() {
+		return
+	}
 	_ = n.c.Close()
 	// 等待其他线程的关闭
 	n.wg.Wait()
-	// 关闭
-	n.SetConnected(false)
 	//TODO 回调关闭事件
 	n.closeNotify()
 
 }
 
 // recvThread 启动一条线程接收数据
+// 调用前需要 wg.Add(1) 否则 Close 可能在线程开始前就结束等待
 func (n *NoiseNetWork) recvThread() {
-	n.wg.Add(1)
+	var err error
 	for {
 		if n.segmentProcessor == nil {
 			//n.segmentProcessor
 			//TODO 建议 使用默认 segment
 			break
 		}
-		data, err := n.segmentProcessor.ReadInputSegmentData()
+		var data []byte
+		data, err = n.segmentProcessor.ReadInputSegmentData()
 		if err != nil {
-			if err == io.EOF {
-				// TODO 直接跳出循环 call Close 关闭后会通知 连接关闭
-				break
-			}
-			//TODO 其他 error
-			n.errorNotify(err)
+			// EOF 直接跳出循环 call Close 关闭后会通知 连接关闭
 			break
 		}
 		n.handleRecvDataEvent(data)
 	}
 
+	// 在 Done 之前放入队列 Close 等待这个线程后才通知断开 保证 OnError 在 OnDisconnect 之前
+	if err != nil && err != io.EOF {
+		n.errorNotify(err)
+	}
 	n.wg.Done()
 	// 退出for 循环意味着连接关闭了 或者发生了错误
 	// 关闭掉链接
-	if n.connected {
+	if n.Connected() {
 		n.Close()
 	}
 }
@@ -289,7 +205,7 @@ func (n *NoiseNetWork) handleConnectEvent() {
 	n.connectNotify()
 	wslog.GetLogger().Info("noise connect successful")
 	// enter next handshake
-	if n.handshake != nil && n.connected {
+	if n.handshake != nil && n.Connected() {
 		wslog.GetLogger().Info("start enter noise handshake....")
 		err := n.handshake.RunHandshake(n.segmentProcessor)
 		if err != nil {
@@ -298,9 +214,9 @@ func (n *NoiseNetWork) handleConnectEvent() {
 			if err == io.EOF {
 				n.Close()
 			} else {
-				n.Close()
-				// TODO call handshakeNotify
+				// 先通知握手失败 再关闭连接 OnDisconnect 在之后
 				n.handshakeNotify(err)
+				n.Close()
 			}
 			return
 		}
@@ -314,27 +230,43 @@ func (n *NoiseNetWork) handleConnectEvent() {
 				iCiphersStateGroup.SetCiphersStateGroup(csIn, csOut)
 			}
 		}
+		if handler, ok := n.events.(iface.HandshakeSuccessHandler); ok && n.Connected() {
+			handler.OnHandshakeSuccess()
+		}
 	}
 	// 握手失败连接会关闭
-	if n.connected {
+	if n.Connected() {
+		n.wg.Add(1)
 		go n.recvThread()
 	}
 
 }
 
-// handleRecvDataEvent 处理接收数据
+// handleRecvDataEvent 处理接收数据 队列满时等待 不再读取 socket
 func (n *NoiseNetWork) handleRecvDataEvent(d []byte) {
-	// call
+	n.inbound.Push(d)
+}
+
+// dispatch 在 inbound 的 goroutine 中按收到的顺序调用
+func (n *NoiseNetWork) dispatch(d []byte) {
 	if n.events != nil {
-		go n.events.OnRecvData(d)
+		n.events.OnRecvData(d)
 	}
 }
 
 // closeNotify 通知连接关闭
+// 在关闭前收到的帧处理完后通知 避免 failure 还没有处理就当作握手失败
 func (n *NoiseNetWork) closeNotify() {
 	if n.events != nil {
-		// 使用协成
-		go n.events.OnDisconnect()
+		// 使用协成 Close 可能在 inbound 的 goroutine 中调用
+		go n.post(n.events.OnDisconnect)
+	}
+}
+
+// post 在已经收到的帧处理完后按顺序调用 fn 账号关闭后直接调用
+func (n *NoiseNetWork) post(fn func()) {
+	if !n.inbound.Post(fn) {
+		fn()
 	}
 }
 
@@ -353,9 +285,9 @@ func (n *NoiseNetWork) handshakeNotify(err error) {
 	}
 }
 
-// errorNotify 通知 连接发生错误
+// errorNotify 通知 连接发生错误 和 closeNotify 一样在收到的帧之后 并且在断开之前
 func (n *NoiseNetWork) errorNotify(err error) {
 	if n.events != nil {
-		n.events.OnError(err)
+		n.post(func() { n.events.OnError(err) })
 	}
 }
@@ -0,0 +1,97 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// frameSegment 依次返回 frames 之后返回 err
+type frameSegment struct {
+	frames [][]byte
+	err    error
+}
+
+func (s *frameSegment) ReadInputSegmentData() ([]byte, error) {
+	if len(s.frames) == 0 {
+		return nil, s.err
+	}
+	d := s.frames[0]
+	s.frames = s.frames[1:]
+	return d, nil
+}
+
+func (s *frameSegment) WriteSegmentOutputData([]byte) error {
+	return nil
+}
+
+// orderEvents 记录收到的通知 处理帧时等待 release
+type orderEvents struct {
+	mutex   sync.Mutex
+	events  []string
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (o *orderEvents) add(e string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.events = append(o.events, e)
+}
+
+func (o *orderEvents) OnHandShakeFailed(error) {}
+func (o *orderEvents) OnConnect()              {}
+func (o *orderEvents) OnRecvData(d []byte) {
+	<-o.release
+	o.add(string(d))
+}
+func (o *orderEvents) OnError(error) { o.add("error") }
+func (o *orderEvents) OnDisconnect() {
+	o.add("disconnect")
+	close(o.done)
+}
+
+// 读取出错时 OnError 和 OnDisconnect 在之前收到的帧处理完后按顺序通知
+func TestNoiseNetWork_NotifyOrder(t *testing.T) {
+	events := &orderEvents{release: make(chan struct{}), done: make(chan struct{})}
+	n := &NoiseNetWork{
+		events:           events,
+		segmentProcessor: &frameSegment{frames: [][]byte{[]byte("a"), []byte("b")}, err: errors.New("connection reset")},
+	}
+	n.inbound = NewInbound(DefaultInboundQueue, n.dispatch)
+	defer n.CloseInbound()
+	c, peer := net.Pipe()
+	defer peer.Close()
+	n.c = c
+	n.SetConnected(true)
+	n.wg.Add(1)
+	go n.recvThread()
+
+	// 帧还没有处理时已经断开
+	deadline := time.Now().Add(5 * time.Second)
+	for n.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("not disconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(events.release)
+	select {
+	case <-events.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	want := []string{"a", "b", "error", "disconnect"}
+	if len(events.events) != len(want) {
+		t.Fatalf("events = %v, want %v", events.events, want)
+	}
+	for i := range want {
+		if events.events[i] != want[i] {
+			t.Fatalf("events = %v, want %v", events.events, want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"ws-go/noise"
+	"ws-go/protocol/iface"
+	"ws-go/wslog"
+)
+
+const (
+	// frameHeaderLen 帧的长度为 3 字节大端
+	frameHeaderLen = 3
+	// MaxFrameSize 3 字节长度能表示的最大值
+	MaxFrameSize = 1<<24 - 1
+	// frameCipherOverhead 握手完成后每帧 AES-GCM 的 tag
+	frameCipherOverhead = 16
+)
+
+// FrameTooLargeError 帧超过 WASegment.MaxFrameSize 读取时超长的帧没有被读取 连接不能继续使用
+type FrameTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("frame too large: %d > %d", e.Size, e.Max)
+}
+
+// WASegment whatsapp 的帧 写入时加锁 保证加密的顺序和发送的顺序一致
+type WASegment struct {
+	iface.INetWork
+	csIn, csOut *noise.CipherState
+	// MaxFrameSize 为 0 或者超过 MaxFrameSize 时使用 MaxFrameSize
+	MaxFrameSize int
+	// ReadTimeout WriteTimeout 为 0 时不超时 ReadSegment WriteSegment 使用 ctx 的 deadline
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	readMutex  sync.Mutex
+	writeMutex sync.Mutex
+}
+
+// WriteSegmentOutputData
+func (w *WASegment) WriteSegmentOutputData(d []byte) error {
+	ctx, cancel := withTimeout(w.WriteTimeout)
+	defer cancel()
+	return w.WriteSegment(ctx, d)
+}
+
+// ReadInputSegmentData
+func (w *WASegment) ReadInputSegmentData() ([]byte, error) {
+	ctx, cancel := withTimeout(w.ReadTimeout)
+	defer cancel()
+	return w.ReadSegment(ctx)
+}
+
+// WriteSegment 超长的帧返回 *FrameTooLargeError 不加密也不发送
+func (w *WASegment) WriteSegment(ctx context.Context, d []byte) error {
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+	size := len(d)
+	if w.csOut != nil {
+		size += frameCipherOverhead
+	}
+	if max := w.maxFrameSize(); size > max {
+		return &FrameTooLargeError{Size: size, Max: max}
+	}
+	conn := w.GetConn()
+	if conn == nil {
+		return nil
+	}
+	if w.csOut != nil {
+		d = w.csOut.Encrypt([]byte{}, []byte{}, d)
+	}
+	frame := make([]byte, 0, frameHeaderLen+len(d))
+	frame = append(frame, writeHeaderLen(len(d))...)
+	frame = append(frame, d...)
+	return withDeadline(ctx, conn.SetWriteDeadline, func() error {
+		_, err := conn.Write(frame)
+		return err
+	})
+}
+
+// ReadSegment 读取一个完整的帧 头和数据可以分多次到达
+func (w *WASegment) ReadSegment(ctx context.Context) ([]byte, error) {
+	w.readMutex.Lock()
+	defer w.readMutex.Unlock()
+	conn := w.GetConn()
+	if conn == nil {
+		return nil, io.ErrClosedPipe
+	}
+	var buffer []byte
+	err := withDeadline(ctx, conn.SetReadDeadline, func() error {
+		header := make([]byte, frameHeaderLen)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return err
+		}
+		size := readHeaderLen(header)
+		wslog.GetLogger().Debug("Parse the length of the packet:", size)
+		if max := w.maxFrameSize(); size > max {
+			return &FrameTooLargeError{Size: size, Max: max}
+		}
+		buffer = make([]byte, size)
+		_, err := io.ReadFull(conn, buffer)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// decrypt
+	if w.csIn != nil {
+		return w.csIn.Decrypt([]byte{}, nil, buffer)
+	}
+	return buffer, nil
+}
+
+// SetCiphersStateGroup
+func (w *WASegment) SetCiphersStateGroup(csIn, csOut *noise.CipherState) {
+	if csIn != nil && csOut != nil {
+		w.csIn = csIn
+		w.csOut = csOut
+	}
+}
+
+func (w *WASegment) maxFrameSize() int {
+	if w.MaxFrameSize <= 0 || w.MaxFrameSize > MaxFrameSize {
+		return MaxFrameSize
+	}
+	return w.MaxFrameSize
+}
+
+// readHeaderLen 取包的长度
+func readHeaderLen(header []byte) int {
+	return int(header[2]) | int(header[1])<<8 | int(header[0])<<16
+}
+
+// writeHeaderLen
+func writeHeaderLen(i int) []byte {
+	return []byte{byte(i >> 16), byte(i >> 8), byte(i)}
+}
+
+// withTimeout timeout 为 0 时不超时
+func withTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// withDeadline 在 f 执行期间使用 ctx 的 deadline ctx 取消时设置过去的时间中断读写 返回 ctx 的错误
+func withDeadline(ctx context.Context, setDeadline func(time.Time) error, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := setDeadline(deadline); err != nil {
+		return err
+	}
+	if ctx.Done() != nil {
+		stop, exited := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(exited)
+			select {
+			case <-ctx.Done():
+				_ = setDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+		// 等待 goroutine 退出后再清除 不影响下次读写
+		defer func() {
+			close(stop)
+			<-exited
+			_ = setDeadline(time.Time{})
+		}()
+	} else if !deadline.IsZero() {
+		defer func() { _ = setDeadline(time.Time{}) }()
+	}
+	err := f()
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// conn 的 deadline 可能比 ctx 先到
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && !deadline.IsZero() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSegment() (*WASegment, net.Conn) {
+	local, remote := net.Pipe()
+	w := &WASegment{INetWork: &NetWork{}}
+	w.SetConn(local)
+	return w, remote
+}
+
+func TestWASegment_ReadFragmented(t *testing.T) {
+	w, remote := newTestSegment()
+	defer remote.Close()
+	payload := bytes.Repeat([]byte("abc"), 100)
+	frame := append(writeHeaderLen(len(payload)), payload...)
+	go func() {
+		// 头和数据逐字节到达
+		for i := range frame {
+			if _, err := remote.Write(frame[i : i+1]); err != nil {
+				return
+			}
+		}
+	}()
+	d, err := w.ReadInputSegmentData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, payload) {
+		t.Fatalf("read %d bytes, want %d", len(d), len(payload))
+	}
+}
+
+func TestWASegment_ReadTooLarge(t *testing.T) {
+	w, remote := newTestSegment()
+	defer remote.Close()
+	w.MaxFrameSize = 10
+	go remote.Write(writeHeaderLen(11))
+	_, err := w.ReadInputSegmentData()
+	var tooLarge *FrameTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Size != 11 || tooLarge.Max != 10 {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestWASegment_WriteTooLarge(t *testing.T) {
+	w, remote := newTestSegment()
+	defer remote.Close()
+	w.MaxFrameSize = 10
+	// 超长时不写入 net.Pipe 没有读取方时写入会阻塞
+	err := w.WriteSegmentOutputData(make([]byte, 11))
+	var tooLarge *FrameTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := w.WriteSegmentOutputData(make([]byte, 11)); !errors.As(err, &tooLarge) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestWASegment_Deadline(t *testing.T) {
+	w, remote := newTestSegment()
+	defer remote.Close()
+	w.ReadTimeout = 20 * time.Millisecond
+	w.WriteTimeout = 20 * time.Millisecond
+	if _, err := w.ReadInputSegmentData(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("read err = %v", err)
+	}
+	if err := w.WriteSegmentOutputData([]byte("hello")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("write err = %v", err)
+	}
+
+	// 取消后清除 deadline 下次读取不受影响
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := w.ReadSegment(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("read err = %v", err)
+	}
+	go remote.Write(append(writeHeaderLen(2), 'o', 'k'))
+	d, err := w.ReadSegment(context.Background())
+	if err != nil || string(d) != "ok" {
+		t.Fatalf("read %q, %v", d, err)
+	}
+}
+
+func TestWASegment_ConcurrentWrite(t *testing.T) {
+	w, remote := newTestSegment()
+	defer remote.Close()
+	const writers, size = 8, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(b byte) {
+			defer wg.Done()
+			if err := w.WriteSegmentOutputData(bytes.Repeat([]byte{b}, size)); err != nil {
+				t.Error(err)
+			}
+		}(byte(i))
+	}
+	for i := 0; i < writers; i++ {
+		header := make([]byte, frameHeaderLen)
+		if _, err := io.ReadFull(remote, header); err != nil {
+			t.Fatal(err)
+		}
+		if n := readHeaderLen(header); n != size {
+			t.Fatalf("frame %d size %d", i, n)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(remote, body); err != nil {
+			t.Fatal(err)
+		}
+		// 一帧的数据不会和其他帧交错
+		if !bytes.Equal(body, bytes.Repeat(body[:1], size)) {
+			t.Fatalf("frame %d interleaved", i)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,169 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// TransportTCP4 TransportTCP6 只使用 IPv4 或 IPv6 TransportTCP 双栈
+	TransportTCP4 = "tcp4"
+	TransportTCP6 = "tcp6"
+	TransportTCP  = "tcp"
+	// TransportWebSocket 和 web 版相同 每个 noise 帧是一个 binary message
+	TransportWebSocket = "ws"
+)
+
+// DefaultWebSocketURL TransportWebSocket 时 ServerAddr 不是 ws:// 或 wss:// 使用
+const DefaultWebSocketURL = "wss://web.whatsapp.com/ws/chat"
+
+// DefaultDialTimeout 建立连接的超时
+const DefaultDialTimeout = 20 * time.Second
+
+var ErrUnknownTransport = errors.New("unknown transport type")
+
+// Transport 建立到服务器的连接 连接上按字节流传输 noise 的帧
+type Transport interface {
+	Dial(ctx context.Context) (net.Conn, error)
+}
+
+// TCPTransport Network 为 tcp4 tcp6 或 tcp Proxy 不为空时通过代理连接
+type TCPTransport struct {
+	Network string
+	Addr    string
+	Proxy   proxy.Dialer
+}
+
+// Dial
+func (t *TCPTransport) Dial(ctx context.Context) (net.Conn, error) {
+	network := t.Network
+	if network == "" {
+		network = TransportTCP
+	}
+	if t.Proxy == nil {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, t.Addr)
+	}
+	if contextDialer, ok := t.Proxy.(proxy.ContextDialer); ok {
+		return contextDialer.DialContext(ctx, network, t.Addr)
+	}
+	return t.Proxy.Dial(network, t.Addr)
+}
+
+// WebSocketTransport
+type WebSocketTransport struct {
+	URL    string
+	Header http.Header
+	Proxy  proxy.Dialer
+}
+
+// Dial
+func (t *WebSocketTransport) Dial(ctx context.Context) (net.Conn, error) {
+	dialer := &websocket.Dialer{HandshakeTimeout: DefaultDialTimeout}
+	if t.Proxy != nil {
+		dialer.NetDial = t.Proxy.Dial
+	}
+	conn, _, err := dialer.DialContext(ctx, t.URL, t.Header)
+	if err != nil {
+		return nil, err
+	}
+	return NewWebSocketConn(conn), nil
+}
+
+// PipeTransport 内存中的连接 用于测试 每次 Dial 创建一对 net.Pipe 另一端交给 Accept
+type PipeTransport struct {
+	Accept func(c net.Conn)
+}
+
+// Dial
+func (t *PipeTransport) Dial(ctx context.Context) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	client, server := net.Pipe()
+	if t.Accept != nil {
+		go t.Accept(server)
+	}
+	return client, nil
+}
+
+// webSocketConn 把 binary message 转换成字节流 写入时每次 Write 是一个 message
+type webSocketConn struct {
+	*websocket.Conn
+	reader     io.Reader
+	readMutex  sync.Mutex
+	writeMutex sync.Mutex
+}
+
+// NewWebSocketConn
+func NewWebSocketConn(conn *websocket.Conn) net.Conn {
+	return &webSocketConn{Conn: conn}
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	for {
+		if c.reader == nil {
+			messageType, reader, err := c.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = reader
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if err := c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *webSocketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// NewTransport 按 TransportType 创建 addr 为 TransportWebSocket 时的 URL 或 TCP 的 host:port
+func NewTransport(transportType, addr string, proxyDialer proxy.Dialer) (Transport, error) {
+	switch transportType {
+	case TransportTCP4, TransportTCP6, TransportTCP:
+		return &TCPTransport{Network: transportType, Addr: addr, Proxy: proxyDialer}, nil
+	case TransportWebSocket:
+		if !strings.HasPrefix(addr, "ws://") && !strings.HasPrefix(addr, "wss://") {
+			addr = DefaultWebSocketURL
+		}
+		return &WebSocketTransport{URL: addr, Proxy: proxyDialer}, nil
+	}
+	return nil, ErrUnknownTransport
+}
@@ -0,0 +1,129 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// echoSegment 读取一帧后原样写回
+func echoSegment(t *testing.T, conn net.Conn) {
+	w := &WASegment{INetWork: &NetWork{}}
+	w.SetConn(conn)
+	defer conn.Close()
+	d, err := w.ReadInputSegmentData()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := w.WriteSegmentOutputData(d); err != nil {
+		t.Error(err)
+	}
+}
+
+// roundTrip 通过 transport 发送一帧 读取回复
+func roundTrip(t *testing.T, transport Transport, payload []byte) {
+	t.Helper()
+	conn, err := transport.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w := &WASegment{INetWork: &NetWork{}}
+	w.SetConn(conn)
+	if err := w.WriteSegmentOutputData(payload); err != nil {
+		t.Fatal(err)
+	}
+	d, err := w.ReadInputSegmentData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, payload) {
+		t.Fatalf("echo %d bytes, want %d", len(d), len(payload))
+	}
+}
+
+func TestTransport_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go echoSegment(t, conn)
+		}
+	}()
+	for _, transportType := range []string{TransportTCP4, TransportTCP} {
+		transport, err := NewTransport(transportType, listener.Addr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(t, transport, []byte(transportType))
+	}
+	// IPv4 的地址不能使用 tcp6 连接
+	transport, _ := NewTransport(TransportTCP6, listener.Addr().String(), nil)
+	if conn, err := transport.Dial(context.Background()); err == nil {
+		conn.Close()
+		t.Fatal("tcp6 dial to an IPv4 address succeeded")
+	}
+}
+
+func TestTransport_WebSocket(t *testing.T) {
+	var upgrader websocket.Upgrader
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// 头和数据分成多个 message 到达
+		_, d, err := conn.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for i := 0; i < len(d); i += 7 {
+			end := i + 7
+			if end > len(d) {
+				end = len(d)
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, d[i:end]); err != nil {
+				t.Error(err)
+				return
+			}
+			// text message 被忽略
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("ignored"))
+		}
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	transport, err := NewTransport(TransportWebSocket, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, transport, bytes.Repeat([]byte("frame"), 20))
+
+	if transport, _ := NewTransport(TransportWebSocket, DefaultServerAddr, nil); transport.(*WebSocketTransport).URL != DefaultWebSocketURL {
+		t.Fatalf("url = %s", transport.(*WebSocketTransport).URL)
+	}
+}
+
+func TestTransport_Pipe(t *testing.T) {
+	roundTrip(t, &PipeTransport{Accept: func(c net.Conn) { echoSegment(t, c) }}, []byte("pipe"))
+	if _, err := NewTransport("udp", "", nil); err != ErrUnknownTransport {
+		t.Fatalf("err = %v", err)
+	}
+}
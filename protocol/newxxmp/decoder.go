@@ -0,0 +1,364 @@
+package newxxmp
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"ws-go/protocol/iface/ixxmp"
+	"ws-go/waver"
+)
+
+const (
+	// maxDecodeDepth list 最大嵌套层数 防止恶意数据导致栈溢出
+	maxDecodeDepth = 64
+	// maxDecompressedLen 解压后的最大长度 防止压缩炸弹
+	maxDecompressedLen = 1 << 24
+)
+
+var (
+	// 解码错误 可以使用 errors.Is 判断
+	ErrTruncated      = errors.New("decoder: truncated input")
+	ErrUnknownToken   = errors.New("decoder: unknown token")
+	ErrBadListSize    = errors.New("decoder: bad list size")
+	ErrBadJIDPair     = errors.New("decoder: bad jid pair")
+	ErrBadPacked      = errors.New("decoder: bad packed bytes")
+	ErrBadNode        = errors.New("decoder: bad node")
+	ErrNestingTooDeep = errors.New("decoder: nesting too deep")
+	ErrDecompress     = errors.New("decoder: decompress failed")
+)
+
+// DecodeError 解码错误 带有出错的位置
+type DecodeError struct {
+	Err    error
+	Offset int
+	Token  byte
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v (token %d at offset %d)", e.Err, e.Token, e.Offset)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder 绑定字典的解码器
+type Decoder struct {
+	dict *Dictionary
+}
+
+// NewDecoder
+func NewDecoder(wav waver.WAVInterface) *Decoder {
+	return &Decoder{dict: NewDictionary(wav)}
+}
+
+// Decode 解码失败时返回 DecodeError 不会 panic
+func (d *Decoder) Decode(b []byte) (*Node, error) {
+	token, err := d.decodeFrame(b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(token)
+}
+
+// decodeFrame 解码带有压缩标志的数据
+func (d *Decoder) decodeFrame(b []byte) (ixxmp.IToken, error) {
+	if len(b) == 0 {
+		return nil, &DecodeError{Err: ErrTruncated}
+	}
+	switch b[0] {
+	case 0x02:
+		data, err := decompress(b[1:])
+		if err != nil {
+			return nil, &DecodeError{Err: ErrDecompress, Token: b[0]}
+		}
+		b = data
+	case 0x00:
+		b = b[1:]
+	}
+	r := newTokenReader(b, d.dict)
+	token, err := r.readToken()
+	if err != nil {
+		return nil, err
+	}
+	// 根节点之后不能有多余的数据
+	if r.index < len(r.data) {
+		return nil, r.error(ErrBadNode, r.data[r.index])
+	}
+	return token, nil
+}
+
+// tokenReader 带边界检查的读取 不会 panic
+type tokenReader struct {
+	dict  *Dictionary
+	data  []byte
+	index int
+	depth int
+}
+
+func newTokenReader(d []byte, dict *Dictionary) *tokenReader {
+	return &tokenReader{data: d, dict: dict}
+}
+
+func (d *tokenReader) error(err error, token byte) error {
+	return &DecodeError{Err: err, Offset: d.index, Token: token}
+}
+
+func (d *tokenReader) readByte() (byte, error) {
+	if d.index >= len(d.data) {
+		return 0, d.error(ErrTruncated, 0)
+	}
+	b := d.data[d.index]
+	d.index++
+	return b, nil
+}
+
+func (d *tokenReader) readBytes(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.index {
+		return nil, d.error(ErrTruncated, 0)
+	}
+	b := make([]byte, n)
+	copy(b, d.data[d.index:])
+	d.index += n
+	return b, nil
+}
+
+// readInt 读取 n 个字节的大端整数
+func (d *tokenReader) readInt(n int) (int, error) {
+	b, err := d.readBytes(n)
+	if err != nil {
+		return 0, err
+	}
+	return byteToInt(b), nil
+}
+
+// readToken 读取一个 token
+func (d *tokenReader) readToken() (ixxmp.IToken, error) {
+	token, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case 236, 237, 238, 239:
+		s, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if !d.dict.hasSecondary(int(s) + int(token-236)*256) {
+			return nil, d.error(ErrUnknownToken, token)
+		}
+		secondary := NewSecondaryToken(token, s)
+		secondary.dict = d.dict
+		return secondary, nil
+	case 247, 250:
+		return d.readJID(token)
+	case 248:
+		length, err := d.readInt(1)
+		if err != nil {
+			return nil, err
+		}
+		list := NewShortArray(token)
+		list.length = length
+		return list, d.readList(token, length, list)
+	case 249:
+		length, err := d.readInt(2)
+		if err != nil {
+			return nil, err
+		}
+		list := NewLongArray(token)
+		list.length = length
+		return list, d.readList(token, length, list)
+	case 251, 255:
+		data, err := d.readPacked(token)
+		if err != nil {
+			return nil, err
+		}
+		if token == 251 {
+			return PackedHex(data), nil
+		}
+		return PackedNibble(data), nil
+	case 252:
+		length, err := d.readInt(1)
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.readBytes(length)
+		if err != nil {
+			return nil, err
+		}
+		return Int8LengthArray(data), nil
+	case 253:
+		length, err := d.readInt(3)
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.readBytes(length & 0xFFFFF)
+		if err != nil {
+			return nil, err
+		}
+		return Int24LengthArray(data), nil
+	case 254:
+		length, err := d.readInt(4)
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.readBytes(length & 0x7FFFFFFF)
+		if err != nil {
+			return nil, err
+		}
+		return Int32LengthArray(data), nil
+	default:
+		if !d.dict.hasPrimary(token) {
+			return nil, d.error(ErrUnknownToken, token)
+		}
+		return &xToken{token: token, dict: d.dict}, nil
+	}
+}
+
+// readList 读取 list 中的每一项
+func (d *tokenReader) readList(token byte, length int, list ixxmp.ITokenList) error {
+	// 每一项至少占一个字节
+	if length > len(d.data)-d.index {
+		return d.error(ErrBadListSize, token)
+	}
+	if d.depth >= maxDecodeDepth {
+		return d.error(ErrNestingTooDeep, token)
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	for i := 0; i < length; i++ {
+		item, err := d.readToken()
+		if err != nil {
+			return err
+		}
+		list.AddItem(item)
+	}
+	return nil
+}
+
+// readJID user 和 server 都必须是字符串 不能嵌套 jid
+func (d *tokenReader) readJID(token byte) (ixxmp.IToken, error) {
+	offset := d.index
+	user, err := d.readToken()
+	if err != nil {
+		return nil, err
+	}
+	server, err := d.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if !isJIDPart(user) || !isJIDPart(server) {
+		return nil, &DecodeError{Err: ErrBadJIDPair, Offset: offset, Token: token}
+	}
+	return JabberId(user, server), nil
+}
+
+// readPacked 解码 packed hex 和 packed nibble
+func (d *tokenReader) readPacked(token byte) ([]byte, error) {
+	header, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	packed, err := d.readBytes(int(header & 127))
+	if err != nil {
+		return nil, err
+	}
+	length := len(packed) << 1
+	if header&128 != 0 {
+		length--
+	}
+	if length < 0 {
+		return nil, d.error(ErrBadPacked, token)
+	}
+	data := make([]byte, length)
+	for i := 0; i < length; i++ {
+		shift := (1 - (i % 2)) << 2
+		nibble := (packed[i>>1] >> shift) & 15
+		switch {
+		case nibble <= 9:
+			data[i] = '0' + nibble
+		case token == 251 && nibble <= 15:
+			data[i] = 'A' + nibble - 10
+		case token == 255 && nibble <= 11:
+			data[i] = '-' + nibble - 10
+		default:
+			return nil, d.error(ErrBadPacked, token)
+		}
+	}
+	return data, nil
+}
+
+// decodeNode 将 list 转换为 node
+func decodeNode(token ixxmp.IToken) (*Node, error) {
+	list, ok := token.(ixxmp.ITokenList)
+	if !ok {
+		return nil, &DecodeError{Err: ErrBadNode, Token: token.GetTokenByte()}
+	}
+	items := list.GetItems()
+	size := len(items)
+	if size == 0 {
+		return nil, &DecodeError{Err: ErrBadListSize, Token: token.GetTokenByte()}
+	}
+	for i := 0; i < size-1+size%2; i++ {
+		if !isStringToken(items[i]) {
+			return nil, &DecodeError{Err: ErrBadNode, Token: items[i].GetTokenByte()}
+		}
+	}
+
+	node := &Node{TagToken: items[0], Tag: items[0].GetTokenString()}
+	for i := 1; i+1 < size; i += 2 {
+		node.Attributes = append(node.Attributes, NewAttribute(items[i].GetTokenString(), items[i+1].GetTokenString()))
+	}
+	if size%2 == 1 {
+		return node, nil
+	}
+
+	content := items[size-1]
+	if children, ok := content.(ixxmp.ITokenList); ok {
+		for _, child := range children.GetItems() {
+			childNode, err := decodeNode(child)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, childNode)
+		}
+	} else {
+		node.Data = content.GetTokenBytes()
+	}
+	return node, nil
+}
+
+// isStringToken 可以作为字符串使用的 token
+func isStringToken(token ixxmp.IToken) bool {
+	if token == nil {
+		return false
+	}
+	_, ok := token.(ixxmp.ITokenList)
+	return !ok
+}
+
+func isJIDPart(token ixxmp.IToken) bool {
+	if _, ok := token.(*jabberId); ok {
+		return false
+	}
+	return isStringToken(token)
+}
+
+func decompress(d []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(d))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxDecompressedLen+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDecompressedLen {
+		return nil, ErrDecompress
+	}
+	return data, nil
+}
@@ -0,0 +1,243 @@
+package newxxmp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"ws-go/waver"
+)
+
+// iqHex 服务器发送的 iq 使用 WA41 字典
+const iqHex = "f8081106fa0003051c04fc023063f801f8025cf801f806170cfaff068869269324910309fc0a31363135353331343333f805f8029afc047ed158fef80205fc0105f8029bfc20e5a3b222f86ee11608b7533980e082105142c54ba0a7c18c22fd8e36102f194bf802cff803f80204fc0300000cf80228fc2041fe9862fc29bc89476b63f85e5d57f62c3967e859dfbd096d88232017d76426f802cefc40f6c2f08f280d14d85e5a5796b9e7a3b7ef983704877fc403bfc9de41f0a81e0333e44bfc5e027a396ca8a1d763e80def76c9455fa80e158c14f5cc752e937704f8028df802f80204fc03086a96f80228fc201dc299cea0dd671b153eb8a031e9feec70c4edd0b9f517cf86df6525a468f95c"
+
+func mustHex(t testing.TB, s string) []byte {
+	t.Helper()
+	d, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDecoder_Decode(t *testing.T) {
+	n, err := NewDecoder(waver.NewWA41()).Decode(append([]byte{0}, mustHex(t, iqHex)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.GetTag() != "iq" {
+		t.Fatalf("tag = %s, want iq", n.GetTag())
+	}
+	if len(n.GetChildren()) == 0 {
+		t.Fatal("iq without children")
+	}
+}
+
+func TestDecoder_Errors(t *testing.T) {
+	decoder := NewDecoder(waver.NewWA42())
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"Empty", "", ErrTruncated},
+		{"OnlyFlag", "00", ErrTruncated},
+		{"ShortListWithoutSize", "00f8", ErrTruncated},
+		{"ListSizeTooLarge", "00f80511", ErrBadListSize},
+		{"LongListSizeTooLarge", "00f9ffff11", ErrBadListSize},
+		{"EmptyNode", "00f800", ErrBadListSize},
+		{"UnknownToken", "00f801f0", ErrUnknownToken},
+		{"Int8Truncated", "00f801fc0561", ErrTruncated},
+		{"Int24Truncated", "00f801fd0fffff", ErrTruncated},
+		{"Int32Truncated", "00f801fe7fffffff", ErrTruncated},
+		{"JIDTruncated", "00f801fa03", ErrTruncated},
+		{"JIDWithList", "00f801faf8010303", ErrBadJIDPair},
+		{"NestedJID", "00f801fa03fa0303", ErrBadJIDPair},
+		{"PackedTruncated", "00f801ff05", ErrTruncated},
+		{"PackedEmptyOdd", "00f801ff80", ErrBadPacked},
+		{"BadNibble", "00f801ff01cc", ErrBadPacked},
+		{"TagIsList", "00f801f80111", ErrBadNode},
+		{"AttributeIsList", "00f8031104f80111", ErrBadNode},
+		{"ChildIsNotList", "00f80211f80106", ErrBadNode},
+		{"RootIsNotList", "0011", ErrBadNode},
+		{"TrailingData", "00f8011111", ErrBadNode},
+		{"BadCompression", "02f801", ErrDecompress},
+		{"TooDeep", "00" + repeat("f801", maxDecodeDepth+1) + "11", ErrNestingTooDeep},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := decoder.Decode(mustHex(t, tt.data))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if n != nil {
+				t.Fatalf("node = %v, want nil", n)
+			}
+			var decodeError *DecodeError
+			if !errors.As(err, &decodeError) {
+				t.Fatalf("err %T is not *DecodeError", err)
+			}
+		})
+	}
+}
+
+func TestDecoder_TrailingData(t *testing.T) {
+	d := append([]byte{0}, mustHex(t, iqHex)...)
+	_, err := NewDecoder(waver.NewWA41()).Decode(append(d, 0x11, 0x11))
+	var decodeError *DecodeError
+	if !errors.As(err, &decodeError) || !errors.Is(err, ErrBadNode) {
+		t.Fatalf("err = %v, want ErrBadNode", err)
+	}
+	// 位置为根节点之后的第一个字节 不包含压缩标志
+	if decodeError.Offset != len(d)-1 || decodeError.Token != 0x11 {
+		t.Fatalf("offset = %d token = %#x, want %d 0x11", decodeError.Offset, decodeError.Token, len(d)-1)
+	}
+}
+
+func TestDecoder_UnknownToken(t *testing.T) {
+	// 字典为空时所有 token 都是未知的
+	if _, err := NewDecoder(nil).Decode(mustHex(t, "00f80111")); !errors.Is(err, ErrUnknownToken) {
+		t.Fatalf("err = %v, want ErrUnknownToken", err)
+	}
+}
+
+func TestDecoder_Truncated(t *testing.T) {
+	decoder := NewDecoder(waver.NewWA41())
+	d := mustHex(t, iqHex)
+	// 任意截断都必须返回错误
+	for i := 0; i < len(d); i++ {
+		if _, err := decoder.Decode(append([]byte{0}, d[:i]...)); err == nil {
+			t.Fatalf("decode %d bytes without error", i)
+		}
+	}
+}
+
+func TestNode_GetData(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want []byte
+	}{
+		{nil, nil},
+		{[]byte{}, []byte{}},
+		{[]byte{0xfc}, []byte{0xfc}},
+		{[]byte{0xfc, 0x01, 0x61}, []byte{0x61}},
+		{[]byte{0xfd, 0x00}, []byte{0xfd, 0x00}},
+		{[]byte{0xfd, 0x00, 0x00, 0x01, 0x61}, []byte{0x61}},
+		{[]byte{0xfe, 0x00, 0x00, 0x00, 0x01, 0x61}, []byte{0x61}},
+		{[]byte{0x61}, []byte{0x61}},
+	}
+	for _, tt := range tests {
+		n := &Node{Data: tt.data}
+		if got := n.GetData(); !bytes.Equal(got, tt.want) {
+			t.Errorf("GetData(%x) = %x, want %x", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	codec := NewCodec(waver.NewWA42())
+	tests := []*Node{
+		EmptyNode("iq", Attributes{
+			NewAttribute("id", "1"),
+			NewAttribute("type", "get"),
+			NewAttribute("to", "s.whatsapp.net"),
+			NewAttribute("from", "8613800000000@s.whatsapp.net"),
+		}, EmptyNode("ping")),
+		EmptyNode("message", Attributes{
+			NewAttribute("to", "abc@g.us"),
+			NewAttribute("notify", "a@b@c"),
+		}, EmptyNode("enc", []byte("hello"))),
+		EmptyNode("media", EmptyNode("data", bytes.Repeat([]byte{1}, 300))),
+		EmptyNode("unknown-tag", Attributes{NewAttribute("", "")}),
+	}
+	for _, want := range tests {
+		assertRoundTrip(t, codec, want)
+	}
+}
+
+// assertRoundTrip 编码后再解码 结果必须一致
+// 解码后的 Data 带有长度头 需要使用 GetData 比较
+func assertRoundTrip(t testing.TB, codec *Codec, want *Node) {
+	t.Helper()
+	d, err := codec.Encode(want)
+	if err != nil {
+		t.Fatalf("encode %s: %v", want.GetString(), err)
+	}
+	got, err := codec.Decode(d)
+	if err != nil {
+		t.Fatalf("decode %s: %v", want.GetString(), err)
+	}
+	if !equalNode(got, want) {
+		t.Fatalf("round trip = %s, want %s", got.GetString(), want.GetString())
+	}
+}
+
+// equalNode a 是解码得到的 node b 是原始 node
+func equalNode(a, b *Node) bool {
+	if a.Tag != b.Tag || len(a.Attributes) != len(b.Attributes) || len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i := range a.Attributes {
+		if a.Attributes[i].Key() != b.Attributes[i].Key() || a.Attributes[i].Value() != b.Attributes[i].Value() {
+			return false
+		}
+	}
+	if !bytes.Equal(a.GetData(), b.Data) {
+		return false
+	}
+	for i := range a.Children {
+		if !equalNode(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func repeat(s string, n int) string {
+	buffer := bytes.Buffer{}
+	for i := 0; i < n; i++ {
+		buffer.WriteString(s)
+	}
+	return buffer.String()
+}
+
+func TestCodec_Isolation(t *testing.T) {
+	// 不同版本的字典互不影响
+	wa41, wa42 := NewCodec(waver.NewWA41()), NewCodec(waver.NewWA42())
+	n := EmptyNode("iq", Attributes{NewAttribute("type", "get")}, EmptyNode("ping"))
+	d41, err := wa41.Encode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d42, err := wa42.Encode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := wa41.Decode(d41); err != nil || !equalNode(got, n) {
+		t.Fatalf("wa41 decode = %v, %v", got, err)
+	}
+	if got, err := wa42.Decode(d42); err != nil || !equalNode(got, n) {
+		t.Fatalf("wa42 decode = %v, %v", got, err)
+	}
+	if bytes.Equal(d41, d42) {
+		t.Fatal("wa41 and wa42 should use different tokens")
+	}
+	// 解码得到的 token 使用解码器自己的字典
+	got, err := wa41.Decode(d41)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TagToken.GetTokenString() != "iq" {
+		t.Fatalf("tag token = %s, want iq", got.TagToken.GetTokenString())
+	}
+}
+
+func TestEncoder_NilNode(t *testing.T) {
+	encoder := NewEncoder(waver.NewWA42())
+	if _, err := encoder.Encode(nil); err != EncoderErrNilPointer {
+		t.Fatalf("err = %v, want EncoderErrNilPointer", err)
+	}
+	if _, err := encoder.Encode(EmptyNode("iq", Nodes{nil})); err != EncoderErrNilPointer {
+		t.Fatalf("err = %v, want EncoderErrNilPointer", err)
+	}
+}
@@ -0,0 +1,100 @@
+package newxxmp
+
+import (
+	"strings"
+	"ws-go/protocol/iface/ixxmp"
+	"ws-go/waver"
+)
+
+// Encoder 绑定字典的编码器
+type Encoder struct {
+	dict *Dictionary
+}
+
+// NewEncoder
+func NewEncoder(wav waver.WAVInterface) *Encoder {
+	return &Encoder{dict: NewDictionary(wav)}
+}
+
+// Encode 编码 node 数据带有不压缩的标志位
+func (e *Encoder) Encode(n *Node) ([]byte, error) {
+	tokenList, err := e.tokenArray(n)
+	if err != nil {
+		return nil, err
+	}
+	return tokenList.GetBytes(true), nil
+}
+
+// tokenArray
+func (e *Encoder) tokenArray(n *Node) (ixxmp.ITokenList, error) {
+	if n == nil {
+		return nil, EncoderErrNilPointer
+	}
+	attributeCount := len(n.Attributes) << 1 // attributeCount * 2
+	size := attributeCount + 1
+	if len(n.Data) != 0 || len(n.Children) != 0 {
+		size++
+	}
+	var tokenList ixxmp.ITokenList
+	if size < 256 {
+		tokenList = ShortArray()
+	} else {
+		tokenList = LongArray()
+	}
+	// write tag
+	tokenList.AddItem(e.writeString(n.Tag, false))
+	// write attributes
+	for _, attribute := range n.Attributes {
+		tokenList.AddItem(e.writeString(attribute.Key(), false))
+		tokenList.AddItem(e.writeString(attribute.Value(), false))
+	}
+	// write data
+	if len(n.Data) != 0 {
+		tokenList.AddItem(e.writeBytes(n.Data, false))
+	} else if len(n.Children) > 0 {
+		var childList ixxmp.ITokenList
+		if len(n.Children) < 256 {
+			childList = ShortArray()
+		} else {
+			childList = LongArray()
+		}
+		for _, child := range n.Children {
+			childTokenList, err := e.tokenArray(child)
+			if err != nil {
+				return nil, err
+			}
+			childList.AddItem(childTokenList.(ixxmp.IToken))
+		}
+		tokenList.AddItem(childList.(ixxmp.IToken))
+	}
+	return tokenList, nil
+}
+
+func (e *Encoder) writeBytes(d []byte, z bool) ixxmp.IToken {
+	length := len(d)
+	if length >= 1048576 {
+		return Int32LengthArray(d)
+	} else if length >= 256 {
+		return Int24LengthArray(d)
+	}
+	// 数据小于 256位 只有数字和 - . 可以压缩
+	if z && packBytes(255, d) != nil {
+		return PackedNibble(d)
+	}
+	return Int8LengthArray(d)
+}
+
+func (e *Encoder) writeString(s string, z bool) ixxmp.IToken {
+	// dictionary
+	if token := e.dict.stringToken(s); token != nil {
+		return token
+	}
+	// jid
+	if strings.Count(s, "@") == 1 {
+		jid := strings.Split(s, "@")
+		user := e.writeString(jid[0], true)
+		server := e.writeString(jid[1], false)
+		return JabberId(user, server)
+	}
+	return e.writeBytes([]byte(s), z)
+}
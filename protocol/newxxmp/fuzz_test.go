@@ -0,0 +1,55 @@
+//go:build go1.18
+// +build go1.18
+
+package newxxmp
+
+import (
+	"testing"
+	"ws-go/waver"
+)
+
+// go test -run '^$' -fuzz FuzzDecoderDecode ./protocol/newxxmp
+// 发现的用例保存在 testdata/fuzz/FuzzDecoderDecode
+func FuzzDecoderDecode(f *testing.F) {
+	decoder := NewDecoder(waver.NewWA42())
+	f.Add(append([]byte{0}, mustHex(f, iqHex)...))
+	f.Add(mustHex(f, "00f80111"))
+	f.Add(mustHex(f, "00f8031104f80111"))
+	f.Add(mustHex(f, "00f801fa03fa0303"))
+	f.Add(mustHex(f, "00f801ff01cc"))
+	f.Add(mustHex(f, "02f801"))
+	f.Fuzz(func(t *testing.T, d []byte) {
+		n, err := decoder.Decode(d)
+		if err != nil {
+			if n != nil {
+				t.Fatalf("node %s with error %v", n.GetString(), err)
+			}
+			return
+		}
+		// 解码成功的 node 可以正常使用
+		_ = n.GetString()
+		_ = n.GetData()
+		for _, child := range n.GetChildren() {
+			_ = child.GetData()
+		}
+	})
+}
+
+// go test -run '^$' -fuzz FuzzNodeRoundTrip ./protocol/newxxmp
+func FuzzNodeRoundTrip(f *testing.F) {
+	codec := NewCodec(waver.NewWA42())
+	f.Add("iq", "to", "s.whatsapp.net", "ping", []byte(nil))
+	f.Add("message", "from", "8613800000000@s.whatsapp.net", "enc", []byte("hello"))
+	f.Add("receipt", "participant", "123-456@g.us", "", []byte{0xfc, 0x01})
+	f.Add("", "", "", "", []byte{})
+	f.Add("notification", "notify", "a@b@c", "item", make([]byte, 300))
+	f.Fuzz(func(t *testing.T, tag, key, value, child string, data []byte) {
+		n := EmptyNode(tag, Attributes{NewAttribute(key, value)})
+		if child != "" {
+			n.Children.AddNode(EmptyNode(child, data))
+		} else {
+			n.Data = data
+		}
+		assertRoundTrip(t, codec, n)
+	})
+}
@@ -2,9 +2,7 @@ package newxxmp
 
 import (
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"log"
 	"strings"
 	"ws-go/protocol/iface/ixxmp"
 )
@@ -74,7 +72,7 @@ func (n *Node) GetChildren() []*Node {
 	return n.Children
 }
 func (n *Node) GetChildrenIndex(i int) *Node {
-	if n.Children == nil || len(n.Children) < i {
+	if i < 0 || len(n.Children) <= i {
 		return nil
 	}
 	return n.Children[i]
@@ -108,167 +106,18 @@ func (n *Node) SetData(d []byte) {
 }
 func (n *Node) GetData() []byte {
 	// 需要进行截断
-	if n.Data[0] == 0xfc {
+	if len(n.Data) >= 2 && n.Data[0] == 0xfc {
 		return n.Data[2:]
 	}
-	if n.Data[0] == 0xfd {
+	if len(n.Data) >= 4 && n.Data[0] == 0xfd {
 		return n.Data[4:]
 	}
-	return n.Data
-}
-func (n *Node) From(list ixxmp.IToken) *Node {
-	var tokenList ixxmp.ITokenList
-
-	if t, ok := list.(ixxmp.ITokenList); ok {
-		tokenList = t
-	}
-
-	if tokenList == nil {
-		return nil
-	}
-	offset := 0
-	node := &Node{}
-	items := tokenList.GetItems()
-	size := len(items)
-
-	if items[offset].GetTokenByte() == 0xf8 {
-
-	}
-
-	node.TagToken = items[offset]
-	node.Tag = node.TagToken.GetTokenString()
-	offset++
-
-	attributeCount := (size - 2 + size%2) / 2
-	for i := 0; i < attributeCount; i++ {
-		k := items[offset].GetTokenString()
-		offset++
-		if items[offset] != nil {
-			v := items[offset].GetTokenString()
-			offset++
-			node.Attributes = append(node.Attributes, NewAttribute(k, v))
-		}
-	}
-
-	if size%2 == 1 {
-		return node
-	}
-
-	child := items[offset]
-	if child == nil {
-		return node
-	}
-	if childTokenList, ok := child.(ixxmp.ITokenList); ok {
-		for _, token := range childTokenList.GetItems() {
-			node.Children = append(node.Children, n.From(token))
-		}
-	} else {
-		node.Data = items[offset].GetTokenBytes()
-	}
-	return node
-}
-func (n *Node) GetTokenArray() ixxmp.ITokenList {
-	defer func() {
-		if r := recover(); r != nil {
-			//打印错误堆栈信息
-			log.Printf("GetTokenArray panic: %v\n", r)
-		}
-	}()
-	size := 0
-	if n == nil {
-		return nil
-	}
-	if n.Data != nil {
-		size++
-	}
-	if size <= 1 {
-		attributes := n.Attributes
-		attributeCount := len(attributes)
-		if attributes != nil && len(attributes) != 0 {
-			attributeCount = attributeCount << 1 // attributeCount * 2
-		}
-		size = attributeCount + 1 + size
-		var tokenList ixxmp.ITokenList
-		if size < 256 {
-			tokenList = ShortArray()
-		} else {
-			tokenList = LongArray()
-		}
-		// write tag
-		tokenList.AddItem(n.writeString(n.Tag, false))
-		// write attributes
-		if attributeCount > 0 {
-			for _, attribute := range attributes {
-				tokenList.AddItem(n.writeString(attribute.Key(), false))
-				tokenList.AddItem(n.writeString(attribute.Value(), false))
-			}
-		}
-		// write data
-		if n.Data != nil && len(n.Data) != 0 {
-			tokenList.AddItem(n.writeBytes(n.Data, false))
-		} else if n.Children != nil && len(n.Children) > 0 {
-			var childList ixxmp.ITokenList
-			if len(n.Children) < 256 {
-				childList = ShortArray()
-			} else {
-				childList = LongArray()
-			}
-			for _, child := range n.Children {
-				childList.AddItem(child.GetTokenArray().(ixxmp.IToken))
-			}
-			tokenList.AddItem(childList.(ixxmp.IToken))
-		}
-		return tokenList
-	}
-	return nil
-}
-func (n *Node) writeBytes(d []byte, z bool) ixxmp.IToken {
-	length := len(d)
-	if length >= 256 {
-		return Int24LengthArray(d)
-	} else if length >= 1048576 {
-		return Int32LengthArray(d)
-	} else { // 数据小于 256位
-		if z {
-			nibble := PackedNibble(d)
-			if nibble != nil {
-				return nibble
-			}
-			h := PackedHex(d)
-			if h != nil {
-				return JoinToken(h, Int8LengthArray2(h.data, length))
-			}
-		}
-		return Int8LengthArray(d)
+	if len(n.Data) >= 5 && n.Data[0] == 0xfe {
+		return n.Data[5:]
 	}
+	return n.Data
 }
-func (n *Node) writeString(s string, z bool) ixxmp.IToken {
-	if len(dictionary) == 0 || len(secondaryDictionary) == 0 {
-		panic(errors.New("dictionary or secondaryDictionary error"))
-	}
-	// dictionary
-	for i := 0; i < len(dictionary); i++ {
-		if strings.Compare(s, dictionary[i]) == 0 {
-			return NewToken(byte(i))
-		}
-	}
-	// secondaryDictionary
-	for i := 0; i < len(secondaryDictionary); i++ {
-		if strings.Compare(s, secondaryDictionary[i]) == 0 {
-			return SecondaryToken(i)
-		}
-	}
 
-	// jid
-	if strings.Contains(s, "@") {
-		jid := strings.Split(s, "@")
-		user := n.writeString(jid[0], true)
-		server := n.writeString(jid[1], false)
-		return JabberId(user, server)
-	}
-
-	return n.writeBytes([]byte(s), z)
-}
 func (n *Node) GetString(index ...*int) string {
 	builder := strings.Builder{}
 
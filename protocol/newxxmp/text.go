@@ -0,0 +1,501 @@
+package newxxmp
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 文本格式 类似 XML 方便调试和编写测试
+//
+//	<iq id="1" type="get" xmlns="w:p">
+//	    <ping/>
+//	</iq>
+//
+// 可打印的数据直接输出文本 其他数据输出为 0x 开头的 hex
+// 如果数据是 protobuf 会在后面加上 <!-- pb ... --> 注释 解析时忽略注释
+
+// ErrSyntax 文本格式错误 可以使用 errors.Is 判断
+var ErrSyntax = errors.New("text: syntax error")
+
+// ParseError 解析错误 带有出错的位置
+type ParseError struct {
+	Msg    string
+	Offset int
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v: %s at offset %d", ErrSyntax, e.Msg, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return ErrSyntax
+}
+
+// hexPrefix 二进制数据的前缀
+const hexPrefix = "0x"
+
+// FormatNode 输出带缩进的文本
+func FormatNode(n *Node) string {
+	builder := strings.Builder{}
+	formatNode(&builder, n, 0)
+	return builder.String()
+}
+
+// String 实现 fmt.Stringer
+func (n *Node) String() string {
+	return FormatNode(n)
+}
+
+func formatNode(builder *strings.Builder, n *Node, depth int) {
+	tabs := strings.Repeat("    ", depth)
+	builder.WriteString(tabs)
+	if n == nil {
+		builder.WriteString("<!-- nil -->")
+		return
+	}
+	builder.WriteByte('<')
+	builder.WriteString(n.Tag)
+	for _, attribute := range n.Attributes {
+		builder.WriteByte(' ')
+		builder.WriteString(attribute.Key())
+		builder.WriteString(`="`)
+		builder.WriteString(escapeText(attribute.Value(), true))
+		builder.WriteByte('"')
+	}
+	data := n.content()
+	switch {
+	case len(data) != 0:
+		builder.WriteByte('>')
+		formatData(builder, data)
+	case len(n.Children) != 0:
+		builder.WriteString(">\n")
+		for _, child := range n.Children {
+			formatNode(builder, child, depth+1)
+			builder.WriteByte('\n')
+		}
+		builder.WriteString(tabs)
+	default:
+		builder.WriteString("/>")
+		return
+	}
+	builder.WriteString("</")
+	builder.WriteString(n.Tag)
+	builder.WriteByte('>')
+}
+
+// content 解码得到的 node 数据带有长度头 需要去掉
+func (n *Node) content() []byte {
+	if n.TagToken != nil {
+		return n.GetData()
+	}
+	return n.Data
+}
+
+func formatData(builder *strings.Builder, d []byte) {
+	if isPrintable(d) {
+		builder.WriteString(escapeText(string(d), false))
+		return
+	}
+	builder.WriteString(hexPrefix)
+	builder.WriteString(hex.EncodeToString(d))
+	if pb, ok := formatProtobuf(d, 0); ok {
+		builder.WriteString("<!-- pb ")
+		builder.WriteString(pb)
+		builder.WriteString(" -->")
+	}
+}
+
+// isPrintable 可以直接作为文本输出 并且解析后不会改变
+func isPrintable(d []byte) bool {
+	if len(d) == 0 || !utf8.Valid(d) || strings.HasPrefix(string(d), hexPrefix) {
+		return false
+	}
+	s := string(d)
+	if strings.TrimSpace(s) != s || strings.Contains(s, "<!--") {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && r != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+func escapeText(s string, attribute bool) string {
+	builder := strings.Builder{}
+	for _, r := range s {
+		switch {
+		case r == '&':
+			builder.WriteString("&amp;")
+		case r == '<':
+			builder.WriteString("&lt;")
+		case r == '>':
+			builder.WriteString("&gt;")
+		case r == '"' && attribute:
+			builder.WriteString("&quot;")
+		case r != utf8.RuneError && r < 0x20:
+			builder.WriteString(fmt.Sprintf("&#x%x;", r))
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// maxProtobufDepth protobuf 注释最多展开的层数
+const maxProtobufDepth = 4
+
+// formatProtobuf 按 protobuf wire 格式解析 不是 protobuf 时返回 false
+func formatProtobuf(d []byte, depth int) (string, bool) {
+	if len(d) == 0 || depth >= maxProtobufDepth {
+		return "", false
+	}
+	var fields []string
+	for index := 0; index < len(d); {
+		key, n := readVarint(d[index:])
+		if n == 0 {
+			return "", false
+		}
+		index += n
+		field, wireType := key>>3, key&7
+		if field == 0 {
+			return "", false
+		}
+		var value string
+		switch wireType {
+		case 0:
+			v, n := readVarint(d[index:])
+			if n == 0 {
+				return "", false
+			}
+			index += n
+			value = strconv.FormatUint(v, 10)
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(d)-index < size {
+				return "", false
+			}
+			value = hexPrefix + hex.EncodeToString(d[index:index+size])
+			index += size
+		case 2:
+			length, n := readVarint(d[index:])
+			if n == 0 || length > uint64(len(d)-index-n) {
+				return "", false
+			}
+			index += n
+			b := d[index : index+int(length)]
+			index += int(length)
+			if isPrintable(b) {
+				value = strconv.Quote(string(b))
+			} else if pb, ok := formatProtobuf(b, depth+1); ok {
+				value = pb
+			} else {
+				value = hexPrefix + hex.EncodeToString(b)
+			}
+		default:
+			return "", false
+		}
+		fields = append(fields, fmt.Sprintf("%d:%s", field, value))
+	}
+	return "{" + strings.Join(fields, " ") + "}", true
+}
+
+// readVarint 读取失败时返回的长度为 0
+func readVarint(d []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(d) && i < 10; i++ {
+		v |= uint64(d[i]&0x7f) << (7 * uint(i))
+		if d[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// ParseNode 将文本解析为 node 只允许有一个根节点
+func ParseNode(s string) (*Node, error) {
+	p := &textParser{data: s}
+	p.skipSpace()
+	n, err := p.parseNode()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.index != len(p.data) {
+		return nil, p.error("unexpected content after root")
+	}
+	return n, nil
+}
+
+// MustParseNode 解析失败时 panic 用于测试和常量
+func MustParseNode(s string) *Node {
+	n, err := ParseNode(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// textParser 解析 FormatNode 输出的文本
+type textParser struct {
+	data  string
+	index int
+	depth int
+}
+
+func (p *textParser) error(msg string) error {
+	return &ParseError{Msg: msg, Offset: p.index}
+}
+
+func (p *textParser) eof() bool {
+	return p.index >= len(p.data)
+}
+
+func (p *textParser) hasPrefix(s string) bool {
+	return strings.HasPrefix(p.data[p.index:], s)
+}
+
+// skipSpace 跳过空白和注释
+func (p *textParser) skipSpace() {
+	for !p.eof() {
+		if p.hasPrefix("<!--") {
+			end := strings.Index(p.data[p.index+4:], "-->")
+			if end < 0 {
+				return
+			}
+			p.index += 4 + end + 3
+			continue
+		}
+		if !isSpace(p.data[p.index]) {
+			return
+		}
+		p.index++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isNameByte(c byte) bool {
+	return c != '=' && c != '>' && c != '/' && c != '<' && c != '"' && c != '\'' && !isSpace(c)
+}
+
+func (p *textParser) parseName() (string, error) {
+	start := p.index
+	for !p.eof() && isNameByte(p.data[p.index]) {
+		p.index++
+	}
+	if start == p.index {
+		return "", p.error("expected name")
+	}
+	return p.data[start:p.index], nil
+}
+
+func (p *textParser) expect(s string) error {
+	if !p.hasPrefix(s) {
+		return p.error(fmt.Sprintf("expected %q", s))
+	}
+	p.index += len(s)
+	return nil
+}
+
+func (p *textParser) parseNode() (*Node, error) {
+	if p.depth >= maxDecodeDepth {
+		return nil, p.error("nesting too deep")
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if err := p.expect("<"); err != nil {
+		return nil, err
+	}
+	tag, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	n := EmptyNode(tag)
+	// attributes
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.error("unexpected end in tag")
+		}
+		if p.hasPrefix("/>") {
+			p.index += 2
+			return n, nil
+		}
+		if p.hasPrefix(">") {
+			p.index++
+			break
+		}
+		key, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		value, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		n.Attributes.AddAttr(key, value)
+	}
+	// children or data
+	start := p.index
+	p.skipSpace()
+	if p.hasPrefix("<") && !p.hasPrefix("</") {
+		for {
+			child, err := p.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			n.Children.AddNode(child)
+			p.skipSpace()
+			if !p.hasPrefix("<") || p.hasPrefix("</") {
+				break
+			}
+		}
+	} else {
+		p.index = start
+		data, err := p.parseData()
+		if err != nil {
+			return nil, err
+		}
+		n.Data = data
+	}
+	if err := p.expect("</" + tag); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if err := p.expect(">"); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (p *textParser) parseQuoted() (string, error) {
+	if p.eof() || (p.data[p.index] != '"' && p.data[p.index] != '\'') {
+		return "", p.error("expected quoted value")
+	}
+	quote := p.data[p.index]
+	p.index++
+	end := strings.IndexByte(p.data[p.index:], quote)
+	if end < 0 {
+		return "", p.error("unterminated value")
+	}
+	value, err := p.unescape(p.data[p.index : p.index+end])
+	if err != nil {
+		return "", err
+	}
+	p.index += end + 1
+	return value, nil
+}
+
+// parseData 读取到结束标签为止 忽略注释
+func (p *textParser) parseData() ([]byte, error) {
+	builder := strings.Builder{}
+	for {
+		if p.eof() {
+			return nil, p.error("unexpected end in data")
+		}
+		if p.hasPrefix("<!--") {
+			end := strings.Index(p.data[p.index:], "-->")
+			if end < 0 {
+				return nil, p.error("unterminated comment")
+			}
+			p.index += end + 3
+			continue
+		}
+		if p.hasPrefix("</") {
+			break
+		}
+		if p.data[p.index] == '<' {
+			return nil, p.error("data mixed with children")
+		}
+		builder.WriteByte(p.data[p.index])
+		p.index++
+	}
+	text := strings.TrimSpace(builder.String())
+	if strings.HasPrefix(text, hexPrefix) {
+		d, err := hex.DecodeString(strings.Join(strings.Fields(text[len(hexPrefix):]), ""))
+		if err != nil {
+			return nil, p.error("bad hex data")
+		}
+		return d, nil
+	}
+	s, err := p.unescape(text)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+func (p *textParser) unescape(s string) (string, error) {
+	if !strings.Contains(s, "&") {
+		return s, nil
+	}
+	builder := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '&' {
+			builder.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i:], ';')
+		if end < 0 {
+			return "", p.error("unterminated entity")
+		}
+		entity := s[i+1 : i+end]
+		switch entity {
+		case "amp":
+			builder.WriteByte('&')
+		case "lt":
+			builder.WriteByte('<')
+		case "gt":
+			builder.WriteByte('>')
+		case "quot":
+			builder.WriteByte('"')
+		case "apos":
+			builder.WriteByte('\'')
+		default:
+			r, err := parseCharRef(entity)
+			if err != nil {
+				return "", p.error("unknown entity &" + entity + ";")
+			}
+			builder.WriteRune(r)
+		}
+		i += end
+	}
+	return builder.String(), nil
+}
+
+func parseCharRef(entity string) (rune, error) {
+	if !strings.HasPrefix(entity, "#") {
+		return 0, ErrSyntax
+	}
+	var (
+		v   uint64
+		err error
+	)
+	if strings.HasPrefix(entity, "#x") {
+		v, err = strconv.ParseUint(entity[2:], 16, 32)
+	} else {
+		v, err = strconv.ParseUint(entity[1:], 10, 32)
+	}
+	if err != nil || !utf8.ValidRune(rune(v)) {
+		return 0, ErrSyntax
+	}
+	return rune(v), nil
+}
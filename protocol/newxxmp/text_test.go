@@ -0,0 +1,169 @@
+package newxxmp
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"ws-go/waver"
+)
+
+// go test ./protocol/newxxmp -run Golden -update
+var update = flag.Bool("update", false, "update golden files")
+
+// assertGolden 比较 testdata 下的 golden 文件
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("%s mismatch\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestParseNode(t *testing.T) {
+	got, err := ParseNode(`<iq type="get" xmlns="w:p"><ping/></iq>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := EmptyNode("iq", Attributes{
+		NewAttribute("type", "get"),
+		NewAttribute("xmlns", "w:p"),
+	}, EmptyNode("ping"))
+	if !sameNode(got, want) {
+		t.Fatalf("parse = %s, want %s", got, want)
+	}
+}
+
+func TestParseNode_Data(t *testing.T) {
+	tests := []struct {
+		text string
+		want []byte
+	}{
+		{`<body>hello world</body>`, []byte("hello world")},
+		{`<body> hello </body>`, []byte("hello")},
+		{`<body>a &lt;b&gt; &amp; &#x1;</body>`, []byte("a <b> & \x01")},
+		{`<enc>0x0a0b</enc>`, []byte{0x0a, 0x0b}},
+		{`<enc>0x0a 0b<!-- pb {1:11} --></enc>`, []byte{0x0a, 0x0b}},
+		{`<enc></enc>`, []byte{}},
+	}
+	for _, tt := range tests {
+		n, err := ParseNode(tt.text)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tt.text, err)
+		}
+		if !bytes.Equal(n.Data, tt.want) {
+			t.Errorf("parse %s data = %x, want %x", tt.text, n.Data, tt.want)
+		}
+	}
+}
+
+func TestParseNode_Errors(t *testing.T) {
+	tests := []string{
+		``,
+		`iq`,
+		`<iq`,
+		`<iq type=get/>`,
+		`<iq type="get/>`,
+		`<iq><ping/>`,
+		`<iq></query>`,
+		`<iq>text<ping/></iq>`,
+		`<iq>0xzz</iq>`,
+		`<iq>&unknown;</iq>`,
+		`<iq/><iq/>`,
+		strings.Repeat("<a>", maxDecodeDepth+1),
+	}
+	for _, text := range tests {
+		n, err := ParseNode(text)
+		if !errors.Is(err, ErrSyntax) {
+			t.Errorf("parse %q err = %v, want ErrSyntax", text, err)
+		}
+		if n != nil {
+			t.Errorf("parse %q node = %s, want nil", text, n)
+		}
+	}
+}
+
+func TestFormatNode_RoundTrip(t *testing.T) {
+	tests := []*Node{
+		EmptyNode("iq"),
+		EmptyNode("message", Attributes{
+			NewAttribute("to", "8613800000000@s.whatsapp.net"),
+			NewAttribute("notify", `<"a&b">`),
+		}, EmptyNode("body", []byte("你好 world"))),
+		EmptyNode("enc", []byte{0xfc, 0x01, 0x00}),
+		EmptyNode("enc", []byte("0x12")),
+		EmptyNode("enc", []byte(" padded ")),
+		EmptyNode("enc", []byte("<!-- comment -->")),
+		EmptyNode("list", Nodes{EmptyNode("a"), EmptyNode("b", EmptyNode("c"))}),
+	}
+	for _, want := range tests {
+		text := FormatNode(want)
+		got, err := ParseNode(text)
+		if err != nil {
+			t.Fatalf("parse %s: %v", text, err)
+		}
+		if !sameNode(got, want) {
+			t.Fatalf("round trip = %s, want %s", got, text)
+		}
+	}
+}
+
+func TestFormatNode_Protobuf(t *testing.T) {
+	// {1:"abc" 2:150 3:{1:1}}
+	n := EmptyNode("enc", []byte{0x0a, 0x03, 'a', 'b', 'c', 0x10, 0x96, 0x01, 0x1a, 0x02, 0x08, 0x01})
+	want := `<enc>0x0a036162631096011a020801<!-- pb {1:"abc" 2:150 3:{1:1}} --></enc>`
+	if got := FormatNode(n); got != want {
+		t.Fatalf("format = %s, want %s", got, want)
+	}
+}
+
+func TestFormatNode_Golden(t *testing.T) {
+	n, err := NewDecoder(waver.NewWA41()).Decode(append([]byte{0}, mustHex(t, iqHex)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := FormatNode(n)
+	assertGolden(t, "iq_prekeys", text)
+	// 解析后再编码和原始数据一致
+	parsed, err := ParseNode(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FormatNode(parsed); got != text {
+		t.Fatalf("format parsed = %s, want %s", got, text)
+	}
+}
+
+// sameNode 使用去掉长度头的数据比较
+func sameNode(a, b *Node) bool {
+	if a.Tag != b.Tag || len(a.Attributes) != len(b.Attributes) || len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i := range a.Attributes {
+		if a.Attributes[i].Key() != b.Attributes[i].Key() || a.Attributes[i].Value() != b.Attributes[i].Value() {
+			return false
+		}
+	}
+	if !bytes.Equal(a.content(), b.content()) {
+		return false
+	}
+	for i := range a.Children {
+		if !sameNode(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
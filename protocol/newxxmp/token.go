@@ -2,125 +2,25 @@ package newxxmp
 
 import (
 	"bytes"
-	"errors"
-	"strconv"
 	"ws-go/protocol/iface/ixxmp"
-	"ws-go/protocol/utils"
 )
 
 type xToken struct {
 	token byte
+	// dict 解码时使用的字典
+	dict *Dictionary
 }
 
 func (t *xToken) GetTokenByte() byte {
 	return t.token
 }
 func (t *xToken) GetTokenString() string {
-	return dictionary[t.token]
+	return t.dict.primaryString(t.token)
 }
 func (t *xToken) GetTokenBytes() []byte {
 	return []byte{t.token}
 }
 
-func (t *xToken) NewFrom(b []byte) ixxmp.IToken {
-	var err error
-	if len(b) == 0 {
-		return nil
-	}
-
-	//wslog.GetLogger().Debug("token new from ", hex.EncodeToString(b))
-	token := b[0]
-	if token == 0x02 {
-		b, err = utils.ZipDecompression(b[1:])
-		if err != nil {
-			return nil
-		}
-
-	} else if token == 0x0 {
-		b = b[1:]
-	}
-	//fmt.Println("=-=---->" + hex.EncodeToString(b))
-	return t.From(bytes.NewBuffer(b))
-}
-
-func (t *xToken) From(buffer *bytes.Buffer) ixxmp.IToken {
-	if buffer == nil || buffer.Len() == 0 {
-		return nil
-	}
-	token := readToken(buffer)
-	if token == 236 || token == 237 || token == 238 || token == 239 {
-		t := readToken(buffer)
-		newSecondaryToken := NewSecondaryToken(token, t)
-		//wslog.GetLogger().Debug("newSecondaryToken", token, t, newSecondaryToken.GetTokenString())
-		return newSecondaryToken
-	} else if token == 247 {
-		u := t.From(buffer)
-		s := t.From(buffer)
-		return JabberId(u, s)
-	} else if token == 248 {
-		newShortArray := NewShortArray(token)
-		newShortArray.length = int(readToken(buffer))
-		for i := 0; i < newShortArray.length; i++ {
-			// 递归
-			newShortArray.AddItem(t.From(buffer))
-		}
-		return newShortArray
-	} else if token == 249 {
-		newLongArray := NewLongArray(token)
-		newLongArray.length = (int(readToken(buffer)) << 8) + int(readToken(buffer))
-		for i := 0; i < newLongArray.length; i++ {
-			// 递归
-			newLongArray.AddItem(t.From(buffer))
-		}
-		return newLongArray
-	} else if token == 250 {
-		u := t.From(buffer)
-		s := t.From(buffer)
-		return JabberId(u, s)
-	} else if token == 251 {
-		i := unpackBytes(int(token), buffer)
-		//wslog.GetLogger().Debug("unpackBytes :", token, hex.EncodeToString(i))
-		return PackedHex(i)
-	} else if token == 252 {
-		length := readToken(buffer)
-		data := readBytes(buffer, int(length))
-		//log.Println("Int8LengthArray", hex.EncodeToString(data))
-		return Int8LengthArray(data)
-	} else if token == 253 {
-		b := readToken(buffer)
-		b1 := readToken(buffer)
-		b2 := readToken(buffer)
-		length := ((int(b) & 0xF) << 16) + (int(b1) & 0xFF << 8) + int(b2)&0xFF
-		data := readBytes(buffer, length)
-		return Int24LengthArray(data)
-	} else if token == 254 {
-		length := (int(readToken(buffer)) << 24) | (int(readToken(buffer)) << 16) | int(readToken(buffer))<<8 | int(readToken(buffer))
-		data := readBytes(buffer, length)
-		return Int32LengthArray(data)
-	} else if token == 255 {
-		i := unpackBytes(int(token), buffer)
-		//wslog.GetLogger().Debug("unpackBytes :", token, hex.EncodeToString(i))
-		return PackedNibble(i)
-	} else {
-		newToken := NewToken(token)
-		//log.Println("newToken ", token, newToken.GetTokenString())
-		return newToken
-	}
-
-}
-func readToken(buffer *bytes.Buffer) byte {
-	b, err := buffer.ReadByte()
-	if err != nil {
-		panic(err)
-	}
-	return b
-}
-func readInt8(buffer *bytes.Buffer) int {
-	data := make([]byte, 1)
-	buffer.Write(data)
-	return byteToInt(data)
-}
-
 func byteToInt(b []byte) int {
 	mask := 0xff
 	temp := 0
@@ -133,15 +33,6 @@ func byteToInt(b []byte) int {
 	return n
 }
 
-func readBytes(buffer *bytes.Buffer, len int) []byte {
-	data := make([]byte, len)
-	_, err := buffer.Read(data)
-	if err != nil {
-		panic(err)
-	}
-	return data
-}
-
 // NewToken
 func NewToken(t byte) *xToken {
 	return &xToken{token: t}
@@ -174,7 +65,7 @@ func (s secondaryToken) GetTokenBytes() []byte {
 func (s *secondaryToken) GetTokenString() string {
 	n := s.token - 236&0xFF
 	n2 := s.secondaryToken & 0xFF
-	return secondaryDictionary[int(n2)+int(n)*256]
+	return s.dict.secondaryString(int(n2) + int(n)*256)
 }
 
 type joinToken struct {
@@ -254,6 +145,10 @@ func (i *int24LengthArray) GetTokenBytes() []byte {
 	return buffer.Bytes()
 }
 
+func (i *int24LengthArray) GetTokenString() string {
+	return string(i.data)
+}
+
 // Int24LengthArray
 func Int24LengthArray(d []byte) *int24LengthArray {
 	return &int24LengthArray{
@@ -284,6 +179,10 @@ func (i *int32LengthArray) GetTokenBytes() []byte {
 	return buffer.Bytes()
 }
 
+func (i *int32LengthArray) GetTokenString() string {
+	return string(i.data)
+}
+
 // Int32LengthArray
 func Int32LengthArray(d []byte) *int32LengthArray {
 	return &int32LengthArray{
@@ -446,47 +345,3 @@ func packBytes(i int, bArr []byte) []byte {
 
 	return bArr2
 }
-
-// unpackBytes
-func unpackBytes(i int, buffer *bytes.Buffer) []byte {
-	var i3, i2 int
-
-	token := readToken(buffer)
-	if (token & 128) != 0 {
-		i3 = 1
-	}
-	i4 := token & 127
-	bArr := make([]byte, i4)
-	_, err := buffer.Read(bArr)
-	if err != nil {
-		panic(err)
-	}
-	i5 := (int(i4) << 1) - i3
-	bArr2 := make([]byte, i5)
-	for i6 := 0; i6 < i5; i6++ {
-		i7 := (1 - (i6 % 2)) << 2
-		i8 := int((bArr[i6>>1] & (15 << i7)) >> i7)
-		if i == 251 {
-			if i8 >= 0 && i8 <= 9 {
-				i2 = i8 + 48
-			} else if i8 >= 10 && i8 <= 15 {
-				i2 = (i8 - 10) + 65
-			} else {
-				panic(errors.New("bad hex " + strconv.Itoa(i8)))
-			}
-		} else if i == 255 {
-			if i8 >= 0 && i8 <= 9 {
-				i2 = i8 + 48
-			} else if i8 == 10 || i8 == 11 {
-				i2 = (i8 - 10) + 45
-			} else {
-				panic(errors.New("bad nibble " + strconv.Itoa(i8)))
-
-			}
-		} else {
-			panic(errors.New("bad packed type " + strconv.Itoa(i)))
-		}
-		bArr2[i6] = byte(i2)
-	}
-	return bArr2
-}
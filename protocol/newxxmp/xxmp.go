@@ -2,42 +2,101 @@ package newxxmp
 
 import (
 	"errors"
-	"fmt"
+	"ws-go/protocol/iface/ixxmp"
 	"ws-go/waver"
 )
 
 var (
-	dictionary          []string
-	secondaryDictionary []string
 	//errors
 	EncoderErrNilPointer = errors.New("EncoderFail Node in null pointer exception")
 	DecoderErrException  = errors.New("Decoder Unknown error occurred")
 )
 
-// SetWAXXMPVersion 设置 WA XXMP 版本
-func SetWAXXMPVersion(wav waver.WAVInterface) {
+// maxPrimaryToken 236 以后的 token 有特殊含义
+const maxPrimaryToken = 236
+
+// Dictionary 一个 WA 版本的 token 字典
+// 每个账号使用自己的字典 不同版本的账号可以在同一个进程中运行
+type Dictionary struct {
+	primary   []string
+	secondary []string
+	// 编码时反查 token
+	primaryIndex   map[string]byte
+	secondaryIndex map[string]int
+}
+
+// NewDictionary wav 为 nil 时返回空字典
+func NewDictionary(wav waver.WAVInterface) *Dictionary {
+	d := &Dictionary{
+		primaryIndex:   make(map[string]byte),
+		secondaryIndex: make(map[string]int),
+	}
 	if wav == nil {
-		return
+		return d
+	}
+	d.primary = wav.GetWADictionary()
+	d.secondary = wav.GetSecondaryDictionary()
+	// 重复的字符串使用第一个 token
+	for i := len(d.primary) - 1; i >= 0; i-- {
+		if i < maxPrimaryToken {
+			d.primaryIndex[d.primary[i]] = byte(i)
+		}
 	}
-	dictionary = wav.GetWADictionary()
-	secondaryDictionary = wav.GetSecondaryDictionary()
+	for i := len(d.secondary) - 1; i >= 0; i-- {
+		d.secondaryIndex[d.secondary[i]] = i
+	}
+	return d
 }
 
-//XMMPDecodeNode
-func XMMPDecodeNode(d []byte) (*Node, error) {
-	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println("run web error:iToken 1", err)
-		}
-	}()
-	if len(d) <= 0 {
-		return nil, errors.New("data len <= 0")
+func (d *Dictionary) primaryString(token byte) string {
+	if d == nil || int(token) >= len(d.primary) {
+		return ""
+	}
+	return d.primary[token]
+}
+
+func (d *Dictionary) secondaryString(index int) string {
+	if d == nil || index < 0 || index >= len(d.secondary) {
+		return ""
+	}
+	return d.secondary[index]
+}
+
+func (d *Dictionary) hasPrimary(token byte) bool {
+	return d != nil && int(token) < len(d.primary)
+}
+
+func (d *Dictionary) hasSecondary(index int) bool {
+	return d != nil && index >= 0 && index < len(d.secondary)
+}
+
+// stringToken 字典中没有时返回 nil
+func (d *Dictionary) stringToken(s string) ixxmp.IToken {
+	if d == nil {
+		return nil
 	}
-	token := NewToken(0)
-	iToken := token.NewFrom(d)
-	if iToken != nil {
-		node := &Node{}
-		return node.From(iToken), nil
+	if i, ok := d.primaryIndex[s]; ok {
+		return &xToken{token: i, dict: d}
+	}
+	if i, ok := d.secondaryIndex[s]; ok {
+		token := SecondaryToken(i)
+		token.dict = d
+		return token
+	}
+	return nil
+}
+
+// Codec 使用同一个字典的编码器和解码器 每个连接一个
+type Codec struct {
+	*Encoder
+	*Decoder
+}
+
+// NewCodec
+func NewCodec(wav waver.WAVInterface) *Codec {
+	dict := NewDictionary(wav)
+	return &Codec{
+		Encoder: &Encoder{dict: dict},
+		Decoder: &Decoder{dict: dict},
 	}
-	return nil, errors.New("iToken is nil")
 }
@@ -32,8 +32,11 @@ func (b *BaseNode) SuccessNotice(any promise.Any) {
 }
 
 // Builder build node to ixxmp data
-func (b *BaseNode) Builder() ([]byte, error) {
-	xxmpData := b.GetTokenArray().GetBytes(true)
+func (b *BaseNode) Builder(encoder *newxxmp.Encoder) ([]byte, error) {
+	xxmpData, err := encoder.Encode(b.Node)
+	if err != nil {
+		return nil, err
+	}
 	log.Println("PresenceNode builder node ", b.Node.GetString())
 	return xxmpData, nil
 }
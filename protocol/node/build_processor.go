@@ -1,19 +1,36 @@
 package node
 
 import (
+	"errors"
 	"github.com/gogf/gf/container/gqueue"
 	"io"
 	"log"
+	"sync"
 	_interface "ws-go/protocol/iface"
+	"ws-go/protocol/newxxmp"
 )
 
+// ErrProcessorClosed 关闭后发送的 node 会被拒绝
+var ErrProcessorClosed = errors.New("node processor closed")
+
+// sentNotifier 没有 promise 的 node 需要知道是否写入连接 例如发件箱中的消息
+type sentNotifier interface {
+	sent(err error)
+}
+
 type processor struct {
-	close         bool
+	// close 发送线程正在处理 sendQueue 并且队列还没有关闭 和 stopped sendQueue 一样使用 mutex
+	close bool
+	// stopped 调用 Close 后不再接收新的 node 重新连接时 Reopen
+	stopped       bool
+	mutex         sync.Mutex
 	segmentOutput _interface.SegmentOutputProcessor
 	sendQueue     *gqueue.Queue
+	// encoder 当前账号的编码器
+	encoder *newxxmp.Encoder
 }
 
-func Processor(s _interface.SegmentOutputProcessor) *processor {
+func Processor(s _interface.SegmentOutputProcessor, encoder *newxxmp.Encoder) *processor {
 	defer func() {
 		if r := recover(); r != nil {
 			//打印错误堆栈信息
@@ -23,17 +40,20 @@ func Processor(s _interface.SegmentOutputProcessor) *processor {
 	p := &processor{
 		segmentOutput: s,
 		sendQueue:     gqueue.New(10),
+		encoder:       encoder,
+		close:         true,
 	}
 
 	// run
-	go func() {
-		p.runSendQueue()
-	}()
+	go p.runSendQueue(p.sendQueue)
 	return p
 }
 
 // Close
 func (p *processor) Close() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stopped = true
 	if p.close {
 		p.sendQueue.Close()
 		p.close = false
@@ -41,10 +61,30 @@ func (p *processor) Close() bool {
 	return true
 }
 
+// Reopen Close 之后重新连接时创建新的发送队列
+func (p *processor) Reopen() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.stopped {
+		return
+	}
+	p.stopped = false
+	p.sendQueue = gqueue.New(10)
+	// 在启动线程前设置 线程开始前调用 Close 也会关闭这个队列
+	p.close = true
+	go p.runSendQueue(p.sendQueue)
+}
+
 // SendBuilder
 func (p *processor) SendBuilder(b _interface.NodeBuilder) {
-	// Confirm whether the queue is closed and build
-	if !p.close && b == nil {
+	if b == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	// 队列已经关闭 直接通知失败
+	if p.stopped {
+		p.rejectBuilder(b, ErrProcessorClosed)
 		return
 	}
 	p.sendQueue.Push(b)
@@ -55,30 +95,37 @@ func (p *processor) SendData(d []byte) error {
 	return p.segmentOutput.WriteSegmentOutputData(d)
 }
 
-// runSendChan
-func (p *processor) runSendQueue() {
+// runSendChan Reopen 会替换 sendQueue 只处理开始时的队列
+func (p *processor) runSendQueue(sendQueue *gqueue.Queue) {
 	defer func() {
 		if r := recover(); r != nil {
 			//打印错误堆栈信息
 			log.Printf("runSendQueue panic: %v\n", r)
 		}
 	}()
-	p.close = true
+	// 退出时关闭队列 之后发送的 node 直接失败
+	defer p.exitSendQueue(sendQueue)
 
 	for {
-		v := p.sendQueue.Pop()
+		v := sendQueue.Pop()
 		if v == nil {
-			if p.close {
-				p.Close()
-			}
 			// 退出队列
 			log.Println("Exit run send queue")
 			break
 		} else {
 			if builder, ok := v.(_interface.NodeBuilder); ok {
-				nodeData, err := builder.Builder()
+				nodeData, err := builder.Builder(p.encoder)
 				//wslog.GetLogger().Debug("send builder", hex.EncodeToString(nodeData), err)
+				if err != nil {
+					// 编码失败 不发送
+					log.Println("builder node error", err)
+					p.rejectBuilder(builder, err)
+					continue
+				}
 				err = p.SendData(nodeData)
+				if notifier, ok := v.(sentNotifier); ok {
+					notifier.sent(err)
+				}
 				if err != nil {
 					if err == io.EOF {
 						//TODO 连接断开了
@@ -96,8 +143,28 @@ func (p *processor) runSendQueue() {
 			}
 		}
 	}
-	// 关闭队列
+}
+
+// rejectBuilder 通知 node 没有发送
+func (p *processor) rejectBuilder(b _interface.NodeBuilder, err error) {
+	if promise := b.GetPromise(); promise != nil {
+		promise.Reject(err)
+	}
+	if notifier, ok := b.(sentNotifier); ok {
+		notifier.sent(err)
+	}
+}
+
+// exitSendQueue 发送线程退出时和 Close 一样 被 Reopen 替换的队列已经关闭 不能再关闭
+func (p *processor) exitSendQueue(sendQueue *gqueue.Queue) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if sendQueue != p.sendQueue {
+		return
+	}
+	p.stopped = true
 	if p.close {
-		p.sendQueue.Close()
+		sendQueue.Close()
+		p.close = false
 	}
 }
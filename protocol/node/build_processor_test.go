@@ -0,0 +1,89 @@
+package node
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"ws-go/protocol/impl"
+	"ws-go/protocol/newxxmp"
+	"ws-go/protocol/utils/promise"
+)
+
+// failedBuilder 编码失败的节点
+type failedBuilder struct {
+	*impl.ResultPromise
+	err error
+}
+
+func (f *failedBuilder) Builder(*newxxmp.Encoder) ([]byte, error) {
+	return nil, f.err
+}
+
+// segmentWrites 记录写入连接的数据
+type segmentWrites struct {
+	mutex sync.Mutex
+	data  [][]byte
+}
+
+func (s *segmentWrites) WriteSegmentOutputData(d []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data = append(s.data, d)
+	return nil
+}
+
+func (s *segmentWrites) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.data)
+}
+
+func TestProcessor_BuilderErrorRejects(t *testing.T) {
+	writes := &segmentWrites{}
+	p := Processor(writes, nil)
+	defer p.Close()
+
+	b := &failedBuilder{ResultPromise: impl.NewResultPromise(), err: errors.New("encode failed")}
+	b.SetPromise(promise.New(func(resolve func(promise.Any), reject func(error)) {}))
+	p.SendBuilder(b)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := b.GetResult()
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != b.err {
+			t.Fatalf("GetResult error = %v, want %v", err, b.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("builder error did not reject the promise")
+	}
+	if n := writes.len(); n != 0 {
+		t.Fatalf("wrote %d segments, want 0", n)
+	}
+}
+
+func TestProcessor_CloseReopen(t *testing.T) {
+	p := Processor(&segmentWrites{}, nil)
+	p.Close()
+
+	b := &failedBuilder{ResultPromise: impl.NewResultPromise()}
+	b.SetPromise(promise.New(func(resolve func(promise.Any), reject func(error)) {}))
+	p.SendBuilder(b)
+	if _, err := b.GetResult(); err != ErrProcessorClosed {
+		t.Fatalf("GetResult error = %v, want %v", err, ErrProcessorClosed)
+	}
+
+	// 重新连接后继续发送
+	p.Reopen()
+	b = &failedBuilder{ResultPromise: impl.NewResultPromise(), err: errors.New("encode failed")}
+	b.SetPromise(promise.New(func(resolve func(promise.Any), reject func(error)) {}))
+	p.SendBuilder(b)
+	if _, err := b.GetResult(); err != b.err {
+		t.Fatalf("GetResult error = %v, want %v", err, b.err)
+	}
+	p.Close()
+}
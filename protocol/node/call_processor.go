@@ -1,6 +1,7 @@
 package node
 
 import (
+	"ws-go/protocol/event"
 	"ws-go/protocol/iface"
 	"ws-go/protocol/newxxmp"
 )
@@ -10,6 +11,7 @@ const NodeCall = "call"
 // CallProcessor
 type CallProcessor struct {
 	iface.IBuildProcessor
+	events *event.Bus
 }
 
 func NewCallProcessor(b iface.IBuildProcessor) *CallProcessor {
@@ -47,6 +49,12 @@ func (c *CallProcessor) handleOffer(node *newxxmp.Node) {
 	if offerNode == nil {
 		return
 	}
+	c.events.Publish(&event.CallOffered{
+		From:   node.GetAttributeByValue("from"),
+		CallId: offerNode.GetAttributeByValue("call-id"),
+		Video:  offerNode.GetChildrenByTag("video") != nil,
+		T:      node.GetAttributeByValue("t"),
+	})
 	offerNode.Children = nil
 	// to
 	to := node.GetAttributeByValue("from")
@@ -1,6 +1,11 @@
 package node
 
-import "ws-go/protocol/newxxmp"
+import (
+	"ws-go/protocol/event"
+	"ws-go/protocol/newxxmp"
+)
+
+const NodeChatState = "chatstate"
 
 type ChatStateNode struct {
 	*BaseNode
@@ -43,3 +48,18 @@ func createChatStateComposing(to string) *ChatStateNode {
 
 	return c
 }
+
+// parseChatState 对方的输入状态
+// <chatstate from="" participant=""><composing media="audio"/></chatstate>
+func parseChatState(node *newxxmp.Node) *event.ChatStateChanged {
+	state := node.GetChildrenIndex(0)
+	if state == nil {
+		return nil
+	}
+	return &event.ChatStateChanged{
+		From:        node.GetAttributeByValue("from"),
+		Participant: node.GetAttributeByValue("participant"),
+		State:       state.GetTag(),
+		Media:       state.GetAttributeByValue("media"),
+	}
+}
@@ -0,0 +1,159 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"ws-go/libsignal/ecc"
+	"ws-go/libsignal/keys/identity"
+	"ws-go/libsignal/state/record"
+	"ws-go/protocol/newxxmp"
+	"ws-go/waver"
+
+	"github.com/gogf/gf/container/gtype"
+)
+
+// go test ./protocol/node -run TestIqBuilder_Golden -update
+var update = flag.Bool("update", false, "update golden files")
+
+// fixedKey 测试用的固定密钥 使用不可打印的字节 输出为 hex
+func fixedKey(b byte) *ecc.ECKeyPair {
+	var public, private [32]byte
+	for i := range public {
+		public[i] = b + byte(i)
+		private[i] = b - byte(i)
+	}
+	return ecc.NewECKeyPair(ecc.NewDjbECPublicKey(public), ecc.NewDjbECPrivateKey(private))
+}
+
+func fixedSignedPreKey() *record.SignedPreKey {
+	var signature [64]byte
+	for i := range signature {
+		signature[i] = byte(i)
+	}
+	return record.NewSignedPreKey(1, 1615531433, fixedKey(0xc0), signature, nil)
+}
+
+// iqGoldenTests 每个 builder 使用固定的 id 和参数
+func iqGoldenTests() []struct {
+	name  string
+	build func(id gtype.Int32) *newxxmp.Node
+} {
+	const (
+		user  = "8613800000000"
+		jid   = "8613800000000@s.whatsapp.net"
+		group = "8613800000000-1624957782@g.us"
+	)
+	contacts := []string{"8613800000001", "+8613800000002"}
+	iq := func(f func(id gtype.Int32) *IqNode) func(id gtype.Int32) *newxxmp.Node {
+		return func(id gtype.Int32) *newxxmp.Node {
+			return f(id).Node
+		}
+	}
+	return []struct {
+		name  string
+		build func(id gtype.Int32) *newxxmp.Node
+	}{
+		{"user_keys", iq(func(id gtype.Int32) *IqNode { return crateIqUserKeys(contacts, id, false) })},
+		{"user_keys_reason", iq(func(id gtype.Int32) *IqNode { return crateIqUserKeys([]string{jid}, id, true) })},
+		{"config", iq(createIqConfig)},
+		{"config_one", iq(createIqConfigOne)},
+		{"config_two", iq(createIqConfigTwo)},
+		{"active", iq(createIqActive)},
+		{"verified_name", iq(func(id gtype.Int32) *IqNode { return createGetVerifiedName(id, jid) })},
+		{"categories", iq(createSendCategories)},
+		{"business_profile", iq(func(id gtype.Int32) *IqNode { return createBusinessProfile(id, "1223524174334504") })},
+		{"business_profile_tow", iq(func(id gtype.Int32) *IqNode { return createBusinessProfileTow(id, jid) })},
+		{"ping", iq(createIqPing)},
+		{"set_encrypt_keys", iq(func(id gtype.Int32) *IqNode {
+			preKeys := []*record.PreKey{
+				record.NewPreKey(1, fixedKey(0x80), nil),
+				record.NewPreKey(0x0a0b0c, fixedKey(0xa0), nil),
+			}
+			identityKey := identity.NewKey(fixedKey(0xe0).PublicKey())
+			return createIqSetEncryptKeys(id, preKeys, fixedSignedPreKey(), *identityKey, 0x7ed158fe)
+		})},
+		{"usync", iq(func(id gtype.Int32) *IqNode { return createIqUSync(id, contacts) })},
+		{"usync_add", iq(func(id gtype.Int32) *IqNode { return createIqUSyncAdd(id, contacts) })},
+		{"usync_interactive", iq(func(id gtype.Int32) *IqNode { return createIqUSyncInteractive(id, contacts) })},
+		{"usync_add_one", iq(func(id gtype.Int32) *IqNode { return createIqUSyncSyncAddOneContacts(id, contacts) })},
+		{"usync_add_scan", iq(func(id gtype.Int32) *IqNode { return createIqUSyncSyncAddScanContacts(id, contacts) })},
+		{"add_group", iq(func(id gtype.Int32) *IqNode { return createIqAddGroup(id, group, contacts...) })},
+		{"invite", iq(func(id gtype.Int32) *IqNode { return createIqInvite(id, group, "IAlVfXsZJW13nKtyieWNQ3") })},
+		{"wg2_query", iq(func(id gtype.Int32) *IqNode { return createIqWg2Query(id, group) })},
+		{"group_code", iq(func(id gtype.Int32) *IqNode { return createIqGetGroupCode(id, group) })},
+		{"set_group_admin", iq(func(id gtype.Int32) *IqNode { return createIqSetGroupAdmin(id, group, jid) })},
+		{"encrypt", iq(func(id gtype.Int32) *IqNode { return createBuildEncryptNode(id, user) })},
+		{"demote_group_admin", iq(func(id gtype.Int32) *IqNode { return createIqDemoteGroupAdmin(id, group, jid) })},
+		{"leave_group", iq(func(id gtype.Int32) *IqNode { return createIqLeaveGroup(id, group) })},
+		{"presence_subscribe", func(id gtype.Int32) *newxxmp.Node { return createPresencesSubscribeNew(id, jid).Node }},
+		{"group_desc", iq(func(id gtype.Int32) *IqNode { return createIqGroupDesc(id, group, "desc <&>") })},
+		{"create_group", iq(func(id gtype.Int32) *IqNode { return createIqGroup(id, user, "subject", contacts) })},
+		{"group_member", iq(func(id gtype.Int32) *IqNode { return createIqGroupMember(id, group) })},
+		{"set_profile_picture", iq(func(id gtype.Int32) *IqNode {
+			return createIqSetProfilePicture(id, []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10}, jid)
+		})},
+		{"media_conn", iq(createIqMediaCon)},
+		{"get_qr", iq(createIqGetQr)},
+		{"revoke_qr", iq(createIqRevokeQr)},
+		{"scan_code", iq(func(id gtype.Int32) *IqNode { return createIqScanCode(id, "3OLFTKU2ZTIYN1", 0) })},
+		{"scan_code_invite", iq(func(id gtype.Int32) *IqNode { return createIqScanCode(id, "IAlVfXsZJW13nKtyieWNQ3", 1) })},
+		{"nick_name", iq(func(id gtype.Int32) *IqNode { return createIqNickName(id, "nick") })},
+		{"get_picture", iq(func(id gtype.Int32) *IqNode { return createIqGetPicture(id, jid) })},
+		{"get_preview", iq(func(id gtype.Int32) *IqNode { return createIqGetPreview(id, jid) })},
+		{"state", iq(func(id gtype.Int32) *IqNode { return createIqState(id, "status") })},
+		{"get_state", iq(func(id gtype.Int32) *IqNode { return createIqGetState(id, jid) })},
+		{"2fa", iq(func(id gtype.Int32) *IqNode { return createIq2Fa(id, "123456", "a@example.com") })},
+	}
+}
+
+func TestIqBuilder_Golden(t *testing.T) {
+	// sid 和建群 key 使用固定的 uuid
+	defer func(f func() string) { newUUID = f }(newUUID)
+	newUUID = func() string { return "00000000-0000-0000-0000-000000000000" }
+
+	codec := newxxmp.NewCodec(waver.NewWA42())
+	for _, tt := range iqGoldenTests() {
+		t.Run(tt.name, func(t *testing.T) {
+			n := tt.build(*gtype.NewInt32(0x1c))
+			d, err := codec.Encode(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text := newxxmp.FormatNode(n) + "\n"
+			assertGolden(t, tt.name+".txt", text)
+			assertGolden(t, tt.name+".hex", hex.EncodeToString(d)+"\n")
+			// 编码后的数据可以解码为同样的文本
+			decoded, err := codec.Decode(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := newxxmp.FormatNode(decoded) + "\n"; got != text {
+				t.Fatalf("decoded = %s, want %s", got, text)
+			}
+		})
+	}
+}
+
+// assertGolden 比较 testdata/iq 下的 golden 文件
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", "iq", name)
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte(got), want) {
+		t.Fatalf("%s mismatch\ngot:\n%s\nwant:\n%s", path, got, strings.TrimSpace(string(want)))
+	}
+}
@@ -36,6 +36,8 @@ const (
 
 var (
 	IdNotExistError = errors.New("id does not exist")
+	// newUUID usync sid 和建群 key 使用 测试时替换为固定值
+	newUUID = func() string { return guuid.New().String() }
 )
 
 // IqNode
@@ -436,11 +438,14 @@ func (i *IqNode) handleKeys(node *newxxmp.Node) {
 }
 
 // Builder build node to ixxmp data
-func (i *IqNode) Builder() ([]byte, error) {
+func (i *IqNode) Builder(encoder *newxxmp.Encoder) ([]byte, error) {
 	if len(i.d) > 0 {
 		return i.d, nil
 	}
-	xxmpData := i.GetTokenArray().GetBytes(true)
+	xxmpData, err := encoder.Encode(i.Node)
+	if err != nil {
+		return nil, err
+	}
 	log.Println("builder node ", i.Node.GetString())
 	return xxmpData, nil
 }
@@ -845,7 +850,7 @@ func createIqUSync(id gtype.Int32, contacts []string) *IqNode {
 	iqNode.Attributes.AddAttr("type", "get")
 	// usync node
 	usyncNode := newxxmp.EmptyNode("usync")
-	usyncNode.Attributes.AddAttr("sid", "sync_sid_delta_"+guuid.New().String())
+	usyncNode.Attributes.AddAttr("sid", "sync_sid_delta_"+newUUID())
 	usyncNode.Attributes.AddAttr("index", "0")
 	usyncNode.Attributes.AddAttr("last", "true")
 	usyncNode.Attributes.AddAttr("mode", "delta")
@@ -913,7 +918,7 @@ func createIqUSyncAdd(id gtype.Int32, contacts []string) *IqNode {
 	iqNode.Attributes.AddAttr("type", "get")
 	// usync node
 	usyncNode := newxxmp.EmptyNode("usync")
-	usyncNode.Attributes.AddAttr("sid", "sync_sid_query_"+guuid.New().String())
+	usyncNode.Attributes.AddAttr("sid", "sync_sid_query_"+newUUID())
 	usyncNode.Attributes.AddAttr("index", "0")
 	usyncNode.Attributes.AddAttr("last", "true")
 	usyncNode.Attributes.AddAttr("mode", "query")
@@ -976,7 +981,7 @@ func createIqUSyncInteractive(id gtype.Int32, contacts []string) *IqNode {
 	iqNode.Attributes.AddAttr("type", "get")
 	// usync node
 	usyncNode := newxxmp.EmptyNode("usync")
-	usyncNode.Attributes.AddAttr("sid", "sync_sid_delta_"+guuid.New().String())
+	usyncNode.Attributes.AddAttr("sid", "sync_sid_delta_"+newUUID())
 	usyncNode.Attributes.AddAttr("index", "0")
 	usyncNode.Attributes.AddAttr("last", "true")
 	usyncNode.Attributes.AddAttr("mode", "delta")
@@ -1044,7 +1049,7 @@ func createIqUSyncSyncAddOneContacts(id gtype.Int32, contacts []string) *IqNode
 	iqNode.Attributes.AddAttr("type", "get")
 	// usync node
 	usyncNode := newxxmp.EmptyNode("usync")
-	usyncNode.Attributes.AddAttr("sid", "sync_sid_delta_"+guuid.New().String())
+	usyncNode.Attributes.AddAttr("sid", "sync_sid_delta_"+newUUID())
 	usyncNode.Attributes.AddAttr("index", "0")
 	usyncNode.Attributes.AddAttr("last", "true")
 	usyncNode.Attributes.AddAttr("mode", "delta")
@@ -1097,7 +1102,7 @@ func createIqUSyncSyncAddScanContacts(id gtype.Int32, contacts []string) *IqNode
 	iqNode.Attributes.AddAttr("type", "get")
 	// usync node
 	usyncNode := newxxmp.EmptyNode("usync")
-	usyncNode.Attributes.AddAttr("sid", "sync_sid_delta_"+guuid.New().String())
+	usyncNode.Attributes.AddAttr("sid", "sync_sid_delta_"+newUUID())
 	usyncNode.Attributes.AddAttr("index", "0")
 	usyncNode.Attributes.AddAttr("last", "true")
 	usyncNode.Attributes.AddAttr("mode", "query")
@@ -1363,7 +1368,7 @@ func createIqGroup(id gtype.Int32, u, subject string, participants []string) *Iq
 	*/
 	// default promise 超时100秒
 	i := &IqNode{id: id.Val(), promise: promise.New(nil)}
-	key := fmt.Sprintf("%s-%s@temp", u, strings.ReplaceAll(guuid.New().String(), "-", ""))
+	key := fmt.Sprintf("%s-%s@temp", u, strings.ReplaceAll(newUUID(), "-", ""))
 	log.Println("createIqGroup key:", key)
 	// participants
 	participantsNode := make([]*newxxmp.Node, 0)
@@ -1663,9 +1668,9 @@ func (i *IqProcessor) reset() {
 }
 
 // iqId
+// 多个线程同时发送 iq 先加一再返回加之前的值 不能直接复制 _iqId
 func (i *IqProcessor) iqId() gtype.Int32 {
-	defer i._iqId.Add(1)
-	return *i._iqId
+	return *gtype.NewInt32(i._iqId.Add(1) - 1)
 }
 
 // GetResult 可以重新定义超时时间 如果设置等待时间，回调函数将失效
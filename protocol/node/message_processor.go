@@ -20,6 +20,8 @@ const NodeReceipt = "receipt"
 type MessageNode struct {
 	*BaseNode
 	id string
+	// onSent 写入连接成功或失败
+	onSent func(err error)
 }
 
 // GetMsgId
@@ -27,6 +29,18 @@ func (m *MessageNode) GetMsgId() string {
 	return m.id
 }
 
+// sent 写入连接后调用 err 不为 nil 时发送失败
+func (m *MessageNode) sent(err error) {
+	if m.onSent != nil {
+		m.onSent(err)
+	}
+}
+
+// newMessageId 加密前生成 发件箱和重发的明文在发送前按 id 保存
+func newMessageId() string {
+	return strings.ToUpper(strings.ReplaceAll(guuid.New().String(), "-", ""))
+}
+
 //func (m *MessageNode) Builder() ([]byte,error) {
 //	return hex.DecodeString("00f8081607faff878617607567005f03051f04fb10e26128b3ca40172ee1020f8a75d310dcf801f80612130d052cfc42330a210539b9b724937542e8e810037c88374d12ecce41190d5787d3e41b4237fa36ac2c1013180022108efefe798e75b2cb8eabcc07bb6de50cc40a64cae881a43f ")
 //}
@@ -99,12 +113,10 @@ func createSnsParticipants(cs map[string]protocol.CiphertextMessage) *newxxmp.No
 }
 
 // createMessageNode
-func createMessageNode(to string, veriFiledName uint64, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
+func createMessageNode(id, to string, veriFiledName uint64, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
 	var encType string
 	//return &MessageNode{BaseNode:NewBaseNode()}
 	encType = protocol.GetEncTypeString(c.Type())
-	// 暂时随机
-	id := strings.ToUpper(strings.ReplaceAll(guuid.New().String(), "-", ""))
 	// default promise 超时100秒
 	p := &MessageNode{id: id, BaseNode: NewBaseNode()}
 	// message node
@@ -169,12 +181,10 @@ func CreateIqSnsText(id gtype.Int32, veriFiledName uint64, jidVal string, c prot
 	return i
 }
 
-func createImageMessageNode(veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
+func createImageMessageNode(id string, veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
 	var encType string
 	//return &MessageNode{BaseNode:NewBaseNode()}
 	encType = protocol.GetEncTypeString(c.Type())
-	// 暂时随机
-	id := strings.ToUpper(strings.ReplaceAll(guuid.New().String(), "-", ""))
 	// default promise 超时100秒
 	p := &MessageNode{id: id, BaseNode: NewBaseNode()}
 	// message node
@@ -207,12 +217,10 @@ func createImageMessageNode(veriFiledName uint64, to, msgType string, c protocol
 	return p
 }
 
-func createAudioMessageNode(veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
+func createAudioMessageNode(id string, veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
 	var encType string
 	//return &MessageNode{BaseNode:NewBaseNode()}
 	encType = protocol.GetEncTypeString(c.Type())
-	// 暂时随机
-	id := strings.ToUpper(strings.ReplaceAll(guuid.New().String(), "-", ""))
 	// default promise 超时100秒
 	p := &MessageNode{id: id, BaseNode: NewBaseNode()}
 	// message node
@@ -246,12 +254,10 @@ func createAudioMessageNode(veriFiledName uint64, to, msgType string, c protocol
 }
 
 //createVideoMessageNode
-func createVideoMessageNode(veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
+func createVideoMessageNode(id string, veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
 	var encType string
 	//return &MessageNode{BaseNode:NewBaseNode()}
 	encType = protocol.GetEncTypeString(c.Type())
-	// 暂时随机
-	id := strings.ToUpper(strings.ReplaceAll(guuid.New().String(), "-", ""))
 	// default promise 超时100秒
 	p := &MessageNode{id: id, BaseNode: NewBaseNode()}
 	// message node
@@ -285,12 +291,10 @@ func createVideoMessageNode(veriFiledName uint64, to, msgType string, c protocol
 }
 
 // createVcardMessageNode
-func createVcardMessageNode(veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
+func createVcardMessageNode(id string, veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, participants *newxxmp.Node, phash ...string) *MessageNode {
 	var encType string
 	//return &MessageNode{BaseNode:NewBaseNode()}
 	encType = protocol.GetEncTypeString(c.Type())
-	// 暂时随机
-	id := strings.ToUpper(strings.ReplaceAll(guuid.New().String(), "-", ""))
 	// default promise 超时100秒
 	p := &MessageNode{id: id, BaseNode: NewBaseNode()}
 	// message node
@@ -340,6 +344,8 @@ func (m *MessageProcessor) Handle(node *newxxmp.Node) interface{} {
 		return m.handleTagMessage(node)
 	case NodeReceipt:
 		return m.handleTagReceipt(node)
+	case NodeAck:
+		return m.handleTagAck(node)
 	}
 
 	return nil
@@ -392,18 +398,39 @@ func (m *MessageProcessor) handleTagReceipt(node *newxxmp.Node) interface{} {
 	// participant
 	participantAttr := node.GetAttributeByValue("participant")
 	// create
-	return entity.NewReceipt(from, id, receiptType, participantAttr)
+	receipt := entity.NewReceipt(from, id, receiptType, participantAttr)
+	// <list><item id=""/></list>
+	if list := node.GetChildrenByTag("list"); list != nil {
+		for _, item := range list.GetChildren() {
+			if itemId := item.GetAttributeByValue("id"); itemId != "" {
+				receipt.ListIds = append(receipt.ListIds, itemId)
+			}
+		}
+	}
+	if receiptType == ReceiptTypeRetry {
+		receipt.Retry = parseReceiptRetry(node)
+	}
+	return receipt
+}
+
+// handleTagAck 处理服务器对发送消息的 ack
+func (m *MessageProcessor) handleTagAck(node *newxxmp.Node) interface{} {
+	return entity.NewMessageAck(
+		node.GetAttributeByValue("id"),
+		node.GetAttributeByValue("from"),
+		node.GetAttributeByValue("class"),
+		node.GetAttributeByValue("error"))
 }
 
 // BuildMessage
-func (m *MessageProcessor) BuildMessage(to string, veriFiledName uint64, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
+func (m *MessageProcessor) BuildMessage(id, to string, veriFiledName uint64, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
 	var participantsNode *newxxmp.Node
 	if cs != nil {
 		// 创建群聊后首次发送需要将秘钥分发给群成员
 		participantsNode = createParticipants(cs)
 	}
 	// create message node
-	messageNode := createMessageNode(to, veriFiledName, msgType, c, participantsNode, phash...)
+	messageNode := createMessageNode(id, to, veriFiledName, msgType, c, participantsNode, phash...)
 	return messageNode
 }
 
@@ -427,48 +454,48 @@ func (i *IqProcessor) BuildIqCreateIqSnsText(veriFiledName uint64, jid string, c
 	return build
 }
 
-func (m *MessageProcessor) BuildImageMessage(veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
+func (m *MessageProcessor) BuildImageMessage(id string, veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
 	var participantsNode *newxxmp.Node
 	if cs != nil {
 		// 创建群聊后首次发送需要将秘钥分发给群成员
 		participantsNode = createParticipants(cs)
 	}
 	// create message node
-	messageNode := createImageMessageNode(veriFiledName, to, msgType, c, participantsNode, phash...)
+	messageNode := createImageMessageNode(id, veriFiledName, to, msgType, c, participantsNode, phash...)
 	return messageNode
 }
-func (m *MessageProcessor) BuildAudioMessage(veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
+func (m *MessageProcessor) BuildAudioMessage(id string, veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
 	var participantsNode *newxxmp.Node
 	if cs != nil {
 		// 创建群聊后首次发送需要将秘钥分发给群成员
 		participantsNode = createParticipants(cs)
 	}
 	// create message node
-	messageNode := createAudioMessageNode(veriFiledName, to, msgType, c, participantsNode, phash...)
+	messageNode := createAudioMessageNode(id, veriFiledName, to, msgType, c, participantsNode, phash...)
 	return messageNode
 }
 
 // BuildVcardMessage
-func (m *MessageProcessor) BuildVcardMessage(veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
+func (m *MessageProcessor) BuildVcardMessage(id string, veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
 	var participantsNode *newxxmp.Node
 	if cs != nil {
 		// 创建群聊后首次发送需要将秘钥分发给群成员
 		participantsNode = createParticipants(cs)
 	}
 	// create message node
-	messageNode := createVcardMessageNode(veriFiledName, to, msgType, c, participantsNode, phash...)
+	messageNode := createVcardMessageNode(id, veriFiledName, to, msgType, c, participantsNode, phash...)
 	return messageNode
 }
 
 // BuildVideoMessage
-func (m *MessageProcessor) BuildVideoMessage(veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
+func (m *MessageProcessor) BuildVideoMessage(id string, veriFiledName uint64, to, msgType string, c protocol.CiphertextMessage, cs map[string]protocol.CiphertextMessage, phash ...string) *MessageNode {
 	var participantsNode *newxxmp.Node
 	if cs != nil {
 		// 创建群聊后首次发送需要将秘钥分发给群成员
 		participantsNode = createParticipants(cs)
 	}
 	// create message node
-	messageNode := createVideoMessageNode(veriFiledName, to, msgType, c, participantsNode, phash...)
+	messageNode := createVideoMessageNode(id, veriFiledName, to, msgType, c, participantsNode, phash...)
 	return messageNode
 }
 
@@ -0,0 +1,113 @@
+package node
+
+import (
+	"path/filepath"
+	"testing"
+	"ws-go/protocol/define"
+	"ws-go/protocol/entity"
+	"ws-go/protocol/event"
+	"ws-go/protocol/msg"
+	"ws-go/protocol/newxxmp"
+
+	"github.com/gogf/gf/container/gqueue"
+)
+
+func TestMessageProcessor_ReceiptUpdatesOutbox(t *testing.T) {
+	manager, err := msg.OpenManager(filepath.Join(t.TempDir(), "outbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	group := "8613800000000-1624957782@g.us"
+	for _, id := range []string{"3EB0000000000001", "3EB0000000000002"} {
+		if err := manager.AddMySendMsg(id, msg.CreateMySendMsg(group, "", "text")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bus := event.NewBus("8613800000000")
+	receipts := bus.Subscribe(10, event.TypeReceiptUpdated)
+	defer receipts.Close()
+	m := &MainNodeProcessor{message: NewMessageProcessor(), msgManager: manager, events: bus}
+
+	// <ack class="message" id="" from=""/>
+	ack := m.message.Handle(newxxmp.EmptyNode(NodeAck, newxxmp.Attributes{
+		newxxmp.NewAttribute("class", "message"),
+		newxxmp.NewAttribute("id", "3EB0000000000001"),
+		newxxmp.NewAttribute("from", group),
+	}))
+	m.updateMessageAck(ack.(*entity.MessageAck))
+
+	// 一个 read 回执确认两条消息
+	list := newxxmp.EmptyNode("list", newxxmp.EmptyNode("item", newxxmp.Attributes{newxxmp.NewAttribute("id", "3EB0000000000002")}))
+	receipt := m.message.Handle(newxxmp.EmptyNode(NodeReceipt, newxxmp.Attributes{
+		newxxmp.NewAttribute("id", "3EB0000000000001"),
+		newxxmp.NewAttribute("from", group),
+		newxxmp.NewAttribute("participant", "8613800000001@s.whatsapp.net"),
+		newxxmp.NewAttribute("type", "read"),
+	}, list)).(*entity.Receipt)
+	if ids := receipt.MsgIds(); len(ids) != 2 {
+		t.Fatalf("MsgIds = %v", ids)
+	}
+	m.updateReceipt(receipt)
+	// 其他设备发送的消息忽略
+	m.updateReceipt(entity.NewReceipt(group, "unknown", "", "8613800000001@s.whatsapp.net"))
+
+	e := (<-receipts.C()).Event.(*event.ReceiptUpdated)
+	if len(e.MsgIds) != 2 || e.Chat != group || e.Recipient != "8613800000001@s.whatsapp.net" || e.Status != define.Read {
+		t.Fatalf("ReceiptUpdated = %+v", e)
+	}
+
+	for _, id := range receipt.MsgIds() {
+		got, err := manager.GetMsg(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != define.Read || len(got.Recipients) != 1 || got.Recipients[0].Recipient != "8613800000001@s.whatsapp.net" {
+			t.Fatalf("GetMsg(%s) = %+v", id, got)
+		}
+	}
+}
+
+func TestMainNodeProcessor_SendSavesOutboxFirst(t *testing.T) {
+	const bobID = "8613800000002"
+	manager, err := msg.OpenManager(filepath.Join(t.TempDir(), "outbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	alice, bob := newTestAxolotl(t), newTestAxolotl(t)
+	if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+	m := &MainNodeProcessor{
+		processor:      &processor{sendQueue: gqueue.New(10)},
+		message:        NewMessageProcessor(),
+		axolotlManager: alice,
+		msgManager:     manager,
+		retries:        msg.NewRetryCache(0, 0),
+	}
+
+	// 进入发送队列时发件箱已经有记录
+	sent, err := m.SendTextMessage(bobID, "hello", 0, nil, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := m.sendQueue.Pop().(*MessageNode)
+	if queued.GetMsgId() != sent.Id {
+		t.Fatalf("queued %s, saved %s", queued.GetMsgId(), sent.Id)
+	}
+	if got, err := manager.GetMsg(sent.Id); err != nil || got.Status != define.Sent {
+		t.Fatalf("GetMsg = %+v, %v", got, err)
+	}
+
+	// 连接已经关闭 消息标记为失败
+	m.processor.Close()
+	sent, err = m.SendTextMessage(bobID, "hello", 0, nil, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := manager.GetMsg(sent.Id)
+	if err != nil || got.Status != define.Failed || got.Error != ErrProcessorClosed.Error() {
+		t.Fatalf("GetMsg = %+v, %v", got, err)
+	}
+}
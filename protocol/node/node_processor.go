@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/gogf/gf/container/gtype"
 	"github.com/golang/protobuf/proto"
@@ -14,6 +15,7 @@ import (
 	"ws-go/protocol/axolotl"
 	"ws-go/protocol/define"
 	entity "ws-go/protocol/entity"
+	"ws-go/protocol/event"
 	iface "ws-go/protocol/iface"
 	"ws-go/protocol/msg"
 	"ws-go/protocol/newxxmp"
@@ -33,18 +35,29 @@ type MainNodeProcessor struct {
 	axolotlManager *axolotl.Manager
 	msgManager     *msg.Manager
 	handlers       iface.IHandlers
+	encoder        *newxxmp.Encoder
+	// events 账号的事件 为 nil 时不发布
+	events *event.Bus
+	// retries 最近发送的消息的明文 收到 retry 回执时重发
+	retries   *msg.RetryCache
+	maxResend int
 }
 
-func NewMainNodeProcessor() *MainNodeProcessor {
-	p := Processor(nil)
+// NewMainNodeProcessor encoder 为当前账号的编码器
+func NewMainNodeProcessor(encoder *newxxmp.Encoder) *MainNodeProcessor {
+	p := Processor(nil, encoder)
 	m := &MainNodeProcessor{
 		processor: p,
+		encoder:   encoder,
 		iq:        NewIqProcessor(),
 		presence:  NewPresenceProcessor(),
 		message:   NewMessageProcessor(),
+		retries:   msg.NewRetryCache(msg.DefaultRetryCacheSize, msg.DefaultRetryCacheTTL),
+		maxResend: msg.DefaultMaxResend,
 	}
 	m.call = NewCallProcessor(m)
 	m.notification = NewNotificationProcessor(m)
+	m.notification.identityChanged = m.refreshIdentity
 	return m
 }
 
@@ -55,12 +68,14 @@ func (m *MainNodeProcessor) ProcessNode(node *newxxmp.Node) {
 		_ = m.iq.Handle(node)
 	case NodePresence:
 		m.presence.Handle(node)
-	case NodeReceipt, NodeMessage:
+	case NodeReceipt, NodeMessage, NodeAck:
 		m.handles(m.message.Handle(node))
 	case NodeNotification:
 		m.notification.handle(node)
 	case NodeCall:
 		m.call.Handle(node)
+	case NodeChatState:
+		m.handleChatState(node)
 	}
 
 }
@@ -95,13 +110,79 @@ func (m *MainNodeProcessor) handles(is ...interface{}) {
 		case *entity.Receipt:
 			// change my msg status
 			e := i.(*entity.Receipt)
-			/**	if strings.Contains(e.ReceiptType, "read") {
-				_ = m.msgManager.UpdateMsgStatus(e.MsgId, define.Read)
-			}**/
+			m.updateReceipt(e)
+			if e.ReceiptType == ReceiptTypeRetry {
+				go m.handleRetryReceipt(e)
+			}
 			// 发送确认
 			m.SendAck(e.MsgId, e.RecipientId, e.ReceiptType, ClassReceipt, e.Participant)
+		case *entity.MessageAck:
+			m.updateMessageAck(i.(*entity.MessageAck))
+		}
+	}
+}
+
+// handleChatState 只发布事件 不需要确认
+func (m *MainNodeProcessor) handleChatState(node *newxxmp.Node) {
+	if e := parseChatState(node); e != nil {
+		m.events.Publish(e)
+	}
+}
+
+// updateReceipt 更新发件箱中接收者的状态 不是程序发送的消息忽略
+func (m *MainNodeProcessor) updateReceipt(e *entity.Receipt) {
+	status, ok := define.ReceiptStatus(e.ReceiptType)
+	if !ok {
+		return
+	}
+	// 群消息的回执 from 为群 participant 为成员
+	recipient := e.RecipientId
+	if e.Participant != "" {
+		recipient = e.Participant
+	}
+	m.events.Publish(&event.ReceiptUpdated{
+		MsgIds:      e.MsgIds(),
+		Chat:        e.RecipientId,
+		Recipient:   recipient,
+		Status:      status,
+		ReceiptType: e.ReceiptType,
+	})
+	if m.msgManager == nil {
+		return
+	}
+	for _, id := range e.MsgIds() {
+		if err := m.msgManager.UpdateReceipt(id, recipient, status); err != nil && !errors.Is(err, msg.ErrMsgNotFound) {
+			log.Println("update receipt", id, err)
+		}
+	}
+}
+
+// updateMessageAck 服务器确认收到发送的消息
+func (m *MainNodeProcessor) updateMessageAck(e *entity.MessageAck) {
+	if e.Class != "message" || m.msgManager == nil {
+		return
+	}
+	if err := m.msgManager.ServerAck(e.MsgId, e.Error); err != nil && !errors.Is(err, msg.ErrMsgNotFound) {
+		log.Println("update message ack", e.MsgId, err)
+	}
+}
+
+// sendMessage 发件箱和重发的明文已经保存 写入连接失败时标记为失败
+func (m *MainNodeProcessor) sendMessage(builder *MessageNode) {
+	builder.onSent = func(err error) {
+		if err != nil {
+			m.messageFailed(builder.GetMsgId(), err)
 		}
 	}
+	m.SendBuilder(builder)
+}
+
+// messageFailed 加密或者发送失败
+func (m *MainNodeProcessor) messageFailed(id string, err error) {
+	log.Println("send message failed", id, err)
+	if err := m.msgManager.MarkFailed(id, err.Error()); err != nil {
+		log.Println("mark message failed", id, err)
+	}
 }
 
 // SendAck 发送确认
@@ -169,6 +250,11 @@ func (m *MainNodeProcessor) SendNormalAndRead(id, to, participant string) {
 	m.SendBuilder(m.message.BuildNormalReceipt(id, to, participant, true))
 }
 
+// SendNormalReceipt 只发送送达回执 不标记为已读
+func (m *MainNodeProcessor) SendNormalReceipt(id, to, participant string) {
+	m.SendBuilder(m.message.BuildNormalReceipt(id, to, participant, false))
+}
+
 func (m *MainNodeProcessor) GetPreKeysNumber(reason bool, us ...string) error {
 	var needGetUsers []string
 	// get pre keys
@@ -207,7 +293,7 @@ func (m *MainNodeProcessor) GetPreKeysNumber(reason bool, us ...string) error {
 	return nil
 }
 
-// GetPreKeys
+// GetPreKeys reason 为 true 时是身份密钥变化 已经有 session 也重新获取
 func (m *MainNodeProcessor) GetPreKeys(reason bool, us ...string) error {
 	var needGetUsers []string
 	// get pre keys
@@ -217,7 +303,7 @@ func (m *MainNodeProcessor) GetPreKeys(reason bool, us ...string) error {
 			u = strings.Split(u, "@")[0]
 		}
 
-		if !m.axolotlManager.ContainsSession(u) {
+		if reason || !m.axolotlManager.ContainsSession(u) {
 			needGetUsers = append(needGetUsers, u)
 		}
 	}
@@ -226,32 +312,64 @@ func (m *MainNodeProcessor) GetPreKeys(reason bool, us ...string) error {
 		return nil
 	}
 
-	getIqUserKeys := m.SendGetIqUserKeys(needGetUsers, reason)
-	result, err := getIqUserKeys.GetResult()
+	preKeys, err := m.fetchPreKeys(needGetUsers, reason)
 	if err != nil {
 		return err
 	}
 	// set session
-	if iqResult, ok := result.(entity.IqResult); ok {
-		for _, keys := range iqResult.GetPreKeys() {
-			// TODO 应该处理下这个错误
-			err := m.axolotlManager.CreateSession(
-				keys.GetJid(),
-				keys.CreatePreKeyBundle())
-			if err != nil {
-				return err
-			}
+	for _, keys := range preKeys {
+		// TODO 应该处理下这个错误
+		err := m.axolotlManager.CreateSession(
+			keys.GetJid(),
+			keys.CreatePreKeyBundle())
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// fetchPreKeys 只获取 prekeys 不建立 session
+func (m *MainNodeProcessor) fetchPreKeys(users []string, reason bool) ([]*entity.USerPreKeys, error) {
+	result, err := m.SendGetIqUserKeys(users, reason).GetResult()
+	if err != nil {
+		return nil, err
+	}
+	if iqResult, ok := result.(entity.IqResult); ok {
+		return iqResult.GetPreKeys(), nil
+	}
+	return nil, nil
+}
+
+// refreshIdentity 联系人的身份密钥变化 重新获取 prekeys 建立新的 session 旧的 session 被归档
+// 新的密钥是否可信由信任策略决定
+func (m *MainNodeProcessor) refreshIdentity(from string) {
+	jid := NewJid(from)
+	id := jid.RawId()
+	if id == "" {
+		return
+	}
+	if err := m.GetPreKeys(true, id); err != nil {
+		log.Println("refreshIdentity get prekeys error", id, err)
+	}
+}
+
 // Reset 重置所有 processor
 func (m *MainNodeProcessor) Reset() bool {
 	m.iq.reset()
 	return true
 }
 
+// Reopen 重新连接后恢复 Close 关闭的发送队列和 handlers
+func (m *MainNodeProcessor) Reopen() {
+	if m.processor != nil {
+		m.processor.Reopen()
+	}
+	if m.handlers != nil {
+		m.handlers.Reopen()
+	}
+}
+
 // =============  settings ==================
 // SetAxolotlManager
 func (m *MainNodeProcessor) SetAxolotlManager(axolotlManager *axolotl.Manager) {
@@ -261,10 +379,17 @@ func (m *MainNodeProcessor) SetMsgManager(manager *msg.Manager) {
 	m.msgManager = manager
 }
 
+// SetEventBus 收到的回执 来电 群成员变化等发布到 bus
+func (m *MainNodeProcessor) SetEventBus(bus *event.Bus) {
+	m.events = bus
+	m.call.events = bus
+	m.notification.events = bus
+}
+
 // SetSegmentOutputProcessor
 func (m *MainNodeProcessor) SetSegmentOutputProcessor(outputProcessor iface.SegmentOutputProcessor) {
 	if m.processor == nil {
-		m.processor = Processor(outputProcessor)
+		m.processor = Processor(outputProcessor, m.encoder)
 	}
 	m.processor.segmentOutput = outputProcessor
 }
@@ -555,18 +680,22 @@ func (m *MainNodeProcessor) SendTextGroupMessage(veriFiledName uint64, u, groupI
 		fmt.Println("发送群@", err.Error())
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(groupId.GroupId(), content, "text")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberGroupSent(id, u, groupId, "text", veriFiledName, pbData)
 	// encrypt pb data
 	c, err := m.axolotlManager.Encrypt(groupId.RawId(), pbData, true, u.RawId())
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
-	builder := m.message.BuildMessage(groupId.GroupId(), veriFiledName, "text", c, cs, utils.CalcPHash(participants))
-	m.SendBuilder(builder)
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(groupId.GroupId(), content, "text")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildMessage(id, groupId.GroupId(), veriFiledName, "text", c, cs, utils.CalcPHash(participants))
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // SendSnsText 发动态文本
@@ -652,20 +781,23 @@ func (m *MainNodeProcessor) SendTextMessage(u, content string, veriFiledName uin
 	if err != nil {
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(jid.Jid(), content, "text")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberSent(id, jid.Jid(), "text", veriFiledName, d)
 	// encrypt
 	ciphertextMessage, err := m.axolotlManager.Encrypt(jid.Jid(), d, false)
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
 	// sendTextMessage
-	builder := m.message.BuildMessage(jid.Jid(), veriFiledName, "text", ciphertextMessage, nil)
-	m.SendBuilder(builder)
-
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(jid.Jid(), content, "text")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildMessage(id, jid.Jid(), veriFiledName, "text", ciphertextMessage, nil)
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // SendImageMessage 发送图片消息
@@ -698,20 +830,23 @@ func (m *MainNodeProcessor) SendImageMessage(u, base64Data, url, directPath stri
 	if err != nil {
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(jid.Jid(), "", "image")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberSent(id, jid.Jid(), "media", veriFiledName, d)
 	// encrypt
 	ciphertextMessage, err := m.axolotlManager.Encrypt(jid.Jid(), d, false)
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
 	// sendImageMessage
-	builder := m.message.BuildImageMessage(veriFiledName, jid.Jid(), "media", ciphertextMessage, nil)
-	m.SendBuilder(builder)
-
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(jid.Jid(), "", "image")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildImageMessage(id, veriFiledName, jid.Jid(), "media", ciphertextMessage, nil)
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // SendAudioMessage 发送语音
@@ -746,20 +881,23 @@ func (m *MainNodeProcessor) SendAudioMessage(u, base64Data, url, directPath stri
 	if err != nil {
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(jid.Jid(), "", "audio")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberSent(id, jid.Jid(), "media", veriFiledName, d)
 	// encrypt
 	ciphertextMessage, err := m.axolotlManager.Encrypt(jid.Jid(), d, false)
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
 	// sendImageMessage
-	builder := m.message.BuildVideoMessage(veriFiledName, jid.Jid(), "media", ciphertextMessage, nil)
-	m.SendBuilder(builder)
-
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(jid.Jid(), "", "audio")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildVideoMessage(id, veriFiledName, jid.Jid(), "media", ciphertextMessage, nil)
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // 发送视频 SendVideoMessage
@@ -796,20 +934,23 @@ func (m *MainNodeProcessor) SendVideoMessage(u, base64Data, url, directPath stri
 	if err != nil {
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(jid.Jid(), "", "audio")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberSent(id, jid.Jid(), "media", veriFiledName, d)
 	// encrypt
 	ciphertextMessage, err := m.axolotlManager.Encrypt(jid.Jid(), d, false)
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
 	// sendImageMessage
-	builder := m.message.BuildAudioMessage(veriFiledName, jid.Jid(), "media", ciphertextMessage, nil)
-	m.SendBuilder(builder)
-
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(jid.Jid(), "", "audio")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildAudioMessage(id, veriFiledName, jid.Jid(), "media", ciphertextMessage, nil)
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // SendVcardMessage 发送名片消息
@@ -832,20 +973,23 @@ func (m *MainNodeProcessor) SendVcardMessage(u string, tel, vcardName string, ve
 	if err != nil {
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(jid.Jid(), "", "vcard")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberSent(id, jid.Jid(), "media", veriFiledName, d)
 	// encrypt
 	ciphertextMessage, err := m.axolotlManager.Encrypt(jid.Jid(), d, false)
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
 	// sendImageMessage
-	builder := m.message.BuildVcardMessage(veriFiledName, jid.Jid(), "media", ciphertextMessage, nil)
-	m.SendBuilder(builder)
-
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(jid.Jid(), "", "vcard")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildVcardMessage(id, veriFiledName, jid.Jid(), "media", ciphertextMessage, nil)
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // SendImageGroupMessage 发送群图片消息
@@ -898,18 +1042,22 @@ func (m *MainNodeProcessor) SendImageGroupMessage(u, groupId JId, base64Data, ur
 	if err != nil {
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(groupId.GroupId(), "", "image")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberGroupSent(id, u, groupId, "media", veriFiledName, pbData)
 	// encrypt pb data
 	c, err := m.axolotlManager.Encrypt(groupId.RawId(), pbData, true, u.RawId())
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
-	builder := m.message.BuildImageMessage(veriFiledName, groupId.GroupId(), "media", c, cs, utils.CalcPHash(participants))
-	m.SendBuilder(builder)
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(groupId.GroupId(), "", "image")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildImageMessage(id, veriFiledName, groupId.GroupId(), "media", c, cs, utils.CalcPHash(participants))
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // SendAudioGroupMessage
@@ -962,18 +1110,22 @@ func (m *MainNodeProcessor) SendAudioGroupMessage(u, groupId JId, base64Data, ur
 	if err != nil {
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(groupId.GroupId(), "", "audio")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberGroupSent(id, u, groupId, "media", veriFiledName, pbData)
 	// encrypt pb data
 	c, err := m.axolotlManager.Encrypt(groupId.RawId(), pbData, true, u.RawId())
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
-	builder := m.message.BuildAudioMessage(veriFiledName, groupId.GroupId(), "media", c, cs, utils.CalcPHash(participants))
-	m.SendBuilder(builder)
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(groupId.GroupId(), "", "audio")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildAudioMessage(id, veriFiledName, groupId.GroupId(), "media", c, cs, utils.CalcPHash(participants))
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // SendVideoGroupMessage 发送群视频消息
@@ -1030,18 +1182,22 @@ func (m *MainNodeProcessor) SendVideoGroupMessage(u, groupId JId, base64Data, ur
 	if err != nil {
 		return nil, err
 	}
+	// 发送前保存 服务器的 ack 回执和重发请求可能在写入后立即到达
+	id := newMessageId()
+	mySendMsg := msg.CreateMySendMsg(groupId.GroupId(), "", "video")
+	if err := m.msgManager.AddMySendMsg(id, mySendMsg); err != nil {
+		return nil, err
+	}
+	m.rememberGroupSent(id, u, groupId, "media", veriFiledName, pbData)
 	// encrypt pb data
 	c, err := m.axolotlManager.Encrypt(groupId.RawId(), pbData, true, u.RawId())
 	if err != nil {
+		m.messageFailed(id, err)
 		return nil, err
 	}
-	builder := m.message.BuildVideoMessage(veriFiledName, groupId.GroupId(), "media", c, cs, utils.CalcPHash(participants))
-	m.SendBuilder(builder)
-	// save content id
-	id := builder.GetMsgId()
-	mySendMsg := msg.CreateMySendMsg(groupId.GroupId(), "", "video")
-	err = m.msgManager.AddMySendMsg(id, mySendMsg)
-	return mySendMsg, err
+	builder := m.message.BuildVideoMessage(id, veriFiledName, groupId.GroupId(), "media", c, cs, utils.CalcPHash(participants))
+	m.sendMessage(builder)
+	return mySendMsg, nil
 }
 
 // SendSyncContacts 同步联系人
@@ -3,13 +3,12 @@ package node
 import (
 	"strconv"
 	"testing"
+	"ws-go/protocol/event"
+	"ws-go/protocol/iface"
 	"ws-go/protocol/newxxmp"
-	"ws-go/waver"
 )
 
 func TestMainNodeProcessor_SendGetIqUserKeys(t *testing.T) {
-	newxxmp.SetWAXXMPVersion(waver.NewWA41())
-
 	t.Log(strconv.FormatInt(int64(2), 16))
 
 	//nodeProcessor := NewMainNodeProcessor()
@@ -18,3 +17,60 @@ func TestMainNodeProcessor_SendGetIqUserKeys(t *testing.T) {
 	//t.Log(nodeProcessor.iq.GetResult(int(iqUserKeys.GetIqId())))
 	//time.Sleep(time.Second * 10000)
 }
+
+// sentBuilders 记录发送的节点 不连接服务器
+type sentBuilders []iface.NodeBuilder
+
+func (s *sentBuilders) SendBuilder(b iface.NodeBuilder) {
+	*s = append(*s, b)
+}
+
+func TestMainNodeProcessor_Events(t *testing.T) {
+	bus := event.NewBus("8613800000000")
+	sub := bus.Subscribe(10)
+	defer sub.Close()
+	sent := &sentBuilders{}
+	m := &MainNodeProcessor{
+		message:      NewMessageProcessor(),
+		call:         NewCallProcessor(sent),
+		notification: NewNotificationProcessor(sent),
+	}
+	m.SetEventBus(bus)
+	group := "8613800000000-1624957782@g.us"
+
+	m.ProcessNode(newxxmp.EmptyNode(NodeChatState, newxxmp.Attributes{
+		newxxmp.NewAttribute("from", group),
+		newxxmp.NewAttribute("participant", "8613800000001@s.whatsapp.net"),
+	}, newxxmp.EmptyNode("composing", newxxmp.Attributes{newxxmp.NewAttribute("media", "audio")})))
+	m.ProcessNode(newxxmp.EmptyNode(NodeNotification, newxxmp.Attributes{
+		newxxmp.NewAttribute("id", "1"),
+		newxxmp.NewAttribute("from", group),
+		newxxmp.NewAttribute("type", notificationTypeWgp2),
+		newxxmp.NewAttribute("participant", "8613800000000@s.whatsapp.net"),
+	}, newxxmp.EmptyNode("add", newxxmp.Nodes{
+		newxxmp.EmptyNode("participant", newxxmp.Attributes{newxxmp.NewAttribute("jid", "8613800000001@s.whatsapp.net")}),
+		newxxmp.EmptyNode("participant", newxxmp.Attributes{newxxmp.NewAttribute("jid", "8613800000002@s.whatsapp.net")}),
+	})))
+	m.ProcessNode(newxxmp.EmptyNode(NodeCall, newxxmp.Attributes{
+		newxxmp.NewAttribute("id", "2"),
+		newxxmp.NewAttribute("from", "8613800000001@s.whatsapp.net"),
+		newxxmp.NewAttribute("t", "1624957782"),
+	}, newxxmp.EmptyNode("offer", newxxmp.Attributes{newxxmp.NewAttribute("call-id", "CALL1")}, newxxmp.EmptyNode("video"))))
+
+	chatState := (<-sub.C()).Event.(*event.ChatStateChanged)
+	if chatState.From != group || chatState.Participant != "8613800000001@s.whatsapp.net" || chatState.State != "composing" || chatState.Media != "audio" {
+		t.Fatalf("ChatStateChanged = %+v", chatState)
+	}
+	participants := (<-sub.C()).Event.(*event.GroupParticipantsChanged)
+	if participants.Group != group || participants.Action != "add" || len(participants.Participants) != 2 || participants.By != "8613800000000@s.whatsapp.net" {
+		t.Fatalf("GroupParticipantsChanged = %+v", participants)
+	}
+	call := (<-sub.C()).Event.(*event.CallOffered)
+	if call.From != "8613800000001@s.whatsapp.net" || call.CallId != "CALL1" || !call.Video {
+		t.Fatalf("CallOffered = %+v", call)
+	}
+	// 通知和来电都需要确认
+	if len(*sent) != 2 {
+		t.Fatalf("sent %d acks, want 2", len(*sent))
+	}
+}
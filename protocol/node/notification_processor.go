@@ -2,6 +2,7 @@ package node
 
 import (
 	"ws-go/protocol/entity"
+	"ws-go/protocol/event"
 	_interface "ws-go/protocol/iface"
 	"ws-go/protocol/newxxmp"
 )
@@ -18,6 +19,9 @@ const (
 // NotificationProcessor
 type NotificationProcessor struct {
 	_interface.IBuildProcessor
+	// identityChanged 联系人的身份密钥变化 from 为联系人 jid
+	identityChanged func(from string)
+	events          *event.Bus
 }
 
 // NewNotificationProcessor
@@ -53,11 +57,13 @@ func (n *NotificationProcessor) handle(node *newxxmp.Node) interface{} {
 func (n *NotificationProcessor) handleEncryptNotification(node *newxxmp.Node) {
 	// 发送确认信号
 	n.sendNotificationAck(*node)
-	// TODO 继续处理
 	children := node.GetChildrenIndex(0)
 	switch children.GetTag() {
 	case "identity":
-		// TODO 获取新的秘钥
+		// 获取新的秘钥 会等待 iq 结果 不能阻塞
+		if n.identityChanged != nil {
+			go n.identityChanged(node.GetAttributeByValue("from"))
+		}
 	}
 }
 
@@ -86,9 +92,19 @@ func (n *NotificationProcessor) handleWgp2Notification(node *newxxmp.Node) {
 	// 处理群事件
 	children := node.GetChildrenIndex(0)
 	switch children.GetTag() {
-	case "add": // 添加成员
-	case "remove": //删除成员
-
+	case "add", "remove", "promote", "demote", "leave": // 成员变化
+		participants := make([]string, 0, len(children.GetChildren()))
+		for _, p := range children.GetChildren() {
+			if jid := p.GetAttributeByValue("jid"); jid != "" {
+				participants = append(participants, jid)
+			}
+		}
+		n.events.Publish(&event.GroupParticipantsChanged{
+			Group:        node.GetAttributeByValue("from"),
+			Action:       children.GetTag(),
+			Participants: participants,
+			By:           node.GetAttributeByValue("participant"),
+		})
 	}
 }
 
@@ -0,0 +1,142 @@
+package node
+
+import (
+	"encoding/binary"
+	"log"
+	"strconv"
+	"ws-go/libsignal/keys/prekey"
+	"ws-go/libsignal/protocol"
+	"ws-go/protocol/entity"
+	"ws-go/protocol/msg"
+	"ws-go/protocol/newxxmp"
+	"ws-go/protocol/waproto"
+
+	"github.com/gogf/gf/util/gconv"
+)
+
+// ReceiptTypeRetry 对方解密失败 需要重新加密后发送
+const ReceiptTypeRetry = "retry"
+
+// parseReceiptRetry
+//<receipt from="8613800000001@s.whatsapp.net" id="3EB0..." type="retry">
+//    <retry count="1" id="3EB0..." t="1624957782" v="1"/>
+//    <registration>4 字节注册 id</registration>
+//</receipt>
+func parseReceiptRetry(node *newxxmp.Node) *entity.ReceiptRetry {
+	retry := &entity.ReceiptRetry{}
+	if retryNode := node.GetChildrenByTag("retry"); retryNode != nil {
+		retry.Count = gconv.Int(retryNode.GetAttributeByValue("count"))
+		retry.T = retryNode.GetAttributeByValue("t")
+	}
+	if registration := node.GetChildrenByTag("registration"); registration != nil && len(registration.GetData()) == 4 {
+		retry.RegistrationId = binary.BigEndian.Uint32(registration.GetData())
+	}
+	return retry
+}
+
+// createRetryMessage 使用原来的 id 重新发送 只发给请求重发的接收者
+func createRetryMessage(to, id, msgType, participant string, veriFiledName uint64, count int, c protocol.CiphertextMessage) *MessageNode {
+	p := &MessageNode{id: id, BaseNode: NewBaseNode()}
+	messageNode := newxxmp.EmptyNode(NodeMessage)
+	messageNode.Attributes.AddAttr("to", to)
+	messageNode.Attributes.AddAttr("type", msgType)
+	messageNode.Attributes.AddAttr("id", id)
+	// 群消息只发给这个成员
+	if participant != "" {
+		messageNode.Attributes.AddAttr("participant", participant)
+	}
+	if veriFiledName != 0 {
+		//商业版本
+		messageNode.Attributes.AddAttr("verified_name", strconv.FormatUint(veriFiledName, 10))
+	}
+	encNode := newxxmp.EmptyNode("enc")
+	encNode.Attributes.AddAttr("v", "2")
+	encNode.Attributes.AddAttr("type", protocol.GetEncTypeString(c.Type()))
+	encNode.Attributes.AddAttr("count", strconv.Itoa(count))
+	encNode.SetData(c.Serialize())
+	messageNode.Children.AddNode(encNode)
+	p.Node = messageNode
+	return p
+}
+
+// rememberSent 保存单聊消息的明文 收到 retry 回执时使用
+func (m *MainNodeProcessor) rememberSent(id, to, msgType string, veriFiledName uint64, plaintext []byte) {
+	m.retries.Add(&msg.SentPlaintext{Id: id, To: to, MsgType: msgType, VerifiedName: veriFiledName, Plaintext: plaintext})
+}
+
+// rememberGroupSent 群消息重发时需要附带自己的 sender key
+func (m *MainNodeProcessor) rememberGroupSent(id string, u, groupId JId, msgType string, veriFiledName uint64, plaintext []byte) {
+	m.retries.Add(&msg.SentPlaintext{
+		Id:           id,
+		To:           groupId.GroupId(),
+		MsgType:      msgType,
+		VerifiedName: veriFiledName,
+		Plaintext:    plaintext,
+		Group:        true,
+		Sender:       u.RawId(),
+	})
+}
+
+// handleRetryReceipt 对方解密失败 重新加密后发送 对方的注册 id 变化时先重新获取 prekeys
+// 获取 prekeys 需要等待 iq 结果 不能在读取节点的 goroutine 中调用
+// 建立 session 和加密在 EncryptResend 的事务中 不会和解密交替修改 session
+func (m *MainNodeProcessor) handleRetryReceipt(e *entity.Receipt) {
+	recipient := e.RecipientId
+	if e.Participant != "" {
+		recipient = e.Participant
+	}
+	sent, n, err := m.retries.Resend(e.MsgId, recipient, m.maxResend)
+	if err != nil {
+		log.Println("retry receipt", e.MsgId, recipient, err)
+		return
+	}
+	jid := NewJid(recipient)
+	var wantRegId uint32
+	if e.Retry != nil {
+		wantRegId = e.Retry.RegistrationId
+	}
+	var bundle *prekey.Bundle
+	regId, ok := m.axolotlManager.RemoteRegistrationId(jid.RawId())
+	if !ok || (wantRegId != 0 && wantRegId != regId) {
+		preKeys, err := m.fetchPreKeys([]string{jid.RawId()}, true)
+		if err != nil {
+			log.Println("retry receipt get prekeys", e.MsgId, recipient, err)
+			return
+		}
+		for _, keys := range preKeys {
+			if keys.GetJid() == jid.RawId() {
+				bundle = keys.CreatePreKeyBundle()
+			}
+		}
+		if bundle == nil {
+			log.Println("retry receipt get prekeys", e.MsgId, recipient, "no prekeys")
+			return
+		}
+	}
+	plaintext := sent.Plaintext
+	if sent.Group {
+		groupId := NewJid(sent.To)
+		skdm, err := m.axolotlManager.CreateGroupSession(groupId.RawId(), sent.Sender)
+		if err != nil {
+			log.Println("retry receipt sender key", e.MsgId, err)
+			return
+		}
+		skdmData, err := waproto.CreatePBWAMessageSkMsg(groupId.GroupId(), skdm.Serialize())
+		if err != nil {
+			log.Println("retry receipt sender key", e.MsgId, err)
+			return
+		}
+		// 两条 protobuf 拼接后解析为一条消息
+		plaintext = append(skdmData, sent.Plaintext...)
+	}
+	c, err := m.axolotlManager.EncryptResend(jid.RawId(), wantRegId, bundle, plaintext)
+	if err != nil {
+		log.Println("retry receipt encrypt", e.MsgId, recipient, err)
+		return
+	}
+	count := n
+	if e.Retry != nil && e.Retry.Count > 0 {
+		count = e.Retry.Count
+	}
+	m.SendBuilder(createRetryMessage(e.RecipientId, sent.Id, sent.MsgType, e.Participant, sent.VerifiedName, count, c))
+}
@@ -0,0 +1,143 @@
+package node
+
+import (
+	"encoding/binary"
+	"testing"
+	"ws-go/libsignal/keys/prekey"
+	"ws-go/libsignal/util/optional"
+	"ws-go/protocol/axolotl"
+	"ws-go/protocol/axolotl/store"
+	"ws-go/protocol/entity"
+	"ws-go/protocol/msg"
+	"ws-go/protocol/newxxmp"
+	"ws-go/protocol/waproto"
+
+	"github.com/gogf/gf/container/gqueue"
+	"github.com/golang/protobuf/proto"
+)
+
+func newTestAxolotl(t *testing.T) *axolotl.Manager {
+	t.Helper()
+	m, err := axolotl.NewAxolotlManager(store.NewMemoryBackend(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func bundleOf(t *testing.T, m *axolotl.Manager) *prekey.Bundle {
+	t.Helper()
+	preKeys, err := m.LoadUnSendPreKey()
+	if err != nil || len(preKeys) == 0 {
+		t.Fatalf("LoadUnSendPreKey = %d, %v", len(preKeys), err)
+	}
+	signedPreKey := m.SignedPreKeyStore.LoadSignedPreKey(0)
+	return prekey.NewBundle(
+		m.IdentityStore.GetLocalRegistrationId(),
+		0,
+		optional.NewOptionalUint32(preKeys[0].ID().Value),
+		signedPreKey.ID(),
+		preKeys[0].KeyPair().PublicKey(),
+		signedPreKey.KeyPair().PublicKey(),
+		signedPreKey.Signature(),
+		m.IdentityStore.GetIdentityKeyPair().PublicKey(),
+	)
+}
+
+func retryReceiptNode(from, id, participant string, count string, regId uint32) *newxxmp.Node {
+	regData := make([]byte, 4)
+	binary.BigEndian.PutUint32(regData, regId)
+	attrs := newxxmp.Attributes{
+		newxxmp.NewAttribute("from", from),
+		newxxmp.NewAttribute("id", id),
+		newxxmp.NewAttribute("type", ReceiptTypeRetry),
+	}
+	if participant != "" {
+		attrs = append(attrs, newxxmp.NewAttribute("participant", participant))
+	}
+	return newxxmp.EmptyNode(NodeReceipt, attrs, newxxmp.Nodes{
+		newxxmp.EmptyNode("retry", newxxmp.Attributes{
+			newxxmp.NewAttribute("count", count),
+			newxxmp.NewAttribute("id", id),
+			newxxmp.NewAttribute("t", "1624957782"),
+			newxxmp.NewAttribute("v", "1"),
+		}),
+		// 和解码后的节点一样带长度前缀
+		newxxmp.EmptyNode("registration", newxxmp.Int8LengthArray(regData).GetTokenBytes()),
+	})
+}
+
+// resent 取出重发的消息 bob 解密
+func resent(t *testing.T, m *MainNodeProcessor, bob *axolotl.Manager, from string) (*newxxmp.Node, *waproto.Message) {
+	t.Helper()
+	if m.sendQueue.Size() != 1 {
+		t.Fatalf("sent %d nodes, want 1", m.sendQueue.Size())
+	}
+	n := m.sendQueue.Pop().(*MessageNode).Node
+	enc := n.GetChildrenByTag("enc")
+	d, err := bob.Decrypt(from, "", enc.GetData(), enc.GetAttributeByValue("type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &waproto.Message{}
+	if err := proto.Unmarshal(d, message); err != nil {
+		t.Fatal(err)
+	}
+	return n, message
+}
+
+func TestMainNodeProcessor_RetryReceipt(t *testing.T) {
+	const (
+		aliceID = "8613800000001"
+		bobID   = "8613800000002"
+		bobJid  = bobID + "@s.whatsapp.net"
+	)
+	alice, bob := newTestAxolotl(t), newTestAxolotl(t)
+	if err := alice.CreateSession(bobID, bundleOf(t, bob)); err != nil {
+		t.Fatal(err)
+	}
+	bobRegId := bob.IdentityStore.GetLocalRegistrationId()
+	if regId, ok := alice.RemoteRegistrationId(bobJid); !ok || regId != bobRegId {
+		t.Fatalf("RemoteRegistrationId = %d, %v want %d", regId, ok, bobRegId)
+	}
+	m := &MainNodeProcessor{
+		processor:      &processor{sendQueue: gqueue.New(10)},
+		message:        NewMessageProcessor(),
+		axolotlManager: alice,
+		retries:        msg.NewRetryCache(0, 0),
+		maxResend:      1,
+	}
+	plaintext, _ := proto.Marshal(&waproto.Message{Conversation: proto.String("hello")})
+	m.rememberSent("3EB0R1", bobJid, "text", 0, plaintext)
+
+	receipt := m.message.Handle(retryReceiptNode(bobJid, "3EB0R1", "", "1", bobRegId)).(*entity.Receipt)
+	if receipt.Retry == nil || receipt.Retry.Count != 1 || receipt.Retry.RegistrationId != bobRegId {
+		t.Fatalf("Retry = %+v", receipt.Retry)
+	}
+	m.handleRetryReceipt(receipt)
+	n, message := resent(t, m, bob, aliceID)
+	if n.GetAttributeByValue("id") != "3EB0R1" || n.GetAttributeByValue("to") != bobJid || n.GetAttributeByValue("type") != "text" {
+		t.Fatalf("resent node = %v", n.Attributes)
+	}
+	if n.GetChildrenByTag("enc").GetAttributeByValue("count") != "1" || message.GetConversation() != "hello" {
+		t.Fatalf("resent message = %v", message)
+	}
+	// 超过重发次数不再发送
+	m.handleRetryReceipt(receipt)
+	if m.sendQueue.Size() != 0 {
+		t.Fatal("resent over the limit")
+	}
+
+	// 群消息附带 sender key 只发给请求的成员
+	group := NewJid("8613800000000-1624957782@g.us")
+	content, _ := proto.Marshal(&waproto.Message{Conversation: proto.String("group hello")})
+	m.rememberGroupSent("3EB0R2", NewJid(aliceID), group, "text", 0, content)
+	m.handleRetryReceipt(m.message.Handle(retryReceiptNode(group.GroupId(), "3EB0R2", bobJid, "2", bobRegId)).(*entity.Receipt))
+	n, message = resent(t, m, bob, aliceID)
+	if n.GetAttributeByValue("to") != group.GroupId() || n.GetAttributeByValue("participant") != bobJid {
+		t.Fatalf("resent group node = %v", n.Attributes)
+	}
+	if message.GetConversation() != "group hello" || message.GetSenderKeyDistributionMessage() == nil {
+		t.Fatalf("resent group message = %v", message)
+	}
+}
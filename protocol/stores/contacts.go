@@ -1,10 +1,11 @@
 package stores
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gogf/gf/database/gdb"
-	"os"
+	"ws-go/protocol/db/migrate"
 	"ws-go/protocol/define"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -16,10 +17,9 @@ type ContactStores struct {
 	UserName string
 }
 
-// createContactTables create contact tables
-func (c *ContactStores) createContactTables() {
-	// create contacts
-	_, err := c.dbSource.Exec(`CREATE TABLE "wa_contacts" (
+// contactMigrations 联系人数据库的迁移 新的表结构修改在末尾追加版本
+var contactMigrations = []migrate.Migration{
+	{Version: 1, Description: "create contact tables", Up: migrate.Exec(`CREATE TABLE IF NOT EXISTS "wa_contacts" (
 	"_id"	INTEGER PRIMARY KEY AUTOINCREMENT,
 	"jid"	TEXT NOT NULL,
 	"is_whatsapp_user"	BOOLEAN NOT NULL,
@@ -46,49 +46,29 @@ func (c *ContactStores) createContactTables() {
 	"is_spam_reported"	INTEGER,
 	"is_sidelist_synced"	BOOLEAN DEFAULT 0,
 	"is_business_synced"	BOOLEAN DEFAULT 0
-);`)
-	if err != nil {
-		panic(err)
-	}
-	// group participants
-	_, err = c.dbSource.Exec(`
-		CREATE TABLE "group_participants" (
+);`, `
+		CREATE TABLE IF NOT EXISTS "group_participants" (
 		"_id"	INTEGER PRIMARY KEY AUTOINCREMENT,
 		"gjid"	TEXT NOT NULL,
 		"jid"	TEXT NOT NULL,
 		"admin"	INTEGER,
 		"pending"	INTEGER,
 		"sent_sender_key"	INTEGER);
-	`)
-	if err != nil {
-		panic(err)
-	}
+	`)},
 }
 
-// check check contact data bases  is exist
-func (c *ContactStores) check() bool {
-	dbPath := fmt.Sprintf("%s/%s/contacts", define.DefaultDbPath, c.UserName)
-	// add data bases config
-	gdb.AddConfigNode(c.UserName, gdb.ConfigNode{
-		Type:    "sqlite",
-		Charset: "utf8",
-		Link:    dbPath,
-	})
-
-	// get data base connect
-	if db, err := gdb.New(c.UserName); err != nil {
-		return false
-	} else {
-		c.dbSource = db
+// open 打开联系人数据库并执行迁移
+func (c *ContactStores) open(dbPath string) error {
+	db, err := migrate.OpenSQLite(dbPath)
+	if err != nil {
+		return err
 	}
-
-	// exist databases file
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		// create tables
-		c.createContactTables()
+	if _, err := migrate.Run(db, contactMigrations); err != nil {
+		_ = db.Close(context.Background())
+		return fmt.Errorf("migrate contacts %s: %w", dbPath, err)
 	}
-
-	return true
+	c.dbSource = db
+	return nil
 }
 
 // AddContact add contact to data base
@@ -165,10 +145,12 @@ func (c *ContactStores) DelGroupParticipant(gJid, jid string) error {
 	return err
 }
 
-// NewContactStores
-func NewContactStores(u string) *ContactStores {
+// NewContactStores 数据库版本比程序新时返回错误
+func NewContactStores(u string) (*ContactStores, error) {
 	contactStores := &ContactStores{UserName: u}
-	// check data bases
-	contactStores.check()
-	return contactStores
+	dbPath := fmt.Sprintf("%s/%s/contacts", define.DefaultDbPath, u)
+	if err := contactStores.open(dbPath); err != nil {
+		return nil, err
+	}
+	return contactStores, nil
 }
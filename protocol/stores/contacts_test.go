@@ -1,7 +1,77 @@
 package stores
 
-import "testing"
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"ws-go/protocol/db/migrate"
+)
 
-func TestNewContactStores(t *testing.T) {
-	NewContactStores("test")
+func TestContactStores_Open(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contacts")
+	c := &ContactStores{UserName: "test"}
+	if err := c.open(path); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := migrate.Version(c.dbSource); err != nil || v != migrate.Latest(contactMigrations) {
+		t.Fatalf("version = %d, %v", v, err)
+	}
+	// 当前的表结构
+	if err := c.AddContact("8613800000001@s.whatsapp.net", "name", "status", "8613800000001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddGroupParticipant("8613800000000-1624957782@g.us", "8613800000001@s.whatsapp.net", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateGroupParticipantSentSenderKey("8613800000000-1624957782@g.us", "8613800000001@s.whatsapp.net", 1); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetSentSenderKey("8613800000000-1624957782@g.us", "8613800000001@s.whatsapp.net"); err != nil || v != 1 {
+		t.Fatalf("GetSentSenderKey = %d, %v", v, err)
+	}
+
+	// 重新打开保留数据
+	c = &ContactStores{UserName: "test"}
+	if err := c.open(path); err != nil {
+		t.Fatal(err)
+	}
+	all, err := c.GetAllContact()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("GetAllContact = %d, %v", len(all), err)
+	}
+}
+
+func TestContactStores_OpenTooNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contacts")
+	c := &ContactStores{UserName: "test"}
+	if err := c.open(path); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.dbSource.Exec("INSERT INTO schema_version (version, description) VALUES (?, 'future')", migrate.Latest(contactMigrations)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&ContactStores{UserName: "test"}).open(path); !errors.Is(err, migrate.ErrTooNew) {
+		t.Fatalf("open err = %v, want ErrTooNew", err)
+	}
+}
+
+func TestContactStores_OpenLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contacts")
+	c := &ContactStores{UserName: "test"}
+	if err := c.open(path); err != nil {
+		t.Fatal(err)
+	}
+	// 旧版本程序新建的数据库没有版本表
+	if _, err := c.dbSource.Exec("DROP TABLE schema_version"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.AddContact("8613800000001@s.whatsapp.net", "name", "", "")
+	c = &ContactStores{UserName: "test"}
+	if err := c.open(path); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := c.GetAllContact(); err != nil || len(all) != 1 {
+		t.Fatalf("GetAllContact = %d, %v", len(all), err)
+	}
 }
@@ -0,0 +1,355 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"ws-go/protocol/db/migrate"
+	"ws-go/protocol/define"
+	"ws-go/protocol/entity"
+	"ws-go/protocol/waproto"
+
+	"github.com/gogf/gf/database/gdb"
+	"github.com/gogf/gf/os/gtime"
+	"github.com/gogf/gf/util/gconv"
+	"github.com/golang/protobuf/proto"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ErrMessageNotFound
+var ErrMessageNotFound = errors.New("message not found")
+
+// MediaInfo 媒体消息的下载信息
+type MediaInfo struct {
+	Mimetype      string `json:"mimetype"`
+	Url           string `json:"url"`
+	DirectPath    string `json:"directPath"`
+	MediaKey      []byte `json:"mediaKey"`
+	FileSha256    []byte `json:"fileSha256"`
+	FileEncSha256 []byte `json:"fileEncSha256"`
+	FileLength    uint64 `json:"fileLength"`
+}
+
+// StoredMessage 保存的收到的消息 Id 为 stanza id Sender 为发送者 群消息时是 participant
+type StoredMessage struct {
+	Id          string              `json:"id"`
+	Chat        string              `json:"chat"`
+	Sender      string              `json:"sender"`
+	Participant string              `json:"participant,omitempty"`
+	ContextType string              `json:"contextType"`
+	MsgType     entity.MessageKind  `json:"msgType"`
+	Timestamp   int64               `json:"timestamp"`
+	Text        string              `json:"text,omitempty"`
+	Media       *MediaInfo          `json:"media,omitempty"`
+	Content     entity.TypedMessage `json:"content,omitempty"`
+	Message     *waproto.Message    `json:"message,omitempty"`
+	ReceivedAt  int64               `json:"receivedAt"`
+}
+
+// ChatSummary 会话列表
+type ChatSummary struct {
+	Chat          string `json:"chat"`
+	LastMessageId string `json:"lastMessageId"`
+	LastTimestamp int64  `json:"lastTimestamp"`
+	LastText      string `json:"lastText,omitempty"`
+	Count         int    `json:"count"`
+}
+
+// messageMigrations 收到的消息的数据库迁移 新的表结构修改在末尾追加版本
+var messageMigrations = []migrate.Migration{
+	{Version: 1, Description: "create message tables", Up: migrate.Exec(`CREATE TABLE IF NOT EXISTS "messages" (
+	"_id"	INTEGER PRIMARY KEY AUTOINCREMENT,
+	"stanza_id"	TEXT NOT NULL UNIQUE,
+	"chat"	TEXT NOT NULL,
+	"sender"	TEXT NOT NULL,
+	"participant"	TEXT,
+	"context_type"	TEXT,
+	"msg_type"	TEXT,
+	"timestamp"	INTEGER,
+	"text"	TEXT,
+	"media_mimetype"	TEXT,
+	"media_url"	TEXT,
+	"media_direct_path"	TEXT,
+	"media_key"	BLOB,
+	"media_file_sha256"	BLOB,
+	"media_file_enc_sha256"	BLOB,
+	"media_file_length"	INTEGER,
+	"message"	BLOB,
+	"received_at"	INTEGER NOT NULL
+);`, `CREATE INDEX IF NOT EXISTS "messages_chat" ON "messages" ("chat", "_id");`)},
+	{Version: 2, Description: "create quarantine table", Up: migrate.Exec(`CREATE TABLE IF NOT EXISTS "quarantine" (
+	"_id"	INTEGER PRIMARY KEY AUTOINCREMENT,
+	"stanza_id"	TEXT NOT NULL UNIQUE,
+	"chat"	TEXT NOT NULL,
+	"participant"	TEXT,
+	"sender"	TEXT NOT NULL,
+	"context_type"	TEXT,
+	"timestamp"	INTEGER,
+	"encs"	TEXT NOT NULL,
+	"error_class"	TEXT,
+	"error"	TEXT,
+	"attempts"	INTEGER NOT NULL DEFAULT 0,
+	"quarantined_at"	INTEGER NOT NULL,
+	"last_attempt_at"	INTEGER
+);`, `CREATE INDEX IF NOT EXISTS "quarantine_sender" ON "quarantine" ("sender", "chat");`)},
+}
+
+// MessageStores 收到的消息 保存在账号目录的 sqlite 中
+type MessageStores struct {
+	dbSource gdb.DB
+	UserName string
+}
+
+// open 打开消息数据库并执行迁移
+func (m *MessageStores) open(dbPath string) error {
+	db, err := migrate.OpenSQLite(dbPath)
+	if err != nil {
+		return err
+	}
+	if _, err := migrate.Run(db, messageMigrations); err != nil {
+		_ = db.Close(context.Background())
+		return fmt.Errorf("migrate messages %s: %w", dbPath, err)
+	}
+	m.dbSource = db
+	return nil
+}
+
+// Close
+func (m *MessageStores) Close() error {
+	return m.dbSource.Close(context.Background())
+}
+
+// SaveChatMessage 保存解密后的消息 stanza id 已经存在时不保存 返回 false
+func (m *MessageStores) SaveChatMessage(message *entity.ChatMessage) (bool, error) {
+	if message.Id() == "" {
+		return false, errors.New("message stanza id is empty")
+	}
+	sender := message.From()
+	if message.Participant() != "" {
+		sender = message.Participant()
+	}
+	data := gdb.Map{
+		"stanza_id":    message.Id(),
+		"chat":         message.From(),
+		"sender":       sender,
+		"participant":  message.Participant(),
+		"context_type": message.ContextType(),
+		"timestamp":    gconv.Int64(message.T()),
+		"received_at":  gtime.Timestamp(),
+	}
+	if pb := message.GetMessage(); pb != nil {
+		d, err := proto.Marshal(pb)
+		if err != nil {
+			return false, err
+		}
+		data["message"] = d
+		typed := entity.ParseProtoMessage(pb)
+		data["msg_type"] = string(typed.Kind())
+		data["text"] = messageText(typed)
+		if media := MediaOf(pb); media != nil {
+			data["media_mimetype"] = media.Mimetype
+			data["media_url"] = media.Url
+			data["media_direct_path"] = media.DirectPath
+			data["media_key"] = media.MediaKey
+			data["media_file_sha256"] = media.FileSha256
+			data["media_file_enc_sha256"] = media.FileEncSha256
+			data["media_file_length"] = media.FileLength
+		}
+	}
+	// gdb 的 sqlite 驱动生成的是 mysql 的 INSERT IGNORE
+	columns := make([]string, 0, len(data))
+	holders := make([]string, 0, len(data))
+	args := make([]interface{}, 0, len(data))
+	for k, v := range data {
+		columns = append(columns, k)
+		holders = append(holders, "?")
+		args = append(args, v)
+	}
+	r, err := m.dbSource.Exec(fmt.Sprintf("INSERT OR IGNORE INTO messages (%s) VALUES (%s)",
+		strings.Join(columns, ","), strings.Join(holders, ",")), args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	return n > 0, err
+}
+
+// GetMessage 按 stanza id 查询
+func (m *MessageStores) GetMessage(id string) (*StoredMessage, error) {
+	one, err := m.dbSource.Model("messages").Where("stanza_id=?", id).FindOne()
+	if err != nil {
+		return nil, err
+	}
+	if one.IsEmpty() {
+		return nil, ErrMessageNotFound
+	}
+	return recordToMessage(one)
+}
+
+// ChatHistory 会话的消息 按收到的顺序倒序 before 为上一页最后一条的 id 为空时从最新的开始
+func (m *MessageStores) ChatHistory(chat, before string, limit int) ([]*StoredMessage, error) {
+	model := m.dbSource.Model("messages").Where("chat=?", chat)
+	if before != "" {
+		one, err := m.dbSource.Model("messages").Fields("_id").Where("stanza_id=? AND chat=?", before, chat).FindOne()
+		if err != nil {
+			return nil, err
+		}
+		if one.IsEmpty() {
+			return nil, ErrMessageNotFound
+		}
+		model = model.Where("_id<?", one["_id"].Int64())
+	}
+	if limit > 0 {
+		model = model.Limit(limit)
+	}
+	all, err := model.Order("_id DESC").All()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*StoredMessage, 0, len(all))
+	for _, one := range all {
+		message, err := recordToMessage(one)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// Chats 有消息的会话 按最后一条消息倒序
+func (m *MessageStores) Chats(limit int) ([]*ChatSummary, error) {
+	sql := `SELECT m.chat, m.stanza_id, m.timestamp, m.text, c.count FROM messages m
+		JOIN (SELECT chat, MAX(_id) AS last_id, COUNT(*) AS count FROM messages GROUP BY chat) c ON m._id = c.last_id
+		ORDER BY m._id DESC`
+	args := []interface{}{}
+	if limit > 0 {
+		sql += " LIMIT ?"
+		args = append(args, limit)
+	}
+	all, err := m.dbSource.GetAll(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	chats := make([]*ChatSummary, 0, len(all))
+	for _, one := range all {
+		chats = append(chats, &ChatSummary{
+			Chat:          one["chat"].String(),
+			LastMessageId: one["stanza_id"].String(),
+			LastTimestamp: one["timestamp"].Int64(),
+			LastText:      one["text"].String(),
+			Count:         one["count"].Int(),
+		})
+	}
+	return chats, nil
+}
+
+func recordToMessage(one gdb.Record) (*StoredMessage, error) {
+	message := &StoredMessage{
+		Id:          one["stanza_id"].String(),
+		Chat:        one["chat"].String(),
+		Sender:      one["sender"].String(),
+		Participant: one["participant"].String(),
+		ContextType: one["context_type"].String(),
+		MsgType:     entity.MessageKind(one["msg_type"].String()),
+		Timestamp:   one["timestamp"].Int64(),
+		Text:        one["text"].String(),
+		ReceivedAt:  one["received_at"].Int64(),
+	}
+	if d := one["message"].Bytes(); len(d) > 0 {
+		message.Message = &waproto.Message{}
+		if err := proto.Unmarshal(d, message.Message); err != nil {
+			return nil, err
+		}
+		message.Content = entity.ParseProtoMessage(message.Message)
+	}
+	if one["media_direct_path"].String() != "" || one["media_url"].String() != "" {
+		message.Media = &MediaInfo{
+			Mimetype:      one["media_mimetype"].String(),
+			Url:           one["media_url"].String(),
+			DirectPath:    one["media_direct_path"].String(),
+			MediaKey:      one["media_key"].Bytes(),
+			FileSha256:    one["media_file_sha256"].Bytes(),
+			FileEncSha256: one["media_file_enc_sha256"].Bytes(),
+			FileLength:    one["media_file_length"].Uint64(),
+		}
+	}
+	return message, nil
+}
+
+// mediaMessage 图片 视频 音频 文件 贴图的公共字段
+type mediaMessage interface {
+	GetUrl() string
+	GetMimetype() string
+	GetDirectPath() string
+	GetMediaKey() []byte
+	GetFileSha256() []byte
+	GetFileEncSha256() []byte
+	GetFileLength() uint64
+}
+
+// MediaOf 媒体消息的下载信息 不是媒体消息时返回 nil
+func MediaOf(msg *waproto.Message) *MediaInfo {
+	msg = entity.UnwrapMessage(msg)
+	var media mediaMessage
+	switch {
+	case msg.GetImageMessage() != nil:
+		media = msg.GetImageMessage()
+	case msg.GetVideoMessage() != nil:
+		media = msg.GetVideoMessage()
+	case msg.GetAudioMessage() != nil:
+		media = msg.GetAudioMessage()
+	case msg.GetDocumentMessage() != nil:
+		media = msg.GetDocumentMessage()
+	case msg.GetStickerMessage() != nil:
+		media = msg.GetStickerMessage()
+	default:
+		return nil
+	}
+	return &MediaInfo{
+		Mimetype:      media.GetMimetype(),
+		Url:           media.GetUrl(),
+		DirectPath:    media.GetDirectPath(),
+		MediaKey:      media.GetMediaKey(),
+		FileSha256:    media.GetFileSha256(),
+		FileEncSha256: media.GetFileEncSha256(),
+		FileLength:    media.GetFileLength(),
+	}
+}
+
+// messageText 文本或者媒体的说明
+func messageText(msg entity.TypedMessage) string {
+	switch m := msg.(type) {
+	case entity.TextMessage:
+		return m.Text
+	case entity.ImageMessage:
+		return m.Caption
+	case entity.VideoMessage:
+		return m.Caption
+	case entity.DocumentMessage:
+		return m.FileName
+	case entity.ReactionMessage:
+		return m.Text
+	}
+	return ""
+}
+
+// NewMessageStores 数据库版本比程序新时返回错误
+func NewMessageStores(u string) (*MessageStores, error) {
+	messageStores, err := OpenMessageStores(fmt.Sprintf("%s/%s/messages", define.DefaultDbPath, u))
+	if err != nil {
+		return nil, err
+	}
+	messageStores.UserName = u
+	return messageStores, nil
+}
+
+// OpenMessageStores 打开 dbPath 的消息数据库
+func OpenMessageStores(dbPath string) (*MessageStores, error) {
+	messageStores := &MessageStores{}
+	if err := messageStores.open(dbPath); err != nil {
+		return nil, err
+	}
+	return messageStores, nil
+}
@@ -0,0 +1,117 @@
+package stores
+
+import (
+	"path/filepath"
+	"testing"
+	"ws-go/protocol/entity"
+	"ws-go/protocol/waproto"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func newChatMessage(id, from, participant, t string, msg *waproto.Message) *entity.ChatMessage {
+	m := entity.EmptyChatMessage()
+	m.SetId(id)
+	m.SetFrom(from)
+	m.SetParticipant(participant)
+	m.SetT(t)
+	m.SetContextType("text")
+	m.SetMessage(msg)
+	return m
+}
+
+func openTestMessageStores(t *testing.T) (*MessageStores, string) {
+	path := filepath.Join(t.TempDir(), "messages")
+	m := &MessageStores{UserName: "test"}
+	if err := m.open(path); err != nil {
+		t.Fatal(err)
+	}
+	return m, path
+}
+
+func TestMessageStores_SaveChatMessage(t *testing.T) {
+	m, path := openTestMessageStores(t)
+	const group = "8613800000000-1624957782@g.us"
+	text := newChatMessage("3EB0A1", group, "8613800000001@s.whatsapp.net", "1624957782",
+		&waproto.Message{Conversation: proto.String("hello")})
+	if ok, err := m.SaveChatMessage(text); err != nil || !ok {
+		t.Fatalf("SaveChatMessage = %v, %v", ok, err)
+	}
+	// 重复的 stanza id 不保存
+	if ok, err := m.SaveChatMessage(text); err != nil || ok {
+		t.Fatalf("duplicate SaveChatMessage = %v, %v", ok, err)
+	}
+	image := newChatMessage("3EB0A2", group, "8613800000002@s.whatsapp.net", "1624957783",
+		&waproto.Message{ImageMessage: &waproto.ImageMessage{
+			Mimetype:   proto.String("image/jpeg"),
+			DirectPath: proto.String("/v/t62.7118-24/1.enc"),
+			MediaKey:   []byte{1, 2, 3},
+			FileLength: proto.Uint64(1024),
+			Caption:    proto.String("photo"),
+		}})
+	if _, err := m.SaveChatMessage(image); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.Close()
+
+	// 重新打开保留数据
+	m = &MessageStores{UserName: "test"}
+	if err := m.open(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.GetMessage("3EB0A2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Chat != group || got.Sender != "8613800000002@s.whatsapp.net" || got.MsgType != "image" ||
+		got.Text != "photo" || got.Timestamp != 1624957783 {
+		t.Fatalf("GetMessage = %+v", got)
+	}
+	if got.Media == nil || got.Media.DirectPath != "/v/t62.7118-24/1.enc" || got.Media.FileLength != 1024 || len(got.Media.MediaKey) != 3 {
+		t.Fatalf("Media = %+v", got.Media)
+	}
+	if got.Message.GetImageMessage().GetCaption() != "photo" {
+		t.Fatalf("Message = %v", got.Message)
+	}
+	if _, err := m.GetMessage("missing"); err != ErrMessageNotFound {
+		t.Fatalf("GetMessage(missing) err = %v", err)
+	}
+}
+
+func TestMessageStores_History(t *testing.T) {
+	m, _ := openTestMessageStores(t)
+	const a, b = "8613800000001@s.whatsapp.net", "8613800000002@s.whatsapp.net"
+	for i, id := range []string{"1", "2", "3", "4"} {
+		if _, err := m.SaveChatMessage(newChatMessage(id, a, "", "100", &waproto.Message{Conversation: proto.String("a" + id)})); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			if _, err := m.SaveChatMessage(newChatMessage("b1", b, "", "100", &waproto.Message{Conversation: proto.String("b")})); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	page, err := m.ChatHistory(a, "", 3)
+	if err != nil || len(page) != 3 || page[0].Id != "4" || page[2].Id != "2" {
+		t.Fatalf("first page = %v, %v", page, err)
+	}
+	page, err = m.ChatHistory(a, page[2].Id, 3)
+	if err != nil || len(page) != 1 || page[0].Id != "1" {
+		t.Fatalf("second page = %v, %v", page, err)
+	}
+	if _, err := m.ChatHistory(a, "b1", 3); err != ErrMessageNotFound {
+		t.Fatalf("cursor from another chat err = %v", err)
+	}
+
+	chats, err := m.Chats(0)
+	if err != nil || len(chats) != 2 {
+		t.Fatalf("Chats = %v, %v", chats, err)
+	}
+	if chats[0].Chat != a || chats[0].Count != 4 || chats[0].LastMessageId != "4" || chats[0].LastText != "a4" {
+		t.Fatalf("chats[0] = %+v", chats[0])
+	}
+	if chats[1].Chat != b || chats[1].Count != 1 {
+		t.Fatalf("chats[1] = %+v", chats[1])
+	}
+}
@@ -0,0 +1,167 @@
+package stores
+
+import (
+	"encoding/json"
+	"ws-go/protocol/entity"
+
+	"github.com/gogf/gf/database/gdb"
+	"github.com/gogf/gf/os/gtime"
+	"github.com/gogf/gf/util/gconv"
+)
+
+// QuarantinedEnc 解密失败的 enc 节点
+type QuarantinedEnc struct {
+	Type string `json:"type"`
+	V    string `json:"v"`
+	Data []byte `json:"data"`
+}
+
+// QuarantinedMessage 解密失败的消息 Sender 为需要密钥的一方 群消息时是 participant
+// Attempts 为重新解密的次数 不包括收到时的解密
+type QuarantinedMessage struct {
+	Id            string            `json:"id"`
+	Chat          string            `json:"chat"`
+	Participant   string            `json:"participant,omitempty"`
+	Sender        string            `json:"sender"`
+	ContextType   string            `json:"contextType"`
+	Timestamp     int64             `json:"timestamp"`
+	Encs          []*QuarantinedEnc `json:"encs"`
+	ErrorClass    string            `json:"errorClass"`
+	Error         string            `json:"error"`
+	Attempts      int               `json:"attempts"`
+	QuarantinedAt int64             `json:"quarantinedAt"`
+	LastAttemptAt int64             `json:"lastAttemptAt,omitempty"`
+}
+
+// ChatMessage 重新解密时使用 和收到时的消息相同
+func (q *QuarantinedMessage) ChatMessage() *entity.ChatMessage {
+	message := entity.EmptyChatMessage()
+	message.SetId(q.Id)
+	message.SetFrom(q.Chat)
+	message.SetParticipant(q.Participant)
+	message.SetContextType(q.ContextType)
+	message.SetT(gconv.String(q.Timestamp))
+	encList := make([]*entity.Enc, 0, len(q.Encs))
+	for _, enc := range q.Encs {
+		encList = append(encList, entity.NewEnc(enc.Data, enc.Type, enc.V))
+	}
+	message.SetEncList(encList)
+	return message
+}
+
+// Quarantine 保存解密失败的消息 已经隔离时只更新错误 第一次隔离时返回 true
+func (m *MessageStores) Quarantine(message *entity.ChatMessage, errorClass, errText string) (bool, error) {
+	encs := make([]*QuarantinedEnc, 0, len(message.EncList()))
+	for _, enc := range message.EncList() {
+		encs = append(encs, &QuarantinedEnc{Type: enc.EncType(), V: enc.V(), Data: enc.Data()})
+	}
+	encData, err := json.Marshal(encs)
+	if err != nil {
+		return false, err
+	}
+	sender := message.From()
+	if message.Participant() != "" {
+		sender = message.Participant()
+	}
+	r, err := m.dbSource.Exec(`INSERT OR IGNORE INTO quarantine
+		(stanza_id, chat, participant, sender, context_type, timestamp, encs, error_class, error, quarantined_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		message.Id(), message.From(), message.Participant(), sender, message.ContextType(),
+		gconv.Int64(message.T()), string(encData), errorClass, errText, gtime.Timestamp())
+	if err != nil {
+		return false, err
+	}
+	if n, err := r.RowsAffected(); err != nil || n > 0 {
+		return n > 0, err
+	}
+	_, err = m.dbSource.Model("quarantine").Data(gdb.Map{
+		"error_class": errorClass,
+		"error":       errText,
+	}).Where("stanza_id=?", message.Id()).Update()
+	return false, err
+}
+
+// QuarantineAttempt 记录一次失败的重新解密
+func (m *MessageStores) QuarantineAttempt(id, errorClass, errText string) error {
+	_, err := m.dbSource.Exec(`UPDATE quarantine SET attempts=attempts+1, error_class=?, error=?, last_attempt_at=?
+		WHERE stanza_id=?`, errorClass, errText, gtime.Timestamp(), id)
+	return err
+}
+
+// RemoveQuarantined 解密成功后删除 不存在时返回 false
+func (m *MessageStores) RemoveQuarantined(id string) (bool, error) {
+	r, err := m.dbSource.Model("quarantine").Where("stanza_id=?", id).Delete()
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	return n > 0, err
+}
+
+// GetQuarantined 按 stanza id 查询
+func (m *MessageStores) GetQuarantined(id string) (*QuarantinedMessage, error) {
+	one, err := m.dbSource.Model("quarantine").Where("stanza_id=?", id).FindOne()
+	if err != nil {
+		return nil, err
+	}
+	if one.IsEmpty() {
+		return nil, ErrMessageNotFound
+	}
+	return recordToQuarantined(one)
+}
+
+// ListQuarantined 按隔离的顺序倒序 sender 为空时返回所有的
+func (m *MessageStores) ListQuarantined(sender string, limit int) ([]*QuarantinedMessage, error) {
+	model := m.dbSource.Model("quarantine")
+	if sender != "" {
+		model = model.Where("sender=?", sender)
+	}
+	if limit > 0 {
+		model = model.Limit(limit)
+	}
+	return recordsToQuarantined(model.Order("_id DESC").All())
+}
+
+// QuarantinedFrom 需要 sender 的密钥的消息 按收到的顺序 chat 不为空时只返回这个会话的
+func (m *MessageStores) QuarantinedFrom(sender, chat string) ([]*QuarantinedMessage, error) {
+	model := m.dbSource.Model("quarantine").Where("sender=?", sender)
+	if chat != "" {
+		model = model.Where("chat=?", chat)
+	}
+	return recordsToQuarantined(model.Order("_id ASC").All())
+}
+
+func recordsToQuarantined(all gdb.Result, err error) ([]*QuarantinedMessage, error) {
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*QuarantinedMessage, 0, len(all))
+	for _, one := range all {
+		message, err := recordToQuarantined(one)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func recordToQuarantined(one gdb.Record) (*QuarantinedMessage, error) {
+	message := &QuarantinedMessage{
+		Id:            one["stanza_id"].String(),
+		Chat:          one["chat"].String(),
+		Participant:   one["participant"].String(),
+		Sender:        one["sender"].String(),
+		ContextType:   one["context_type"].String(),
+		Timestamp:     one["timestamp"].Int64(),
+		ErrorClass:    one["error_class"].String(),
+		Error:         one["error"].String(),
+		Attempts:      one["attempts"].Int(),
+		QuarantinedAt: one["quarantined_at"].Int64(),
+		LastAttemptAt: one["last_attempt_at"].Int64(),
+	}
+	if err := json.Unmarshal(one["encs"].Bytes(), &message.Encs); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
@@ -0,0 +1,74 @@
+package stores
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"ws-go/protocol/entity"
+)
+
+func newEncMessage(id, from, participant string, encs ...*entity.Enc) *entity.ChatMessage {
+	m := newChatMessage(id, from, participant, "1624957782", nil)
+	m.SetEncList(encs)
+	return m
+}
+
+func TestMessageStores_Quarantine(t *testing.T) {
+	m, _ := openTestMessageStores(t)
+	const (
+		group = "8613800000000-1624957782@g.us"
+		alice = "8613800000001@s.whatsapp.net"
+		bob   = "8613800000002@s.whatsapp.net"
+	)
+	groupMessage := newEncMessage("3EB0Q1", group, alice,
+		entity.NewEnc([]byte{1, 2}, "pkmsg", "2"), entity.NewEnc([]byte{3, 4}, "skmsg", "2"))
+	if ok, err := m.Quarantine(groupMessage, "no_sender_key", "No sender key for: group"); err != nil || !ok {
+		t.Fatalf("Quarantine = %v, %v", ok, err)
+	}
+	// 已经隔离时只更新错误
+	if ok, err := m.Quarantine(groupMessage, "invalid_message", "bad mac"); err != nil || ok {
+		t.Fatalf("second Quarantine = %v, %v", ok, err)
+	}
+	if _, err := m.Quarantine(newEncMessage("3EB0Q2", bob, "", entity.NewEnc([]byte{5}, "msg", "2")), "no_valid_session", "No valid sessions"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.QuarantineAttempt("3EB0Q1", "no_sender_key", "No sender key for: group"); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := m.GetQuarantined("3EB0Q1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Chat != group || q.Sender != alice || q.Attempts != 1 || q.ErrorClass != "no_sender_key" || q.LastAttemptAt == 0 {
+		t.Fatalf("GetQuarantined = %+v", q)
+	}
+	// 重新解密时和收到的消息相同
+	message := q.ChatMessage()
+	if message.Id() != "3EB0Q1" || message.From() != group || message.Participant() != alice || message.T() != "1624957782" {
+		t.Fatalf("ChatMessage = %+v", message)
+	}
+	if len(message.EncList()) != 2 || message.EncList()[1].EncType() != "skmsg" || !bytes.Equal(message.EncList()[1].Data(), []byte{3, 4}) {
+		t.Fatalf("EncList = %v", message.EncList())
+	}
+
+	if all, err := m.ListQuarantined("", 0); err != nil || len(all) != 2 || all[0].Id != "3EB0Q2" {
+		t.Fatalf("ListQuarantined = %v, %v", all, err)
+	}
+	if from, err := m.QuarantinedFrom(alice, group); err != nil || len(from) != 1 || from[0].Id != "3EB0Q1" {
+		t.Fatalf("QuarantinedFrom = %v, %v", from, err)
+	}
+	if from, err := m.QuarantinedFrom(alice, bob); err != nil || len(from) != 0 {
+		t.Fatalf("QuarantinedFrom other chat = %v, %v", from, err)
+	}
+
+	if ok, err := m.RemoveQuarantined("3EB0Q1"); err != nil || !ok {
+		t.Fatalf("RemoveQuarantined = %v, %v", ok, err)
+	}
+	if ok, err := m.RemoveQuarantined("3EB0Q1"); err != nil || ok {
+		t.Fatalf("second RemoveQuarantined = %v, %v", ok, err)
+	}
+	if _, err := m.GetQuarantined("3EB0Q1"); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("GetQuarantined removed = %v", err)
+	}
+}
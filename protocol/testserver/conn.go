@@ -0,0 +1,230 @@
+package testserver
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"ws-go/noise"
+	"ws-go/protocol/handshake"
+	"ws-go/protocol/newxxmp"
+	"ws-go/protocol/waproto"
+)
+
+// maxFrameLen 3 字节长度头能表示的最大长度
+const maxFrameLen = 1<<24 - 1
+
+var (
+	ErrFrameTooLarge = errors.New("testserver: frame too large")
+	ErrWaitTimeout   = errors.New("testserver: wait node time out")
+)
+
+// Conn 握手完成后的服务端连接
+type Conn struct {
+	conn net.Conn
+	// 握手信息
+	version      handshake.WAProtocolVersion
+	routingInfo  []byte
+	clientStatic []byte
+	payload      []byte
+	pattern      string
+	// 握手成功后的密钥
+	csIn, csOut *noise.CipherState
+	codec       *newxxmp.Codec
+
+	readMutex  sync.Mutex
+	writeMutex sync.Mutex
+	// 收到的 node
+	nodeMutex sync.Mutex
+	received  []*newxxmp.Node
+}
+
+// Version 客户端发送的协议版本
+func (c *Conn) Version() handshake.WAProtocolVersion {
+	return c.version
+}
+
+// RoutingInfo 客户端发送的 routing info 没有发送时为 nil
+func (c *Conn) RoutingInfo() []byte {
+	return c.routingInfo
+}
+
+// ClientStatic 客户端静态公钥
+func (c *Conn) ClientStatic() []byte {
+	return c.clientStatic
+}
+
+// HandshakePattern 握手使用的模式 XX IK XXfallback
+func (c *Conn) HandshakePattern() string {
+	return c.pattern
+}
+
+// ClientPayload 客户端在 client finish 中发送的认证数据
+func (c *Conn) ClientPayload() (*waproto.ClientPayload, error) {
+	payload := &waproto.ClientPayload{}
+	if err := proto.Unmarshal(c.payload, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// SetDeadline
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Close
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadFrame 读取并解密一个数据帧
+func (c *Conn) ReadFrame() ([]byte, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	d, err := c.readRawFrame()
+	if err != nil {
+		return nil, err
+	}
+	return c.csIn.Decrypt(nil, nil, d)
+}
+
+// WriteFrame 加密并写入一个数据帧
+func (c *Conn) WriteFrame(d []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeRawFrame(c.csOut.Encrypt(nil, nil, d))
+}
+
+// ReadNode 读取一个 node 并记录到 Received
+func (c *Conn) ReadNode() (*newxxmp.Node, error) {
+	d, err := c.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	n, err := c.codec.Decode(d)
+	if err != nil {
+		return nil, err
+	}
+	c.nodeMutex.Lock()
+	c.received = append(c.received, n)
+	c.nodeMutex.Unlock()
+	return n, nil
+}
+
+// Received 已经收到的 node
+func (c *Conn) Received() []*newxxmp.Node {
+	c.nodeMutex.Lock()
+	defer c.nodeMutex.Unlock()
+	nodes := make([]*newxxmp.Node, len(c.received))
+	copy(nodes, c.received)
+	return nodes
+}
+
+// WaitNode 等待收到符合条件的 node
+// 需要脚本在读取 node (例如 ReplyIq Hold)
+func (c *Conn) WaitNode(match func(n *newxxmp.Node) bool, timeout time.Duration) (*newxxmp.Node, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, n := range c.Received() {
+			if match(n) {
+				return n, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrWaitTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// WriteNode 写入一个 node
+func (c *Conn) WriteNode(n *newxxmp.Node) error {
+	d, err := c.codec.Encode(n)
+	if err != nil {
+		return err
+	}
+	return c.WriteFrame(d)
+}
+
+func (c *Conn) readRawFrame() ([]byte, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, err
+	}
+	d := make([]byte, decodeLength(header))
+	if _, err := io.ReadFull(c.conn, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (c *Conn) writeRawFrame(d []byte) error {
+	if len(d) > maxFrameLen {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, 0, len(d)+3)
+	frame = append(frame, byte(len(d)>>16), byte(len(d)>>8), byte(len(d)))
+	frame = append(frame, d...)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *Conn) readHandshakeMessage() (*waproto.HandshakeMessage, error) {
+	d, err := c.readRawFrame()
+	if err != nil {
+		return nil, err
+	}
+	message := &waproto.HandshakeMessage{}
+	if err := proto.Unmarshal(d, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// readClientFinish 读取 client finish 并保存握手结果
+func (c *Conn) readClientFinish(state *noise.HandshakeState, initiator bool) error {
+	message, err := c.readHandshakeMessage()
+	if err != nil {
+		return err
+	}
+	if message.ClientFinish == nil {
+		return ErrBadFinish
+	}
+	finish := append(append([]byte{}, message.ClientFinish.Static...), message.ClientFinish.Payload...)
+	payload, cs1, cs2, err := state.ReadMessage(nil, finish)
+	if err != nil {
+		return err
+	}
+	if cs1 == nil || cs2 == nil {
+		return ErrBadFinish
+	}
+	c.clientStatic = state.PeerStatic()
+	c.payload = payload
+	if initiator {
+		c.csIn, c.csOut = cs2, cs1
+	} else {
+		c.csIn, c.csOut = cs1, cs2
+	}
+	return nil
+}
+
+func (c *Conn) writeServerHello(ephemeral, static, payload []byte) error {
+	d, err := proto.Marshal(&waproto.HandshakeMessage{
+		ServerHello: &waproto.HandshakeMessage_ServerHello{
+			Ephemeral: ephemeral,
+			Static:    static,
+			Payload:   payload,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return c.writeRawFrame(d)
+}
+
+func decodeLength(b []byte) int {
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
@@ -0,0 +1,53 @@
+package testserver
+
+import (
+	"crypto/rand"
+	"github.com/gogf/gf/os/gcfg"
+	"github.com/golang/protobuf/proto"
+	"os"
+	"testing"
+	"ws-go/noise"
+	"ws-go/protocol/define"
+	"ws-go/protocol/waproto"
+)
+
+// Config redis 指向一个不可用的端口 推送只会打印错误
+const Config = `
+[redis]
+    default = "127.0.0.1:1,0"
+    topic   = "test"
+`
+
+// Workspace 使用 config 作为配置 数据库创建在临时目录 测试结束后切换回原来的目录
+func Workspace(t testing.TB, config string) {
+	t.Helper()
+	gcfg.SetContent(config)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.Mkdir(define.DefaultDbPath, 0777); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Account 安卓账号的认证数据和 noise 静态密钥对
+func Account(t testing.TB, username uint64) (*waproto.ClientPayload, noise.DHKey) {
+	t.Helper()
+	staticKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := &waproto.ClientPayload{
+		Username: proto.Uint64(username),
+		UserAgent: &waproto.ClientPayload_UserAgent{
+			Platform: waproto.ClientPayload_UserAgent_ANDROID.Enum(),
+		},
+	}
+	return payload, staticKey
+}
@@ -0,0 +1,164 @@
+package testserver
+
+import (
+	"net"
+	"strconv"
+	"time"
+	"ws-go/protocol/newxxmp"
+)
+
+// IqReply 根据客户端的 iq 生成回复 返回 nil 表示不回复
+type IqReply func(iq *newxxmp.Node) *newxxmp.Node
+
+// Sequence 依次执行多个脚本 任意一个返回错误时停止
+func Sequence(steps ...Scenario) Scenario {
+	return func(c *Conn) error {
+		for _, step := range steps {
+			if err := step(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SendSuccess 发送登录成功
+func SendSuccess() Scenario {
+	return func(c *Conn) error {
+		return c.WriteNode(newxxmp.EmptyNode("success", newxxmp.Attributes{
+			newxxmp.NewAttribute("t", strconv.FormatInt(time.Now().Unix(), 10)),
+			newxxmp.NewAttribute("props", "2"),
+			newxxmp.NewAttribute("creation", "1600000000"),
+			newxxmp.NewAttribute("location", "test"),
+		}))
+	}
+}
+
+// SendFailure 发送登录失败 reason 例如 401 403
+func SendFailure(reason string) Scenario {
+	return func(c *Conn) error {
+		return c.WriteNode(newxxmp.EmptyNode("failure", newxxmp.Attributes{
+			newxxmp.NewAttribute("reason", reason),
+		}))
+	}
+}
+
+// SendStreamError 发送 stream:error conflictType 例如 replaced
+func SendStreamError(conflictType string) Scenario {
+	return func(c *Conn) error {
+		conflict := newxxmp.EmptyNode("conflict", newxxmp.Attributes{
+			newxxmp.NewAttribute("type", conflictType),
+		})
+		return c.WriteNode(newxxmp.EmptyNode("stream:error", conflict))
+	}
+}
+
+// ReplyIq 读取客户端的 node 直到连接关闭 对 iq 使用 reply 回复
+// reply 为 nil 时回复空的 result
+func ReplyIq(reply IqReply) Scenario {
+	if reply == nil {
+		reply = EmptyIqResult
+	}
+	return func(c *Conn) error {
+		for {
+			n, err := c.ReadNode()
+			if err != nil {
+				return err
+			}
+			if n.GetTag() != "iq" {
+				continue
+			}
+			iqType := n.GetAttributeByValue("type")
+			if iqType != "get" && iqType != "set" {
+				continue
+			}
+			result := reply(n)
+			if result == nil {
+				continue
+			}
+			if err := c.WriteNode(result); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ReadUntil 读取客户端的 node 直到收到 tag
+// 客户端是并发处理收到的 node 用于等待客户端处理完上一个 node
+func ReadUntil(tag string) Scenario {
+	return func(c *Conn) error {
+		for {
+			n, err := c.ReadNode()
+			if err != nil {
+				return err
+			}
+			if n.GetTag() == tag {
+				return nil
+			}
+		}
+	}
+}
+
+// Hold 读取客户端的 node 直到连接关闭 但不做任何回复
+func Hold() Scenario {
+	return func(c *Conn) error {
+		for {
+			if _, err := c.ReadNode(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Drain 在一段时间内读取并丢弃客户端的 node
+// 用于等待客户端处理完收到的 node 关闭连接前读完数据可以避免客户端收到 RST
+func Drain(d time.Duration) Scenario {
+	return func(c *Conn) error {
+		if err := c.SetDeadline(time.Now().Add(d)); err != nil {
+			return err
+		}
+		defer c.SetDeadline(time.Time{})
+		for {
+			if _, err := c.ReadNode(); err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+// EmptyIqResult 回复一个没有子节点的 result
+func EmptyIqResult(iq *newxxmp.Node) *newxxmp.Node {
+	return newxxmp.EmptyNode("iq", newxxmp.Attributes{
+		newxxmp.NewAttribute("from", "s.whatsapp.net"),
+		newxxmp.NewAttribute("type", "result"),
+		newxxmp.NewAttribute("id", iq.GetAttributeByValue("id")),
+	})
+}
+
+// Success 登录成功 并回复所有 iq
+func Success() Scenario {
+	return Sequence(SendSuccess(), ReplyIq(nil))
+}
+
+// Failure 登录失败 发送后关闭连接
+func Failure(reason string) Scenario {
+	return SendFailure(reason)
+}
+
+// StreamError 登录成功后发送 stream:error 然后关闭连接
+func StreamError(conflictType string) Scenario {
+	return Sequence(SendSuccess(), SendStreamError(conflictType))
+}
+
+// IqResult 登录成功 并使用 reply 回复 iq
+func IqResult(reply IqReply) Scenario {
+	return Sequence(SendSuccess(), ReplyIq(reply))
+}
+
+// Timeout 登录成功 之后不回复任何 iq
+func Timeout() Scenario {
+	return Sequence(SendSuccess(), Hold())
+}
@@ -0,0 +1,346 @@
+// Package testserver 本地模拟的 whatsapp 服务器
+// 用于在没有真实服务器的情况下测试 noise 握手 以及 binary node 的收发
+package testserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"ws-go/noise"
+	"ws-go/protocol/handshake"
+	"ws-go/protocol/newxxmp"
+	"ws-go/protocol/waproto"
+	"ws-go/waver"
+)
+
+var (
+	ErrBadPrologue = errors.New("testserver: bad handshake prologue")
+	ErrBadHello    = errors.New("testserver: bad client hello")
+	ErrBadFinish   = errors.New("testserver: bad client finish")
+	ErrDroppedIK   = errors.New("testserver: dropped IK handshake")
+)
+
+var cipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherAESGCM, noise.HashSHA256)
+
+// Scenario 握手成功后执行的脚本
+// 返回后连接会被关闭
+type Scenario func(c *Conn) error
+
+// Server
+type Server struct {
+	listener net.Listener
+	scenario Scenario
+	// StaticKey 服务器静态密钥对
+	StaticKey noise.DHKey
+	// CertPayload 握手时发送给客户端的证书数据
+	CertPayload []byte
+	// RejectIK 拒绝所有 IK 握手 模拟服务器更换了静态密钥
+	RejectIK bool
+	// Codec 连接使用的编解码器 默认为 WA42
+	Codec *newxxmp.Codec
+
+	mutex sync.Mutex
+	// dropIK 收到 IK 握手时直接关闭连接 不回退到 XXfallback
+	dropIK bool
+	conns  []*Conn
+	errs   []error
+	closed bool
+	wg     sync.WaitGroup
+	// accepted 每接受一个连接并握手成功后通知
+	accepted chan *Conn
+}
+
+// NewServer 监听本地随机端口
+func NewServer(scenario Scenario) (*Server, error) {
+	staticKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener:  listener,
+		scenario:  scenario,
+		StaticKey: staticKey,
+		Codec:     newxxmp.NewCodec(waver.NewWA42()),
+		accepted:  make(chan *Conn, 16),
+	}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Addr 服务器地址 可以直接设置到 NoiseClientConfig.ServerAddr
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// SetScenario 替换后续连接使用的脚本
+func (s *Server) SetScenario(scenario Scenario) {
+	s.mutex.Lock()
+	s.scenario = scenario
+	s.mutex.Unlock()
+}
+
+// SetDropIK 后续连接收到 IK 握手时直接关闭 模拟服务器更换密钥后不返回 XXfallback
+func (s *Server) SetDropIK(drop bool) {
+	s.mutex.Lock()
+	s.dropIK = drop
+	s.mutex.Unlock()
+}
+
+// Accepted 握手成功的连接
+func (s *Server) Accepted() <-chan *Conn {
+	return s.accepted
+}
+
+// Conns 已经握手成功的连接
+func (s *Server) Conns() []*Conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	conns := make([]*Conn, len(s.conns))
+	copy(conns, s.conns)
+	return conns
+}
+
+// Errors 握手或者脚本执行中产生的错误
+func (s *Server) Errors() []error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	errs := make([]error, len(s.errs))
+	copy(errs, s.errs)
+	return errs
+}
+
+// Close 关闭监听和所有连接
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	conns := s.conns
+	s.mutex.Unlock()
+
+	err := s.listener.Close()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// ServeConn 处理一个已经建立的连接 和 network.PipeTransport 一起使用时不经过 TCP
+func (s *Server) ServeConn(c net.Conn) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		_ = c.Close()
+		return
+	}
+	s.wg.Add(1)
+	s.mutex.Unlock()
+	s.serve(c)
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer s.wg.Done()
+	defer c.Close()
+
+	conn, err := s.handshake(c)
+	if err != nil {
+		s.addError(err)
+		return
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.conns = append(s.conns, conn)
+	scenario := s.scenario
+	s.mutex.Unlock()
+
+	select {
+	case s.accepted <- conn:
+	default:
+	}
+
+	if scenario == nil {
+		return
+	}
+	if err := scenario(conn); err != nil && err != io.EOF {
+		s.addError(err)
+	}
+}
+
+func (s *Server) addError(err error) {
+	s.mutex.Lock()
+	s.errs = append(s.errs, err)
+	s.mutex.Unlock()
+}
+
+// handshake 读取 routing info 和 WA 头部 然后执行 XX 或者 IK 握手
+func (s *Server) handshake(c net.Conn) (*Conn, error) {
+	conn := &Conn{conn: c, codec: s.Codec}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return nil, err
+	}
+	// routing info
+	if bytes.Equal(header, handshake.DHString) {
+		lenData := make([]byte, 3)
+		if _, err := io.ReadFull(c, lenData); err != nil {
+			return nil, err
+		}
+		conn.routingInfo = make([]byte, decodeLength(lenData))
+		if _, err := io.ReadFull(c, conn.routingInfo); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(c, header); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(header[:2], handshake.InitString) {
+		return nil, ErrBadPrologue
+	}
+	conn.version = handshake.WAProtocolVersion{VersionMajor: header[2], VersionMinor: header[3]}
+
+	// client hello
+	message, err := conn.readHandshakeMessage()
+	if err != nil {
+		return nil, err
+	}
+	if message.ClientHello == nil {
+		return nil, ErrBadHello
+	}
+	// 带有静态公钥的是 IK 握手
+	if len(message.ClientHello.Static) > 0 {
+		s.mutex.Lock()
+		drop := s.dropIK
+		s.mutex.Unlock()
+		if drop {
+			return nil, ErrDroppedIK
+		}
+		return s.handshakeIK(conn, header, message.ClientHello)
+	}
+	return s.handshakeXX(conn, header, message.ClientHello)
+}
+
+// handshakeXX 服务器作为响应方
+func (s *Server) handshakeXX(conn *Conn, prologue []byte, hello *waproto.HandshakeMessage_ClientHello) (*Conn, error) {
+	state, err := noise.NewHandshakeState(noise.Config{
+		StaticKeypair: s.StaticKey,
+		Initiator:     false,
+		Pattern:       noise.HandshakeXX,
+		CipherSuite:   cipherSuite,
+		Prologue:      prologue,
+		Random:        rand.Reader,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err = state.ReadMessage(nil, hello.Ephemeral); err != nil {
+		return nil, err
+	}
+
+	// server hello e + s + payload
+	out, _, _, err := state.WriteMessage(nil, s.CertPayload)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.writeServerHello(out[:32], out[32:80], out[80:]); err != nil {
+		return nil, err
+	}
+	// 服务器是响应方 csIn 用于客户端到服务器
+	if err := conn.readClientFinish(state, false); err != nil {
+		return nil, err
+	}
+	conn.pattern = noise.HandshakeXX.Name
+	return conn, nil
+}
+
+// handshakeIK 服务器作为响应方 无法解密客户端静态公钥时回退到 XXfallback
+func (s *Server) handshakeIK(conn *Conn, prologue []byte, hello *waproto.HandshakeMessage_ClientHello) (*Conn, error) {
+	state, err := noise.NewHandshakeState(noise.Config{
+		StaticKeypair: s.StaticKey,
+		Initiator:     false,
+		Pattern:       noise.HandshakeIK,
+		CipherSuite:   cipherSuite,
+		Prologue:      prologue,
+		Random:        rand.Reader,
+	})
+	if err != nil {
+		return nil, err
+	}
+	message := append(append([]byte{}, hello.Ephemeral...), hello.Static...)
+	message = append(message, hello.Payload...)
+	payload, _, _, err := state.ReadMessage(nil, message)
+	if err != nil || s.RejectIK {
+		return s.handshakeXXFallback(conn, prologue, hello)
+	}
+
+	// server hello e + payload
+	out, csIn, csOut, err := state.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.writeServerHello(out[:32], nil, out[32:]); err != nil {
+		return nil, err
+	}
+	conn.clientStatic = state.PeerStatic()
+	conn.payload = payload
+	conn.csIn, conn.csOut = csIn, csOut
+	conn.pattern = noise.HandshakeIK.Name
+	return conn, nil
+}
+
+// handshakeXXFallback 服务器作为发起方 使用客户端 IK 中的临时公钥
+func (s *Server) handshakeXXFallback(conn *Conn, prologue []byte, hello *waproto.HandshakeMessage_ClientHello) (*Conn, error) {
+	state, err := noise.NewHandshakeState(noise.Config{
+		StaticKeypair: s.StaticKey,
+		Initiator:     true,
+		Pattern:       noise.HandshakeXXfallback,
+		CipherSuite:   cipherSuite,
+		Prologue:      prologue,
+		PeerEphemeral: hello.Ephemeral,
+		Random:        rand.Reader,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// server hello e + s + payload
+	out, _, _, err := state.WriteMessage(nil, s.CertPayload)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.writeServerHello(out[:32], out[32:80], out[80:]); err != nil {
+		return nil, err
+	}
+	// 服务器是发起方 csOut 用于客户端到服务器
+	if err := conn.readClientFinish(state, true); err != nil {
+		return nil, err
+	}
+	conn.pattern = noise.HandshakeXXfallback.Name
+	return conn, nil
+}
@@ -0,0 +1,330 @@
+package testserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"ws-go/noise"
+	"ws-go/protocol/handshake"
+	"ws-go/protocol/network"
+	"ws-go/protocol/newxxmp"
+	"ws-go/protocol/waproto"
+	"ws-go/waver"
+)
+
+// recorder 记录 NoiseNetWork 回调
+type recorder struct {
+	codec      *newxxmp.Codec
+	data       chan []byte
+	handshake  chan error
+	disconnect chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		codec:      newxxmp.NewCodec(waver.NewWA42()),
+		data:       make(chan []byte, 16),
+		handshake:  make(chan error, 1),
+		disconnect: make(chan struct{}, 1),
+	}
+}
+
+func (r *recorder) OnHandShakeFailed(err error) {
+	select {
+	case r.handshake <- err:
+	default:
+	}
+}
+func (r *recorder) OnRecvData(d []byte) { r.data <- d }
+func (r *recorder) OnConnect()          {}
+func (r *recorder) OnError(err error)   {}
+func (r *recorder) OnDisconnect() {
+	select {
+	case r.disconnect <- struct{}{}:
+	default:
+	}
+}
+
+func (r *recorder) nextNode(t *testing.T) *newxxmp.Node {
+	t.Helper()
+	select {
+	case d := <-r.data:
+		n, err := r.codec.Decode(d)
+		if err != nil {
+			t.Fatalf("decode node: %v", err)
+		}
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait node time out")
+	}
+	return nil
+}
+
+func newClient(t *testing.T, addr string, routingInfo []byte, username uint64, events *recorder) (*network.NoiseNetWork, noise.DHKey) {
+	t.Helper()
+	staticKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := proto.Marshal(&waproto.ClientPayload{Username: proto.Uint64(username)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := network.NewNoiseClient(routingInfo, payload, staticKey, events)
+	client.SetServerAddr(addr)
+	return client, staticKey
+}
+
+func waitAccepted(t *testing.T, s *Server) *Conn {
+	t.Helper()
+	select {
+	case c := <-s.Accepted():
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait accepted time out %v", s.Errors())
+	}
+	return nil
+}
+
+func TestServer_Handshake(t *testing.T) {
+	s, err := NewServer(Success())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	events := newRecorder()
+	routingInfo := []byte{0x08, 0x0b, 0x08, 0x0c}
+	client, staticKey := newClient(t, s.Addr(), routingInfo, 8613800000000, events)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c := waitAccepted(t, s)
+	if !bytes.Equal(c.RoutingInfo(), routingInfo) {
+		t.Errorf("routing info = %x, want %x", c.RoutingInfo(), routingInfo)
+	}
+	if v := c.Version(); v.VersionMajor != 5 || v.VersionMinor != 2 {
+		t.Errorf("version = %d.%d, want 5.2", v.VersionMajor, v.VersionMinor)
+	}
+	if !bytes.Equal(c.ClientStatic(), staticKey.Public) {
+		t.Errorf("client static = %x, want %x", c.ClientStatic(), staticKey.Public)
+	}
+	payload, err := c.ClientPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.GetUsername() != 8613800000000 {
+		t.Errorf("username = %d", payload.GetUsername())
+	}
+
+	if n := events.nextNode(t); n.GetTag() != "success" {
+		t.Fatalf("first node = %s, want success", n.GetString())
+	}
+
+	// client -> server iq
+	ping := newxxmp.EmptyNode("iq", newxxmp.Attributes{
+		newxxmp.NewAttribute("id", "7"),
+		newxxmp.NewAttribute("xmlns", "w:p"),
+		newxxmp.NewAttribute("type", "get"),
+		newxxmp.NewAttribute("to", "s.whatsapp.net"),
+	}, newxxmp.EmptyNode("ping"))
+	d, err := events.codec.Encode(ping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.GetSegment().WriteSegmentOutputData(d); err != nil {
+		t.Fatal(err)
+	}
+	n := events.nextNode(t)
+	if n.GetTag() != "iq" || n.GetAttributeByValue("type") != "result" || n.GetAttributeByValue("id") != "7" {
+		t.Fatalf("iq reply = %s", n.GetString())
+	}
+	if _, err := c.WaitNode(func(n *newxxmp.Node) bool { return n.GetChildrenByTag("ping") != nil }, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_WithoutRoutingInfo(t *testing.T) {
+	s, err := NewServer(Failure("401"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	events := newRecorder()
+	client, _ := newClient(t, s.Addr(), nil, 1, events)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c := waitAccepted(t, s)
+	if c.RoutingInfo() != nil {
+		t.Errorf("routing info = %x, want nil", c.RoutingInfo())
+	}
+	n := events.nextNode(t)
+	if n.GetTag() != "failure" || n.GetAttributeByValue("reason") != "401" {
+		t.Fatalf("node = %s", n.GetString())
+	}
+	select {
+	case <-events.disconnect:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client not disconnected")
+	}
+}
+
+func TestServer_StreamError(t *testing.T) {
+	s, err := NewServer(StreamError("replaced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	events := newRecorder()
+	client, _ := newClient(t, s.Addr(), nil, 1, events)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// OnRecvData 在各自的协程中回调 不保证顺序
+	var streamError *newxxmp.Node
+	for i := 0; i < 2; i++ {
+		if n := events.nextNode(t); n.GetTag() == "stream:error" {
+			streamError = n
+		}
+	}
+	if streamError == nil {
+		t.Fatal("stream:error not received")
+	}
+	if streamError.GetChildrenByTag("conflict").GetAttributeByValue("type") != "replaced" {
+		t.Fatalf("node = %s", streamError.GetString())
+	}
+}
+
+func TestServer_BadPrologue(t *testing.T) {
+	s, err := NewServer(Success())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c, err := net.Dial("tcp4", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte{'X', 'X', 5, 2}); err != nil {
+		t.Fatal(err)
+	}
+	// 服务器会直接关闭连接
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read err = %v, want EOF", err)
+	}
+	errs := s.Errors()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrBadPrologue) {
+		t.Fatalf("errors = %v", errs)
+	}
+}
+
+// connectWithPeerStatic 使用指定的服务器公钥握手 返回握手后的配置
+func connectWithPeerStatic(t *testing.T, s *Server, peerStatic []byte) (*handshake.WAHandshakeSettings, *Conn) {
+	t.Helper()
+	events := newRecorder()
+	client, staticKey := newClient(t, s.Addr(), nil, 1, events)
+	payload, err := proto.Marshal(&waproto.ClientPayload{Username: proto.Uint64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := &handshake.WAHandshakeSettings{
+		Payload:    payload,
+		StaticKey:  staticKey,
+		PeerStatic: peerStatic,
+	}
+	if err := client.Connect(settings); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	c := waitAccepted(t, s)
+	if !bytes.Equal(c.ClientStatic(), staticKey.Public) {
+		t.Errorf("client static = %x, want %x", c.ClientStatic(), staticKey.Public)
+	}
+	if p, err := c.ClientPayload(); err != nil || p.GetUsername() != 1 {
+		t.Errorf("client payload = %v, %v", p, err)
+	}
+	// 握手后双方密钥一致才能收到 success
+	if n := events.nextNode(t); n.GetTag() != "success" {
+		t.Fatalf("first node = %s, want success", n.GetString())
+	}
+	return settings, c
+}
+
+func TestServer_HandshakePattern(t *testing.T) {
+	s, err := NewServer(Success())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	staleKey, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		peerStatic []byte
+		rejectIK   bool
+		want       string
+	}{
+		{"XX", []byte{}, false, "XX"},
+		{"IK", s.StaticKey.Public, false, "IK"},
+		{"StaleKey", staleKey.Public, false, "XXfallback"},
+		{"RejectIK", s.StaticKey.Public, true, "XXfallback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.RejectIK = tt.rejectIK
+			settings, c := connectWithPeerStatic(t, s, tt.peerStatic)
+			if c.HandshakePattern() != tt.want {
+				t.Errorf("pattern = %s, want %s", c.HandshakePattern(), tt.want)
+			}
+			// 握手成功后保存服务器公钥
+			if !bytes.Equal(settings.PeerStatic, s.StaticKey.Public) {
+				t.Errorf("peer static = %x, want %x", settings.PeerStatic, s.StaticKey.Public)
+			}
+		})
+	}
+}
+
+func TestServer_PipeTransport(t *testing.T) {
+	s, err := NewServer(Success())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	events := newRecorder()
+	client, _ := newClient(t, "", nil, 1, events)
+	client.SetTransport(&network.PipeTransport{Accept: s.ServeConn})
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c := waitAccepted(t, s)
+	if c.HandshakePattern() != "XX" {
+		t.Errorf("pattern = %s, want XX", c.HandshakePattern())
+	}
+	if n := events.nextNode(t); n.GetTag() != "success" {
+		t.Fatalf("first node = %s, want success", n.GetString())
+	}
+}
@@ -0,0 +1,401 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"ws-go/protocol/event"
+	"ws-go/wslog"
+)
+
+// 推送的请求头 签名为 hex(HMAC-SHA256(secret, timestamp + "." + body))
+const (
+	HeaderSignature = "X-WS-Signature"
+	HeaderTimestamp = "X-WS-Timestamp"
+	HeaderEvent     = "X-WS-Event"
+	HeaderDelivery  = "X-WS-Delivery"
+	signaturePrefix = "sha256="
+	// DefaultTolerance 接收方校验时允许的时间戳误差
+	DefaultTolerance = 5 * time.Minute
+)
+
+// errStopped 停止推送时还在队列中的事件
+var errStopped = errors.New("webhook stopped before delivery")
+
+// Options 推送的重试 第 n 次重试前等待 BaseDelay*2^(n-1) 最多 MaxDelay
+type Options struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Timeout 每次请求的超时
+	Timeout time.Duration
+	// QueueSize 每个 webhook 等待推送的事件数 满了直接进入死信
+	QueueSize int
+	Client    *http.Client
+}
+
+// DefaultOptions
+func DefaultOptions() Options {
+	return Options{
+		MaxAttempts: 6,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		Timeout:     10 * time.Second,
+		QueueSize:   1024,
+	}
+}
+
+// backoff 第 attempt 次失败后的等待时间 attempt 从 1 开始
+func (o Options) backoff(attempt int) time.Duration {
+	d := o.BaseDelay
+	for i := 1; i < attempt && d < o.MaxDelay; i++ {
+		d *= 2
+	}
+	if o.MaxDelay > 0 && d > o.MaxDelay {
+		d = o.MaxDelay
+	}
+	return d
+}
+
+// Sign 推送的签名 接收方使用相同的方法校验
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验请求头中的签名 时间戳和当前时间相差超过 tolerance 时拒绝 防止重放
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) bool {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(Sign(secret, timestamp, body)))
+}
+
+// NewSecret 没有指定 secret 时生成
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// delivery 一次推送
+type delivery struct {
+	eventType event.Type
+	seq       uint64
+	payload   []byte
+}
+
+// Dispatcher 订阅账号的事件 每个 webhook 一个 goroutine 按顺序推送
+// 重试都失败后保存到死信 不会阻塞事件的发布
+type Dispatcher struct {
+	store   *Store
+	options Options
+	sub     *event.Subscription
+	mutex   sync.Mutex
+	workers map[int64]*worker
+	closed  bool
+}
+
+// NewDispatcher 从 store 加载 webhook 并订阅 bus
+func NewDispatcher(store *Store, bus *event.Bus, options Options) (*Dispatcher, error) {
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: options.Timeout}
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 1
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultOptions().QueueSize
+	}
+	d := &Dispatcher{store: store, options: options, workers: make(map[int64]*worker)}
+	hooks, err := store.Hooks()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hooks {
+		d.startWorker(h)
+	}
+	d.sub = bus.SubscribeFunc(options.QueueSize, d.dispatch)
+	return d, nil
+}
+
+// Store
+func (d *Dispatcher) Store() *Store {
+	return d.store
+}
+
+// AddHook secret 为空时生成
+func (d *Dispatcher) AddHook(url, secret string, types []event.Type) (*Hook, error) {
+	if url == "" {
+		return nil, errors.New("webhook url is empty")
+	}
+	if secret == "" {
+		var err error
+		if secret, err = NewSecret(); err != nil {
+			return nil, err
+		}
+	}
+	h := &Hook{Url: url, Secret: secret, Types: types}
+	if err := d.store.AddHook(h); err != nil {
+		return nil, err
+	}
+	d.startWorker(h)
+	return h, nil
+}
+
+// Hooks
+func (d *Dispatcher) Hooks() ([]*Hook, error) {
+	return d.store.Hooks()
+}
+
+// DeleteHook 停止推送 队列中未推送的事件保存到死信
+func (d *Dispatcher) DeleteHook(id int64) error {
+	if err := d.store.DeleteHook(id); err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	w, ok := d.workers[id]
+	delete(d.workers, id)
+	d.mutex.Unlock()
+	if ok {
+		w.stop()
+	}
+	return nil
+}
+
+// DeadLetters hookId 为 0 时返回所有
+func (d *Dispatcher) DeadLetters(hookId int64, limit int) ([]*DeadLetter, error) {
+	return d.store.DeadLetters(hookId, limit)
+}
+
+// DeleteDeadLetter
+func (d *Dispatcher) DeleteDeadLetter(id int64) error {
+	return d.store.DeleteDeadLetter(id)
+}
+
+// Replay 重新推送一次死信 成功后删除 失败时更新次数和错误并返回错误
+func (d *Dispatcher) Replay(ctx context.Context, id int64) error {
+	letter, err := d.store.DeadLetter(id)
+	if err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	w, ok := d.workers[letter.HookId]
+	d.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("dead letter %d: %w", id, ErrNotFound)
+	}
+	err = d.post(ctx, w.hook, &delivery{eventType: letter.EventType, seq: letter.Seq, payload: []byte(letter.Payload)})
+	if err != nil {
+		if updateErr := d.store.UpdateDeadLetter(id, letter.Attempts+1, err.Error()); updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
+	return d.store.DeleteDeadLetter(id)
+}
+
+// Close 取消订阅 停止所有推送 队列中未推送的事件保存到死信 之后可以关闭 store
+func (d *Dispatcher) Close() {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return
+	}
+	d.closed = true
+	workers := d.workers
+	d.workers = make(map[int64]*worker)
+	d.mutex.Unlock()
+	d.sub.Close()
+	for _, w := range workers {
+		w.stop()
+	}
+}
+
+// dispatch 在订阅的 goroutine 中调用 只放入队列
+func (d *Dispatcher) dispatch(env *event.Envelope) {
+	var payload []byte
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, w := range d.workers {
+		if !w.hook.Accept(env.Type) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(env); err != nil {
+				wslog.GetLogger().Error("webhook marshal event:", err)
+				return
+			}
+		}
+		dl := &delivery{eventType: env.Type, seq: env.Seq, payload: payload}
+		select {
+		case w.queue <- dl:
+		default:
+			go d.deadLetter(w.hook, dl, 0, errors.New("webhook queue is full"))
+		}
+	}
+}
+
+func (d *Dispatcher) startWorker(h *Hook) {
+	w := &worker{
+		hook:   h,
+		queue:  make(chan *delivery, d.options.QueueSize),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return
+	}
+	d.workers[h.Id] = w
+	d.mutex.Unlock()
+	go w.run(d)
+}
+
+// deliver 按 Options 重试 都失败或者停止时保存到死信
+func (d *Dispatcher) deliver(w *worker, dl *delivery) {
+	var (
+		err     error
+		attempt int
+	)
+	for attempt < d.options.MaxAttempts {
+		// 停止后不再请求 队列中的事件直接保存
+		select {
+		case <-w.done:
+			if err == nil {
+				err = errStopped
+			}
+			d.deadLetter(w.hook, dl, attempt, err)
+			return
+		default:
+		}
+		attempt++
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-w.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err = d.post(ctx, w.hook, dl)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt == d.options.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(d.options.backoff(attempt)):
+		case <-w.done:
+		}
+	}
+	d.deadLetter(w.hook, dl, attempt, err)
+}
+
+func (d *Dispatcher) deadLetter(h *Hook, dl *delivery, attempts int, cause error) {
+	letter := &DeadLetter{
+		HookId:    h.Id,
+		EventType: dl.eventType,
+		Seq:       dl.seq,
+		Payload:   string(dl.payload),
+		Attempts:  attempts,
+		LastError: cause.Error(),
+	}
+	if err := d.store.AddDeadLetter(letter); err != nil {
+		wslog.GetLogger().Error("webhook save dead letter:", err)
+	}
+}
+
+// post 一次请求 2xx 为成功
+func (d *Dispatcher) post(ctx context.Context, h *Hook, dl *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, h.Url, bytes.NewReader(dl.payload))
+	if err != nil {
+		return err
+	}
+	if d.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.options.Timeout)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(h.Secret, timestamp, dl.payload))
+	req.Header.Set(HeaderEvent, dl.eventType.String())
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(dl.seq, 10))
+	resp, err := d.options.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded %s", h.Url, resp.Status)
+	}
+	return nil
+}
+
+// worker 一个 webhook 的推送队列
+type worker struct {
+	hook     *Hook
+	queue    chan *delivery
+	done     chan struct{}
+	exited   chan struct{}
+	stopOnce sync.Once
+}
+
+func (w *worker) run(d *Dispatcher) {
+	defer close(w.exited)
+	for {
+		select {
+		case dl := <-w.queue:
+			d.deliver(w, dl)
+		case <-w.done:
+			// 已经从 workers 中删除 不会再放入新的事件
+			for {
+				select {
+				case dl := <-w.queue:
+					d.deadLetter(w.hook, dl, 0, errStopped)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// stop 等待正在推送的事件和队列写入死信
+func (w *worker) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+	<-w.exited
+}
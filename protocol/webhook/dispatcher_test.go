@@ -0,0 +1,282 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"ws-go/protocol/event"
+)
+
+// receiver 记录收到的推送 fail 为 true 时返回 500
+type receiver struct {
+	t      *testing.T
+	secret string
+	fail   int32
+	calls  int32
+	mutex  sync.Mutex
+	got    []*event.Envelope
+	notify chan struct{}
+}
+
+func newReceiver(t *testing.T, secret string) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, secret: secret, notify: make(chan struct{}, 100)}
+	s := httptest.NewServer(r)
+	t.Cleanup(s.Close)
+	return r, s
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&r.calls, 1)
+	defer func() { r.notify <- struct{}{} }()
+	body, _ := ioutil.ReadAll(req.Body)
+	if !Verify(r.secret, req.Header, body, DefaultTolerance) {
+		r.t.Errorf("bad signature %q", req.Header.Get(HeaderSignature))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if atomic.LoadInt32(&r.fail) == 1 {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var env struct {
+		event.Envelope
+		Event json.RawMessage `json:"event"`
+	}
+	if err := json.Unmarshal(body, &env); err != nil {
+		r.t.Errorf("decode %s: %v", body, err)
+	}
+	if req.Header.Get(HeaderEvent) != env.Type.String() {
+		r.t.Errorf("event header %q, type %v", req.Header.Get(HeaderEvent), env.Type)
+	}
+	r.mutex.Lock()
+	r.got = append(r.got, &env.Envelope)
+	r.mutex.Unlock()
+}
+
+func (r *receiver) wait(t *testing.T, calls int32) {
+	deadline := time.After(5 * time.Second)
+	for atomic.LoadInt32(&r.calls) < calls {
+		select {
+		case <-r.notify:
+		case <-deadline:
+			t.Fatalf("received %d calls, want %d", atomic.LoadInt32(&r.calls), calls)
+		}
+	}
+}
+
+func newTestDispatcher(t *testing.T, bus *event.Bus) *Dispatcher {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "webhooks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	d, err := NewDispatcher(store, bus, Options{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    5 * time.Millisecond,
+		Timeout:     time.Second,
+		QueueSize:   16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	bus := event.NewBus("8613800000000")
+	d := newTestDispatcher(t, bus)
+	r, s := newReceiver(t, "secret")
+	if _, err := d.AddHook(s.URL, "secret", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bus.Publish(&event.MessageReceived{})
+	// 默认不推送输入状态
+	bus.Publish(&event.ChatStateChanged{State: "composing"})
+	bus.Publish(&event.ReceiptUpdated{MsgIds: []string{"3EB0"}})
+	r.wait(t, 2)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.got) != 2 || r.got[0].Type != event.TypeMessageReceived || r.got[1].Type != event.TypeReceiptUpdated {
+		t.Fatalf("got %+v", r.got)
+	}
+	if r.got[0].Seq != 1 || r.got[1].Seq != 3 || r.got[0].Account != "8613800000000" {
+		t.Fatalf("got %+v %+v", r.got[0], r.got[1])
+	}
+}
+
+func TestDispatcher_DeadLetterAndReplay(t *testing.T) {
+	bus := event.NewBus("8613800000000")
+	d := newTestDispatcher(t, bus)
+	r, s := newReceiver(t, "secret")
+	atomic.StoreInt32(&r.fail, 1)
+	hook, err := d.AddHook(s.URL, "secret", []event.Type{event.TypeCallOffered})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus.Publish(&event.CallOffered{CallId: "CALL1"})
+	r.wait(t, 3)
+	var letters []*DeadLetter
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if letters, err = d.DeadLetters(hook.Id, 0); err != nil || len(letters) > 0 {
+			break
+		}
+	}
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("DeadLetters = %d, %v", len(letters), err)
+	}
+	letter := letters[0]
+	if letter.Attempts != 3 || letter.EventType != event.TypeCallOffered || letter.LastError == "" || letter.Seq != 1 {
+		t.Fatalf("dead letter = %+v", letter)
+	}
+
+	// 接收方还是失败 次数增加
+	if err := d.Replay(context.Background(), letter.Id); err == nil {
+		t.Fatal("Replay succeeded against a failing receiver")
+	}
+	if letter, err = d.Store().DeadLetter(letter.Id); err != nil || letter.Attempts != 4 {
+		t.Fatalf("dead letter = %+v, %v", letter, err)
+	}
+
+	atomic.StoreInt32(&r.fail, 0)
+	if err := d.Replay(context.Background(), letter.Id); err != nil {
+		t.Fatal(err)
+	}
+	if letters, _ = d.DeadLetters(0, 0); len(letters) != 0 {
+		t.Fatalf("dead letters after replay = %d", len(letters))
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.got) != 1 || r.got[0].Type != event.TypeCallOffered || r.got[0].Seq != 1 {
+		t.Fatalf("got %+v", r.got)
+	}
+}
+
+// 关闭时正在重试和还在队列中的事件都保存到死信
+func TestDispatcher_CloseDeadLetters(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "webhooks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bus := event.NewBus("8613800000000")
+	d, err := NewDispatcher(store, bus, Options{MaxAttempts: 3, BaseDelay: time.Hour, Timeout: time.Second, QueueSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s := newReceiver(t, "secret")
+	atomic.StoreInt32(&r.fail, 1)
+	hook, err := d.AddHook(s.URL, "secret", []event.Type{event.TypeCallOffered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"CALL1", "CALL2", "CALL3"} {
+		bus.Publish(&event.CallOffered{CallId: id})
+	}
+	r.wait(t, 1)
+	queue := d.workers[hook.Id].queue
+	for deadline := time.Now().Add(5 * time.Second); len(queue) < 2 && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+	}
+	d.Close()
+
+	letters, err := d.DeadLetters(hook.Id, 0)
+	if err != nil || len(letters) != 3 {
+		t.Fatalf("DeadLetters = %d, %v", len(letters), err)
+	}
+	attempts := map[uint64]int{}
+	for _, letter := range letters {
+		attempts[letter.Seq] = letter.Attempts
+	}
+	if attempts[1] != 1 || attempts[2] != 0 || attempts[3] != 0 {
+		t.Fatalf("dead letter attempts = %v", attempts)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"message.received"}`)
+	header := http.Header{}
+	sign := func(at time.Time) {
+		header.Set(HeaderTimestamp, strconv.FormatInt(at.Unix(), 10))
+		header.Set(HeaderSignature, Sign("secret", at.Unix(), body))
+	}
+	sign(time.Now())
+	if !Verify("secret", header, body, DefaultTolerance) {
+		t.Fatal("Verify rejected a fresh request")
+	}
+	if Verify("other", header, body, DefaultTolerance) || Verify("secret", header, []byte("{}"), DefaultTolerance) {
+		t.Fatal("Verify accepted a bad signature")
+	}
+	// 重放的旧请求和超前的时间戳
+	for _, at := range []time.Time{time.Now().Add(-time.Hour), time.Now().Add(time.Hour)} {
+		sign(at)
+		if Verify("secret", header, body, DefaultTolerance) {
+			t.Fatalf("Verify accepted timestamp %v", at)
+		}
+	}
+}
+
+func TestDispatcher_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDispatcher(store, event.NewBus("8613800000000"), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := d.AddHook("http://127.0.0.1/hook", "", []event.Type{event.TypeStreamError, event.TypeIdentityChanged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.Secret) != 64 {
+		t.Fatalf("generated secret = %q", hook.Secret)
+	}
+	d.Close()
+	_ = store.Close()
+
+	if store, err = OpenStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	d, err = NewDispatcher(store, event.NewBus("8613800000000"), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	hooks, err := d.Hooks()
+	if err != nil || len(hooks) != 1 {
+		t.Fatalf("Hooks = %d, %v", len(hooks), err)
+	}
+	if h := hooks[0]; h.Secret != hook.Secret || len(h.Types) != 2 || !h.Accept(event.TypeIdentityChanged) || h.Accept(event.TypeMessageReceived) {
+		t.Fatalf("hook = %+v", h)
+	}
+	if err := d.DeleteHook(hook.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteHook(hook.Id); err != ErrNotFound {
+		t.Fatalf("DeleteHook err = %v", err)
+	}
+}
+
+func TestOptions_Backoff(t *testing.T) {
+	o := Options{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := o.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
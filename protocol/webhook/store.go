@@ -0,0 +1,304 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"ws-go/protocol/db/migrate"
+	"ws-go/protocol/define"
+	"ws-go/protocol/event"
+
+	"github.com/gogf/gf/database/gdb"
+	"github.com/gogf/gf/os/gtime"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotFound webhook 或者死信不存在
+	ErrNotFound = errors.New("webhook not found")
+)
+
+// Hook 账号的 webhook 订阅 Types 为空时只推送新消息和回执
+type Hook struct {
+	Id        int64        `json:"id"`
+	Url       string       `json:"url"`
+	Secret    string       `json:"secret"`
+	Types     []event.Type `json:"types"`
+	CreatedAt int64        `json:"createdAt"`
+}
+
+// DefaultTypes 没有指定类型时推送的事件
+var DefaultTypes = []event.Type{event.TypeMessageReceived, event.TypeReceiptUpdated}
+
+// Accept 是否推送该类型的事件
+func (h *Hook) Accept(t event.Type) bool {
+	types := h.Types
+	if len(types) == 0 {
+		types = DefaultTypes
+	}
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter 重试后仍然失败的推送 Payload 为推送的 json
+type DeadLetter struct {
+	Id        int64      `json:"id"`
+	HookId    int64      `json:"hookId"`
+	EventType event.Type `json:"eventType"`
+	Seq       uint64     `json:"seq"`
+	Payload   string     `json:"payload"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"lastError"`
+	CreatedAt int64      `json:"createdAt"`
+	UpdatedAt int64      `json:"updatedAt"`
+}
+
+// webhookMigrations 新的表结构修改在末尾追加版本
+var webhookMigrations = []migrate.Migration{
+	{Version: 1, Description: "create webhook tables", Up: migrate.Exec(`CREATE TABLE IF NOT EXISTS "webhooks" (
+	"id"	INTEGER PRIMARY KEY AUTOINCREMENT,
+	"url"	TEXT NOT NULL,
+	"secret"	TEXT NOT NULL,
+	"types"	TEXT,
+	"created_at"	INTEGER NOT NULL
+);`, `CREATE TABLE IF NOT EXISTS "webhook_dead_letters" (
+	"id"	INTEGER PRIMARY KEY AUTOINCREMENT,
+	"hook_id"	INTEGER NOT NULL,
+	"event_type"	TEXT NOT NULL,
+	"seq"	INTEGER,
+	"payload"	TEXT NOT NULL,
+	"attempts"	INTEGER NOT NULL,
+	"last_error"	TEXT,
+	"created_at"	INTEGER NOT NULL,
+	"updated_at"	INTEGER NOT NULL
+);`)},
+}
+
+// Store 保存在账号目录的 sqlite 中
+type Store struct {
+	dbSource gdb.DB
+}
+
+// OpenStore 打开数据库并执行迁移
+func OpenStore(dbPath string) (*Store, error) {
+	db, err := migrate.OpenSQLite(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate.Run(db, webhookMigrations); err != nil {
+		_ = db.Close(context.Background())
+		return nil, fmt.Errorf("migrate webhooks %s: %w", dbPath, err)
+	}
+	return &Store{dbSource: db}, nil
+}
+
+// Close
+func (s *Store) Close() error {
+	return s.dbSource.Close(context.Background())
+}
+
+// AddHook 保存后设置 Id
+func (s *Store) AddHook(h *Hook) error {
+	h.CreatedAt = gtime.Timestamp()
+	r, err := s.dbSource.Model("webhooks").Insert(gdb.Map{
+		"url":        h.Url,
+		"secret":     h.Secret,
+		"types":      joinTypes(h.Types),
+		"created_at": h.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	h.Id, err = r.LastInsertId()
+	return err
+}
+
+// Hooks 所有 webhook
+func (s *Store) Hooks() ([]*Hook, error) {
+	all, err := s.dbSource.Model("webhooks").Order("id").All()
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]*Hook, 0, len(all))
+	for _, one := range all {
+		h, err := recordToHook(one)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// DeleteHook 死信保留 可以重新添加后再查看
+func (s *Store) DeleteHook(id int64) error {
+	r, err := s.dbSource.Model("webhooks").Delete("id=?", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(r)
+}
+
+// AddDeadLetter 保存后设置 Id
+func (s *Store) AddDeadLetter(d *DeadLetter) error {
+	now := gtime.Timestamp()
+	d.CreatedAt, d.UpdatedAt = now, now
+	r, err := s.dbSource.Model("webhook_dead_letters").Insert(gdb.Map{
+		"hook_id":    d.HookId,
+		"event_type": d.EventType.String(),
+		"seq":        d.Seq,
+		"payload":    d.Payload,
+		"attempts":   d.Attempts,
+		"last_error": d.LastError,
+		"created_at": now,
+		"updated_at": now,
+	})
+	if err != nil {
+		return err
+	}
+	d.Id, err = r.LastInsertId()
+	return err
+}
+
+// DeadLetters hookId 为 0 时返回所有 按 id 顺序
+func (s *Store) DeadLetters(hookId int64, limit int) ([]*DeadLetter, error) {
+	model := s.dbSource.Model("webhook_dead_letters")
+	if hookId > 0 {
+		model = model.Where("hook_id=?", hookId)
+	}
+	if limit > 0 {
+		model = model.Limit(limit)
+	}
+	all, err := model.Order("id").All()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0, len(all))
+	for _, one := range all {
+		d, err := recordToDeadLetter(one)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, d)
+	}
+	return letters, nil
+}
+
+// DeadLetter
+func (s *Store) DeadLetter(id int64) (*DeadLetter, error) {
+	one, err := s.dbSource.Model("webhook_dead_letters").Where("id=?", id).FindOne()
+	if err != nil {
+		return nil, err
+	}
+	if one.IsEmpty() {
+		return nil, ErrNotFound
+	}
+	return recordToDeadLetter(one)
+}
+
+// UpdateDeadLetter 重放失败时更新次数和错误
+func (s *Store) UpdateDeadLetter(id int64, attempts int, lastError string) error {
+	r, err := s.dbSource.Model("webhook_dead_letters").
+		Data(gdb.Map{"attempts": attempts, "last_error": lastError, "updated_at": gtime.Timestamp()}).
+		Where("id=?", id).
+		Update()
+	if err != nil {
+		return err
+	}
+	return checkAffected(r)
+}
+
+// DeleteDeadLetter 重放成功或者手动删除
+func (s *Store) DeleteDeadLetter(id int64) error {
+	r, err := s.dbSource.Model("webhook_dead_letters").Delete("id=?", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(r)
+}
+
+func checkAffected(r interface{ RowsAffected() (int64, error) }) error {
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func joinTypes(types []event.Type) string {
+	names := make([]string, 0, len(types))
+	for _, t := range types {
+		names = append(names, t.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseTypes 逗号分隔的事件名称
+func ParseTypes(s string) ([]event.Type, error) {
+	var types []event.Type
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		t, err := event.ParseType(name)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func recordToHook(one gdb.Record) (*Hook, error) {
+	types, err := ParseTypes(one["types"].String())
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{
+		Id:        one["id"].Int64(),
+		Url:       one["url"].String(),
+		Secret:    one["secret"].String(),
+		Types:     types,
+		CreatedAt: one["created_at"].Int64(),
+	}, nil
+}
+
+func recordToDeadLetter(one gdb.Record) (*DeadLetter, error) {
+	t, err := event.ParseType(one["event_type"].String())
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetter{
+		Id:        one["id"].Int64(),
+		HookId:    one["hook_id"].Int64(),
+		EventType: t,
+		Seq:       one["seq"].Uint64(),
+		Payload:   one["payload"].String(),
+		Attempts:  one["attempts"].Int(),
+		LastError: one["last_error"].String(),
+		CreatedAt: one["created_at"].Int64(),
+		UpdatedAt: one["updated_at"].Int64(),
+	}, nil
+}
+
+// Open 打开账号的 webhook 并开始推送 bus 的事件
+func Open(u string, bus *event.Bus) (*Dispatcher, error) {
+	store, err := OpenStore(fmt.Sprintf("%s/%s/webhooks", define.DefaultDbPath, u))
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewDispatcher(store, bus, DefaultOptions())
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return d, nil
+}
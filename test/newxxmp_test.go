@@ -91,17 +91,14 @@ func TestToken(t *testing.T) {
 }
 
 func TestXToken(t *testing.T) {
-	newxxmp.SetWAXXMPVersion(waver.NewWA41())
-	token := newxxmp.NewToken(0)
 	d, err := hex.DecodeString("F80C0C06FAFF091203630410779581761304D508FB0536567556990DEC401DFF051650181924F801F804FC06637265617465EC07EC40F801F812ED9E53FC145847353338205477697474657220466F6C6C6F77EDBBFAFF8685592684050F03EED8FAFF8613237819834F0308FC123132303336333034313037373935383137363FFC0A31363437313735313332EE9CFC0A31363438363630353132ED5FFC1031363530313831393234303538343138EDFDFC1031363530313335323638303535353732F8DEF808C105FAFF8613322568556F0308FC0E313634383135303937322D3134361DFC0A31363438343036383834F801F802EF98FC5957656C636F6D6520746F206A6F696E2074686520476C6F62616C204167656E74207465616D2C2077652070726F677265737320746F67657468657220616E6420736861726520746865207765616C746820746F676574686572F801EEA8F801EF1FF803050FFAFF0699699508152703F803050FFAFF0663915205769903F803050FFAFF8660147231680F03F803050FFAFF872348033566273F03F803050FFAFF872348162036354F03F803050FFAFF0625479443693303F803050FFAFF0625471801888003F803050FFAFF0625677311888103F803050FFAFF0663906843375603F803050FFAFF0623320711921603F803050FFAFF878801771257468F03F803050FFAFF0625474212563303F803050FFAFF8694762111232F03F803050FFAFF0660116229912803F803050FFAFF0692310007470603F803050FFAFF0625569359876803F803050FFAFF878801883902483F03F803050FFAFF0625479087957303F803050FFAFF872348164623405F03F803050FFAFF0625078855454103F803050FFAFF872349093007591F03F803050FFAFF872348034970503F03F803050FFAFF0692332858358103F803050FFAFF872347035595985F03F803050FFAFF0623354475181403F805050FFAFF8613237819834F0304EC6EF803050FFAFF872347015976329F03F803050FFAFF0625575943725603F803050FFAFF0625479816370203F803050FFAFF8684582758797F03F803050FFAFF0625078499203903F803050FFAFF0623359512287303F803050FFAFF0692336509361503F803050FFAFF0625475910382803F803050FFAFF8666634059781F03F803050FFAFF872348035589933F03F803050FFAFF0692332303403103F803050FFAFF0625479213697303F803050FFAFF0623324720023403F803050FFAFF872347030399344F03F803050FFAFF0692349427513103F803050FFAFF0691886003564203F803050FFAFF0663907608275903F803050FFAFF0644784031713603F803050FFAFF0623324301891903F803050FFAFF0663997597686203F803050FFAFF0692312707915003F803050FFAFF0692318124447903F803050FFAFF0624990572175203F803050FFAFF0663927282322903F803050FFAFF0623355367567403F803050FFAFF0691816809822603F803050FFAFF0623324018243103F803050FFAFF8651950639278F03F803050FFAFF0625565610452203F803050FFAFF872349034241808F03F803050FFAFF872348066937462F03F803050FFAFF0692346955062903F803050FFAFF0663961815911503F803050FFAFF8666993036250F03F803050FFAFF872348167734913F03F803050FFAFF0663965234581403F803050FFAFF0625479268909303F803050FFAFF0623355201086003F803050FFAFF0625470010847503F803050FFAFF872348035801853F03F803050FFAFF0699699076522503F803050FFAFF0625475264081903F803050FFAFF876283893774356F03F803050FFAFF0625677205140103F803050FFAFF0625471150257803F803050FFAFF0625479229729303F803050FFAFF8694778180695F03F803050FFAFF0625670447758103F803050FFAFF0692300957463603F803050FFAFF8694777753367F03F803050FFAFF8660174198357F03F803050FFAFF0625479579681303F803050FFAFF0625472632683003F803050FFAFF0663908344906303F803050FFAFF0625470023093903F803050FFAFF0625475635185903F803050FFAFF878801973339105F03F803050FFAFF0663916243693403F803050FFAFF0625078827779403F803050FFAFF878801646554838F03F803050FFAFF872348023041172F03F803050FFAFF872347010472889F03F803050FFAFF0699699508152403F803050FFAFF0625470791951503F803050FFAFF0625475818786803F803050FFAFF0625470539767503F803050FFAFF8696176952364F03F803050FFAFF0625670519369503F803050FFAFF0663945417690103F803050FFAFF8666948712390F03F803050FFAFF0623324515361403F803050FFAFF0692345698626203F803050FFAFF0625678076763803F803050FFAFF0625470649079503F803050FFAFF0625675087705003F803050FFAFF0692332806623203F803050FFAFF8694765525443F03F803050FFAFF0625470116499503F803050FFAFF0624999384213103F803050FFAFF872347047616412F03F803050FFAFF0663955075861903F803050FFAFF0692333125458003F803050FFAFF0625470092723503F803050FFAFF878801933435576F03F803050FFAFF0624991244462603F803050FFAFF872347017193009F03F803050FFAFF0624991378765803F803050FFAFF8694768307227F03F803050FFAFF879779824262929F03F803050FFAFF0692342417581503F803050FFAFF0692344098249603F803050FFAFF0699699508152803F803050FFAFF878801784183496F03F803050FFAFF872347063581495F03F803050FFAFF0625571351203703F803050FFAFF0625475983802803F803050FFAFF878801907537989F03F803050FFAFF0625078008371503F805050FFAFF8613322568556F0304EC6EF803050FFAFF878801707142286F03F803050FFAFF8694740462574F03F803050FFAFF0644776178575703F803050FFAFF0625675071775203F803050FFAFF872348160638745F03F803050FFAFF0644783093072603F803050FFAFF0692302848717503F803050FFAFF0625470689315503F803050FFAFF0663975715709203F803050FFAFF0625470755951503F803050FFAFF872348035529404F03F803050FFAFF0625470079975503F803050FFAFF0625475842534803F803050FFAFF8660174199037F03F803050FFAFF876289502951559F03F803050FFAFF0644786513978503F803050FFAFF0663965315165403F803050FFAFF0625470088439503F803050FFAFF0625479418545303F803050FFAFF0625479974171903F803050FFAFF8666993017809F03F803050FFAFF0624996368009503F803050FFAFF872349060654109F03F803050FFAFF872348148847666F03F803050FFAFF0625078722126703F803050FFAFF878613558684045F03F803050FFAFF0625472532293603F803050FFAFF872347015690249F03F803050FFAFF872348166024165F03F803050FFAFF8660174195275F03F803050FFAFF0625475745069903F803050FFAFF872348034152299F03F803050FFAFF878801918808903F03F803050FFAFF0625475873350803F803050FFAFF878801740996631F03F803050FFAFF872347037402416F03F803050FFAFF0625479152945303F803050FFAFF0663938425822303F803050FFAFF05961376751603F803050FFAFF0623359334895503F803050FFAFF872349060405649F03F803050FFAFF8694770447764F03F803050FFAFF0625479973076503F803050FFAFF0625475976198803F803050FFAFF0699699508152903F803050FFAFF0663963524286603F803050FFAFF8694707061562F03F803050FFAFF0625470727775503F803050FFAFF0625479383141303F803050FFAFF0625479986134203F803050FFAFF0625885346481803F803050FFAFF0663948293564203F803050FFAFF0625475949166803F803050FFAFF0625479782797303F803050FFAFF875511931487598F03F803050FFAFF8694771849130F03F803050FFAFF8694707516053F03F803050FFAFF876283826990500F03F803050FFAFF8694775897105F03F803050FFAFF872348035288661F03F803050FFAFF872348030679287F03F803050FFAFF0692303869640503F803050FFAFF0625677534629003F803050FFAFF0621263626167403F803050FFAFF8666948719187F03F803050FFAFF8694702384894F03F803050FFAFF076289532603905003F803050FFAFF878801765578128F03F803050FFAFF0624912382883803F803050FFAFF0625478621255503F803050FFAFF0625670317700003F803050FFAFF0625677807909703F803050FFAFF0624991111736703F803050FFAFF872349061135189F03F805050FFAFF064473614106370304EC6EF803050FFAFF0625471952645103F803050FFAFF0625475815122803F803050FFAFF872347068608304F03F803050FFAFF0663995741020703F803050FFAFF0663995976903503F803050FFAFF8694779779053F03F803050FFAFF0623327679054803F803050FFAFF0625477779686003F803050FFAFF8694703886937F03F803050FFAFF0625078864199603F803050FFAFF872348037703401F03F803050FFAFF872348038549101F03F803050FFAFF0625479053889303F803050FFAFF0625477986662503F803050FFAFF0623355075184403F803050FFAFF0692314789449203F803050FFAFF0625411351291203F803050FFAFF0625472345911803F803050FFAFF0692301271046203")
 	if err != nil {
 		t.Fatal(err)
 	}
-	iToken := token.NewFrom(d)
-	t.Log(iToken)
-
-	node := &newxxmp.Node{}
-	fromNode := node.From(iToken)
+	fromNode, err := newxxmp.NewDecoder(waver.NewWA41()).Decode(append([]byte{0}, d...))
+	if err != nil {
+		t.Fatal(err)
+	}
 	//a := make([]int,0)
 	//for _, child := range fromNode.Children[3].Children {
 	//	aa, _ := strconv.ParseInt(hex.EncodeToString(child.GetChildrenByTag("id").GetData()), 16, 0)
@@ -137,7 +134,7 @@ func TestNewXXMP(t *testing.T) {
 	// f80a1104741b0b052207fa0003f801f8020bf801f803170cfaff8703
 
 	// <ib from='s.whatsapp.net'><dirty type='account_sync' timestamp='1614238348'/></ib>
-	newxxmp.SetWAXXMPVersion(waver.NewWA41())
+	encoder := newxxmp.NewEncoder(waver.NewWA41())
 	attributes := make([]newxxmp.Attribute, 1)
 	attributes[0] = newxxmp.NewAttribute("from", "@s.whatsapp.net")
 
@@ -161,14 +158,21 @@ func TestNewXXMP(t *testing.T) {
 	node.Children.AddNode(&newxxmp.Node{
 		Tag: "from",
 	})
-	tokenList := node.GetTokenArray()
-	t.Log(hex.EncodeToString(tokenList.GetBytes()))
+	d, err := encoder.Encode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(hex.EncodeToString(d))
 	t.Log(node.GetString())
 	nodes := newxxmp.Nodes{}
 	nodes.AddNode(node)
 	emptyNode := newxxmp.EmptyNode("ib", node)
 	log.Println(emptyNode.GetString())
-	t.Log(hex.EncodeToString(emptyNode.GetTokenArray().GetBytes()))
+	d, err = encoder.Encode(emptyNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(hex.EncodeToString(d))
 }
 
 func TestXml(t *testing.T) {
@@ -20,7 +20,10 @@ func TestManager_GeneratingPreKeys(t *testing.T) {
 }
 
 func TestNewContactStores(t *testing.T) {
-	contactStores := stores.NewContactStores("test")
+	contactStores, err := stores.NewContactStores("test")
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Log(contactStores.AddContact("test1", "", "status", "1111111111111"))
 	cs, err := contactStores.GetAllContact()
 	if err != nil {
@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"testing"
+	"ws-go/protocol/newxxmp"
 	"ws-go/protocol/waproto"
-	"ws-go/protocol/xxmp"
 	"ws-go/waver"
 )
 
@@ -47,7 +47,7 @@ func TestXMMPEnAndDe(t *testing.T) {
 		t.Fatal(err)
 	}
 	t.Log(len(data))
-	node, err := xxmp.Decoder(data)
+	node, err := newxxmp.NewDecoder(waver.NewWA41()).Decode(append([]byte{0}, data...))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,8 +55,6 @@ func TestXMMPEnAndDe(t *testing.T) {
 }
 
 func TestXMMP(t *testing.T) {
-	xxmp.SetWAXXMPVersion(waver.NewWA41())
-
 	str := "fca73308e5cbd00112210588af9fb5515a4df22d5c9b904363d42e5926e8b933b11c6ee49b4d754455236e1a2105e15b1b33b2b3fa564293730fd5f1ba194bee1480bb34fb20a9e3e623722725202252330a2105c2ad200b6a7d5da512aa55f775b9b95bc85dae1266db6feddc9c15270e4fe246100118002220068b12afce666dcca349b6a1bfde9a836617d5b922805ddf562ed35ea6c2455e8eb6a79c1a37c5cc28b281d0233002"
 	data, err := hex.DecodeString(str)
 	if err != nil {
@@ -74,15 +72,17 @@ func TestXMMP(t *testing.T) {
 
 		t.Log(nn.GetString())*/
 
-	node := xxmp.NewNode("message", []xxmp.Attribute{
-		xxmp.NewAttribute("to", "44791605783@s.whatsapp.net"),
-		xxmp.NewAttribute("type", "text"),
-		xxmp.NewAttribute("id", "02B5D09DD92276D1D41158F51E31704"),
-	}, []*xxmp.Node{
-		xxmp.NewNode("enc", []xxmp.Attribute{
-			xxmp.NewAttribute("v", "2"),
-			xxmp.NewAttribute("type", "pkmsg"),
-		}, nil, data),
-	}, nil)
-	t.Log(hex.EncodeToString(node.GetToken().GetBytes()))
+	node := newxxmp.EmptyNode("message", newxxmp.Attributes{
+		newxxmp.NewAttribute("to", "44791605783@s.whatsapp.net"),
+		newxxmp.NewAttribute("type", "text"),
+		newxxmp.NewAttribute("id", "02B5D09DD92276D1D41158F51E31704"),
+	}, newxxmp.EmptyNode("enc", newxxmp.Attributes{
+		newxxmp.NewAttribute("v", "2"),
+		newxxmp.NewAttribute("type", "pkmsg"),
+	}, data))
+	d, err := newxxmp.NewEncoder(waver.NewWA41()).Encode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(hex.EncodeToString(d))
 }